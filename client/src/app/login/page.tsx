@@ -26,7 +26,8 @@ const loginSchema = z.object({
 type LoginFormData = z.infer<typeof loginSchema>;
 
 const twoFASchema = z.object({
-  code: z.string().length(6, "2FA kodu 6 haneli olmalıdır"),
+  // 6 haneli TOTP kodu veya xxxxx-xxxxx biçiminde kurtarma kodu
+  code: z.string().min(6, "2FA kodu en az 6 karakter olmalıdır"),
 });
 
 type TwoFAFormData = z.infer<typeof twoFASchema>;
@@ -52,6 +53,13 @@ export default function LoginPage() {
     resolver: zodResolver(twoFASchema),
   });
 
+  // Sıfırlanan veya süresi dolan şifre önce değiştirilmeli
+  const redirectAfterLogin = (loggedInUser: { must_change_password?: boolean }) => {
+    router.push(
+      loggedInUser.must_change_password ? "/settings?change_password=1" : "/dashboard"
+    );
+  };
+
   const onLoginSubmit = async (data: LoginFormData) => {
     try {
       const response = await loginMutation.mutateAsync(data);
@@ -60,8 +68,7 @@ export default function LoginPage() {
       if (response.data.requires_2fa) {
         setRequires2FA(true);
       } else {
-        // Redirect to dashboard
-        router.push("/dashboard");
+        redirectAfterLogin(response.data.user);
       }
     } catch (error) {
       console.error("Login error:", error);
@@ -71,8 +78,8 @@ export default function LoginPage() {
 
   const onTwoFASubmit = async (data: TwoFAFormData) => {
     try {
-      await verify2FAMutation.mutateAsync(data);
-      router.push("/dashboard");
+      const response = await verify2FAMutation.mutateAsync(data);
+      redirectAfterLogin(response.data.user);
     } catch (error) {
       // Error is handled by the mutation
     }
@@ -102,7 +109,7 @@ export default function LoginPage() {
                   id="code"
                   type="text"
                   placeholder="123456"
-                  maxLength={6}
+                  maxLength={11}
                   {...twoFAForm.register("code")}
                   className="text-center text-lg tracking-widest"
                 />
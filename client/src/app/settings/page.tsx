@@ -25,8 +25,17 @@ import {
   Palette,
   Globe,
   Save,
+  KeyRound,
+  Monitor,
 } from "lucide-react";
-import { useMe, useLogout } from "@/hooks/useApi";
+import {
+  useMe,
+  useLogout,
+  usePasswordPolicy,
+  useChangePassword,
+  useMySessions,
+  useRevokeMySession,
+} from "@/hooks/useApi";
 import { toast } from "react-hot-toast";
 
 export default function SettingsPage() {
@@ -34,6 +43,14 @@ export default function SettingsPage() {
   const [mounted, setMounted] = useState(false);
   const { data: me, isLoading: meLoading } = useMe();
   const logoutMutation = useLogout();
+  const { data: passwordPolicy } = usePasswordPolicy();
+  const changePasswordMutation = useChangePassword();
+  const { data: sessions } = useMySessions();
+  const revokeSessionMutation = useRevokeMySession();
+  const [passwordForm, setPasswordForm] = useState({
+    currentPassword: "",
+    newPassword: "",
+  });
 
   // Settings state
   const [settings, setSettings] = useState({
@@ -71,6 +88,31 @@ export default function SettingsPage() {
     router.push("/login");
   };
 
+  const handleChangePassword = async () => {
+    try {
+      await changePasswordMutation.mutateAsync(passwordForm);
+      setPasswordForm({ currentPassword: "", newPassword: "" });
+    } catch (error) {
+      // Error is handled by the mutation
+    }
+  };
+
+  const policy = passwordPolicy?.data;
+  const passwordRules = policy
+    ? [
+        `En az ${policy.min_length} karakter`,
+        policy.require_upper && "En az bir büyük harf",
+        policy.require_lower && "En az bir küçük harf",
+        policy.require_digit && "En az bir rakam",
+        policy.require_symbol && "En az bir özel karakter",
+        "E-posta adresinizi içermemeli",
+        policy.history_size > 0 &&
+          `Son ${policy.history_size} şifrenizden farklı olmalı`,
+        policy.max_age_days > 0 &&
+          `${policy.max_age_days} günde bir değiştirilmeli`,
+      ].filter(Boolean)
+    : [];
+
   const handleSave = () => {
     // Save settings logic here
     toast.success("Ayarlar kaydedildi!");
@@ -142,6 +184,110 @@ export default function SettingsPage() {
               </CardContent>
             </Card>
 
+            {/* Password Settings */}
+            <Card>
+              <CardHeader>
+                <CardTitle className="flex items-center gap-2">
+                  <KeyRound className="h-5 w-5" />
+                  Şifre Değiştir
+                </CardTitle>
+                <CardDescription>
+                  {me.data?.must_change_password
+                    ? "Devam etmeden önce şifrenizi değiştirmelisiniz"
+                    : "Hesap şifrenizi güncelleyin"}
+                </CardDescription>
+              </CardHeader>
+              <CardContent className="space-y-4">
+                <div className="space-y-2">
+                  <Label htmlFor="current-password">Mevcut Şifre</Label>
+                  <Input
+                    id="current-password"
+                    type="password"
+                    value={passwordForm.currentPassword}
+                    onChange={(e) =>
+                      setPasswordForm({
+                        ...passwordForm,
+                        currentPassword: e.target.value,
+                      })
+                    }
+                  />
+                </div>
+                <div className="space-y-2">
+                  <Label htmlFor="new-password">Yeni Şifre</Label>
+                  <Input
+                    id="new-password"
+                    type="password"
+                    value={passwordForm.newPassword}
+                    onChange={(e) =>
+                      setPasswordForm({
+                        ...passwordForm,
+                        newPassword: e.target.value,
+                      })
+                    }
+                  />
+                </div>
+                {passwordRules.length > 0 && (
+                  <ul className="list-disc pl-5 text-sm text-muted-foreground">
+                    {passwordRules.map((rule) => (
+                      <li key={rule as string}>{rule}</li>
+                    ))}
+                  </ul>
+                )}
+                <Button
+                  onClick={handleChangePassword}
+                  disabled={
+                    changePasswordMutation.isPending ||
+                    !passwordForm.currentPassword ||
+                    !passwordForm.newPassword
+                  }
+                >
+                  Şifreyi Değiştir
+                </Button>
+              </CardContent>
+            </Card>
+
+            {/* Active Sessions */}
+            <Card>
+              <CardHeader>
+                <CardTitle className="flex items-center gap-2">
+                  <Monitor className="h-5 w-5" />
+                  Aktif Oturumlar
+                </CardTitle>
+                <CardDescription>
+                  Hesabınızın açık olduğu cihazlar
+                </CardDescription>
+              </CardHeader>
+              <CardContent className="space-y-4">
+                {sessions?.data.map((session) => (
+                  <div
+                    key={session.id}
+                    className="flex items-center justify-between"
+                  >
+                    <div className="space-y-0.5">
+                      <Label>
+                        {session.device}
+                        {session.current && " (bu cihaz)"}
+                      </Label>
+                      <p className="text-sm text-muted-foreground">
+                        {session.ip} · Son görülme:{" "}
+                        {new Date(session.last_seen_at).toLocaleString("tr-TR")}
+                      </p>
+                    </div>
+                    {!session.current && (
+                      <Button
+                        variant="outline"
+                        size="sm"
+                        onClick={() => revokeSessionMutation.mutate(session.id)}
+                        disabled={revokeSessionMutation.isPending}
+                      >
+                        Oturumu Kapat
+                      </Button>
+                    )}
+                  </div>
+                ))}
+              </CardContent>
+            </Card>
+
             {/* Appearance Settings */}
             <Card>
               <CardHeader>
@@ -89,6 +89,59 @@ export const useMe = () => {
   });
 };
 
+// useCan tells whether the current user holds a permission, using the
+// effective permission list returned by /me
+export const useCan = (permission: string) => {
+  const me = useMe();
+  return me.data?.data.permissions.includes(permission) ?? false;
+};
+
+export const usePasswordPolicy = () => {
+  return useQuery({
+    queryKey: ["password-policy"],
+    queryFn: () => apiClient.getPasswordPolicy(),
+    staleTime: 60 * 60 * 1000, // 1 hour
+  });
+};
+
+export const useChangePassword = () => {
+  const queryClient = useQueryClient();
+
+  return useMutation({
+    mutationFn: (data: { currentPassword: string; newPassword: string }) =>
+      apiClient.changePassword(data.currentPassword, data.newPassword),
+    onSuccess: () => {
+      queryClient.invalidateQueries({ queryKey: ["me"] });
+      toast.success("Şifre değiştirildi");
+    },
+    onError: (error: any) => {
+      toast.error(error.response?.data?.error || "Şifre değiştirilemedi");
+    },
+  });
+};
+
+export const useMySessions = () => {
+  return useQuery({
+    queryKey: ["me", "sessions"],
+    queryFn: () => apiClient.getMySessions(),
+  });
+};
+
+export const useRevokeMySession = () => {
+  const queryClient = useQueryClient();
+
+  return useMutation({
+    mutationFn: (id: number) => apiClient.revokeMySession(id),
+    onSuccess: () => {
+      queryClient.invalidateQueries({ queryKey: ["me", "sessions"] });
+      toast.success("Oturum kapatıldı");
+    },
+    onError: (error: any) => {
+      toast.error(error.response?.data?.error || "Oturum kapatılamadı");
+    },
+  });
+};
+
 // Customer hooks
 export const useCustomers = (params?: {
   query?: string;
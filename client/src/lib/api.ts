@@ -9,7 +9,7 @@ export interface LoginRequest {
 }
 
 export interface LoginResponse {
-  token_pair: {
+  token_pair?: {
     access_token: string;
     refresh_token: string;
     expires_at: number;
@@ -17,21 +17,165 @@ export interface LoginResponse {
   user: {
     id: number;
     email: string;
+    role_id: number;
     role: string;
+    branch_id: number | null;
+    agent_id: number | null;
     two_fa_enabled: boolean;
     is_active: boolean;
+    must_change_password: boolean;
+    locked_until?: string;
+    last_login_at?: string;
     created_at: string;
   };
   requires_2fa: boolean;
+  mfa_token?: string;
+}
+
+export type User = LoginResponse["user"];
+
+export interface UserDataScope {
+  level: DataScope;
+  user_id: number;
+  branch_id: number | null;
+  agent_id: number | null;
+  delegated_branch_ids?: number[];
+  delegated_user_ids?: number[];
+}
+
+export interface Me extends User {
+  permissions: string[];
+  data_scope: UserDataScope;
+}
+
+export interface PasswordPolicy {
+  min_length: number;
+  require_upper: boolean;
+  require_lower: boolean;
+  require_digit: boolean;
+  require_symbol: boolean;
+  history_size: number;
+  max_age_days: number;
+}
+
+export interface Session {
+  id: number;
+  device: string;
+  user_agent: string;
+  ip: string;
+  created_at: string;
+  last_seen_at: string;
+  expires_at: string;
+  current: boolean;
+}
+
+export type DataScope = "all" | "branch" | "own";
+
+export type TimelineEventType =
+  | "customer_created"
+  | "profile_updated"
+  | "quote_created"
+  | "offer_received"
+  | "quote_approved"
+  | "policy_issued"
+  | "policy_updated"
+  | "policy_renewed"
+  | "policy_cancelled"
+  | "payment_received";
+
+export interface TimelineEvent {
+  type: TimelineEventType;
+  at: string;
+  entity: string;
+  entity_id: number;
+  user_id?: number;
+  data?: Record<string, unknown>;
+}
+
+export interface TimelineParams {
+  types?: TimelineEventType[];
+  page?: number;
+  pageSize?: number;
+}
+
+export interface AuditLog {
+  id: number;
+  user_id: number | null;
+  user_email?: string;
+  action: string;
+  entity: string;
+  entity_id: number | null;
+  method: string;
+  route: string;
+  status_code: number;
+  latency_ms: number;
+  ip: string;
+  user_agent: string;
+  meta: Record<string, unknown> | null;
+  changes: Record<string, { old: unknown; new: unknown }> | null;
+  prev_hash: string;
+  hash: string;
+  created_at: string;
+}
+
+export interface AuditLogPage {
+  data: AuditLog[];
+  next_cursor?: string;
+}
+
+export interface AuditChainReport {
+  valid: boolean;
+  checked: number;
+  unhashed: number;
+  broken_at?: number;
+  reason?: string;
+  last_hash?: string;
+}
+
+export interface PermissionGrant {
+  id: number;
+  user_id: number;
+  permission: string;
+  branch_id: number | null;
+  agent_user_id: number | null;
+  reason: string;
+  granted_by_id: number;
+  expires_at: string;
+  revoked_at: string | null;
+  revoked_by_id: number | null;
+  active: boolean;
+  created_at: string;
+}
+
+export interface Role {
+  id: number;
+  name: string;
+  description: string;
+  is_system: boolean;
+  data_scope: DataScope;
+  permissions: string[];
+  user_count: number;
+  created_at: string;
+}
+
+export interface Permission {
+  id: number;
+  name: string;
+  description: string;
+  resource: string;
+  action: string;
 }
 
 export interface TwoFARequest {
   code: string;
 }
 
+export type CustomerType = "bireysel" | "kurumsal";
+
 export interface Customer {
   id: number;
   tc_vkn: string;
+  customer_type: CustomerType;
   name: string;
   email: string;
   phone: string;
@@ -41,12 +185,17 @@ export interface Customer {
   postal_code: string;
   birth_date: string;
   gender: string;
+  tax_office: string;
+  trade_title: string;
+  authorized_person: string;
+  anonymized_at?: string;
   created_at: string;
   updated_at: string;
 }
 
 export interface CustomerRequest {
   tc_vkn: string;
+  customer_type?: CustomerType;
   name: string;
   email?: string;
   phone?: string;
@@ -56,6 +205,145 @@ export interface CustomerRequest {
   postal_code?: string;
   birth_date?: string;
   gender?: string;
+  tax_office?: string;
+  trade_title?: string;
+  authorized_person?: string;
+}
+
+export interface DuplicateMatch {
+  customer: Customer;
+  duplicate: Customer;
+  score: number;
+  reasons: ("phone" | "email" | "birth_date" | "name")[];
+}
+
+export interface MergeCustomerResponse {
+  customer: Customer;
+  merged_id: number;
+  moved: {
+    quotes: number;
+    policies: number;
+    payments: number;
+  };
+}
+
+export type CustomerSortField =
+  | "name"
+  | "city"
+  | "district"
+  | "birth_date"
+  | "created_at"
+  | "updated_at";
+
+// Filters of the customer list. Text and enum filters take comma-separated
+// values to match any of them; ranges are set with the [gte]/[lte] keys.
+export interface CustomerSearchParams {
+  query?: string;
+  customer_type?: CustomerType;
+  city?: string;
+  district?: string;
+  gender?: string;
+  birth_date?: string;
+  "birth_date[gte]"?: string;
+  "birth_date[lte]"?: string;
+  age?: number;
+  "age[gte]"?: number;
+  "age[lte]"?: number;
+  has_active_policy?: boolean;
+  product_type?: string;
+  agent_id?: number;
+  branch_id?: number;
+  created_at?: string;
+  "created_at[gte]"?: string;
+  "created_at[lte]"?: string;
+  // e.g. "-created_at,name", - for descending
+  sort?: string;
+  page?: number;
+  pageSize?: number;
+}
+
+export type ConsentPurpose = "marketing" | "data_sharing" | "abroad_transfer";
+export type ConsentChannel = "sms" | "email" | "call" | "all";
+
+export interface CustomerConsent {
+  id: number;
+  customer_id: number;
+  purpose: ConsentPurpose;
+  channel: ConsentChannel;
+  text_version: string;
+  granted_at: string;
+  revoked_at: string | null;
+  recorded_by: number | null;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface ConsentRequest {
+  purpose: ConsentPurpose;
+  channel?: ConsentChannel;
+  text_version: string;
+  granted_at?: string;
+}
+
+export interface CustomerDataExport {
+  exported_at: string;
+  customer: Customer;
+  consents: CustomerConsent[];
+  quotes: Record<string, unknown>[];
+  offers: Record<string, unknown>[];
+  policies: Record<string, unknown>[];
+  payments: Record<string, unknown>[];
+  timeline: Record<string, unknown>[];
+}
+
+export interface AnonymizeCustomerResponse {
+  customer: Customer;
+  anonymized: {
+    quotes: number;
+    consents: number;
+  };
+}
+
+export type CustomerImportField =
+  | "tc_vkn"
+  | "customer_type"
+  | "name"
+  | "email"
+  | "phone"
+  | "address"
+  | "city"
+  | "district"
+  | "postal_code"
+  | "birth_date"
+  | "gender"
+  | "tax_office"
+  | "trade_title"
+  | "authorized_person";
+
+export interface CustomerImportRowError {
+  line: number;
+  tc_vkn?: string;
+  fields?: Partial<Record<CustomerImportField, string>>;
+  error?: string;
+}
+
+export interface CustomerImport {
+  id: number;
+  file_name: string;
+  format: "csv" | "xlsx";
+  dry_run: boolean;
+  status: "pending" | "running" | "completed" | "failed";
+  total_rows: number;
+  processed_rows: number;
+  created: number;
+  updated: number;
+  unchanged: number;
+  failed: number;
+  errors: CustomerImportRowError[];
+  error?: string;
+  started_at: string | null;
+  finished_at: string | null;
+  created_at: string;
 }
 
 export interface PaginationResponse<T> {
@@ -70,6 +358,10 @@ export interface ErrorResponse {
   error: string;
 }
 
+export interface ValidationErrorResponse extends ErrorResponse {
+  fields: Record<string, string>;
+}
+
 export interface SuccessResponse {
   message: string;
 }
@@ -78,6 +370,7 @@ class ApiClient {
   private client: AxiosInstance;
   private accessToken: string | null = null;
   private refreshToken: string | null = null;
+  private mfaToken: string | null = null;
 
   constructor() {
     this.client = axios.create({
@@ -171,10 +464,15 @@ class ApiClient {
       "/auth/login",
       credentials
     );
-    this.setTokens(
-      response.data.token_pair.access_token,
-      response.data.token_pair.refresh_token
-    );
+    if (response.data.requires_2fa) {
+      // Only a short-lived token for the code step; no session yet
+      this.mfaToken = response.data.mfa_token ?? null;
+    } else if (response.data.token_pair) {
+      this.setTokens(
+        response.data.token_pair.access_token,
+        response.data.token_pair.refresh_token
+      );
+    }
     return response;
   }
 
@@ -187,13 +485,27 @@ class ApiClient {
   }
 
   async logout(): Promise<AxiosResponse<SuccessResponse>> {
-    const response = await this.client.post<SuccessResponse>("/auth/logout");
+    // Sending the refresh token lets the server revoke the session
+    const response = await this.client.post<SuccessResponse>("/auth/logout", {
+      refresh_token: this.refreshToken,
+    });
     this.clearTokens();
     return response;
   }
 
   async verify2FA(code: string): Promise<AxiosResponse<LoginResponse>> {
-    return this.client.post<LoginResponse>("/auth/2fa/login", { code });
+    const response = await this.client.post<LoginResponse>("/auth/2fa/login", {
+      mfa_token: this.mfaToken,
+      code,
+    });
+    this.mfaToken = null;
+    if (response.data.token_pair) {
+      this.setTokens(
+        response.data.token_pair.access_token,
+        response.data.token_pair.refresh_token
+      );
+    }
+    return response;
   }
 
   async enable2FA(
@@ -202,20 +514,194 @@ class ApiClient {
     return this.client.post("/auth/2fa/enable", { password });
   }
 
-  async verify2FASetup(code: string): Promise<AxiosResponse<SuccessResponse>> {
-    return this.client.post<SuccessResponse>("/auth/2fa/verify", { code });
+  async verify2FASetup(
+    code: string
+  ): Promise<AxiosResponse<SuccessResponse & { recovery_codes: string[] }>> {
+    return this.client.post("/auth/2fa/verify", { code });
   }
 
-  async getMe(): Promise<AxiosResponse<LoginResponse["user"]>> {
-    return this.client.get("/me");
+  async disable2FA(
+    password: string,
+    code: string
+  ): Promise<AxiosResponse<SuccessResponse>> {
+    return this.client.post<SuccessResponse>("/auth/2fa/disable", {
+      password,
+      code,
+    });
   }
 
-  // Customer methods
-  async getCustomers(params?: {
+  async getMe(): Promise<AxiosResponse<Me>> {
+    return this.client.get<Me>("/me");
+  }
+
+  async checkPermissions(
+    permissions: string[]
+  ): Promise<AxiosResponse<{ permissions: Record<string, boolean> }>> {
+    return this.client.post("/auth/check", { permissions });
+  }
+
+  async getPasswordPolicy(): Promise<AxiosResponse<PasswordPolicy>> {
+    return this.client.get<PasswordPolicy>("/auth/password-policy");
+  }
+
+  async changePassword(
+    currentPassword: string,
+    newPassword: string
+  ): Promise<AxiosResponse<SuccessResponse>> {
+    return this.client.put<SuccessResponse>("/me/password", {
+      current_password: currentPassword,
+      new_password: newPassword,
+    });
+  }
+
+  async getMySessions(): Promise<AxiosResponse<Session[]>> {
+    return this.client.get<Session[]>("/me/sessions");
+  }
+
+  async revokeMySession(id: number): Promise<AxiosResponse<SuccessResponse>> {
+    return this.client.delete<SuccessResponse>(`/me/sessions/${id}`);
+  }
+
+  // User methods
+  async getUsers(params?: {
     query?: string;
+    role_id?: number;
+    is_active?: boolean;
     page?: number;
     pageSize?: number;
-  }): Promise<AxiosResponse<PaginationResponse<Customer>>> {
+  }): Promise<AxiosResponse<PaginationResponse<User>>> {
+    return this.client.get<PaginationResponse<User>>("/users", { params });
+  }
+
+  async getUser(id: number): Promise<AxiosResponse<User>> {
+    return this.client.get<User>(`/users/${id}`);
+  }
+
+  async createUser(user: {
+    email: string;
+    password: string;
+    role_id: number;
+    branch_id?: number;
+    agent_id?: number;
+  }): Promise<AxiosResponse<User>> {
+    return this.client.post<User>("/users", user);
+  }
+
+  async updateUserRole(
+    id: number,
+    roleId: number
+  ): Promise<AxiosResponse<User>> {
+    return this.client.put<User>(`/users/${id}/role`, { role_id: roleId });
+  }
+
+  async updateUserAssignment(
+    id: number,
+    assignment: { branch_id: number | null; agent_id: number | null }
+  ): Promise<AxiosResponse<User>> {
+    return this.client.put<User>(`/users/${id}/assignment`, assignment);
+  }
+
+  async getUserGrants(id: number): Promise<AxiosResponse<PermissionGrant[]>> {
+    return this.client.get<PermissionGrant[]>(`/users/${id}/grants`);
+  }
+
+  async createUserGrant(
+    id: number,
+    grant: {
+      permission: string;
+      branch_id?: number;
+      agent_user_id?: number;
+      expires_at: string;
+      reason: string;
+    }
+  ): Promise<AxiosResponse<PermissionGrant>> {
+    return this.client.post<PermissionGrant>(`/users/${id}/grants`, grant);
+  }
+
+  async revokeUserGrant(
+    id: number,
+    grantId: number
+  ): Promise<AxiosResponse<PermissionGrant>> {
+    return this.client.delete<PermissionGrant>(`/users/${id}/grants/${grantId}`);
+  }
+
+  async setUserActive(
+    id: number,
+    active: boolean
+  ): Promise<AxiosResponse<User>> {
+    return this.client.post<User>(
+      `/users/${id}/${active ? "activate" : "deactivate"}`
+    );
+  }
+
+  async resetUserPassword(
+    id: number
+  ): Promise<AxiosResponse<{ message: string; temporary_password: string }>> {
+    return this.client.post(`/users/${id}/password-reset`);
+  }
+
+  async unlockUser(id: number): Promise<AxiosResponse<SuccessResponse>> {
+    return this.client.post<SuccessResponse>(`/users/${id}/unlock`);
+  }
+
+  async getUserSessions(id: number): Promise<AxiosResponse<Session[]>> {
+    return this.client.get<Session[]>(`/users/${id}/sessions`);
+  }
+
+  async revokeUserSession(
+    id: number,
+    sessionId: number
+  ): Promise<AxiosResponse<SuccessResponse>> {
+    return this.client.delete<SuccessResponse>(
+      `/users/${id}/sessions/${sessionId}`
+    );
+  }
+
+  async deleteUser(id: number): Promise<AxiosResponse<SuccessResponse>> {
+    return this.client.delete<SuccessResponse>(`/users/${id}`);
+  }
+
+  // Role methods
+  async getRoles(): Promise<AxiosResponse<Role[]>> {
+    return this.client.get<Role[]>("/roles");
+  }
+
+  async getRole(id: number): Promise<AxiosResponse<Role>> {
+    return this.client.get<Role>(`/roles/${id}`);
+  }
+
+  async getPermissions(): Promise<AxiosResponse<Permission[]>> {
+    return this.client.get<Permission[]>("/permissions");
+  }
+
+  async createRole(role: {
+    name: string;
+    description?: string;
+    data_scope?: DataScope;
+    permissions: string[];
+  }): Promise<AxiosResponse<Role>> {
+    return this.client.post<Role>("/roles", role);
+  }
+
+  async updateRole(
+    id: number,
+    role: {
+      description?: string;
+      data_scope?: DataScope;
+      permissions: string[];
+    }
+  ): Promise<AxiosResponse<Role>> {
+    return this.client.put<Role>(`/roles/${id}`, role);
+  }
+
+  async deleteRole(id: number): Promise<AxiosResponse<SuccessResponse>> {
+    return this.client.delete<SuccessResponse>(`/roles/${id}`);
+  }
+
+  // Customer methods
+  async getCustomers(
+    params?: CustomerSearchParams,
+  ): Promise<AxiosResponse<PaginationResponse<Customer>>> {
     return this.client.get<PaginationResponse<Customer>>("/customers", {
       params,
     });
@@ -242,6 +728,93 @@ class ApiClient {
     return this.client.delete<SuccessResponse>(`/customers/${id}`);
   }
 
+  async findDuplicateCustomers(params?: {
+    customer_id?: number;
+    min_score?: number;
+    page?: number;
+    pageSize?: number;
+  }): Promise<AxiosResponse<PaginationResponse<DuplicateMatch>>> {
+    return this.client.get<PaginationResponse<DuplicateMatch>>(
+      "/customers/duplicates",
+      { params }
+    );
+  }
+
+  async mergeCustomer(
+    id: number,
+    duplicateId: number
+  ): Promise<AxiosResponse<MergeCustomerResponse>> {
+    return this.client.post<MergeCustomerResponse>(`/customers/${id}/merge`, {
+      duplicate_id: duplicateId,
+    });
+  }
+
+  // Small files are imported right away (200); larger ones answer 202 and
+  // are polled with getCustomerImport until completed or failed
+  async importCustomers(
+    file: File,
+    options?: {
+      mapping?: Partial<Record<CustomerImportField, string>>;
+      dryRun?: boolean;
+    }
+  ): Promise<AxiosResponse<CustomerImport>> {
+    const form = new FormData();
+    form.append("file", file);
+    if (options?.mapping) {
+      form.append("mapping", JSON.stringify(options.mapping));
+    }
+    if (options?.dryRun) {
+      form.append("dry_run", "true");
+    }
+    return this.client.post<CustomerImport>("/customers/import", form, {
+      headers: { "Content-Type": "multipart/form-data" },
+    });
+  }
+
+  async getCustomerImport(id: number): Promise<AxiosResponse<CustomerImport>> {
+    return this.client.get<CustomerImport>(`/customers/imports/${id}`);
+  }
+
+  // KVKK
+  async getCustomerConsents(
+    id: number
+  ): Promise<AxiosResponse<CustomerConsent[]>> {
+    return this.client.get<CustomerConsent[]>(`/customers/${id}/consents`);
+  }
+
+  async grantCustomerConsent(
+    id: number,
+    consent: ConsentRequest
+  ): Promise<AxiosResponse<CustomerConsent>> {
+    return this.client.post<CustomerConsent>(
+      `/customers/${id}/consents`,
+      consent
+    );
+  }
+
+  async revokeCustomerConsent(
+    id: number,
+    consentId: number
+  ): Promise<AxiosResponse<CustomerConsent>> {
+    return this.client.post<CustomerConsent>(
+      `/customers/${id}/consents/${consentId}/revoke`
+    );
+  }
+
+  async exportCustomerData(
+    id: number
+  ): Promise<AxiosResponse<CustomerDataExport>> {
+    return this.client.get<CustomerDataExport>(`/customers/${id}/kvkk-export`);
+  }
+
+  async anonymizeCustomer(
+    id: number
+  ): Promise<AxiosResponse<AnonymizeCustomerResponse>> {
+    return this.client.post<AnonymizeCustomerResponse>(
+      `/customers/${id}/anonymize`
+    );
+  }
+
   // Branch methods
   async getBranches(params?: {
     query?: string;
@@ -379,6 +952,36 @@ class ApiClient {
     return this.client.get<any[]>(`/quotes/${quoteId}/scraped`);
   }
 
+  // Timeline methods
+  async getTimeline(
+    entity: "customers" | "policies" | "quotes",
+    id: number,
+    params?: TimelineParams
+  ): Promise<AxiosResponse<PaginationResponse<TimelineEvent>>> {
+    return this.client.get<PaginationResponse<TimelineEvent>>(
+      `/${entity}/${id}/timeline`,
+      { params: { ...params, types: params?.types?.join(",") } }
+    );
+  }
+
+  // Audit log methods
+  async getAuditLogs(params?: {
+    user_id?: number;
+    entity?: string;
+    entity_id?: number;
+    action?: string;
+    from?: string;
+    to?: string;
+    cursor?: string;
+    limit?: number;
+  }): Promise<AxiosResponse<AuditLogPage>> {
+    return this.client.get<AuditLogPage>("/audit-logs", { params });
+  }
+
+  async verifyAuditLogs(): Promise<AxiosResponse<AuditChainReport>> {
+    return this.client.get<AuditChainReport>("/audit-logs/verify");
+  }
+
   // Utility methods
   isAuthenticated(): boolean {
     if (typeof window === "undefined") {
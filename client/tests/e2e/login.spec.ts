@@ -273,7 +273,7 @@ test.describe("EESigorta Portal E2E Tests", () => {
           contentType: "application/json",
           body: JSON.stringify({
             id: 1,
-            tc_vkn: "12345678901",
+            tc_vkn: "12345678950",
             name: "Test Customer",
             email: "test@example.com",
             phone: "0555 123 45 67",
@@ -309,7 +309,7 @@ test.describe("EESigorta Portal E2E Tests", () => {
     await page.click("text=Yeni Müşteri");
 
     // Fill customer form
-    await page.fill('input[name="tc_vkn"]', "12345678901");
+    await page.fill('input[name="tc_vkn"]', "12345678950");
     await page.fill('input[name="name"]', "Test Customer");
     await page.fill('input[name="email"]', "test@example.com");
     await page.fill('input[name="phone"]', "0555 123 45 67");
@@ -4,12 +4,20 @@
 -- Enable UUID extension
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 
+-- Turkish-aware case folding for search and filters: İ, I, ı and i all
+-- become i. Must match filter.Fold in the backend.
+CREATE OR REPLACE FUNCTION tr_fold(value TEXT) RETURNS TEXT AS $$
+    SELECT lower(translate(value, 'İIı', 'iii'))
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
 -- Users table
 CREATE TABLE users (
     id SERIAL PRIMARY KEY,
     email VARCHAR(255) UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
-    role VARCHAR(50) NOT NULL DEFAULT 'viewer',
+    role_id INTEGER,
+    branch_id INTEGER,
+    agent_id INTEGER,
     twofa_enabled BOOLEAN DEFAULT FALSE,
     twofa_secret VARCHAR(255),
     is_active BOOLEAN DEFAULT TRUE,
@@ -52,6 +60,7 @@ CREATE TABLE agents (
 CREATE TABLE customers (
     id SERIAL PRIMARY KEY,
     tc_vkn VARCHAR(11) UNIQUE NOT NULL,
+    customer_type VARCHAR(10) NOT NULL DEFAULT 'bireysel' CHECK (customer_type IN ('bireysel', 'kurumsal')),
     name VARCHAR(255) NOT NULL,
     email VARCHAR(255),
     phone VARCHAR(20),
@@ -61,11 +70,59 @@ CREATE TABLE customers (
     postal_code VARCHAR(10),
     birth_date DATE,
     gender VARCHAR(10),
+    tax_office VARCHAR(100),
+    trade_title VARCHAR(255),
+    authorized_person VARCHAR(255),
+    anonymized_at TIMESTAMP,
+    search_vector TSVECTOR GENERATED ALWAYS AS (
+        to_tsvector('simple', tr_fold(
+            coalesce(name, '') || ' ' || coalesce(trade_title, '') || ' ' ||
+            coalesce(email, '') || ' ' || coalesce(phone, '') || ' ' || tc_vkn
+        ))
+    ) STORED,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     deleted_at TIMESTAMP
 );
 
+-- Customer KVKK consents table
+CREATE TABLE customer_consents (
+    id SERIAL PRIMARY KEY,
+    customer_id INTEGER NOT NULL REFERENCES customers(id),
+    purpose VARCHAR(30) NOT NULL CHECK (purpose IN ('marketing', 'data_sharing', 'abroad_transfer')),
+    channel VARCHAR(10) NOT NULL CHECK (channel IN ('sms', 'email', 'call', 'all')),
+    text_version VARCHAR(50) NOT NULL,
+    granted_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    recorded_by INTEGER REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Customer imports table
+CREATE TABLE customer_imports (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    file_name VARCHAR(255) NOT NULL,
+    format VARCHAR(10) NOT NULL CHECK (format IN ('csv', 'xlsx')),
+    mapping_json JSONB,
+    dry_run BOOLEAN DEFAULT FALSE,
+    file BYTEA,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    total_rows INTEGER DEFAULT 0,
+    processed_rows INTEGER DEFAULT 0,
+    created INTEGER DEFAULT 0,
+    updated INTEGER DEFAULT 0,
+    unchanged INTEGER DEFAULT 0,
+    failed INTEGER DEFAULT 0,
+    report_json JSONB,
+    error_msg TEXT,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 -- Products table
 CREATE TABLE products (
     id SERIAL PRIMARY KEY,
@@ -138,9 +195,16 @@ CREATE TABLE audit_logs (
     action VARCHAR(100) NOT NULL,
     entity VARCHAR(100) NOT NULL,
     entity_id INTEGER,
+    method VARCHAR(10),
+    route VARCHAR(255),
+    status_code INTEGER,
+    latency_ms BIGINT,
     ip VARCHAR(45),
     user_agent TEXT,
     meta_json JSONB,
+    changes_json JSONB,
+    prev_hash VARCHAR(64),
+    hash VARCHAR(64),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -203,6 +267,7 @@ CREATE TABLE roles (
     name VARCHAR(100) UNIQUE NOT NULL,
     description TEXT,
     is_system BOOLEAN DEFAULT FALSE,
+    data_scope VARCHAR(20) NOT NULL DEFAULT 'own',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     deleted_at TIMESTAMP
@@ -216,13 +281,44 @@ CREATE TABLE role_permissions (
     PRIMARY KEY (role_id, permission_id)
 );
 
+-- Permission grants table
+CREATE TABLE permission_grants (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    permission VARCHAR(100) NOT NULL,
+    branch_id INTEGER REFERENCES branches(id),
+    agent_user_id INTEGER REFERENCES users(id),
+    reason TEXT,
+    granted_by_id INTEGER REFERENCES users(id),
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    revoked_by_id INTEGER REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 -- Create indexes for better performance
 CREATE INDEX idx_users_email ON users(email);
-CREATE INDEX idx_users_role ON users(role);
+CREATE INDEX idx_users_role_id ON users(role_id);
+CREATE INDEX idx_users_branch_id ON users(branch_id);
+CREATE INDEX idx_users_agent_id ON users(agent_id);
+CREATE INDEX idx_permission_grants_user_id ON permission_grants(user_id);
+CREATE INDEX idx_permission_grants_expires_at ON permission_grants(expires_at);
 CREATE INDEX idx_customers_tc_vkn ON customers(tc_vkn);
 CREATE INDEX idx_customers_name ON customers(name);
+CREATE INDEX idx_customers_search_vector ON customers USING GIN (search_vector);
+CREATE INDEX idx_customers_city ON customers(tr_fold(city));
+CREATE INDEX idx_customers_district ON customers(tr_fold(district));
+CREATE INDEX idx_customers_birth_date ON customers(birth_date);
+CREATE INDEX idx_customers_created_at ON customers(created_at);
+CREATE INDEX idx_customer_consents_customer_id ON customer_consents(customer_id);
+CREATE INDEX idx_customer_imports_user_id ON customer_imports(user_id);
 CREATE INDEX idx_policies_policy_no ON policies(policy_no);
 CREATE INDEX idx_policies_status ON policies(status);
+CREATE INDEX idx_policies_customer_id ON policies(customer_id, status);
+CREATE INDEX idx_policies_agent_id ON policies(agent_id);
+CREATE INDEX idx_quotes_customer_id ON quotes(customer_id);
+CREATE INDEX idx_quotes_agent_id ON quotes(agent_id);
 CREATE INDEX idx_audit_logs_user_id ON audit_logs(user_id);
 CREATE INDEX idx_audit_logs_created_at ON audit_logs(created_at);
 CREATE INDEX idx_scraped_rows_hash_key ON scraped_rows(hash_key);
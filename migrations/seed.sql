@@ -2,11 +2,11 @@
 -- Demo data for development and testing
 
 -- Insert system roles
-INSERT INTO roles (name, description, is_system) VALUES
-('admin', 'System Administrator', true),
-('branch_manager', 'Branch Manager', true),
-('agent', 'Insurance Agent', true),
-('viewer', 'Read-only User', true);
+INSERT INTO roles (name, description, is_system, data_scope) VALUES
+('admin', 'System Administrator', true, 'all'),
+('branch_manager', 'Branch Manager', true, 'branch'),
+('agent', 'Insurance Agent', true, 'own'),
+('viewer', 'Read-only User', true, 'all');
 
 -- Insert permissions
 INSERT INTO permissions (name, description, resource, action) VALUES
@@ -37,6 +37,8 @@ INSERT INTO permissions (name, description, resource, action) VALUES
 ('customer:update', 'Update customer information', 'customer', 'update'),
 ('customer:delete', 'Delete customers', 'customer', 'delete'),
 ('customer:list', 'List customers', 'customer', 'list'),
+('customer:read_pii', 'Read unmasked customer personal data', 'customer', 'read_pii'),
+('customer:kvkk', 'Export and anonymize customer personal data for KVKK requests', 'customer', 'kvkk'),
 
 -- Policy permissions
 ('policy:create', 'Create policies', 'policy', 'create'),
@@ -58,7 +60,10 @@ INSERT INTO permissions (name, description, resource, action) VALUES
 
 -- Scraper permissions
 ('scraper:run', 'Run scraper', 'scraper', 'run'),
-('scraper:manage', 'Manage scraper', 'scraper', 'manage');
+('scraper:manage', 'Manage scraper', 'scraper', 'manage'),
+
+-- Audit log permissions
+('audit:read', 'Read and verify the audit log', 'audit', 'read');
 
 -- Assign permissions to roles
 -- Admin gets all permissions
@@ -74,7 +79,7 @@ FROM roles r, permissions p
 WHERE r.name = 'branch_manager'
 AND p.name IN (
     'agent:create', 'agent:read', 'agent:update', 'agent:delete', 'agent:list',
-    'customer:create', 'customer:read', 'customer:update', 'customer:delete', 'customer:list',
+    'customer:create', 'customer:read', 'customer:update', 'customer:delete', 'customer:list', 'customer:read_pii',
     'policy:create', 'policy:read', 'policy:update', 'policy:delete', 'policy:list',
     'quote:create', 'quote:read', 'quote:update', 'quote:delete', 'quote:list',
     'report:read', 'report:export'
@@ -105,11 +110,16 @@ AND p.name IN (
 );
 
 -- Insert demo users
-INSERT INTO users (email, password_hash, role, twofa_enabled, is_active) VALUES
-('admin@eesigorta.com', '$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi', 'admin', false, true),
-('manager@eesigorta.com', '$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi', 'branch_manager', false, true),
-('agent@eesigorta.com', '$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi', 'agent', false, true),
-('viewer@eesigorta.com', '$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi', 'viewer', false, true);
+INSERT INTO users (email, password_hash, role_id, twofa_enabled, is_active)
+SELECT u.email, '$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi', r.id, false, true
+FROM (VALUES
+    (1, 'admin@eesigorta.com', 'admin'),
+    (2, 'manager@eesigorta.com', 'branch_manager'),
+    (3, 'agent@eesigorta.com', 'agent'),
+    (4, 'viewer@eesigorta.com', 'viewer')
+) AS u(ord, email, role)
+JOIN roles r ON r.name = u.role
+ORDER BY u.ord;
 
 -- Insert demo branches
 INSERT INTO branches (name, city, address, phone, email, manager_id) VALUES
@@ -124,13 +134,20 @@ INSERT INTO agents (branch_id, name, phone, email, license_no) VALUES
 (2, 'Mehmet Kaya', '0555 345 67 89', 'mehmet.kaya@eesigorta.com', 'AGT003'),
 (3, 'Ayşe Özkan', '0555 456 78 90', 'ayse.ozkan@eesigorta.com', 'AGT004');
 
+-- Assign demo users to their branch and agent record
+UPDATE users SET branch_id = 1 WHERE email = 'manager@eesigorta.com';
+UPDATE users SET branch_id = 1, agent_id = 1 WHERE email = 'agent@eesigorta.com';
+
 -- Insert demo customers
 INSERT INTO customers (tc_vkn, name, email, phone, address, city, district, postal_code, gender) VALUES
-('12345678901', 'Ali Veli', 'ali.veli@email.com', '0555 111 22 33', 'Kadıköy Mah. No:1', 'İstanbul', 'Kadıköy', '34710', 'male'),
-('12345678902', 'Ayşe Yılmaz', 'ayse.yilmaz@email.com', '0555 222 33 44', 'Çankaya Mah. No:2', 'Ankara', 'Çankaya', '06420', 'female'),
-('12345678903', 'Mehmet Demir', 'mehmet.demir@email.com', '0555 333 44 55', 'Konak Mah. No:3', 'İzmir', 'Konak', '35250', 'male'),
-('12345678904', 'Fatma Kaya', 'fatma.kaya@email.com', '0555 444 55 66', 'Bornova Mah. No:4', 'İzmir', 'Bornova', '35050', 'female'),
-('12345678905', 'Mustafa Özkan', 'mustafa.ozkan@email.com', '0555 555 66 77', 'Beşiktaş Mah. No:5', 'İstanbul', 'Beşiktaş', '34353', 'male');
+('12345678950', 'Ali Veli', 'ali.veli@email.com', '0555 111 22 33', 'Kadıköy Mah. No:1', 'İstanbul', 'Kadıköy', '34710', 'male'),
+('23456789138', 'Ayşe Yılmaz', 'ayse.yilmaz@email.com', '0555 222 33 44', 'Çankaya Mah. No:2', 'Ankara', 'Çankaya', '06420', 'female'),
+('34567891238', 'Mehmet Demir', 'mehmet.demir@email.com', '0555 333 44 55', 'Konak Mah. No:3', 'İzmir', 'Konak', '35250', 'male'),
+('45678912316', 'Fatma Kaya', 'fatma.kaya@email.com', '0555 444 55 66', 'Bornova Mah. No:4', 'İzmir', 'Bornova', '35050', 'female'),
+('56789123416', 'Mustafa Özkan', 'mustafa.ozkan@email.com', '0555 555 66 77', 'Beşiktaş Mah. No:5', 'İstanbul', 'Beşiktaş', '34353', 'male');
+
+INSERT INTO customers (tc_vkn, customer_type, name, email, phone, address, city, district, postal_code, tax_office, trade_title, authorized_person) VALUES
+('1234567890', 'kurumsal', 'Demir Lojistik', 'info@demirlojistik.com', '0216 555 66 77', 'Tuzla OSB No:6', 'İstanbul', 'Tuzla', '34956', 'Tuzla', 'Demir Lojistik Taşımacılık A.Ş.', 'Mehmet Demir');
 
 -- Insert demo products
 INSERT INTO products (type, name, description, params_json) VALUES
@@ -191,7 +208,7 @@ INSERT INTO scraped_rows (target_id, hash_key, url, type, raw_json, normalized_j
 -- Insert demo audit logs
 INSERT INTO audit_logs (user_id, action, entity, entity_id, ip, user_agent, meta_json) VALUES
 (1, 'login', 'user', 1, '192.168.1.100', 'Mozilla/5.0 (Windows NT 10.0; Win64; x64)', '{"email": "admin@eesigorta.com"}'),
-(2, 'customer_created', 'customer', 1, '192.168.1.101', 'Mozilla/5.0 (Windows NT 10.0; Win64; x64)', '{"tc_vkn": "12345678901", "name": "Ali Veli"}'),
+(2, 'customer_created', 'customer', 1, '192.168.1.101', 'Mozilla/5.0 (Windows NT 10.0; Win64; x64)', '{"tc_vkn": "12345678950", "name": "Ali Veli"}'),
 (3, 'policy_created', 'policy', 1, '192.168.1.102', 'Mozilla/5.0 (Windows NT 10.0; Win64; x64)', '{"policy_no": "POL001", "premium": 2500.00}'),
 (1, 'scraper_run', 'scraper_run', 1, '192.168.1.100', 'EESigorta-Scraper/1.0', '{"target": "Sigorta Şirketi A", "status": "completed"}');
 
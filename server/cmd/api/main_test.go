@@ -1,19 +1,41 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
-	apih "eesigorta/backend/internal/api" // <-- package alias çakışmasını önledik
+	apih "eesigorta/backend/internal/api"
 	"eesigorta/backend/internal/auth"
 	"eesigorta/backend/internal/config"
+	"eesigorta/backend/internal/jobs"
 	"eesigorta/backend/internal/rbac"
 	"eesigorta/backend/internal/repo"
+	"eesigorta/backend/internal/validation"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hibiken/asynq"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gorm.io/driver/sqlite"
@@ -29,7 +51,7 @@ type TestDeps struct {
 	Repo     *repo.Repository
 	JWTMgr   *auth.JWTManager
 	TOTPMgr  *auth.TOTPManager
-	RBACMgr  *rbac.Manager
+	RBACMgr  *rbac.RBACManager
 	Router   *gin.Engine
 	JWTConf  config.JWTConfig
 	TOTPConf config.TOTPConfig
@@ -44,11 +66,19 @@ func setupTestDB(t *testing.T) *gorm.DB {
 	// Auto-migrate tables
 	err = db.AutoMigrate(
 		&repo.User{},
+		&repo.RefreshToken{},
+		&repo.Session{},
+		&repo.RecoveryCode{},
+		&repo.PasswordHistory{},
 		&repo.Branch{},
 		&repo.Agent{},
 		&repo.Customer{},
+		&repo.CustomerDuplicate{},
+		&repo.CustomerConsent{},
+		&repo.CustomerImport{},
 		&repo.Product{},
 		&repo.Quote{},
+		&repo.ScrapedQuote{},
 		&repo.Policy{},
 		&repo.Account{},
 		&repo.Payment{},
@@ -59,6 +89,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 		&repo.Permission{},
 		&repo.Role{},
 		&repo.RolePermission{},
+		&repo.PermissionGrant{},
 	)
 	require.NoError(t, err, "auto-migrate failed")
 
@@ -72,61 +103,65 @@ func setupTestDeps(t *testing.T) *TestDeps {
 
 	db := setupTestDB(t)
 
-	// Repository; alan ismi senin projende farklıysa (DB/db) uyarlayabilirsin
-	r := &repo.Repository{DB: db}
+	r := repo.NewRepositoryWithDB(db)
 
 	cfg := &config.Config{
 		JWT: config.JWTConfig{
 			Secret:     "test-secret",
-			AccessTTL:  15,  // minutes
-			RefreshTTL: 168, // hours (7d)
+			AccessTTL:  15 * time.Minute,
+			RefreshTTL: 168 * time.Hour, // 7d
 		},
 		TOTP: config.TOTPConfig{
-			Issuer: "TestEESigorta",
+			Issuer:        "TestEESigorta",
+			Period:        30,
+			Skew:          1,
+			Digits:        6,
+			MaxAttempts:   5,
+			AttemptWindow: 15 * time.Minute,
+		},
+		Login: config.LoginConfig{
+			MaxFailures:     5,
+			FailureWindow:   15 * time.Minute,
+			LockoutDuration: 15 * time.Minute,
+			DelayAfter:      5, // no delays in tests
+			MaxDelay:        time.Second,
+			MaxIPFailures:   50,
+			RateLimitPerMin: 100,
+		},
+		Password: config.PasswordConfig{
+			MinLength:    8,
+			RequireUpper: true,
+			RequireLower: true,
+			RequireDigit: true,
+			HistorySize:  3,
 		},
 	}
 
 	jwtMgr := auth.NewJWTManager(cfg.JWT.Secret, cfg.JWT.AccessTTL, cfg.JWT.RefreshTTL)
-	totpMgr := auth.NewTOTPManager(cfg.TOTP.Issuer)
-	rbacMgr := rbac.NewRBACManager(db)
+	attempts := auth.NewMemoryAttemptCounter()
+	totpMgr := auth.NewTOTPManager(cfg.TOTP, attempts)
+	loginGuard := auth.NewLoginGuard(cfg.Login, attempts)
+	passwordPolicy := auth.NewPasswordPolicy(cfg.Password)
+	rbacMgr := rbac.NewRBACManager(db, cfg.RBAC)
 
 	// RBAC seed
 	require.NoError(t, rbacMgr.InitializeRoles(), "rbac initialize failed")
 
-	authHandler := apih.NewAuthHandler(r, jwtMgr, totpMgr)
-	customerHandler := apih.NewCustomerHandler(r)
-
-	router := gin.New()
-
-	// Routes
-	apiV1 := router.Group("/api/v1") // <-- 'api' DEĞİL, alias çakışması yok
-	{
-		authGroup := apiV1.Group("/auth")
-		{
-			authGroup.POST("/login", authHandler.Login)
-			authGroup.POST("/refresh", authHandler.RefreshToken)
-			authGroup.POST("/logout", authHandler.Logout)
-		}
-
-		protected := apiV1.Group("")
-		protected.Use(apih.AuthMiddleware(jwtMgr)) // <-- package fonksiyonu
-		{
-			protected.GET("/me", authHandler.GetMe)
-			protected.POST("/auth/2fa/enable", authHandler.Enable2FA)
-			protected.POST("/auth/2fa/verify", authHandler.Verify2FA)
-			protected.POST("/auth/2fa/login", authHandler.Verify2FALogin)
-
-			customers := protected.Group("/customers")
-			customers.Use(apih.PaginationMiddleware())
-			{
-				customers.GET("", apih.RBACMiddleware(rbacMgr, rbac.PermissionCustomerList), customerHandler.GetCustomers)
-				customers.GET("/:id", apih.RBACMiddleware(rbacMgr, rbac.PermissionCustomerRead), customerHandler.GetCustomer)
-				customers.POST("", apih.RBACMiddleware(rbacMgr, rbac.PermissionCustomerCreate), customerHandler.CreateCustomer)
-				customers.PUT("/:id", apih.RBACMiddleware(rbacMgr, rbac.PermissionCustomerUpdate), customerHandler.UpdateCustomer)
-				customers.DELETE("/:id", apih.RBACMiddleware(rbacMgr, rbac.PermissionCustomerDelete), customerHandler.DeleteCustomer)
-			}
-		}
-	}
+	// Enqueue yalnızca geçerli isteklerde Redis'e bağlanır
+	jobClient := jobs.NewClient(cfg)
+	t.Cleanup(func() { jobClient.Close() })
+
+	router := newRouter(routerDeps{
+		cfg:            cfg,
+		repo:           r,
+		jwtMgr:         jwtMgr,
+		totpMgr:        totpMgr,
+		loginGuard:     loginGuard,
+		passwordPolicy: passwordPolicy,
+		rbacMgr:        rbacMgr,
+		attempts:       attempts,
+		jobs:           jobClient,
+	})
 
 	return &TestDeps{
 		DB:       db,
@@ -140,6 +175,86 @@ func setupTestDeps(t *testing.T) *TestDeps {
 	}
 }
 
+// roleID seed edilmiş bir rolün ID'sini döner
+func roleID(t *testing.T, td *TestDeps, name string) uint {
+	t.Helper()
+
+	var role repo.Role
+	require.NoError(t, td.DB.Where("name = ?", name).First(&role).Error)
+	return role.ID
+}
+
+// issueTokens bir kullanıcı için token çifti ve AuthMiddleware'in aradığı
+// oturum kaydını oluşturur
+func issueTokens(t *testing.T, td *TestDeps, user repo.User) *auth.TokenPair {
+	t.Helper()
+
+	tokenPair, err := td.JWTMgr.GenerateTokenPair(user.ID, user.Email, user.RoleName())
+	require.NoError(t, err)
+
+	require.NoError(t, td.Repo.CreateSession(&repo.Session{
+		FamilyID:   tokenPair.FamilyID,
+		UserID:     user.ID,
+		ExpiresAt:  tokenPair.RefreshExpiresAt,
+		LastSeenAt: time.Now(),
+	}))
+
+	return tokenPair
+}
+
+// doJSON router'a JSON gövdeli bir istek gönderir. token boşsa
+// Authorization başlığı eklenmez.
+func doJSON(t *testing.T, td *TestDeps, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req, _ := http.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	td.Router.ServeHTTP(w, req)
+	return w
+}
+
+// login e-posta ve şifreyle giriş yapar, 200 bekler
+func login(t *testing.T, td *TestDeps, email, password string) apih.LoginResponse {
+	t.Helper()
+
+	w := doJSON(t, td, "POST", "/api/v1/auth/login", "", map[string]string{"email": email, "password": password})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response apih.LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+// doFrom router'a verilen bağlantı adresinden ve X-Forwarded-For
+// başlığıyla bir istek gönderir
+func doFrom(t *testing.T, td *TestDeps, remoteAddr, forwardedFor, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req, _ := http.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+
+	w := httptest.NewRecorder()
+	td.Router.ServeHTTP(w, req)
+	return w
+}
+
 /***************
  *   TESTS     *
  ***************/
@@ -154,7 +269,7 @@ func TestLogin(t *testing.T) {
 	user := repo.User{
 		Email:        "test@example.com",
 		PasswordHash: hashedPassword,
-		Role:         "admin",
+		RoleID:       roleID(t, td, "admin"),
 		IsActive:     true,
 	}
 	require.NoError(t, td.DB.Create(&user).Error)
@@ -206,6 +321,323 @@ func TestLoginInvalidCredentials(t *testing.T) {
 	assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
 }
 
+func TestLoginLockout(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+	admin := repo.User{Email: "admin@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAdmin), IsActive: true}
+	user := repo.User{Email: "test@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAgent), IsActive: true}
+	require.NoError(t, td.DB.Create(&admin).Error)
+	require.NoError(t, td.DB.Create(&user).Error)
+
+	// MaxFailures hatalı şifreden sonra doğru şifre de kilide takılır
+	for i := 0; i < 5; i++ {
+		w := doJSON(t, td, "POST", "/api/v1/auth/login", "", map[string]string{"email": user.Email, "password": "wrong-password"})
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	w := doJSON(t, td, "POST", "/api/v1/auth/login", "", map[string]string{"email": user.Email, "password": "password123"})
+	assert.Equal(t, http.StatusLocked, w.Code, w.Body.String())
+
+	require.NoError(t, td.DB.First(&user, user.ID).Error)
+	require.NotNil(t, user.LockedUntil)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), *user.LockedUntil, time.Minute)
+	var locked int64
+	require.NoError(t, td.DB.Model(&repo.AuditLog{}).Where("action = ? AND entity_id = ?", "account_locked", user.ID).Count(&locked).Error)
+	assert.Equal(t, int64(1), locked)
+
+	// Kilidi yalnızca yönetici açar, sayaç da sıfırlanır
+	unlockPath := fmt.Sprintf("/api/v1/users/%d/unlock", user.ID)
+	assert.Equal(t, http.StatusForbidden, doJSON(t, td, "POST", unlockPath, issueTokens(t, td, user).AccessToken, nil).Code)
+	require.Equal(t, http.StatusOK, doJSON(t, td, "POST", unlockPath, issueTokens(t, td, admin).AccessToken, nil).Code)
+
+	var unlocked repo.User
+	require.NoError(t, td.DB.First(&unlocked, user.ID).Error)
+	assert.Nil(t, unlocked.LockedUntil)
+	assert.NotNil(t, login(t, td, user.Email, "password123").TokenPair)
+	w = doJSON(t, td, "POST", "/api/v1/auth/login", "", map[string]string{"email": user.Email, "password": "wrong-password"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestLoginIPLimit(t *testing.T) {
+	td := setupTestDeps(t)
+
+	// X-Forwarded-For her denemede değişse de sayaç bağlantı adresinde tutulur
+	for i := 0; i < 50; i++ {
+		w := doFrom(t, td, "192.0.2.10:4000", fmt.Sprintf("198.51.100.%d", i), "POST", "/api/v1/auth/login",
+			map[string]string{"email": fmt.Sprintf("user%d@example.com", i), "password": "wrong-password"})
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	w := doFrom(t, td, "192.0.2.10:4000", "203.0.113.1", "POST", "/api/v1/auth/login",
+		map[string]string{"email": "other@example.com", "password": "wrong-password"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+
+	// Başka bir istemci etkilenmez
+	w = doFrom(t, td, "192.0.2.20:4000", "", "POST", "/api/v1/auth/login",
+		map[string]string{"email": "other@example.com", "password": "wrong-password"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAuthRateLimit(t *testing.T) {
+	td := setupTestDeps(t)
+
+	for i := 0; i < 100; i++ {
+		w := doFrom(t, td, "192.0.2.10:4000", fmt.Sprintf("198.51.100.%d", i), "GET", "/api/v1/auth/password-policy", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// Sahte X-Forwarded-For sınırı aşmaya yetmez
+	w := doFrom(t, td, "192.0.2.10:4000", "203.0.113.1", "GET", "/api/v1/auth/password-policy", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// Sınır /auth dışındaki route'ları ve diğer istemcileri etkilemez
+	assert.Equal(t, http.StatusOK, doFrom(t, td, "192.0.2.10:4000", "", "GET", "/health", nil).Code)
+	assert.Equal(t, http.StatusOK, doFrom(t, td, "192.0.2.20:4000", "", "GET", "/api/v1/auth/password-policy", nil).Code)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+	user := repo.User{Email: "test@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAgent), IsActive: true}
+	require.NoError(t, td.DB.Create(&user).Error)
+
+	first := login(t, td, user.Email, "password123").TokenPair
+	require.NotNil(t, first)
+
+	refresh := func(refreshToken string) *httptest.ResponseRecorder {
+		return doJSON(t, td, "POST", "/api/v1/auth/refresh", "", map[string]string{"refresh_token": refreshToken})
+	}
+
+	// Her yenileme refresh token'ı döndürür, eskisi iptal edilir
+	w := refresh(first.RefreshToken)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var second auth.TokenPair
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &second))
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	w = refresh(second.RefreshToken)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var third auth.TokenPair
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &third))
+
+	var tokens []repo.RefreshToken
+	require.NoError(t, td.DB.Where("user_id = ?", user.ID).Order("id").Find(&tokens).Error)
+	require.Len(t, tokens, 3)
+	assert.Equal(t, tokens[0].FamilyID, tokens[2].FamilyID)
+	assert.Equal(t, tokens[1].JTI, tokens[0].ReplacedBy)
+	assert.NotNil(t, tokens[0].RevokedAt)
+	assert.Nil(t, tokens[2].RevokedAt)
+	assert.Equal(t, http.StatusOK, doJSON(t, td, "GET", "/api/v1/me", third.AccessToken, nil).Code)
+
+	// Döndürülmüş bir token'ın tekrar kullanılması çalındığını gösterir;
+	// bütün aile iptal edilir ve oturum kapanır
+	assert.Equal(t, http.StatusUnauthorized, refresh(first.RefreshToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, refresh(third.RefreshToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, doJSON(t, td, "GET", "/api/v1/me", third.AccessToken, nil).Code)
+
+	var active int64
+	require.NoError(t, td.DB.Model(&repo.RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&active).Error)
+	assert.Zero(t, active)
+	var reuse int64
+	require.NoError(t, td.DB.Model(&repo.AuditLog{}).Where("action = ? AND user_id = ?", "refresh_token_reuse", user.ID).Count(&reuse).Error)
+	assert.Equal(t, int64(1), reuse)
+
+	// Çıkış yapılan oturumun token'ı yenilenemez, diğer oturumlar etkilenmez
+	other := login(t, td, user.Email, "password123").TokenPair
+	last := login(t, td, user.Email, "password123").TokenPair
+	require.Equal(t, http.StatusOK, doJSON(t, td, "POST", "/api/v1/auth/logout", "", map[string]string{"refresh_token": other.RefreshToken}).Code)
+	assert.Equal(t, http.StatusUnauthorized, refresh(other.RefreshToken).Code)
+	assert.Equal(t, http.StatusOK, refresh(last.RefreshToken).Code)
+
+	// Access token refresh token yerine kullanılamaz
+	assert.Equal(t, http.StatusUnauthorized, refresh(last.AccessToken).Code)
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+	secret, err := td.TOTPMgr.GenerateSecret("test@example.com")
+	require.NoError(t, err)
+	user := repo.User{
+		Email: "test@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAgent),
+		IsActive: true, TwoFAEnabled: true, TwoFASecret: secret.Secret,
+	}
+	require.NoError(t, td.DB.Create(&user).Error)
+
+	// Şifre tek başına yalnızca kod adımı için kısa ömürlü bir token verir
+	pending := login(t, td, user.Email, "password123")
+	assert.True(t, pending.Requires2FA)
+	assert.Nil(t, pending.TokenPair)
+	require.NotEmpty(t, pending.MFAToken)
+
+	var sessions int64
+	require.NoError(t, td.DB.Model(&repo.Session{}).Where("user_id = ?", user.ID).Count(&sessions).Error)
+	assert.Zero(t, sessions)
+
+	// mfa_token ne API'ye erişim ne de yenileme için kullanılabilir
+	assert.Equal(t, http.StatusUnauthorized, doJSON(t, td, "GET", "/api/v1/me", pending.MFAToken, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, doJSON(t, td, "POST", "/api/v1/auth/refresh", "", map[string]string{"refresh_token": pending.MFAToken}).Code)
+
+	verify := func(mfaToken, code string) *httptest.ResponseRecorder {
+		return doJSON(t, td, "POST", "/api/v1/auth/2fa/login", "", map[string]string{"mfa_token": mfaToken, "code": code})
+	}
+	code, err := td.TOTPMgr.GenerateCode(secret.Secret)
+	require.NoError(t, err)
+
+	// Access token mfa_token yerine geçmez
+	other := issueTokens(t, td, user)
+	assert.Equal(t, http.StatusUnauthorized, verify(other.AccessToken, code).Code)
+	assert.Equal(t, http.StatusUnauthorized, verify("invalid", code).Code)
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	assert.Equal(t, http.StatusUnauthorized, verify(pending.MFAToken, wrong).Code)
+
+	w := verify(pending.MFAToken, code)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response apih.LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.False(t, response.Requires2FA)
+	require.NotNil(t, response.TokenPair)
+	assert.Equal(t, http.StatusOK, doJSON(t, td, "GET", "/api/v1/me", response.TokenPair.AccessToken, nil).Code)
+	assert.NotNil(t, response.User.LastLoginAt)
+
+	// Kod adımına gelmeden devre dışı bırakılan kullanıcı giriş yapamaz
+	pending = login(t, td, user.Email, "password123")
+	require.NoError(t, td.DB.Model(&user).Update("is_active", false).Error)
+	assert.Equal(t, http.StatusUnauthorized, verify(pending.MFAToken, code).Code)
+}
+
+func TestTOTPReplay(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+	user := repo.User{Email: "test@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAgent), IsActive: true}
+	require.NoError(t, td.DB.Create(&user).Error)
+	token := issueTokens(t, td, user).AccessToken
+
+	w := doJSON(t, td, "POST", "/api/v1/auth/2fa/enable", token, map[string]string{"password": "password123"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var setup apih.Enable2FAResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &setup))
+
+	secondFactor := func(code string) int {
+		pending := login(t, td, user.Email, "password123")
+		require.True(t, pending.Requires2FA)
+		return doJSON(t, td, "POST", "/api/v1/auth/2fa/login", "", map[string]string{"mfa_token": pending.MFAToken, "code": code}).Code
+	}
+
+	// Kurulumu onaylayan kod girişte tekrar kullanılamaz
+	code, err := td.TOTPMgr.GenerateCode(setup.Secret)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, doJSON(t, td, "POST", "/api/v1/auth/2fa/verify", token, map[string]string{"code": code}).Code)
+	assert.Equal(t, http.StatusUnauthorized, secondFactor(code))
+
+	// Girişte kabul edilen kod, yeni bir mfa_token ile de reddedilir
+	next, err := totp.GenerateCodeCustom(setup.Secret, time.Now().Add(30*time.Second), totp.ValidateOpts{
+		Period: 30, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1,
+	})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, secondFactor(next))
+	assert.Equal(t, http.StatusUnauthorized, secondFactor(next))
+
+	// Son giriş kaydedilirken kabul edilen adım geri yazılmaz
+	require.NoError(t, td.DB.First(&user, user.ID).Error)
+	assert.NotNil(t, user.LastLoginAt)
+	assert.NotZero(t, user.TOTPLastStep)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+	admin := repo.User{Email: "admin@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAdmin), IsActive: true}
+	user := repo.User{Email: "test@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAgent), IsActive: true}
+	require.NoError(t, td.DB.Create(&admin).Error)
+	require.NoError(t, td.DB.Create(&user).Error)
+	token := issueTokens(t, td, user).AccessToken
+
+	// Kurulum şifreyle başlar, ilk geçerli kodla tamamlanır
+	assert.Equal(t, http.StatusUnauthorized, doJSON(t, td, "POST", "/api/v1/auth/2fa/enable", token, map[string]string{"password": "wrong-password"}).Code)
+	w := doJSON(t, td, "POST", "/api/v1/auth/2fa/enable", token, map[string]string{"password": "password123"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var setup apih.Enable2FAResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &setup))
+	require.NotEmpty(t, setup.Secret)
+
+	code, err := td.TOTPMgr.GenerateCode(setup.Secret)
+	require.NoError(t, err)
+	w = doJSON(t, td, "POST", "/api/v1/auth/2fa/verify", token, map[string]string{"code": code})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var verified apih.Verify2FAResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &verified))
+	require.Len(t, verified.RecoveryCodes, auth.RecoveryCodeCount)
+
+	// Açıkken yeniden kurulum başlatılamaz, kayıtlı secret değişmez
+	w = doJSON(t, td, "POST", "/api/v1/auth/2fa/enable", token, map[string]string{"password": "password123"})
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	var enabled repo.User
+	require.NoError(t, td.DB.First(&enabled, user.ID).Error)
+	assert.Equal(t, setup.Secret, enabled.TwoFASecret)
+
+	// Kodlar yalnızca hash'leriyle saklanır
+	var stored []repo.RecoveryCode
+	require.NoError(t, td.DB.Where("user_id = ?", user.ID).Find(&stored).Error)
+	require.Len(t, stored, auth.RecoveryCodeCount)
+	for _, rc := range stored {
+		assert.NotContains(t, verified.RecoveryCodes, rc.CodeHash)
+	}
+
+	loginWith := func(code string) int {
+		pending := login(t, td, user.Email, "password123")
+		require.True(t, pending.Requires2FA)
+		return doJSON(t, td, "POST", "/api/v1/auth/2fa/login", "", map[string]string{"mfa_token": pending.MFAToken, "code": code}).Code
+	}
+
+	// Kurtarma kodu büyük harfle ve tiresiz de kabul edilir, bir kez kullanılır
+	first := verified.RecoveryCodes[0]
+	assert.Equal(t, http.StatusOK, loginWith(strings.ToUpper(strings.ReplaceAll(first, "-", ""))))
+	assert.Equal(t, http.StatusUnauthorized, loginWith(first))
+	var used int64
+	require.NoError(t, td.DB.Model(&repo.AuditLog{}).Where("action = ? AND user_id = ?", "2fa_recovery_code_used", user.ID).Count(&used).Error)
+	assert.Equal(t, int64(1), used)
+
+	// Kapatmak şifre ve ikinci faktör ister
+	disable := func(password, code string) int {
+		return doJSON(t, td, "POST", "/api/v1/auth/2fa/disable", token, map[string]string{"password": password, "code": code}).Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, disable("wrong-password", verified.RecoveryCodes[1]))
+	assert.Equal(t, http.StatusUnauthorized, disable("password123", first))
+	assert.Equal(t, http.StatusOK, disable("password123", verified.RecoveryCodes[1]))
+	assert.Equal(t, http.StatusBadRequest, disable("password123", verified.RecoveryCodes[2]))
+
+	require.NoError(t, td.DB.First(&user, user.ID).Error)
+	assert.False(t, user.TwoFAEnabled)
+	assert.Empty(t, user.TwoFASecret)
+	var remaining int64
+	require.NoError(t, td.DB.Model(&repo.RecoveryCode{}).Where("user_id = ?", user.ID).Count(&remaining).Error)
+	assert.Zero(t, remaining)
+	assert.False(t, login(t, td, user.Email, "password123").Requires2FA)
+
+	// Authenticator'ını ve kodlarını kaybeden kullanıcının 2FA'sını yönetici sıfırlar
+	require.NoError(t, td.DB.Model(&user).Updates(map[string]interface{}{"two_fa_enabled": true, "twofa_secret": setup.Secret}).Error)
+	resetPath := fmt.Sprintf("/api/v1/users/%d/2fa/reset", user.ID)
+	assert.Equal(t, http.StatusForbidden, doJSON(t, td, "POST", resetPath, token, nil).Code)
+	require.Equal(t, http.StatusOK, doJSON(t, td, "POST", resetPath, issueTokens(t, td, admin).AccessToken, nil).Code)
+	require.NoError(t, td.DB.First(&user, user.ID).Error)
+	assert.False(t, user.TwoFAEnabled)
+	assert.Empty(t, user.TwoFASecret)
+}
+
 func TestGetMe(t *testing.T) {
 	td := setupTestDeps(t)
 
@@ -215,13 +647,12 @@ func TestGetMe(t *testing.T) {
 	user := repo.User{
 		Email:        "test@example.com",
 		PasswordHash: hashedPassword,
-		Role:         "admin",
+		RoleID:       roleID(t, td, "admin"),
 		IsActive:     true,
 	}
 	require.NoError(t, td.DB.Create(&user).Error)
 
-	tokenPair, err := td.JWTMgr.GenerateTokenPair(user.ID, user.Email, user.Role)
-	require.NoError(t, err)
+	tokenPair := issueTokens(t, td, user)
 
 	req, _ := http.NewRequest("GET", "/api/v1/me", nil)
 	req.Header.Set("Authorization", "Bearer "+tokenPair.AccessToken)
@@ -237,7 +668,423 @@ func TestGetMe(t *testing.T) {
 
 	assert.Equal(t, float64(user.ID), response["id"])
 	assert.Equal(t, user.Email, response["email"])
-	assert.Equal(t, user.Role, response["role"])
+	assert.Equal(t, "admin", response["role"])
+}
+
+func TestMePermissions(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	branch := repo.Branch{Name: "Kadıköy"}
+	require.NoError(t, td.DB.Create(&branch).Error)
+
+	user := repo.User{
+		Email:        "agent@example.com",
+		PasswordHash: hashedPassword,
+		RoleID:       roleID(t, td, rbac.RoleAgent),
+		BranchID:     &branch.ID,
+		IsActive:     true,
+	}
+	require.NoError(t, td.DB.Create(&user).Error)
+	token := issueTokens(t, td, user).AccessToken
+
+	req, _ := http.NewRequest("GET", "/api/v1/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	td.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var me apih.MeResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &me))
+	assert.Equal(t, user.Email, me.Email)
+	assert.Contains(t, me.Permissions, rbac.PermissionPolicyCreate)
+	assert.NotContains(t, me.Permissions, rbac.PermissionPolicyDelete)
+	assert.True(t, slices.IsSorted(me.Permissions))
+	assert.Equal(t, repo.ScopeOwn, me.DataScope.Level)
+	require.NotNil(t, me.DataScope.BranchID)
+	assert.Equal(t, branch.ID, *me.DataScope.BranchID)
+
+	// Unknown permissions are simply not held
+	body, _ := json.Marshal(map[string]interface{}{
+		"permissions": []string{rbac.PermissionPolicyCreate, rbac.PermissionPolicyDelete, "policy:teleport"},
+	})
+	req, _ = http.NewRequest("POST", "/api/v1/auth/check", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	td.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var check apih.CheckPermissionsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &check))
+	assert.Equal(t, map[string]bool{
+		rbac.PermissionPolicyCreate: true,
+		rbac.PermissionPolicyDelete: false,
+		"policy:teleport":           false,
+	}, check.Permissions)
+
+	req, _ = http.NewRequest("POST", "/api/v1/auth/check", bytes.NewBufferString(`{"permissions": []}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	td.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+}
+
+func TestUsersAPI(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+	admin := repo.User{Email: "admin@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAdmin), IsActive: true}
+	require.NoError(t, td.DB.Create(&admin).Error)
+	token := issueTokens(t, td, admin).AccessToken
+	agentRole := roleID(t, td, rbac.RoleAgent)
+
+	// Oluşturma şifre politikasını, rolü ve e-postanın tekliğini denetler
+	create := func(email, password string, roleID uint) *httptest.ResponseRecorder {
+		return doJSON(t, td, "POST", "/api/v1/users", token, map[string]interface{}{"email": email, "password": password, "role_id": roleID})
+	}
+	assert.Equal(t, http.StatusBadRequest, create("agent@example.com", "short", agentRole).Code)
+	assert.Equal(t, http.StatusBadRequest, create("agent@example.com", "Kasa2024Guvenli", 9999).Code)
+	w := create("agent@example.com", "Kasa2024Guvenli", agentRole)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created apih.UserResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, rbac.RoleAgent, created.Role)
+	assert.True(t, created.IsActive)
+	assert.Equal(t, http.StatusConflict, create("agent@example.com", "Kasa2024Guvenli", agentRole).Code)
+
+	var history int64
+	require.NoError(t, td.DB.Model(&repo.PasswordHistory{}).Where("user_id = ?", created.ID).Count(&history).Error)
+	assert.Equal(t, int64(1), history)
+
+	// Liste e-posta, rol ve duruma göre süzülür
+	list := func(query string) (int, []apih.UserResponse) {
+		w := doJSON(t, td, "GET", "/api/v1/users?"+query, token, nil)
+		var page struct {
+			Data []apih.UserResponse `json:"data"`
+		}
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		}
+		return w.Code, page.Data
+	}
+	code, users := list("")
+	require.Equal(t, http.StatusOK, code)
+	assert.Len(t, users, 2)
+	_, users = list("query=AGENT")
+	require.Len(t, users, 1)
+	assert.Equal(t, created.ID, users[0].ID)
+	_, users = list(fmt.Sprintf("role_id=%d", roleID(t, td, rbac.RoleAdmin)))
+	require.Len(t, users, 1)
+	assert.Equal(t, admin.ID, users[0].ID)
+	code, _ = list("role_id=abc")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = list("is_active=maybe")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	userPath := fmt.Sprintf("/api/v1/users/%d", created.ID)
+	assert.Equal(t, http.StatusOK, doJSON(t, td, "GET", userPath, token, nil).Code)
+	assert.Equal(t, http.StatusNotFound, doJSON(t, td, "GET", "/api/v1/users/9999", token, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, doJSON(t, td, "GET", "/api/v1/users/abc", token, nil).Code)
+
+	// Rol değişir, kullanıcı kendi rolünü değiştiremez
+	viewerRole := roleID(t, td, rbac.RoleViewer)
+	w = doJSON(t, td, "PUT", userPath+"/role", token, map[string]uint{"role_id": viewerRole})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, rbac.RoleViewer, created.Role)
+	assert.Equal(t, http.StatusBadRequest, doJSON(t, td, "PUT", fmt.Sprintf("/api/v1/users/%d/role", admin.ID), token, map[string]uint{"role_id": viewerRole}).Code)
+
+	// Pasife alınan kullanıcının oturumları biter ve giriş yapamaz
+	userToken := login(t, td, "agent@example.com", "Kasa2024Guvenli").TokenPair.AccessToken
+	require.Equal(t, http.StatusOK, doJSON(t, td, "POST", userPath+"/deactivate", token, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, doJSON(t, td, "GET", "/api/v1/me", userToken, nil).Code)
+	w = doJSON(t, td, "POST", "/api/v1/auth/login", "", map[string]string{"email": "agent@example.com", "password": "Kasa2024Guvenli"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	_, users = list("is_active=false")
+	require.Len(t, users, 1)
+	assert.Equal(t, created.ID, users[0].ID)
+	assert.Equal(t, http.StatusBadRequest, doJSON(t, td, "POST", fmt.Sprintf("/api/v1/users/%d/deactivate", admin.ID), token, nil).Code)
+
+	require.Equal(t, http.StatusOK, doJSON(t, td, "POST", userPath+"/activate", token, nil).Code)
+	userToken = login(t, td, "agent@example.com", "Kasa2024Guvenli").TokenPair.AccessToken
+
+	// Şifre sıfırlama oturumları bitirir, geçici şifre yalnızca şifre değiştirmeye yarar
+	w = doJSON(t, td, "POST", userPath+"/password-reset", token, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var reset apih.PasswordResetResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reset))
+	require.NotEmpty(t, reset.TemporaryPassword)
+	assert.Equal(t, http.StatusUnauthorized, doJSON(t, td, "GET", "/api/v1/me", userToken, nil).Code)
+
+	temporary := login(t, td, "agent@example.com", reset.TemporaryPassword)
+	assert.True(t, temporary.User.MustChangePassword)
+	assert.Equal(t, http.StatusForbidden, doJSON(t, td, "GET", "/api/v1/customers", temporary.TokenPair.AccessToken, nil).Code)
+	var logged int64
+	require.NoError(t, td.DB.Model(&repo.AuditLog{}).Where("action = ? AND entity_id = ?", "password_reset", created.ID).Count(&logged).Error)
+	assert.Equal(t, int64(1), logged)
+
+	// Silinen kullanıcı bulunamaz, e-postası yeniden kullanılamaz
+	assert.Equal(t, http.StatusBadRequest, doJSON(t, td, "DELETE", fmt.Sprintf("/api/v1/users/%d", admin.ID), token, nil).Code)
+	require.Equal(t, http.StatusOK, doJSON(t, td, "DELETE", userPath, token, nil).Code)
+	assert.Equal(t, http.StatusNotFound, doJSON(t, td, "GET", userPath, token, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, doJSON(t, td, "GET", "/api/v1/me", temporary.TokenPair.AccessToken, nil).Code)
+	assert.Equal(t, http.StatusConflict, create("agent@example.com", "Kasa2024Guvenli", agentRole).Code)
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	td := setupTestDeps(t)
+
+	w := doJSON(t, td, "GET", "/api/v1/auth/password-policy", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var policy auth.PasswordPolicy
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &policy))
+	assert.Equal(t, 8, policy.MinLength)
+	assert.True(t, policy.RequireUpper)
+	assert.Equal(t, 3, policy.HistorySize)
+
+	hash, err := auth.HashPassword("Baslangic1")
+	require.NoError(t, err)
+	user := repo.User{Email: "ayse.kaya@example.com", PasswordHash: hash, RoleID: roleID(t, td, rbac.RoleAgent), IsActive: true}
+	require.NoError(t, td.DB.Create(&user).Error)
+	require.NoError(t, td.Repo.SetPassword(&user, hash, false, 3))
+	token := issueTokens(t, td, user).AccessToken
+
+	current := "Baslangic1"
+	change := func(newPassword string) *httptest.ResponseRecorder {
+		w := doJSON(t, td, "PUT", "/api/v1/me/password", token, map[string]string{"current_password": current, "new_password": newPassword})
+		if w.Code == http.StatusOK {
+			current = newPassword
+		}
+		return w
+	}
+
+	// Kurala uymayan her şifre, ihlal edilen tüm kurallarla reddedilir
+	w = change("kisa")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "at least 8 characters")
+	assert.Contains(t, w.Body.String(), "uppercase letter")
+	assert.Contains(t, w.Body.String(), "digit")
+	assert.Contains(t, change("Password123").Body.String(), "is too common")
+	assert.Contains(t, change("Ayse.Kaya2024").Body.String(), "email address")
+
+	w = doJSON(t, td, "PUT", "/api/v1/me/password", token, map[string]string{"current_password": "wrong-password", "new_password": "Yenisifre1"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Son HistorySize şifre tekrar kullanılamaz
+	assert.Equal(t, http.StatusBadRequest, change("Baslangic1").Code)
+	require.Equal(t, http.StatusOK, change("Yenisifre1").Code)
+	assert.Equal(t, http.StatusBadRequest, change("Baslangic1").Code)
+	require.Equal(t, http.StatusOK, change("Yenisifre2").Code)
+	require.Equal(t, http.StatusOK, change("Yenisifre3").Code)
+	assert.Equal(t, http.StatusBadRequest, change("Yenisifre1").Code)
+
+	var history int64
+	require.NoError(t, td.DB.Model(&repo.PasswordHistory{}).Where("user_id = ?", user.ID).Count(&history).Error)
+	assert.Equal(t, int64(3), history)
+	assert.Equal(t, http.StatusOK, change("Baslangic1").Code)
+
+	// Süresi dolan şifreyle giriş yapılır ama yalnızca şifre değiştirilebilir
+	expiring := auth.NewPasswordPolicy(config.PasswordConfig{MaxAge: 90 * 24 * time.Hour})
+	assert.True(t, expiring.Expired(time.Now().AddDate(0, 0, -91)))
+	assert.False(t, expiring.Expired(time.Now().AddDate(0, 0, -89)))
+	assert.False(t, auth.NewPasswordPolicy(config.PasswordConfig{}).Expired(time.Now().AddDate(-5, 0, 0)))
+
+	require.NoError(t, td.DB.Model(&user).Update("must_change_password", true).Error)
+	pending := login(t, td, user.Email, current)
+	assert.True(t, pending.User.MustChangePassword)
+	token = pending.TokenPair.AccessToken
+	assert.Equal(t, http.StatusForbidden, doJSON(t, td, "GET", "/api/v1/customers", token, nil).Code)
+	assert.Equal(t, http.StatusOK, doJSON(t, td, "GET", "/api/v1/me", token, nil).Code)
+	require.Equal(t, http.StatusOK, change("Yenisifre4").Code)
+	assert.Equal(t, http.StatusOK, doJSON(t, td, "GET", "/api/v1/customers", token, nil).Code)
+}
+
+// writeKey bir anahtarı PEM olarak dizine <kid>.pem adıyla yazar
+func writeKey(t *testing.T, dir, kid, blockType string, der []byte) {
+	t.Helper()
+
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0o600))
+}
+
+func TestJWTKeySet(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+	writeKey(t, dir, "2025-01", "PRIVATE KEY", der)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err = x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	writeKey(t, dir, "2025-02", "PRIVATE KEY", der)
+
+	retired, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	retiredPublic, err := x509.MarshalPKIXPublicKey(&retired.PublicKey)
+	require.NoError(t, err)
+	writeKey(t, dir, "2024-12", "PUBLIC KEY", retiredPublic)
+
+	// Aktif anahtar dizinde olmalı ve özel anahtarı bulunmalı
+	_, err = auth.LoadKeySet(dir, "missing")
+	assert.Error(t, err)
+	_, err = auth.LoadKeySet(dir, "2024-12")
+	assert.Error(t, err)
+
+	oldKeys, err := auth.LoadKeySet(dir, "2025-01")
+	require.NoError(t, err)
+	newKeys, err := auth.LoadKeySet(dir, "2025-02")
+	require.NoError(t, err)
+	oldMgr := auth.NewJWTManagerWithKeys(oldKeys, 15*time.Minute, time.Hour)
+	newMgr := auth.NewJWTManagerWithKeys(newKeys, 15*time.Minute, time.Hour)
+
+	// Yeni token'lar aktif anahtarla imzalanır, kid başlıkta taşınır
+	pair, err := newMgr.GenerateTokenPair(1, "test@example.com", rbac.RoleAgent)
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(pair.AccessToken, &auth.Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "EdDSA", parsed.Method.Alg())
+	assert.Equal(t, "2025-02", parsed.Header["kid"])
+	claims, err := newMgr.ValidateToken(pair.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), claims.UserID)
+
+	// Anahtar değiştikten sonra eski anahtarla imzalanmış token'lar geçerli kalır
+	oldPair, err := oldMgr.GenerateTokenPair(1, "test@example.com", rbac.RoleAgent)
+	require.NoError(t, err)
+	_, err = newMgr.ValidateToken(oldPair.AccessToken)
+	assert.NoError(t, err)
+
+	// JWKS yalnızca açık anahtarları kid sırasıyla yayınlar
+	jwks := newMgr.JWKS()
+	require.Len(t, jwks.Keys, 3)
+	assert.Equal(t, []string{"2024-12", "2025-01", "2025-02"}, []string{jwks.Keys[0].Kid, jwks.Keys[1].Kid, jwks.Keys[2].Kid})
+	assert.Equal(t, "RSA", jwks.Keys[1].Kty)
+	assert.Equal(t, "RS256", jwks.Keys[1].Alg)
+	assert.NotEmpty(t, jwks.Keys[1].N)
+	assert.Equal(t, "OKP", jwks.Keys[2].Kty)
+	assert.Equal(t, "Ed25519", jwks.Keys[2].Crv)
+	assert.Empty(t, auth.NewJWTManager("secret", time.Minute, time.Hour).JWKS().Keys)
+
+	// HS256 anahtarı yayınlanmaz
+	td := setupTestDeps(t)
+	w := doJSON(t, td, "GET", "/.well-known/jwks.json", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"keys":[]}`, w.Body.String())
+
+	// alg anahtarın algoritmasına sabitlenir: açık RSA anahtarı HMAC sırrı
+	// olarak, bir anahtar başka bir algoritmayla veya imzasız token kullanılamaz
+	forge := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, &auth.Claims{
+			UserID:           1,
+			TokenType:        auth.TokenTypeAccess,
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+		})
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: retiredPublic})
+	for name, forged := range map[string]string{
+		"hmac with public key": forge(jwt.SigningMethodHS256, "2024-12", publicPEM),
+		"rsa key as eddsa":     forge(jwt.SigningMethodRS256, "2025-02", rsaKey),
+		"none":                 forge(jwt.SigningMethodNone, "2025-01", jwt.UnsafeAllowNoneSignatureType),
+		"unknown kid":          forge(jwt.SigningMethodRS256, "2099-01", rsaKey),
+	} {
+		_, err := newMgr.ValidateToken(forged)
+		assert.Error(t, err, name)
+	}
+	_, err = newMgr.ValidateToken(forge(jwt.SigningMethodRS256, "2025-01", rsaKey))
+	assert.NoError(t, err)
+}
+
+func TestSessions(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+	admin := repo.User{Email: "admin@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAdmin), IsActive: true}
+	user := repo.User{Email: "test@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAgent), IsActive: true}
+	require.NoError(t, td.DB.Create(&admin).Error)
+	require.NoError(t, td.DB.Create(&user).Error)
+
+	loginFrom := func(userAgent, remoteAddr string) *auth.TokenPair {
+		body, _ := json.Marshal(map[string]string{"email": user.Email, "password": "password123"})
+		req, _ := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var response apih.LoginResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.TokenPair
+	}
+	listSessions := func(path, token string) []apih.SessionResponse {
+		w := doJSON(t, td, "GET", path, token, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var sessions []apih.SessionResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sessions))
+		return sessions
+	}
+
+	// Her giriş cihazı ve IP'siyle ayrı bir oturumdur
+	desktop := loginFrom("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36", "192.0.2.10:4000")
+	phone := loginFrom("Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1", "192.0.2.20:4000")
+
+	sessions := listSessions("/api/v1/me/sessions", desktop.AccessToken)
+	require.Len(t, sessions, 2)
+	devices := map[string]apih.SessionResponse{}
+	for _, session := range sessions {
+		devices[session.Device] = session
+	}
+	require.Contains(t, devices, "Chrome on Windows")
+	require.Contains(t, devices, "Safari on iOS")
+	assert.True(t, devices["Chrome on Windows"].Current)
+	assert.False(t, devices["Safari on iOS"].Current)
+	assert.Equal(t, "192.0.2.10", devices["Chrome on Windows"].IP)
+	assert.Equal(t, "192.0.2.20", devices["Safari on iOS"].IP)
+
+	// Kaybolan telefonun oturumu kapatılır: access ve refresh token'ı geçersiz olur
+	phonePath := fmt.Sprintf("/api/v1/me/sessions/%d", devices["Safari on iOS"].ID)
+	require.Equal(t, http.StatusOK, doJSON(t, td, "DELETE", phonePath, desktop.AccessToken, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, doJSON(t, td, "GET", "/api/v1/me", phone.AccessToken, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, doJSON(t, td, "POST", "/api/v1/auth/refresh", "", map[string]string{"refresh_token": phone.RefreshToken}).Code)
+	assert.Equal(t, http.StatusOK, doJSON(t, td, "GET", "/api/v1/me", desktop.AccessToken, nil).Code)
+	assert.Len(t, listSessions("/api/v1/me/sessions", desktop.AccessToken), 1)
+	assert.Equal(t, http.StatusNotFound, doJSON(t, td, "DELETE", phonePath, desktop.AccessToken, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, doJSON(t, td, "DELETE", "/api/v1/me/sessions/abc", desktop.AccessToken, nil).Code)
+
+	// Başka bir kullanıcının oturumu /me üzerinden kapatılamaz
+	adminTokens := issueTokens(t, td, admin)
+	adminSessions := listSessions("/api/v1/me/sessions", adminTokens.AccessToken)
+	require.Len(t, adminSessions, 1)
+	assert.Equal(t, http.StatusNotFound, doJSON(t, td, "DELETE", fmt.Sprintf("/api/v1/me/sessions/%d", adminSessions[0].ID), desktop.AccessToken, nil).Code)
+
+	// Yönetici herhangi bir kullanıcının oturumlarını görür ve kapatır
+	userSessionsPath := fmt.Sprintf("/api/v1/users/%d/sessions", user.ID)
+	assert.Equal(t, http.StatusForbidden, doJSON(t, td, "GET", userSessionsPath, desktop.AccessToken, nil).Code)
+	sessions = listSessions(userSessionsPath, adminTokens.AccessToken)
+	require.Len(t, sessions, 1)
+	assert.False(t, sessions[0].Current)
+	require.Equal(t, http.StatusOK, doJSON(t, td, "DELETE", fmt.Sprintf("%s/%d", userSessionsPath, sessions[0].ID), adminTokens.AccessToken, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, doJSON(t, td, "GET", "/api/v1/me", desktop.AccessToken, nil).Code)
+	assert.Empty(t, listSessions(userSessionsPath, adminTokens.AccessToken))
+
+	var revoked int64
+	require.NoError(t, td.DB.Model(&repo.AuditLog{}).Where("action = ? AND entity_id = ?", "session_revoked", user.ID).Count(&revoked).Error)
+	assert.Equal(t, int64(2), revoked)
 }
 
 func TestCreateCustomer(t *testing.T) {
@@ -249,16 +1096,15 @@ func TestCreateCustomer(t *testing.T) {
 	user := repo.User{
 		Email:        "admin@example.com",
 		PasswordHash: hashedPassword,
-		Role:         "admin",
+		RoleID:       roleID(t, td, "admin"),
 		IsActive:     true,
 	}
 	require.NoError(t, td.DB.Create(&user).Error)
 
-	tokenPair, err := td.JWTMgr.GenerateTokenPair(user.ID, user.Email, user.Role)
-	require.NoError(t, err)
+	tokenPair := issueTokens(t, td, user)
 
 	customerData := map[string]interface{}{
-		"tc_vkn": "12345678901",
+		"tc_vkn": "12345678950",
 		"name":   "Test Customer",
 		"email":  "customer@example.com",
 		"phone":  "0555 123 45 67",
@@ -279,7 +1125,7 @@ func TestCreateCustomer(t *testing.T) {
 	err = json.Unmarshal(w.Body.Bytes(), &response)
 	require.NoError(t, err)
 
-	assert.Equal(t, "12345678901", response["tc_vkn"])
+	assert.Equal(t, "12345678950", response["tc_vkn"])
 	assert.Equal(t, "Test Customer", response["name"])
 	assert.Equal(t, "customer@example.com", response["email"])
 }
@@ -293,13 +1139,12 @@ func TestGetCustomers(t *testing.T) {
 	user := repo.User{
 		Email:        "admin@example.com",
 		PasswordHash: hashedPassword,
-		Role:         "admin",
+		RoleID:       roleID(t, td, "admin"),
 		IsActive:     true,
 	}
 	require.NoError(t, td.DB.Create(&user).Error)
 
-	tokenPair, err := td.JWTMgr.GenerateTokenPair(user.ID, user.Email, user.Role)
-	require.NoError(t, err)
+	tokenPair := issueTokens(t, td, user)
 
 	// seed customers to the SAME DB
 	customers := []repo.Customer{
@@ -327,6 +1172,141 @@ func TestGetCustomers(t *testing.T) {
 	assert.Equal(t, float64(2), response["total"])
 }
 
+func TestCustomerPIIMasking(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	admin := repo.User{Email: "admin@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAdmin), IsActive: true}
+	agent := repo.User{Email: "agent@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAgent), IsActive: true}
+	require.NoError(t, td.DB.Create(&admin).Error)
+	require.NoError(t, td.DB.Create(&agent).Error)
+
+	birthDate := time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)
+	customer := repo.Customer{
+		TCVKN:     "12300004190",
+		Name:      "Ali Veli",
+		Email:     "ali.veli@email.com",
+		Phone:     "0555 111 22 33",
+		Address:   "Kadıköy Mah. No:1",
+		City:      "İstanbul",
+		BirthDate: &birthDate,
+	}
+	product := repo.Product{Type: "kasko", Name: "Kasko"}
+	require.NoError(t, td.DB.Create(&customer).Error)
+	require.NoError(t, td.DB.Create(&product).Error)
+	policy := repo.Policy{
+		CustomerID:   customer.ID,
+		ProductID:    product.ID,
+		AgentID:      agent.ID,
+		PolicyNumber: "TEST-1",
+		CompanyName:  "Anadolu",
+		Premium:      1000,
+		StartDate:    "2026-01-01",
+		EndDate:      "2027-01-01",
+	}
+	require.NoError(t, td.DB.Create(&policy).Error)
+
+	get := func(path string, user repo.User, out interface{}) {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+issueTokens(t, td, user).AccessToken)
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), out))
+	}
+	piiReads := func() int64 {
+		var count int64
+		require.NoError(t, td.DB.Model(&repo.AuditLog{}).
+			Where("action = ? AND entity_id = ?", "customer_pii_viewed", customer.ID).Count(&count).Error)
+		return count
+	}
+	customerPath := fmt.Sprintf("/api/v1/customers/%d", customer.ID)
+	policyPath := fmt.Sprintf("/api/v1/policies/%d", policy.ID)
+
+	// Agents don't hold customer:read_pii
+	var masked apih.CustomerResponse
+	get(customerPath, agent, &masked)
+	assert.Equal(t, "123******90", masked.TCVKN)
+	assert.Equal(t, "a*******@email.com", masked.Email)
+	assert.Equal(t, "0*** *** ** 33", masked.Phone)
+	assert.Equal(t, "***", masked.Address)
+	assert.Empty(t, masked.BirthDate)
+	assert.Equal(t, "Ali Veli", masked.Name)
+	assert.Equal(t, "İstanbul", masked.City)
+
+	var maskedPolicy apih.PolicyResponse
+	get(policyPath, agent, &maskedPolicy)
+	assert.Equal(t, "123******90", maskedPolicy.Customer.TCVKN)
+	assert.Zero(t, piiReads())
+
+	// Admins see everything, and each read is audited
+	var full apih.CustomerResponse
+	get(customerPath, admin, &full)
+	assert.Equal(t, "12300004190", full.TCVKN)
+	assert.Equal(t, "ali.veli@email.com", full.Email)
+	assert.Equal(t, "1990-05-17", full.BirthDate)
+	assert.EqualValues(t, 1, piiReads())
+
+	var exported []apih.CustomerResponse
+	get("/api/v1/reports/export/customers?format=csv", admin, &exported)
+	require.Len(t, exported, 1)
+	assert.Equal(t, "12300004190", exported[0].TCVKN)
+	assert.EqualValues(t, 2, piiReads())
+
+	// Bir istekte gösterilen müşteriler tek kayıtta listelenir
+	other := repo.Customer{TCVKN: "10000000146", Name: "Ayşe Yılmaz"}
+	require.NoError(t, td.DB.Create(&other).Error)
+	var page apih.PaginationResponse
+	get("/api/v1/customers", admin, &page)
+	assert.EqualValues(t, 2, page.Total)
+	var listed repo.AuditLog
+	require.NoError(t, td.DB.Where("action = ?", "customer_pii_viewed").Order("id DESC").First(&listed).Error)
+	assert.Nil(t, listed.EntityID)
+	var meta struct {
+		Route       string `json:"route"`
+		CustomerIDs []uint `json:"customer_ids"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(listed.MetaJSON), &meta))
+	assert.Equal(t, "GET /api/v1/customers", meta.Route)
+	assert.ElementsMatch(t, []uint{customer.ID, other.ID}, meta.CustomerIDs)
+	var count int64
+	td.DB.Model(&repo.AuditLog{}).Where("action = ?", "customer_pii_viewed").Count(&count)
+	assert.EqualValues(t, 3, count)
+
+	// The stored record itself is never touched
+	var stored repo.Customer
+	require.NoError(t, td.DB.First(&stored, customer.ID).Error)
+	assert.Equal(t, "12300004190", stored.TCVKN)
+
+	// Acente maskeli müşteriyi gördüğü haliyle ya da kişisel verileri boş
+	// göndererek düzenler, saklanan kişisel veriler değişmez
+	update := func(body map[string]interface{}) {
+		w := doJSON(t, td, "PUT", customerPath, issueTokens(t, td, agent).AccessToken, body)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var updated apih.CustomerResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+		assert.Equal(t, "123******90", updated.TCVKN)
+	}
+	update(map[string]interface{}{
+		"tc_vkn": masked.TCVKN, "name": masked.Name, "email": masked.Email, "phone": masked.Phone,
+		"address": masked.Address, "birth_date": masked.BirthDate, "city": "Ankara",
+	})
+	require.NoError(t, td.DB.First(&stored, customer.ID).Error)
+	assert.Equal(t, "Ankara", stored.City)
+	update(map[string]interface{}{"name": "Ali Veli", "city": "İzmir"})
+
+	require.NoError(t, td.DB.First(&stored, customer.ID).Error)
+	assert.Equal(t, "İzmir", stored.City)
+	assert.Equal(t, "12300004190", stored.TCVKN)
+	assert.Equal(t, "ali.veli@email.com", stored.Email)
+	assert.Equal(t, "0555 111 22 33", stored.Phone)
+	assert.Equal(t, "Kadıköy Mah. No:1", stored.Address)
+	require.NotNil(t, stored.BirthDate)
+	assert.Equal(t, "1990-05-17", stored.BirthDate.Format("2006-01-02"))
+}
+
 func TestRBACPermissions(t *testing.T) {
 	td := setupTestDeps(t)
 
@@ -336,13 +1316,12 @@ func TestRBACPermissions(t *testing.T) {
 	user := repo.User{
 		Email:        "viewer@example.com",
 		PasswordHash: hashedPassword,
-		Role:         "viewer",
+		RoleID:       roleID(t, td, "viewer"),
 		IsActive:     true,
 	}
 	require.NoError(t, td.DB.Create(&user).Error)
 
-	tokenPair, err := td.JWTMgr.GenerateTokenPair(user.ID, user.Email, user.Role)
-	require.NoError(t, err)
+	tokenPair := issueTokens(t, td, user)
 
 	customerData := map[string]interface{}{
 		"tc_vkn": "12345678901",
@@ -360,21 +1339,2046 @@ func TestRBACPermissions(t *testing.T) {
 	assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
 }
 
-func TestHealthCheck(t *testing.T) {
-	router := gin.New()
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
-	})
+func TestRBACCacheInvalidation(t *testing.T) {
+	td := setupTestDeps(t)
 
-	req, _ := http.NewRequest("GET", "/health", nil)
-	w := httptest.NewRecorder()
-	router.ServeHTTP(w, req)
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
 
-	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	user := repo.User{
+		Email:        "viewer@example.com",
+		PasswordHash: hashedPassword,
+		RoleID:       roleID(t, td, rbac.RoleViewer),
+		IsActive:     true,
+	}
+	require.NoError(t, td.DB.Create(&user).Error)
 
-	var response map[string]interface{}
-	err := json.Unmarshal(w.Body.Bytes(), &response)
-	require.NoError(t, err)
+	deleteCustomer := func() int {
+		req, _ := http.NewRequest("DELETE", "/api/v1/customers/999999", nil)
+		req.Header.Set("Authorization", "Bearer "+issueTokens(t, td, user).AccessToken)
 
-	assert.Equal(t, "ok", response["status"])
-}
\ No newline at end of file
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, deleteCustomer())
+
+	// Grant the permission behind the cache's back
+	var role repo.Role
+	require.NoError(t, td.DB.Where("name = ?", rbac.RoleViewer).First(&role).Error)
+	var permission repo.Permission
+	require.NoError(t, td.DB.Where("name = ?", rbac.PermissionCustomerDelete).First(&permission).Error)
+	require.NoError(t, td.DB.Create(&repo.RolePermission{RoleID: role.ID, PermissionID: permission.ID}).Error)
+
+	assert.Equal(t, http.StatusForbidden, deleteCustomer(), "cached permissions should still apply")
+
+	require.NoError(t, td.RBACMgr.Invalidate(context.Background()))
+	assert.NotEqual(t, http.StatusForbidden, deleteCustomer())
+
+	permissions, err := td.RBACMgr.GetUserPermissions(user.ID)
+	require.NoError(t, err)
+	assert.Contains(t, permissions, rbac.PermissionCustomerDelete)
+}
+
+// TestRouteRBAC her seed rolünü korunan her rotaya karşı dener. İzinli
+// roller yetki hatası almamalı, diğerleri 403 almalı. Yan etki olmaması
+// için var olmayan ID'ler ve geçersiz gövdeler kullanılır.
+func TestRouteRBAC(t *testing.T) {
+	td := setupTestDeps(t)
+
+	var (
+		everyone = []string{rbac.RoleAdmin, rbac.RoleBranchManager, rbac.RoleAgent, rbac.RoleViewer}
+		staff    = []string{rbac.RoleAdmin, rbac.RoleBranchManager, rbac.RoleAgent}
+		managers = []string{rbac.RoleAdmin, rbac.RoleBranchManager}
+		admins   = []string{rbac.RoleAdmin}
+	)
+
+	routes := []struct {
+		method  string
+		path    string
+		allowed []string
+	}{
+		{"GET", "/api/v1/customers", everyone},
+		{"GET", "/api/v1/customers/duplicates", everyone},
+		{"GET", "/api/v1/customers/999999", everyone},
+		{"GET", "/api/v1/customers/999999/timeline", everyone},
+		{"POST", "/api/v1/customers", staff},
+		{"POST", "/api/v1/customers/import", staff},
+		{"GET", "/api/v1/customers/imports/999999", staff},
+		{"PUT", "/api/v1/customers/999999", staff},
+		{"DELETE", "/api/v1/customers/999999", managers},
+		{"POST", "/api/v1/customers/999999/merge", managers},
+		{"GET", "/api/v1/customers/999999/consents", everyone},
+		{"POST", "/api/v1/customers/999999/consents", staff},
+		{"POST", "/api/v1/customers/999999/consents/999999/revoke", staff},
+		{"GET", "/api/v1/customers/999999/kvkk-export", admins},
+		{"POST", "/api/v1/customers/999999/anonymize", admins},
+
+		{"GET", "/api/v1/quotes", everyone},
+		{"GET", "/api/v1/quotes/999999", everyone},
+		{"POST", "/api/v1/quotes", staff},
+		{"GET", "/api/v1/quotes/999999/comparison", everyone},
+		{"GET", "/api/v1/quotes/999999/scraped", everyone},
+		{"GET", "/api/v1/quotes/999999/timeline", everyone},
+		{"POST", "/api/v1/quotes/999999/approve/999999", staff},
+
+		{"GET", "/api/v1/branches", admins},
+		{"GET", "/api/v1/branches/999999", admins},
+		{"POST", "/api/v1/branches", admins},
+		{"PUT", "/api/v1/branches/999999", admins},
+		{"DELETE", "/api/v1/branches/999999", admins},
+
+		{"GET", "/api/v1/agents", managers},
+		{"GET", "/api/v1/agents/999999", managers},
+		{"POST", "/api/v1/agents", managers},
+		{"PUT", "/api/v1/agents/999999", managers},
+		{"DELETE", "/api/v1/agents/999999", managers},
+
+		{"GET", "/api/v1/policies", everyone},
+		{"GET", "/api/v1/policies/999999", everyone},
+		{"GET", "/api/v1/policies/999999/timeline", everyone},
+		{"POST", "/api/v1/policies", staff},
+		{"PUT", "/api/v1/policies/999999", staff},
+		{"DELETE", "/api/v1/policies/999999", managers},
+
+		{"GET", "/api/v1/reports/dashboard", everyone},
+		{"GET", "/api/v1/reports/policy-stats", everyone},
+		{"GET", "/api/v1/reports/monthly-stats", everyone},
+		{"GET", "/api/v1/reports/branch-stats", everyone},
+		{"GET", "/api/v1/reports/agent-stats", everyone},
+		{"GET", "/api/v1/reports/export/policies", managers},
+		{"GET", "/api/v1/reports/export/customers", managers},
+
+		{"GET", "/api/v1/scraper/targets", admins},
+		{"POST", "/api/v1/scraper/run", admins},
+
+		{"GET", "/api/v1/roles", admins},
+		{"GET", "/api/v1/roles/999999", admins},
+		{"POST", "/api/v1/roles", admins},
+		{"PUT", "/api/v1/roles/999999", admins},
+		{"DELETE", "/api/v1/roles/999999", admins},
+		{"GET", "/api/v1/permissions", admins},
+
+		{"GET", "/api/v1/audit-logs", admins},
+		{"GET", "/api/v1/audit-logs/verify", admins},
+	}
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	tokens := make(map[string]string)
+	for _, role := range everyone {
+		user := repo.User{
+			Email:        role + "@example.com",
+			PasswordHash: hashedPassword,
+			RoleID:       roleID(t, td, role),
+			IsActive:     true,
+		}
+		require.NoError(t, td.DB.Create(&user).Error)
+		tokens[role] = issueTokens(t, td, user).AccessToken
+	}
+
+	for _, route := range routes {
+		for _, role := range everyone {
+			t.Run(role+" "+route.method+" "+route.path, func(t *testing.T) {
+				var body *bytes.Buffer
+				if route.method == "POST" || route.method == "PUT" {
+					body = bytes.NewBufferString("{")
+				} else {
+					body = &bytes.Buffer{}
+				}
+
+				req, _ := http.NewRequest(route.method, route.path, body)
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Authorization", "Bearer "+tokens[role])
+
+				w := httptest.NewRecorder()
+				td.Router.ServeHTTP(w, req)
+
+				if slices.Contains(route.allowed, role) {
+					assert.NotEqual(t, http.StatusUnauthorized, w.Code, w.Body.String())
+					assert.NotEqual(t, http.StatusForbidden, w.Code, w.Body.String())
+				} else {
+					assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+				}
+			})
+		}
+	}
+
+	// Guarded groups must not gain routes the table above doesn't cover
+	covered := make(map[string]bool)
+	for _, route := range routes {
+		covered[route.method+" "+route.path] = true
+	}
+	for _, info := range td.Router.Routes() {
+		for _, prefix := range []string{"customers", "quotes", "branches", "agents", "policies", "reports", "scraper", "roles", "permissions", "audit-logs"} {
+			if !strings.HasPrefix(info.Path, "/api/v1/"+prefix) {
+				continue
+			}
+			path := strings.NewReplacer(":id", "999999", ":scraped_quote_id", "999999", ":consent_id", "999999").Replace(info.Path)
+			assert.True(t, covered[info.Method+" "+path], "route %s %s is not covered by the RBAC table", info.Method, info.Path)
+		}
+	}
+}
+
+func TestCustomRoles(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	admin := repo.User{
+		Email:        "admin@example.com",
+		PasswordHash: hashedPassword,
+		RoleID:       roleID(t, td, rbac.RoleAdmin),
+		IsActive:     true,
+	}
+	require.NoError(t, td.DB.Create(&admin).Error)
+	adminToken := issueTokens(t, td, admin).AccessToken
+
+	do := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&buf).Encode(body))
+		}
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Create a role that may only look customers up
+	w := do("POST", "/api/v1/roles", adminToken, map[string]interface{}{
+		"name":        "call_center",
+		"permissions": []string{rbac.PermissionCustomerRead},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var role apih.RoleResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &role))
+	assert.False(t, role.IsSystem)
+	assert.Equal(t, repo.ScopeOwn, role.DataScope)
+	assert.Equal(t, []string{rbac.PermissionCustomerRead}, role.Permissions)
+
+	w = do("POST", "/api/v1/roles", adminToken, map[string]interface{}{
+		"name":        "broken",
+		"permissions": []string{"customer:teleport"},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+	// Assign it to a new user through the user API
+	w = do("POST", "/api/v1/users", adminToken, map[string]interface{}{
+		"email":    "callcenter@example.com",
+		"password": "Operator123x",
+		"role_id":  role.ID,
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var member repo.User
+	require.NoError(t, td.DB.Preload("Role").Where("email = ?", "callcenter@example.com").First(&member).Error)
+	assert.Equal(t, "call_center", member.RoleName())
+	memberToken := issueTokens(t, td, member).AccessToken
+
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/customers/999999", memberToken, nil).Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/api/v1/customers", memberToken, nil).Code)
+
+	// Editing the permission set applies on the next request
+	w = do("PUT", fmt.Sprintf("/api/v1/roles/%d", role.ID), adminToken, map[string]interface{}{
+		"permissions": []string{rbac.PermissionCustomerRead, rbac.PermissionCustomerList},
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/customers", memberToken, nil).Code)
+
+	// Roles in use can't be deleted
+	assert.Equal(t, http.StatusConflict, do("DELETE", fmt.Sprintf("/api/v1/roles/%d", role.ID), adminToken, nil).Code)
+
+	// System roles are read-only
+	viewerPath := fmt.Sprintf("/api/v1/roles/%d", roleID(t, td, rbac.RoleViewer))
+	w = do("PUT", viewerPath, adminToken, map[string]interface{}{"permissions": []string{}})
+	assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusForbidden, do("DELETE", viewerPath, adminToken, nil).Code)
+
+	// Once nobody holds it, the role can go
+	require.NoError(t, td.DB.Unscoped().Delete(&member).Error)
+	assert.Equal(t, http.StatusOK, do("DELETE", fmt.Sprintf("/api/v1/roles/%d", role.ID), adminToken, nil).Code)
+}
+
+func TestRoleEscalation(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+	admin := repo.User{Email: "admin@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAdmin), IsActive: true}
+	require.NoError(t, td.DB.Create(&admin).Error)
+	adminToken := issueTokens(t, td, admin).AccessToken
+
+	// Kullanıcı ve rol yönetebilen, şube kapsamlı bir rol
+	managerPermissions := []string{
+		rbac.PermissionUserCreate, rbac.PermissionUserUpdate, rbac.PermissionUserDelete,
+		rbac.PermissionRoleCreate, rbac.PermissionRoleUpdate, rbac.PermissionCustomerRead,
+	}
+	w := doJSON(t, td, "POST", "/api/v1/roles", adminToken, map[string]interface{}{
+		"name": "user_manager", "data_scope": repo.ScopeBranch, "permissions": managerPermissions,
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var managerRole apih.RoleResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &managerRole))
+
+	w = doJSON(t, td, "POST", "/api/v1/roles", adminToken, map[string]interface{}{
+		"name": "clerk", "data_scope": repo.ScopeOwn, "permissions": []string{rbac.PermissionCustomerRead},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var clerkRole apih.RoleResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &clerkRole))
+
+	branchA := repo.Branch{Name: "Kadıköy"}
+	branchB := repo.Branch{Name: "Çankaya"}
+	require.NoError(t, td.DB.Create(&branchA).Error)
+	require.NoError(t, td.DB.Create(&branchB).Error)
+
+	manager := repo.User{Email: "manager@example.com", PasswordHash: hashedPassword, RoleID: managerRole.ID, BranchID: &branchA.ID, IsActive: true}
+	other := repo.User{Email: "other@example.com", PasswordHash: hashedPassword, RoleID: clerkRole.ID, BranchID: &branchA.ID, IsActive: true}
+	boss := repo.User{Email: "boss@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleBranchManager), BranchID: &branchA.ID, IsActive: true}
+	outsider := repo.User{Email: "outsider@example.com", PasswordHash: hashedPassword, RoleID: clerkRole.ID, BranchID: &branchB.ID, IsActive: true}
+	for _, user := range []*repo.User{&manager, &other, &boss, &outsider} {
+		require.NoError(t, td.DB.Create(user).Error)
+	}
+	token := issueTokens(t, td, manager).AccessToken
+
+	// Sahip olmadığı izinlerle veya daha geniş kapsamla rol kuramaz
+	createRole := func(name, scope string, permissions []string) int {
+		return doJSON(t, td, "POST", "/api/v1/roles", token, map[string]interface{}{"name": name, "data_scope": scope, "permissions": permissions}).Code
+	}
+	w = doJSON(t, td, "POST", "/api/v1/roles", token, map[string]interface{}{
+		"name": "deleter", "permissions": []string{rbac.PermissionCustomerRead, rbac.PermissionCustomerDelete},
+	})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), rbac.PermissionCustomerDelete)
+	assert.Equal(t, http.StatusForbidden, createRole("company_reader", repo.ScopeAll, []string{rbac.PermissionCustomerRead}))
+	require.Equal(t, http.StatusCreated, createRole("branch_reader", repo.ScopeBranch, []string{rbac.PermissionCustomerRead}))
+
+	// Var olan bir role de sahip olmadığı izni ekleyemez
+	var customRole repo.Role
+	require.NoError(t, td.DB.Where("name = ?", "branch_reader").First(&customRole).Error)
+	rolePath := fmt.Sprintf("/api/v1/roles/%d", customRole.ID)
+	assert.Equal(t, http.StatusForbidden, doJSON(t, td, "PUT", rolePath, token, map[string]interface{}{
+		"permissions": []string{rbac.PermissionCustomerRead, rbac.PermissionCustomerDelete},
+	}).Code)
+	assert.Equal(t, http.StatusForbidden, doJSON(t, td, "PUT", rolePath, token, map[string]interface{}{
+		"data_scope": repo.ScopeAll, "permissions": []string{rbac.PermissionCustomerRead},
+	}).Code)
+
+	// Yönetici rolünü kimseye atayamaz, sahip olduğu izinlerden oluşan rolü atar
+	rolePathOf := fmt.Sprintf("/api/v1/users/%d/role", other.ID)
+	assert.Equal(t, http.StatusForbidden, doJSON(t, td, "PUT", rolePathOf, token, map[string]uint{"role_id": roleID(t, td, rbac.RoleAdmin)}).Code)
+	assert.Equal(t, http.StatusOK, doJSON(t, td, "PUT", rolePathOf, token, map[string]uint{"role_id": customRole.ID}).Code)
+	w = doJSON(t, td, "POST", "/api/v1/users", token, map[string]interface{}{
+		"email": "new@example.com", "password": "Kasa2024Guvenli", "role_id": roleID(t, td, rbac.RoleAdmin),
+	})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	var created int64
+	require.NoError(t, td.DB.Model(&repo.User{}).Where("email = ?", "new@example.com").Count(&created).Error)
+	assert.Zero(t, created)
+
+	require.NoError(t, td.DB.First(&other, other.ID).Error)
+	assert.Equal(t, customRole.ID, other.RoleID)
+
+	// Kendisinden geniş yetkili bir kullanıcıya ve kapsamı dışındaki
+	// kullanıcılara dokunamaz
+	actions := []struct{ method, path string }{
+		{"PUT", "/api/v1/users/%d/role"},
+		{"POST", "/api/v1/users/%d/deactivate"},
+		{"POST", "/api/v1/users/%d/activate"},
+		{"POST", "/api/v1/users/%d/password-reset"},
+		{"POST", "/api/v1/users/%d/2fa/reset"},
+		{"POST", "/api/v1/users/%d/unlock"},
+		{"DELETE", "/api/v1/users/%d"},
+	}
+	for _, action := range actions {
+		var body interface{}
+		if action.method == "PUT" {
+			body = map[string]uint{"role_id": customRole.ID}
+		}
+		w = doJSON(t, td, action.method, fmt.Sprintf(action.path, boss.ID), token, body)
+		assert.Equal(t, http.StatusForbidden, w.Code, action.path)
+		w = doJSON(t, td, action.method, fmt.Sprintf(action.path, outsider.ID), token, body)
+		assert.Equal(t, http.StatusNotFound, w.Code, action.path)
+	}
+	for _, user := range []repo.User{boss, outsider} {
+		var current repo.User
+		require.NoError(t, td.DB.First(&current, user.ID).Error)
+		assert.Equal(t, user.RoleID, current.RoleID)
+		assert.True(t, current.IsActive)
+		assert.Equal(t, user.PasswordHash, current.PasswordHash)
+	}
+
+	// Kendini ve kapsamı dışındaki kullanıcıları atayamaz, kimseyi başka
+	// şubeye taşıyamaz
+	assignmentPath := func(id uint) string { return fmt.Sprintf("/api/v1/users/%d/assignment", id) }
+	assert.Equal(t, http.StatusBadRequest, doJSON(t, td, "PUT", assignmentPath(manager.ID), token, map[string]uint{"branch_id": branchB.ID}).Code)
+	assert.Equal(t, http.StatusBadRequest, doJSON(t, td, "PUT", assignmentPath(other.ID), token, map[string]uint{"branch_id": branchB.ID}).Code)
+	assert.Equal(t, http.StatusNotFound, doJSON(t, td, "PUT", assignmentPath(outsider.ID), token, map[string]uint{"branch_id": branchA.ID}).Code)
+	assert.Equal(t, http.StatusForbidden, doJSON(t, td, "PUT", assignmentPath(boss.ID), token, map[string]uint{"branch_id": branchA.ID}).Code)
+	require.NoError(t, td.DB.First(&manager, manager.ID).Error)
+	require.NoError(t, td.DB.First(&other, other.ID).Error)
+	assert.Equal(t, branchA.ID, *manager.BranchID)
+	assert.Equal(t, branchA.ID, *other.BranchID)
+
+	// Şubesindeki, yetkisi içindeki kullanıcıyı yönetir
+	assert.Equal(t, http.StatusOK, doJSON(t, td, "PUT", assignmentPath(other.ID), token, map[string]uint{"branch_id": branchA.ID}).Code)
+	assert.Equal(t, http.StatusOK, doJSON(t, td, "POST", fmt.Sprintf("/api/v1/users/%d/password-reset", other.ID), token, nil).Code)
+	assert.Equal(t, http.StatusOK, doJSON(t, td, "DELETE", fmt.Sprintf("/api/v1/users/%d", other.ID), token, nil).Code)
+}
+
+func TestMigrateLegacyUserRoles(t *testing.T) {
+	td := setupTestDeps(t)
+
+	// Simulate a database from before users referenced roles by ID
+	require.NoError(t, td.DB.Exec("ALTER TABLE users ADD COLUMN `role` text").Error)
+	require.NoError(t, td.DB.Exec(`INSERT INTO users (email, password_hash, role, is_active) VALUES
+		('agent@example.com', 'x', 'agent', true),
+		('ghost@example.com', 'x', 'no_such_role', true)`).Error)
+
+	require.NoError(t, td.RBACMgr.InitializeRoles())
+
+	var agent, ghost repo.User
+	require.NoError(t, td.DB.Preload("Role").Where("email = ?", "agent@example.com").First(&agent).Error)
+	require.NoError(t, td.DB.Preload("Role").Where("email = ?", "ghost@example.com").First(&ghost).Error)
+	assert.Equal(t, rbac.RoleAgent, agent.RoleName())
+	assert.Equal(t, rbac.RoleViewer, ghost.RoleName())
+	assert.False(t, td.DB.Migrator().HasColumn(&repo.User{}, "role"))
+}
+
+func TestDataScope(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	branchA := repo.Branch{Name: "Kadıköy"}
+	branchB := repo.Branch{Name: "Çankaya"}
+	require.NoError(t, td.DB.Create(&branchA).Error)
+	require.NoError(t, td.DB.Create(&branchB).Error)
+
+	agentA1 := repo.Agent{BranchID: branchA.ID, Name: "Ayşe"}
+	agentA2 := repo.Agent{BranchID: branchA.ID, Name: "Mehmet"}
+	agentB := repo.Agent{BranchID: branchB.ID, Name: "Zeynep"}
+	require.NoError(t, td.DB.Create(&agentA1).Error)
+	require.NoError(t, td.DB.Create(&agentA2).Error)
+	require.NoError(t, td.DB.Create(&agentB).Error)
+
+	newUser := func(email, role string, branchID, agentID *uint) repo.User {
+		user := repo.User{
+			Email:        email,
+			PasswordHash: hashedPassword,
+			RoleID:       roleID(t, td, role),
+			BranchID:     branchID,
+			AgentID:      agentID,
+			IsActive:     true,
+		}
+		require.NoError(t, td.DB.Create(&user).Error)
+		return user
+	}
+	admin := newUser("admin@example.com", rbac.RoleAdmin, nil, nil)
+	manager := newUser("manager@example.com", rbac.RoleBranchManager, &branchA.ID, nil)
+	userA1 := newUser("a1@example.com", rbac.RoleAgent, &branchA.ID, &agentA1.ID)
+	userA2 := newUser("a2@example.com", rbac.RoleAgent, &branchA.ID, &agentA2.ID)
+	userB := newUser("b@example.com", rbac.RoleAgent, &branchB.ID, &agentB.ID)
+	unassigned := newUser("new@example.com", rbac.RoleBranchManager, nil, nil)
+
+	customer := repo.Customer{TCVKN: "10000000146", Name: "Ali Veli"}
+	product := repo.Product{Type: "kasko", Name: "Kasko"}
+	require.NoError(t, td.DB.Create(&customer).Error)
+	require.NoError(t, td.DB.Create(&product).Error)
+
+	policyIDs := make(map[uint]uint)
+	for i, agentUser := range []repo.User{userA1, userA2, userB} {
+		policy := repo.Policy{
+			CustomerID:   customer.ID,
+			ProductID:    product.ID,
+			AgentID:      agentUser.ID,
+			PolicyNumber: fmt.Sprintf("TEST-%d", i),
+			CompanyName:  "Anadolu",
+			Premium:      1000,
+			StartDate:    "2026-01-01",
+			EndDate:      "2027-01-01",
+		}
+		require.NoError(t, td.DB.Create(&policy).Error)
+		policyIDs[agentUser.ID] = policy.ID
+
+		require.NoError(t, td.DB.Create(&repo.Quote{
+			CustomerID:   customer.ID,
+			ProductID:    product.ID,
+			AgentID:      agentUser.ID,
+			CoverageType: "kasko",
+			StartDate:    "2026-01-01",
+			EndDate:      "2027-01-01",
+		}).Error)
+	}
+
+	do := func(method, path string, user repo.User, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&buf).Encode(body))
+		}
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+issueTokens(t, td, user).AccessToken)
+
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		return w
+	}
+	total := func(path string, user repo.User) int64 {
+		w := do("GET", path, user, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var page apih.PaginationResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		return page.Total
+	}
+
+	// Admin sees the whole company, the manager their branch, agents themselves
+	for _, path := range []string{"/api/v1/policies", "/api/v1/quotes"} {
+		assert.EqualValues(t, 3, total(path, admin), path)
+		assert.EqualValues(t, 2, total(path, manager), path)
+		assert.EqualValues(t, 1, total(path, userA1), path)
+		assert.EqualValues(t, 0, total(path, unassigned), path)
+	}
+	assert.EqualValues(t, 3, total("/api/v1/agents", admin))
+	assert.EqualValues(t, 2, total("/api/v1/agents", manager))
+
+	// Records outside the scope look like they don't exist
+	assert.Equal(t, http.StatusNotFound, do("GET", fmt.Sprintf("/api/v1/policies/%d", policyIDs[userB.ID]), manager, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", fmt.Sprintf("/api/v1/policies/%d", policyIDs[userA2.ID]), userA1, nil).Code)
+	assert.Equal(t, http.StatusOK, do("GET", fmt.Sprintf("/api/v1/policies/%d", policyIDs[userA2.ID]), manager, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", fmt.Sprintf("/api/v1/agents/%d", agentB.ID), manager, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", fmt.Sprintf("/api/v1/policies/%d", policyIDs[userB.ID]), manager, nil).Code)
+
+	// Policies can't be handed to an agent of another branch
+	w := do("POST", "/api/v1/policies", manager, map[string]interface{}{
+		"customer_id":  customer.ID,
+		"product_id":   product.ID,
+		"agent_id":     userB.ID,
+		"company_name": "Anadolu",
+		"premium":      1000,
+		"start_date":   "2026-01-01",
+		"end_date":     "2027-01-01",
+	})
+	assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+
+	// Reports only add up what the user can see
+	w = do("GET", "/api/v1/reports/dashboard", manager, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var stats apih.DashboardStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.EqualValues(t, 2, stats.TotalPolicies)
+	assert.EqualValues(t, 2000, stats.TotalPremium)
+
+	w = do("GET", "/api/v1/reports/branch-stats", admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var branchStats []map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &branchStats))
+	assert.Len(t, branchStats, 2)
+
+	w = do("GET", "/api/v1/reports/branch-stats", manager, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &branchStats))
+	require.Len(t, branchStats, 1)
+	assert.Equal(t, "Kadıköy", branchStats[0]["branch_name"])
+	assert.EqualValues(t, 2, branchStats[0]["policy_count"])
+
+	// Assigning a user to an agent record puts them in the agent's branch
+	path := fmt.Sprintf("/api/v1/users/%d/assignment", unassigned.ID)
+	w = do("PUT", path, admin, map[string]interface{}{"branch_id": branchA.ID, "agent_id": agentB.ID})
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+	w = do("PUT", path, admin, map[string]interface{}{"agent_id": agentB.ID})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var assigned apih.UserResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &assigned))
+	require.NotNil(t, assigned.BranchID)
+	assert.Equal(t, branchB.ID, *assigned.BranchID)
+	assert.EqualValues(t, 1, total("/api/v1/policies", unassigned))
+}
+
+func TestCustomerOwnership(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	branchA := repo.Branch{Name: "Kadıköy"}
+	branchB := repo.Branch{Name: "Çankaya"}
+	require.NoError(t, td.DB.Create(&branchA).Error)
+	require.NoError(t, td.DB.Create(&branchB).Error)
+
+	newUser := func(email, role string, branchID *uint) repo.User {
+		user := repo.User{Email: email, PasswordHash: hashedPassword, RoleID: roleID(t, td, role), BranchID: branchID, IsActive: true}
+		require.NoError(t, td.DB.Create(&user).Error)
+		return user
+	}
+	admin := newUser("admin@example.com", rbac.RoleAdmin, nil)
+	managerA := newUser("manager-a@example.com", rbac.RoleBranchManager, &branchA.ID)
+	managerB := newUser("manager-b@example.com", rbac.RoleBranchManager, &branchB.ID)
+	agentA := newUser("a@example.com", rbac.RoleAgent, &branchA.ID)
+	agentA2 := newUser("a2@example.com", rbac.RoleAgent, &branchA.ID)
+	agentB := newUser("b@example.com", rbac.RoleAgent, &branchB.ID)
+
+	product := repo.Product{Type: "kasko", Name: "Kasko"}
+	require.NoError(t, td.DB.Create(&product).Error)
+
+	total := func(user repo.User) int64 {
+		w := doJSON(t, td, "GET", "/api/v1/customers", issueTokens(t, td, user).AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var page apih.PaginationResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		return page.Total
+	}
+	create := func(user repo.User, tcvkn, name string) uint {
+		w := doJSON(t, td, "POST", "/api/v1/customers", issueTokens(t, td, user).AccessToken, map[string]interface{}{"tc_vkn": tcvkn, "name": name})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var customer apih.CustomerResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &customer))
+		return customer.ID
+	}
+
+	// Müşteri, oluşturan kullanıcının ve şubesinin olur
+	aliID := create(agentA, "10000000146", "Ali Veli")
+	ayseID := create(agentB, "12345678950", "Ayşe Yılmaz")
+	var ali repo.Customer
+	require.NoError(t, td.DB.First(&ali, aliID).Error)
+	require.NotNil(t, ali.OwnerID)
+	require.NotNil(t, ali.BranchID)
+	assert.Equal(t, agentA.ID, *ali.OwnerID)
+	assert.Equal(t, branchA.ID, *ali.BranchID)
+
+	// Sahibi kaydedilmemiş eski müşteriye teklifi üzerinden ulaşılır
+	legacy := repo.Customer{TCVKN: "23456789138", Name: "Mehmet Demir"}
+	require.NoError(t, td.DB.Create(&legacy).Error)
+	require.NoError(t, td.DB.Create(&repo.Quote{
+		CustomerID:   legacy.ID,
+		ProductID:    product.ID,
+		AgentID:      agentB.ID,
+		CoverageType: "kasko",
+		StartDate:    "2026-01-01",
+		EndDate:      "2027-01-01",
+	}).Error)
+
+	assert.EqualValues(t, 3, total(admin))
+	assert.EqualValues(t, 1, total(managerA))
+	assert.EqualValues(t, 2, total(managerB))
+	assert.EqualValues(t, 1, total(agentA))
+	assert.EqualValues(t, 0, total(agentA2))
+	assert.EqualValues(t, 2, total(agentB))
+
+	// Kapsam dışındaki müşteri yokmuş gibi görünür
+	aliPath := fmt.Sprintf("/api/v1/customers/%d", aliID)
+	body := map[string]interface{}{"tc_vkn": "10000000146", "name": "Ali Değişti"}
+	for _, user := range []repo.User{agentA2, agentB} {
+		token := issueTokens(t, td, user).AccessToken
+		assert.Equal(t, http.StatusNotFound, doJSON(t, td, "GET", aliPath, token, nil).Code, user.Email)
+		assert.Equal(t, http.StatusNotFound, doJSON(t, td, "PUT", aliPath, token, body).Code, user.Email)
+		assert.Equal(t, http.StatusNotFound, doJSON(t, td, "GET", aliPath+"/timeline", token, nil).Code, user.Email)
+		assert.Equal(t, http.StatusNotFound, doJSON(t, td, "GET", aliPath+"/consents", token, nil).Code, user.Email)
+	}
+	assert.Equal(t, http.StatusNotFound, doJSON(t, td, "DELETE", aliPath, issueTokens(t, td, managerB).AccessToken, nil).Code)
+	require.NoError(t, td.DB.First(&ali, aliID).Error)
+	assert.Equal(t, "Ali Veli", ali.Name)
+
+	// Kapsam dışındaki müşteri için teklif istenemez
+	w := doJSON(t, td, "POST", "/api/v1/quotes", issueTokens(t, td, agentA).AccessToken, map[string]interface{}{
+		"customer_id":   ayseID,
+		"product_id":    product.ID,
+		"coverage_type": "kasko",
+		"start_date":    "2026-01-01",
+		"end_date":      "2027-01-01",
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+	// Şube müdürü şubesinin müşterisini yönetir
+	managerToken := issueTokens(t, td, managerA).AccessToken
+	assert.Equal(t, http.StatusOK, doJSON(t, td, "GET", aliPath, managerToken, nil).Code)
+	w = doJSON(t, td, "PUT", aliPath, managerToken, body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusOK, doJSON(t, td, "DELETE", aliPath, managerToken, nil).Code)
+	assert.EqualValues(t, 2, total(admin))
+}
+
+func TestPermissionGrants(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	branch := repo.Branch{Name: "Kadıköy"}
+	require.NoError(t, td.DB.Create(&branch).Error)
+	agentA := repo.Agent{BranchID: branch.ID, Name: "Ayşe"}
+	agentB := repo.Agent{BranchID: branch.ID, Name: "Mehmet"}
+	require.NoError(t, td.DB.Create(&agentA).Error)
+	require.NoError(t, td.DB.Create(&agentB).Error)
+
+	newUser := func(email, role string, agentID *uint) repo.User {
+		user := repo.User{
+			Email:        email,
+			PasswordHash: hashedPassword,
+			RoleID:       roleID(t, td, role),
+			BranchID:     &branch.ID,
+			AgentID:      agentID,
+			IsActive:     true,
+		}
+		require.NoError(t, td.DB.Create(&user).Error)
+		return user
+	}
+	admin := newUser("admin@example.com", rbac.RoleAdmin, nil)
+	userA := newUser("a@example.com", rbac.RoleAgent, &agentA.ID)
+	userB := newUser("b@example.com", rbac.RoleAgent, &agentB.ID)
+
+	customer := repo.Customer{TCVKN: "10000000146", Name: "Ali Veli"}
+	product := repo.Product{Type: "kasko", Name: "Kasko"}
+	require.NoError(t, td.DB.Create(&customer).Error)
+	require.NoError(t, td.DB.Create(&product).Error)
+	for i, agentUser := range []repo.User{userA, userB} {
+		require.NoError(t, td.DB.Create(&repo.Policy{
+			CustomerID:   customer.ID,
+			ProductID:    product.ID,
+			AgentID:      agentUser.ID,
+			PolicyNumber: fmt.Sprintf("TEST-%d", i),
+			CompanyName:  "Anadolu",
+			Premium:      1000,
+			StartDate:    "2026-01-01",
+			EndDate:      "2027-01-01",
+		}).Error)
+	}
+
+	do := func(method, path string, user repo.User, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&buf).Encode(body))
+		}
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+issueTokens(t, td, user).AccessToken)
+
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		return w
+	}
+	total := func(path string, user repo.User) int64 {
+		w := do("GET", path, user, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var page apih.PaginationResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		return page.Total
+	}
+	grantsPath := fmt.Sprintf("/api/v1/users/%d/grants", userA.ID)
+
+	// Agents can't list agents and see only their own policies
+	assert.Equal(t, http.StatusForbidden, do("GET", "/api/v1/agents", userA, nil).Code)
+	assert.EqualValues(t, 1, total("/api/v1/policies", userA))
+
+	// Grants need a known permission and an expiry in the future
+	w := do("POST", grantsPath, admin, map[string]interface{}{
+		"permission": "agent:fly", "expires_at": time.Now().Add(time.Hour), "reason": "İzin",
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	w = do("POST", grantsPath, admin, map[string]interface{}{
+		"permission": rbac.PermissionAgentList, "expires_at": time.Now().Add(-time.Hour), "reason": "İzin",
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+	// A grant on a colleague's portfolio opens it up until it ends
+	w = do("POST", grantsPath, admin, map[string]interface{}{
+		"permission":    rbac.PermissionAgentList,
+		"agent_user_id": userB.ID,
+		"expires_at":    time.Now().Add(24 * time.Hour),
+		"reason":        "Mehmet yıllık izinde",
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var grant apih.GrantResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &grant))
+	assert.True(t, grant.Active)
+
+	// Sınırlı izin yalnızca o acentenin kayıtlarında ve yalnızca verilen
+	// izin için geçerlidir: kendi acente kaydı da, poliçeler de açılmaz
+	assert.EqualValues(t, 1, total("/api/v1/agents", userA))
+	assert.EqualValues(t, 1, total("/api/v1/policies", userA))
+	assert.Equal(t, http.StatusForbidden, do("GET", fmt.Sprintf("/api/v1/agents/%d", agentB.ID), userA, nil).Code, "agent:read wasn't granted")
+
+	held, err := td.RBACMgr.HasPermission(userA.ID, rbac.PermissionAgentList)
+	require.NoError(t, err)
+	assert.False(t, held)
+	permissions, err := td.RBACMgr.GetUserPermissions(userA.ID)
+	require.NoError(t, err)
+	assert.Contains(t, permissions, rbac.PermissionAgentList)
+	permissions, err = td.RBACMgr.GetHeldPermissions(userA.ID)
+	require.NoError(t, err)
+	assert.NotContains(t, permissions, rbac.PermissionAgentList)
+
+	// Rolün verdiği izne eklenen sınırlı izin kapsamı genişletir
+	w = do("POST", grantsPath, admin, map[string]interface{}{
+		"permission":    rbac.PermissionPolicyList,
+		"agent_user_id": userB.ID,
+		"expires_at":    time.Now().Add(24 * time.Hour),
+		"reason":        "Mehmet yıllık izinde",
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var policyGrant apih.GrantResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &policyGrant))
+	assert.EqualValues(t, 2, total("/api/v1/policies", userA))
+	require.Equal(t, http.StatusOK, do("DELETE", fmt.Sprintf("%s/%d", grantsPath, policyGrant.ID), admin, nil).Code)
+
+	// Kayıt oluşturma ve kişisel veri izinleri şubeye ya da acenteye sınırlanamaz
+	for _, permission := range []string{rbac.PermissionCustomerCreate, rbac.PermissionCustomerReadPII, rbac.PermissionReportRead} {
+		w = do("POST", grantsPath, admin, map[string]interface{}{
+			"permission": permission, "branch_id": branch.ID, "expires_at": time.Now().Add(time.Hour), "reason": "İzin",
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code, permission)
+	}
+
+	// Expired grants stop counting without anyone revoking them
+	require.NoError(t, td.DB.Model(&repo.PermissionGrant{}).Where("id = ?", grant.ID).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/api/v1/agents", userA, nil).Code)
+	assert.EqualValues(t, 1, total("/api/v1/policies", userA))
+	assert.Equal(t, http.StatusConflict, do("DELETE", fmt.Sprintf("%s/%d", grantsPath, grant.ID), admin, nil).Code)
+
+	// Revoked grants stop counting at once
+	w = do("POST", grantsPath, admin, map[string]interface{}{
+		"permission": rbac.PermissionAgentList, "expires_at": time.Now().Add(time.Hour), "reason": "Devir",
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &grant))
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/agents", userA, nil).Code)
+
+	w = do("DELETE", fmt.Sprintf("%s/%d", grantsPath, grant.ID), admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusForbidden, do("GET", "/api/v1/agents", userA, nil).Code)
+
+	w = do("GET", grantsPath, admin, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var grants []apih.GrantResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &grants))
+	require.Len(t, grants, 3)
+	assert.False(t, grants[0].Active)
+	assert.NotNil(t, grants[0].RevokedAt)
+
+	// Every grant and revoke is audited
+	var granted, revoked int64
+	td.DB.Model(&repo.AuditLog{}).Where("action = ?", "permission_granted").Count(&granted)
+	td.DB.Model(&repo.AuditLog{}).Where("action = ?", "permission_revoked").Count(&revoked)
+	assert.EqualValues(t, 3, granted)
+	assert.EqualValues(t, 2, revoked)
+
+	// The cleanup job removes grants that ended before its cutoff
+	removed, err := td.Repo.DeleteOldPermissionGrants(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, removed)
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	admin := repo.User{
+		Email:        "admin@example.com",
+		PasswordHash: hashedPassword,
+		RoleID:       roleID(t, td, rbac.RoleAdmin),
+		IsActive:     true,
+	}
+	require.NoError(t, td.DB.Create(&admin).Error)
+	customer := repo.Customer{TCVKN: "10000000146", Name: "Ali Veli", City: "İstanbul"}
+	require.NoError(t, td.DB.Create(&customer).Error)
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&buf).Encode(body))
+		}
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+issueTokens(t, td, admin).AccessToken)
+
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		return w
+	}
+	customerPath := fmt.Sprintf("/api/v1/customers/%d", customer.ID)
+
+	// Reads aren't audited
+	require.Equal(t, http.StatusOK, do("GET", "/api/v1/branches", nil).Code)
+	var count int64
+	td.DB.Model(&repo.AuditLog{}).Count(&count)
+	assert.EqualValues(t, 0, count)
+
+	// Updates store the handler's metadata and what changed
+	w := do("PUT", customerPath, map[string]interface{}{
+		"tc_vkn": customer.TCVKN,
+		"name":   "Ali Veli Yılmaz",
+		"city":   "İstanbul",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var entry repo.AuditLog
+	require.NoError(t, td.DB.Where("action = ?", "customer_updated").First(&entry).Error)
+	assert.Equal(t, admin.ID, *entry.UserID)
+	assert.Equal(t, "customer", entry.Entity)
+	assert.Equal(t, customer.ID, *entry.EntityID)
+	assert.Equal(t, "PUT", entry.Method)
+	assert.Equal(t, "/api/v1/customers/:id", entry.Route)
+	assert.Equal(t, http.StatusOK, entry.StatusCode)
+
+	// Müşterinin kişisel verileri kayda yazılmaz, yalnızca değişen alan görünür
+	assert.NotContains(t, entry.MetaJSON, "Ali Veli")
+	require.NotNil(t, entry.ChangesJSON)
+	assert.NotContains(t, *entry.ChangesJSON, "Ali Veli")
+	var changes map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(*entry.ChangesJSON), &changes))
+	assert.Equal(t, map[string]interface{}{"old": nil, "new": nil, "redacted": true}, changes["name"])
+	assert.NotContains(t, changes, "city")
+	assert.NotContains(t, changes, "updated_at")
+
+	// Failed and unannotated requests are recorded from their route
+	w = do("PUT", customerPath, map[string]interface{}{"name": "Eksik"})
+	require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	entry = repo.AuditLog{}
+	require.NoError(t, td.DB.Where("action = ?", "put").First(&entry).Error)
+	assert.Equal(t, "customer", entry.Entity)
+	assert.Equal(t, customer.ID, *entry.EntityID)
+	assert.Equal(t, http.StatusBadRequest, entry.StatusCode)
+	assert.Nil(t, entry.ChangesJSON)
+
+	w = do("POST", "/api/v1/branches", map[string]interface{}{"name": "Kadıköy", "city": "İstanbul"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	entry = repo.AuditLog{}
+	require.NoError(t, td.DB.Where("action = ?", "post").First(&entry).Error)
+	assert.Equal(t, "branch", entry.Entity)
+	assert.Equal(t, "/api/v1/branches", entry.Route)
+
+	// Logins are attributed to the user logging in
+	body, _ := json.Marshal(map[string]string{"email": admin.Email, "password": "password123"})
+	req, _ := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	td.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	entry = repo.AuditLog{}
+	require.NoError(t, td.DB.Where("action = ?", "login").First(&entry).Error)
+	assert.Equal(t, admin.ID, *entry.UserID)
+	assert.Contains(t, entry.MetaJSON, admin.Email)
+}
+
+func TestAuditLogs(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	admin := repo.User{
+		Email:        "admin@example.com",
+		PasswordHash: hashedPassword,
+		RoleID:       roleID(t, td, rbac.RoleAdmin),
+		IsActive:     true,
+	}
+	require.NoError(t, td.DB.Create(&admin).Error)
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&buf).Encode(body))
+		}
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+issueTokens(t, td, admin).AccessToken)
+
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		return w
+	}
+	list := func(query string) apih.AuditLogPage {
+		w := do("GET", "/api/v1/audit-logs?"+query, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var page apih.AuditLogPage
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		return page
+	}
+	verify := func() repo.AuditChainReport {
+		w := do("GET", "/api/v1/audit-logs/verify", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var report repo.AuditChainReport
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		return report
+	}
+
+	for _, name := range []string{"Kadıköy", "Çankaya", "Konak"} {
+		w := do("POST", "/api/v1/branches", map[string]interface{}{"name": name, "city": "İstanbul"})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	}
+	w := do("PUT", "/api/v1/branches/1", map[string]interface{}{"name": "Kadıköy Merkez"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Pages go from newest to oldest, linked by cursor
+	first := list("entity=branch&limit=3")
+	require.Len(t, first.Data, 3)
+	assert.Equal(t, "branch_updated", first.Data[0].Action)
+	assert.Equal(t, admin.Email, first.Data[0].UserEmail)
+	assert.Contains(t, string(first.Data[0].Changes), "Kadıköy Merkez")
+	require.NotEmpty(t, first.NextCursor)
+
+	second := list("entity=branch&limit=3&cursor=" + first.NextCursor)
+	require.Len(t, second.Data, 1)
+	assert.Empty(t, second.NextCursor)
+	assert.Less(t, second.Data[0].ID, first.Data[2].ID)
+
+	assert.Len(t, list("action=post").Data, 3)
+	assert.Len(t, list("entity=branch&entity_id=1").Data, 1)
+	assert.Len(t, list(fmt.Sprintf("user_id=%d&from=%s", admin.ID, time.Now().UTC().Format("2006-01-02"))).Data, 4)
+	assert.Empty(t, list("to=2020-01-01").Data)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/api/v1/audit-logs?from=yesterday", nil).Code)
+
+	// The untouched chain verifies
+	report := verify()
+	assert.True(t, report.Valid, report.Reason)
+	assert.EqualValues(t, 4, report.Checked)
+	assert.Equal(t, first.Data[0].Hash, report.LastHash)
+
+	// Editing an entry breaks the chain at that entry
+	target := second.Data[0].ID
+	require.NoError(t, td.DB.Model(&repo.AuditLog{}).Where("id = ?", target).Update("ip", "10.0.0.1").Error)
+	report = verify()
+	assert.False(t, report.Valid)
+	require.NotNil(t, report.BrokenAt)
+	assert.Equal(t, target, *report.BrokenAt)
+
+	// So does removing one, at the entry after it
+	require.NoError(t, td.DB.Delete(&repo.AuditLog{}, target).Error)
+	report = verify()
+	assert.False(t, report.Valid)
+	require.NotNil(t, report.BrokenAt)
+	assert.Equal(t, first.Data[2].ID, *report.BrokenAt)
+}
+
+func TestTimeline(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	branch := repo.Branch{Name: "Kadıköy"}
+	require.NoError(t, td.DB.Create(&branch).Error)
+	newUser := func(email, role string) repo.User {
+		user := repo.User{
+			Email:        email,
+			PasswordHash: hashedPassword,
+			RoleID:       roleID(t, td, role),
+			BranchID:     &branch.ID,
+			IsActive:     true,
+		}
+		require.NoError(t, td.DB.Create(&user).Error)
+		return user
+	}
+	admin := newUser("admin@example.com", rbac.RoleAdmin)
+	agent := newUser("agent@example.com", rbac.RoleAgent)
+	otherAgent := newUser("other@example.com", rbac.RoleAgent)
+
+	customer := repo.Customer{TCVKN: "10000000146", Name: "Ali Veli", Phone: "0555 123 45 67"}
+	product := repo.Product{Type: "kasko", Name: "Kasko"}
+	require.NoError(t, td.DB.Create(&customer).Error)
+	require.NoError(t, td.DB.Create(&product).Error)
+	quote := repo.Quote{
+		CustomerID:   customer.ID,
+		ProductID:    product.ID,
+		AgentID:      agent.ID,
+		CoverageType: "kasko",
+		StartDate:    "2026-01-01",
+		EndDate:      "2027-01-01",
+	}
+	require.NoError(t, td.DB.Create(&quote).Error)
+	offer := repo.ScrapedQuote{QuoteID: quote.ID, CompanyName: "Anadolu", Premium: 1200, FinalPrice: 1100, Status: "scraped", ScrapedAt: time.Now()}
+	require.NoError(t, td.DB.Create(&offer).Error)
+
+	do := func(method, path string, user repo.User, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&buf).Encode(body))
+		}
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+issueTokens(t, td, user).AccessToken)
+
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		return w
+	}
+	timeline := func(path string, user repo.User) ([]repo.TimelineEvent, int64) {
+		w := do("GET", path, user, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var page struct {
+			Data  []repo.TimelineEvent `json:"data"`
+			Total int64                `json:"total"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		return page.Data, page.Total
+	}
+	types := func(events []repo.TimelineEvent) []string {
+		var names []string
+		for _, event := range events {
+			names = append(names, event.Type)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	// Approving an offer issues the policy, which is then renewed and paid
+	w := do("POST", fmt.Sprintf("/api/v1/quotes/%d/approve/%d", quote.ID, offer.ID), agent, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var policy repo.Policy
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &policy))
+
+	w = do("PUT", fmt.Sprintf("/api/v1/policies/%d", policy.ID), agent, map[string]interface{}{"end_date": "2028-01-01"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	account := repo.Account{BranchID: branch.ID}
+	require.NoError(t, td.DB.Create(&account).Error)
+	require.NoError(t, td.DB.Create(&repo.Payment{AccountID: account.ID, PolicyID: &policy.ID, Amount: 1100, Method: "kredi_karti", PaidAt: time.Now()}).Error)
+
+	w = do("PUT", fmt.Sprintf("/api/v1/customers/%d", customer.ID), admin, map[string]interface{}{
+		"tc_vkn": customer.TCVKN,
+		"name":   customer.Name,
+		"phone":  "0532 000 00 00",
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	customerPath := fmt.Sprintf("/api/v1/customers/%d/timeline", customer.ID)
+	events, total := timeline(customerPath, agent)
+	assert.EqualValues(t, 8, total)
+	assert.Equal(t, []string{
+		repo.TimelineCustomerCreated, repo.TimelineOfferReceived, repo.TimelinePaymentReceived, repo.TimelinePolicyIssued,
+		repo.TimelinePolicyRenewed, repo.TimelineProfileUpdated, repo.TimelineQuoteApproved, repo.TimelineQuoteCreated,
+	}, types(events))
+	for i := 1; i < len(events); i++ {
+		assert.False(t, events[i].At.After(events[i-1].At), "timeline must be newest first")
+	}
+
+	// Profile edits name the fields but don't reveal personal data
+	for _, event := range events {
+		if event.Type == repo.TimelineProfileUpdated {
+			assert.Equal(t, []interface{}{"phone"}, event.Data["fields"])
+			assert.NotContains(t, fmt.Sprint(event.Data), "0532")
+		}
+	}
+
+	// Type filters and pagination
+	events, total = timeline(customerPath+"?types=payment_received,policy_renewed", agent)
+	assert.EqualValues(t, 2, total)
+	assert.Equal(t, []string{repo.TimelinePaymentReceived, repo.TimelinePolicyRenewed}, types(events))
+	events, total = timeline(customerPath+"?page=2&pageSize=5", agent)
+	assert.EqualValues(t, 8, total)
+	assert.Len(t, events, 3)
+	assert.Equal(t, http.StatusBadRequest, do("GET", customerPath+"?types=birthday", agent, nil).Code)
+
+	// Müşteri, teklifi ve poliçesi olmayan acentenin veri kapsamı dışında
+	assert.Equal(t, http.StatusNotFound, do("GET", customerPath, otherAgent, nil).Code)
+
+	// Policies and quotes have their own timelines
+	events, _ = timeline(fmt.Sprintf("/api/v1/policies/%d/timeline", policy.ID), agent)
+	assert.Equal(t, []string{
+		repo.TimelineOfferReceived, repo.TimelinePaymentReceived, repo.TimelinePolicyIssued,
+		repo.TimelinePolicyRenewed, repo.TimelineQuoteApproved, repo.TimelineQuoteCreated,
+	}, types(events))
+	events, _ = timeline(fmt.Sprintf("/api/v1/quotes/%d/timeline?types=quote_approved", quote.ID), agent)
+	require.Len(t, events, 1)
+	assert.EqualValues(t, policy.ID, events[0].Data["policy_id"])
+	assert.Equal(t, http.StatusNotFound, do("GET", fmt.Sprintf("/api/v1/quotes/%d/timeline", quote.ID), otherAgent, nil).Code)
+}
+
+func TestCustomerValidation(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	admin := repo.User{
+		Email:        "admin@example.com",
+		PasswordHash: hashedPassword,
+		RoleID:       roleID(t, td, rbac.RoleAdmin),
+		IsActive:     true,
+	}
+	require.NoError(t, td.DB.Create(&admin).Error)
+	tokenPair := issueTokens(t, td, admin)
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		jsonData, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+tokenPair.AccessToken)
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		return w
+	}
+	fieldErrors := func(w *httptest.ResponseRecorder) map[string]string {
+		require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+		var response apih.ValidationErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Fields
+	}
+
+	// Eski kontrol yalnızca 11. haneye bakıyordu, 10. hane kuralını atlıyordu
+	assert.True(t, validation.ValidateTCKN("10000000146"))
+	assert.False(t, validation.ValidateTCKN("10000000156"))
+	assert.False(t, validation.ValidateTCKN("01234567890"))
+	assert.True(t, validation.ValidateVKN("1234567890"))
+	assert.False(t, validation.ValidateVKN("1234567891"))
+
+	// Tüm hatalar alan alan döner
+	fields := fieldErrors(do("POST", "/api/v1/customers", map[string]interface{}{
+		"tc_vkn":      "10000000156",
+		"email":       "not-an-email",
+		"phone":       "123",
+		"postal_code": "34",
+		"birth_date":  "01.01.1990",
+	}))
+	assert.Equal(t, []string{"birth_date", "email", "name", "phone", "postal_code", "tc_vkn"}, sortedKeys(fields))
+	assert.Contains(t, fields["tc_vkn"], "TC Kimlik No")
+
+	// Tür kimlik numarasından çıkarılır
+	w := do("POST", "/api/v1/customers", map[string]interface{}{
+		"tc_vkn":     "100 000 001 46",
+		"name":       "Ali Veli",
+		"phone":      "+90 555 123 45 67",
+		"birth_date": "1990-01-01",
+		"gender":     "male",
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var individual apih.CustomerResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &individual))
+	assert.Equal(t, "10000000146", individual.TCVKN)
+	assert.Equal(t, repo.CustomerTypeIndividual, individual.CustomerType)
+	assert.Equal(t, "1990-01-01", individual.BirthDate)
+
+	// Kurumsal müşterinin kendi alanları zorunludur
+	fields = fieldErrors(do("POST", "/api/v1/customers", map[string]interface{}{
+		"tc_vkn": "1234567890",
+		"name":   "Demir Lojistik",
+		"gender": "male",
+	}))
+	assert.Equal(t, []string{"gender", "tax_office", "trade_title"}, sortedKeys(fields))
+
+	fields = fieldErrors(do("POST", "/api/v1/customers", map[string]interface{}{
+		"tc_vkn":        "1234567890",
+		"customer_type": repo.CustomerTypeIndividual,
+		"name":          "Demir Lojistik",
+	}))
+	assert.Contains(t, fields, "customer_type")
+
+	w = do("POST", "/api/v1/customers", map[string]interface{}{
+		"tc_vkn":            "1234567890",
+		"customer_type":     repo.CustomerTypeCorporate,
+		"name":              "Demir Lojistik",
+		"tax_office":        "Tuzla",
+		"trade_title":       "Demir Lojistik Taşımacılık A.Ş.",
+		"authorized_person": "Mehmet Demir",
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var corporate apih.CustomerResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &corporate))
+	assert.Equal(t, repo.CustomerTypeCorporate, corporate.CustomerType)
+	assert.Equal(t, "Tuzla", corporate.TaxOffice)
+
+	// Güncelleme aynı kurallarla denetlenir, geçersiz istek kaydı değiştirmez
+	fields = fieldErrors(do("PUT", fmt.Sprintf("/api/v1/customers/%d", individual.ID), map[string]interface{}{
+		"tc_vkn":     individual.TCVKN,
+		"name":       individual.Name,
+		"tax_office": "Kadıköy",
+	}))
+	assert.Equal(t, []string{"tax_office"}, sortedKeys(fields))
+
+	var stored repo.Customer
+	require.NoError(t, td.DB.First(&stored, individual.ID).Error)
+	assert.Empty(t, stored.TaxOffice)
+
+	w = do("PUT", fmt.Sprintf("/api/v1/customers/%d", individual.ID), map[string]interface{}{
+		"tc_vkn": corporate.TCVKN,
+		"name":   individual.Name,
+		"gender": "male",
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestCustomerDuplicates(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	branch := repo.Branch{Name: "Kadıköy"}
+	require.NoError(t, td.DB.Create(&branch).Error)
+	admin := repo.User{
+		Email:        "admin@example.com",
+		PasswordHash: hashedPassword,
+		RoleID:       roleID(t, td, rbac.RoleAdmin),
+		BranchID:     &branch.ID,
+		IsActive:     true,
+	}
+	require.NoError(t, td.DB.Create(&admin).Error)
+
+	birthDate := time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+	ali := repo.Customer{TCVKN: "10000000146", Name: "Ali Veli", Phone: "0555 123 45 67", BirthDate: &birthDate}
+	// Aynı kişi, TC yerine VKN ile ve yazım hatasıyla girilmiş
+	aliVKN := repo.Customer{TCVKN: "1234567890", CustomerType: repo.CustomerTypeCorporate, Name: "ALİ VELI", Phone: "555 123 4567"}
+	ayse := repo.Customer{TCVKN: "12345678950", Name: "Ayşe Yılmaz", Email: "ayse@example.com"}
+	ayseTypo := repo.Customer{TCVKN: "23456789138", Name: "Ayse Yilmaz", Email: "AYSE@example.com "}
+	mehmet := repo.Customer{TCVKN: "34567891238", Name: "Mehmet Demir"}
+	mehmetOther := repo.Customer{TCVKN: "45678912316", Name: "Mehmet Demir"}
+	for _, customer := range []*repo.Customer{&ali, &aliVKN, &ayse, &ayseTypo, &mehmet, &mehmetOther} {
+		require.NoError(t, td.DB.Create(customer).Error)
+	}
+	// Doğrudan yazılan müşterilerin çiftleri periyodik iş ile puanlanır
+	task := asynq.NewTask(jobs.TypeScoreDuplicates, nil)
+	require.NoError(t, jobs.HandleScoreDuplicatesTask(context.Background(), task, td.Repo))
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&buf).Encode(body))
+		}
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+issueTokens(t, td, admin).AccessToken)
+
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		return w
+	}
+	duplicates := func(query string) []apih.DuplicateMatch {
+		w := do("GET", "/api/v1/customers/duplicates"+query, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var page struct {
+			Data []apih.DuplicateMatch `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		return page.Data
+	}
+
+	// Yalnızca isim benzerliği varsayılan eşiğin altında kalır
+	matches := duplicates("")
+	require.Len(t, matches, 2)
+	for _, match := range matches {
+		assert.Equal(t, 70, match.Score)
+	}
+	assert.Equal(t, ali.ID, matches[0].Customer.ID)
+	assert.Equal(t, aliVKN.ID, matches[0].Duplicate.ID)
+	assert.Equal(t, []string{"phone", "name"}, matches[0].Reasons)
+	assert.Equal(t, ayse.ID, matches[1].Customer.ID)
+	assert.Equal(t, []string{"email", "name"}, matches[1].Reasons)
+
+	assert.Len(t, duplicates("?min_score=40"), 3)
+	matches = duplicates(fmt.Sprintf("?customer_id=%d", ayseTypo.ID))
+	require.Len(t, matches, 1)
+	assert.Equal(t, ayseTypo.ID, matches[0].Duplicate.ID)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/api/v1/customers/duplicates?min_score=20", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/api/v1/customers/duplicates?min_score=101", nil).Code)
+
+	// Birleştirme teklif, poliçe ve ödemeleri taşır, kopyayı siler
+	product := repo.Product{Type: "kasko", Name: "Kasko"}
+	require.NoError(t, td.DB.Create(&product).Error)
+	quote := repo.Quote{CustomerID: aliVKN.ID, ProductID: product.ID, AgentID: admin.ID, CoverageType: "kasko"}
+	require.NoError(t, td.DB.Create(&quote).Error)
+	policy := repo.Policy{
+		CustomerID: aliVKN.ID, ProductID: product.ID, AgentID: admin.ID, QuoteID: &quote.ID,
+		PolicyNumber: "POL-1", CompanyName: "Anadolu", Premium: 1100, StartDate: "2026-01-01", EndDate: "2027-01-01",
+	}
+	require.NoError(t, td.DB.Create(&policy).Error)
+	account := repo.Account{BranchID: branch.ID}
+	require.NoError(t, td.DB.Create(&account).Error)
+	payment := repo.Payment{AccountID: account.ID, PolicyID: &policy.ID, Amount: 1100, Method: "kredi_karti", PaidAt: time.Now()}
+	require.NoError(t, td.DB.Create(&payment).Error)
+	consent := repo.CustomerConsent{CustomerID: aliVKN.ID, Purpose: repo.ConsentMarketing, Channel: repo.ConsentChannelSMS, TextVersion: "2026-01", GrantedAt: time.Now()}
+	require.NoError(t, td.DB.Create(&consent).Error)
+
+	mergePath := fmt.Sprintf("/api/v1/customers/%d/merge", ali.ID)
+	assert.Equal(t, http.StatusBadRequest, do("POST", mergePath, map[string]interface{}{"duplicate_id": ali.ID}).Code)
+
+	w := do("POST", mergePath, map[string]interface{}{"duplicate_id": aliVKN.ID})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var merged apih.MergeCustomerResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &merged))
+	assert.Equal(t, ali.ID, merged.Customer.ID)
+	assert.Equal(t, aliVKN.ID, merged.MergedID)
+	assert.Equal(t, repo.CustomerMerge{Quotes: 1, Policies: 1, Payments: 1, Consents: 1}, merged.Moved)
+	require.NoError(t, td.DB.First(&consent, consent.ID).Error)
+	assert.Equal(t, ali.ID, consent.CustomerID)
+
+	require.NoError(t, td.DB.First(&quote, quote.ID).Error)
+	require.NoError(t, td.DB.First(&policy, policy.ID).Error)
+	assert.Equal(t, ali.ID, quote.CustomerID)
+	assert.Equal(t, ali.ID, policy.CustomerID)
+	assert.ErrorIs(t, td.DB.First(&repo.Customer{}, aliVKN.ID).Error, gorm.ErrRecordNotFound)
+
+	// Her iki taraf da denetim kaydına yazılır
+	var entries []repo.AuditLog
+	require.NoError(t, td.DB.Where("action LIKE ?", "customer_merged%").Order("id").Find(&entries).Error)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "customer_merged", entries[0].Action)
+	assert.Equal(t, ali.ID, *entries[0].EntityID)
+	assert.Equal(t, "customer_merged_into", entries[1].Action)
+	assert.Equal(t, aliVKN.ID, *entries[1].EntityID)
+	for _, entry := range entries {
+		assert.NotContains(t, entry.MetaJSON, "Ali Veli")
+		assert.NotContains(t, entry.MetaJSON, ali.TCVKN)
+	}
+
+	assert.Equal(t, http.StatusNotFound, do("POST", mergePath, map[string]interface{}{"duplicate_id": aliVKN.ID}).Code)
+	assert.Len(t, duplicates(""), 1)
+
+	// Kaydedilen müşterinin çiftleri hemen puanlanır
+	w = do("POST", "/api/v1/customers", map[string]interface{}{"tc_vkn": "56789123416", "name": "Ali Veli", "phone": "+90 555 123 45 67"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created apih.CustomerResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	matches = duplicates(fmt.Sprintf("?customer_id=%d", ali.ID))
+	require.Len(t, matches, 1)
+	assert.Equal(t, created.ID, matches[0].Duplicate.ID)
+	assert.Equal(t, []string{"phone", "name"}, matches[0].Reasons)
+
+	w = do("PUT", fmt.Sprintf("/api/v1/customers/%d", created.ID), map[string]interface{}{"tc_vkn": "56789123416", "name": "Veli Kaya"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(t, duplicates(fmt.Sprintf("?customer_id=%d", ali.ID)))
+}
+
+func TestCustomerImport(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	admin := repo.User{Email: "admin@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAdmin), IsActive: true}
+	agent := repo.User{Email: "agent@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAgent), IsActive: true}
+	otherAgent := repo.User{Email: "other@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAgent), IsActive: true}
+	for _, user := range []*repo.User{&admin, &agent, &otherAgent} {
+		require.NoError(t, td.DB.Create(user).Error)
+	}
+
+	ali := repo.Customer{TCVKN: "10000000146", Name: "Ali Veli", Phone: "0555 123 45 67"}
+	require.NoError(t, td.DB.Create(&ali).Error)
+
+	upload := func(user repo.User, fileName string, data []byte, fields map[string]string) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		form := multipart.NewWriter(&buf)
+		part, err := form.CreateFormFile("file", fileName)
+		require.NoError(t, err)
+		part.Write(data)
+		for name, value := range fields {
+			require.NoError(t, form.WriteField(name, value))
+		}
+		require.NoError(t, form.Close())
+
+		req, _ := http.NewRequest("POST", "/api/v1/customers/import", &buf)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+issueTokens(t, td, user).AccessToken)
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		return w
+	}
+	imported := func(w *httptest.ResponseRecorder) apih.CustomerImportResponse {
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var result apih.CustomerImportResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result
+	}
+
+	// Türkçe Excel'in kaydettiği gibi noktalı virgüllü CSV
+	csvFile := []byte(strings.Join([]string{
+		"TC No;Ad Soyad;E-posta;Telefon;Doğum Tarihi;Vergi Dairesi;Unvan",
+		"10000000146;;ali@example.com;;;;",
+		"12345678950;Ayşe Yılmaz;ayse@example.com;0532 111 22 33;15.03.1985;;",
+		"1234567890;Acme;info@acme.com;;;Kadıköy;Acme A.Ş.",
+		";;;;;;",
+		"12345678951;Hatalı TC;bad-email;;;;",
+		"12345678950;Ayşe Tekrar;;;;;",
+	}, "\n"))
+	mapping := `{"tc_vkn":"TC No","name":"ad soyad","email":"E-posta","phone":"Telefon","birth_date":"Doğum Tarihi","tax_office":"Vergi Dairesi","trade_title":"Unvan"}`
+
+	// Deneme çalıştırması hiçbir şey kaydetmeden satır bazında rapor döner
+	result := imported(upload(admin, "musteriler.csv", csvFile, map[string]string{"mapping": mapping, "dry_run": "true"}))
+	assert.Equal(t, repo.ImportCompleted, result.Status)
+	assert.True(t, result.DryRun)
+	assert.Equal(t, 5, result.TotalRows)
+	assert.Equal(t, 5, result.ProcessedRows)
+	assert.Equal(t, 2, result.Created)
+	assert.Equal(t, 1, result.Updated)
+	assert.Equal(t, 2, result.Failed)
+	require.Len(t, result.Errors, 2)
+	assert.Equal(t, 6, result.Errors[0].Line)
+	assert.Equal(t, []string{"email", "tc_vkn"}, sortedKeys(result.Errors[0].Fields))
+	assert.Equal(t, 7, result.Errors[1].Line)
+	assert.Equal(t, "is already in row 3", result.Errors[1].Fields["tc_vkn"])
+
+	var count int64
+	td.DB.Model(&repo.Customer{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// İçe aktarma mevcut müşterileri güncellediği için yalnızca müşteri
+	// oluşturabilen kullanıcı içe aktaramaz
+	w := doJSON(t, td, "POST", "/api/v1/roles", issueTokens(t, td, admin).AccessToken, map[string]interface{}{
+		"name": "customer_creator", "data_scope": repo.ScopeAll, "permissions": []string{rbac.PermissionCustomerCreate, rbac.PermissionCustomerRead},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var creatorRole apih.RoleResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &creatorRole))
+	creator := repo.User{Email: "creator@example.com", PasswordHash: hashedPassword, RoleID: creatorRole.ID, IsActive: true}
+	require.NoError(t, td.DB.Create(&creator).Error)
+	w = upload(creator, "musteriler.csv", csvFile, map[string]string{"mapping": mapping})
+	assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), rbac.PermissionCustomerUpdate)
+	require.NoError(t, td.DB.First(&ali, ali.ID).Error)
+	assert.Empty(t, ali.Email)
+
+	// Gerçek çalıştırma, mevcut TC'yi 409 yerine günceller
+	result = imported(upload(admin, "musteriler.csv", csvFile, map[string]string{"mapping": mapping}))
+	assert.Equal(t, 2, result.Created)
+	assert.Equal(t, 1, result.Updated)
+	assert.Equal(t, 2, result.Failed)
+
+	require.NoError(t, td.DB.First(&ali, ali.ID).Error)
+	assert.Equal(t, "Ali Veli", ali.Name, "empty cells keep the current value")
+	assert.Equal(t, "ali@example.com", ali.Email)
+	var ayse, acme repo.Customer
+	require.NoError(t, td.DB.Where("tc_vkn = ?", "12345678950").First(&ayse).Error)
+	assert.Equal(t, "Ayşe Yılmaz", ayse.Name)
+	require.NotNil(t, ayse.BirthDate)
+	assert.Equal(t, "1985-03-15", ayse.BirthDate.Format("2006-01-02"))
+	require.NoError(t, td.DB.Where("tc_vkn = ?", "1234567890").First(&acme).Error)
+	assert.Equal(t, repo.CustomerTypeCorporate, acme.CustomerType)
+	assert.Equal(t, "Acme A.Ş.", acme.TradeTitle)
+
+	var entries []repo.AuditLog
+	require.NoError(t, td.DB.Where("action IN ?", []string{"customer_created", "customer_updated"}).Order("id").Find(&entries).Error)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "customer_updated", entries[0].Action)
+	assert.Equal(t, ali.ID, *entries[0].EntityID)
+	require.NotNil(t, entries[0].ChangesJSON)
+	assert.Contains(t, *entries[0].ChangesJSON, `"email"`)
+	assert.Contains(t, entries[1].MetaJSON, `"source":"import"`)
+	td.DB.Model(&repo.AuditLog{}).Where("action = ?", "customers_imported").Count(&count)
+	assert.Equal(t, int64(2), count)
+
+	// Aynı dosya tekrar yüklendiğinde değişmeyen satırlar güncellenmez
+	result = imported(upload(admin, "musteriler.csv", csvFile, map[string]string{"mapping": mapping}))
+	assert.Equal(t, 0, result.Created)
+	assert.Equal(t, 0, result.Updated)
+	assert.Equal(t, 3, result.Unchanged)
+
+	// XLSX: paylaşılan ve satır içi metinler, sayı olarak saklanan TC ve Excel tarihi
+	xlsxFile := buildXLSX(t,
+		[]string{"tc_vkn", "Name", "Cinsiyet"},
+		`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="s"><v>2</v></c><c r="D1" t="inlineStr"><is><t>birth_date</t></is></c></row>`+
+			`<row r="2"><c r="A2"><v>2.3456789138E10</v></c><c r="B2" t="inlineStr"><is><r><t>Mehmet </t></r><r><t>Demir</t></r></is></c><c r="D2"><v>32874</v></c></row>`+
+			`<row r="4"><c r="A4" t="inlineStr"><is><t>34567891238</t></is></c><c r="B4" t="inlineStr"><is><t>Zeynep Kaya</t></is></c><c r="C4" t="inlineStr"><is><t>FEMALE</t></is></c></row>`,
+	)
+	result = imported(upload(agent, "liste.xlsx", xlsxFile, map[string]string{"mapping": `{"gender":"CİNSİYET"}`}))
+	assert.Equal(t, "xlsx", result.Format)
+	assert.Equal(t, 2, result.Created, result.Errors)
+	var mehmet, zeynep repo.Customer
+	require.NoError(t, td.DB.Where("tc_vkn = ?", "23456789138").First(&mehmet).Error)
+	assert.Equal(t, "Mehmet Demir", mehmet.Name)
+	require.NotNil(t, mehmet.BirthDate)
+	assert.Equal(t, "1990-01-01", mehmet.BirthDate.Format("2006-01-02"))
+	require.NoError(t, td.DB.Where("tc_vkn = ?", "34567891238").First(&zeynep).Error)
+	assert.Equal(t, "female", zeynep.Gender)
+
+	// Dosya bütünüyle hatalıysa içe aktarma başlamadan reddedilir
+	for name, fields := range map[string]map[string]string{
+		"unknown field":  {"mapping": `{"nickname":"Ad Soyad"}`},
+		"missing column": {"mapping": `{"phone":"Cep"}`},
+		"bad mapping":    {"mapping": `["tc_vkn"]`},
+		"bad dry_run":    {"mapping": mapping, "dry_run": "maybe"},
+	} {
+		assert.Equal(t, http.StatusBadRequest, upload(admin, "musteriler.csv", csvFile, fields).Code, name)
+	}
+	assert.Equal(t, http.StatusBadRequest, upload(admin, "eski.xls", csvFile, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, upload(admin, "bos.csv", []byte("tc_vkn;name\n"), nil).Code)
+
+	// Büyük dosyalar kuyruğa alınır; iş, ilerlemesi sorgulanan kaydı tamamlar
+	customerImport := repo.CustomerImport{
+		UserID:      agent.ID,
+		FileName:    "buyuk.csv",
+		Format:      "csv",
+		MappingJSON: mapping,
+		File:        csvFile,
+		Status:      repo.ImportPending,
+		TotalRows:   5,
+		ReportJSON:  "[]",
+	}
+	require.NoError(t, td.Repo.CreateCustomerImport(&customerImport))
+	task, err := jobs.NewImportCustomersTask(customerImport.ID)
+	require.NoError(t, err)
+	require.NoError(t, jobs.HandleImportCustomersTask(context.Background(), task, td.Repo))
+
+	poll := func(user repo.User) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/customers/imports/%d", customerImport.ID), nil)
+		req.Header.Set("Authorization", "Bearer "+issueTokens(t, td, user).AccessToken)
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		return w
+	}
+	// Dosyadaki mevcut müşteriler yöneticinin, acentenin veri kapsamı dışında
+	// kaldıkları için güncellenmez
+	result = imported(poll(agent))
+	assert.Equal(t, repo.ImportCompleted, result.Status)
+	assert.Equal(t, 5, result.ProcessedRows)
+	assert.Equal(t, 5, result.Failed)
+	assert.Equal(t, "belongs to a customer outside your data scope", result.Errors[0].Fields["tc_vkn"])
+	assert.Equal(t, "100******46", result.Errors[0].TCVKN, "agents can't see TCs unmasked")
+	assert.Equal(t, "10000000146", imported(poll(admin)).Errors[0].TCVKN)
+	assert.Equal(t, http.StatusNotFound, poll(otherAgent).Code)
+
+	require.NoError(t, td.DB.First(&customerImport, customerImport.ID).Error)
+	assert.Nil(t, customerImport.File, "the file isn't kept once imported")
+
+	// Denetim kaydı yazılamayan satır kaydedilmez
+	require.NoError(t, td.DB.Exec(`CREATE TRIGGER fail_customer_audit BEFORE INSERT ON audit_logs
+		WHEN NEW.action = 'customer_created' BEGIN SELECT RAISE(ABORT, 'audit unavailable'); END`).Error)
+	result = imported(upload(admin, "yeni.csv", []byte("tc_vkn;name\n45678912316;Fatma Şahin\n"), nil))
+	assert.Equal(t, 0, result.Created)
+	assert.Equal(t, 1, result.Failed)
+	require.Len(t, result.Errors, 1)
+	assert.Equal(t, "could not be saved", result.Errors[0].Error)
+	assert.ErrorIs(t, td.DB.Where("tc_vkn = ?", "45678912316").First(&repo.Customer{}).Error, gorm.ErrRecordNotFound)
+}
+
+// buildXLSX writes a minimal workbook with the given shared strings and
+// sheet rows
+func buildXLSX(t *testing.T, sharedStrings []string, rows string) []byte {
+	t.Helper()
+
+	var shared strings.Builder
+	for _, s := range sharedStrings {
+		shared.WriteString("<si><t>" + s + "</t></si>")
+	}
+	parts := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Müşteriler" sheetId="1" r:id="rId7"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId7" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/musteriler.xml"/></Relationships>`,
+		"xl/sharedStrings.xml":         `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` + shared.String() + `</sst>`,
+		"xl/worksheets/musteriler.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + rows + `</sheetData></worksheet>`,
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range parts {
+		w, err := archive.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+	return buf.Bytes()
+}
+
+func TestCustomerKVKK(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	admin := repo.User{Email: "admin@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAdmin), IsActive: true}
+	agent := repo.User{Email: "agent@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAgent), IsActive: true}
+	require.NoError(t, td.DB.Create(&admin).Error)
+	require.NoError(t, td.DB.Create(&agent).Error)
+
+	birthDate := time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+	customer := repo.Customer{
+		TCVKN: "10000000146", Name: "Ali Veli", Email: "ali@example.com", Phone: "0555 123 45 67",
+		Address: "Kadıköy Mah. No:1", City: "İstanbul", District: "Kadıköy", BirthDate: &birthDate,
+	}
+	require.NoError(t, td.DB.Create(&customer).Error)
+	product := repo.Product{Type: "kasko", Name: "Kasko"}
+	require.NoError(t, td.DB.Create(&product).Error)
+	quote := repo.Quote{CustomerID: customer.ID, ProductID: product.ID, AgentID: agent.ID, CoverageType: "kasko", VehiclePlate: "34 ABC 123"}
+	require.NoError(t, td.DB.Create(&quote).Error)
+	policy := repo.Policy{
+		CustomerID: customer.ID, ProductID: product.ID, AgentID: agent.ID, QuoteID: &quote.ID,
+		PolicyNumber: "POL-1", CompanyName: "Anadolu", Premium: 1100, StartDate: "2026-01-01", EndDate: "2027-01-01",
+	}
+	require.NoError(t, td.DB.Create(&policy).Error)
+	branch := repo.Branch{Name: "Kadıköy"}
+	require.NoError(t, td.DB.Create(&branch).Error)
+	account := repo.Account{BranchID: branch.ID}
+	require.NoError(t, td.DB.Create(&account).Error)
+	payment := repo.Payment{AccountID: account.ID, PolicyID: &policy.ID, Amount: 1100, Method: "kredi_karti", PaidAt: time.Now()}
+	require.NoError(t, td.DB.Create(&payment).Error)
+
+	do := func(user repo.User, method, path string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&buf).Encode(body))
+		}
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+issueTokens(t, td, user).AccessToken)
+
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		return w
+	}
+	consentsPath := fmt.Sprintf("/api/v1/customers/%d/consents", customer.ID)
+	notify := func(category, channel string) error {
+		task, err := jobs.NewSendNotificationTask(jobs.SendNotificationPayload{
+			CustomerID: customer.ID, Category: category, Channel: channel, Subject: "Kasko yenileme",
+		})
+		require.NoError(t, err)
+		return jobs.HandleSendNotificationTask(context.Background(), task, td.Repo)
+	}
+
+	// Rıza olmadan pazarlama bildirimi gönderilmez, hizmet bildirimi gönderilir
+	assert.ErrorIs(t, notify(jobs.NotificationMarketing, "sms"), jobs.ErrNoMarketingConsent)
+	assert.NoError(t, notify(jobs.NotificationService, "email"))
+
+	// Bilinmeyen kategoriler rıza kontrolünü atlayamaz, yeniden denenmez
+	for _, category := range []string{"", "Marketing", "promo"} {
+		err := notify(category, "sms")
+		assert.ErrorIs(t, err, jobs.ErrUnknownCategory, category)
+		assert.ErrorIs(t, err, asynq.SkipRetry, category)
+	}
+
+	w := do(agent, "POST", consentsPath, map[string]interface{}{"purpose": "marketing", "channel": "sms", "text_version": "2026-01"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var smsConsent repo.CustomerConsent
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &smsConsent))
+	assert.Equal(t, agent.ID, *smsConsent.RecordedBy)
+	assert.Nil(t, smsConsent.RevokedAt)
+
+	assert.NoError(t, notify(jobs.NotificationMarketing, "sms"))
+	assert.ErrorIs(t, notify(jobs.NotificationMarketing, "email"), jobs.ErrNoMarketingConsent)
+
+	for name, body := range map[string]map[string]interface{}{
+		"unknown purpose":      {"purpose": "profiling", "text_version": "2026-01"},
+		"missing text version": {"purpose": "marketing", "channel": "email"},
+		"channel for sharing":  {"purpose": "data_sharing", "channel": "sms", "text_version": "2026-01"},
+		"granted in future":    {"purpose": "marketing", "channel": "email", "text_version": "2026-01", "granted_at": time.Now().Add(time.Hour)},
+	} {
+		assert.Equal(t, http.StatusBadRequest, do(agent, "POST", consentsPath, body).Code, name)
+	}
+	assert.Equal(t, http.StatusConflict, do(agent, "POST", consentsPath, map[string]interface{}{"purpose": "marketing", "channel": "sms", "text_version": "2026-02"}).Code)
+
+	signedAt := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	w = do(agent, "POST", consentsPath, map[string]interface{}{"purpose": "data_sharing", "text_version": "2025-05", "granted_at": signedAt})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	// Geri alınan rıza silinmez, pazarlama yeniden engellenir
+	revokePath := fmt.Sprintf("%s/%d/revoke", consentsPath, smsConsent.ID)
+	require.Equal(t, http.StatusOK, do(agent, "POST", revokePath, nil).Code)
+	assert.Equal(t, http.StatusConflict, do(agent, "POST", revokePath, nil).Code)
+	assert.ErrorIs(t, notify(jobs.NotificationMarketing, "sms"), jobs.ErrNoMarketingConsent)
+
+	w = do(agent, "GET", consentsPath, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var consents []repo.CustomerConsent
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &consents))
+	require.Len(t, consents, 2)
+	assert.Equal(t, smsConsent.ID, consents[0].ID)
+	assert.NotNil(t, consents[0].RevokedAt)
+	assert.Equal(t, "all", consents[1].Channel)
+	assert.True(t, signedAt.Equal(consents[1].GrantedAt))
+
+	// Dışa aktarma kişi hakkında tutulan her şeyi maskesiz verir
+	w = do(admin, "GET", fmt.Sprintf("/api/v1/customers/%d/kvkk-export", customer.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+	var export repo.CustomerDataExport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &export))
+	assert.Equal(t, "10000000146", export.Customer.TCVKN)
+	assert.Equal(t, "ali@example.com", export.Customer.Email)
+	assert.Len(t, export.Consents, 2)
+	require.Len(t, export.Quotes, 1)
+	assert.Equal(t, "34 ABC 123", export.Quotes[0]["vehicle_plate"])
+	assert.NotContains(t, export.Quotes[0], "customer")
+	require.Len(t, export.Policies, 1)
+	assert.Equal(t, "POL-1", export.Policies[0]["policy_number"])
+	assert.Len(t, export.Payments, 1)
+	assert.NotEmpty(t, export.Timeline)
+
+	// Aktif poliçesi olan müşteri anonimleştirilemez
+	anonymizePath := fmt.Sprintf("/api/v1/customers/%d/anonymize", customer.ID)
+	assert.Equal(t, http.StatusConflict, do(admin, "POST", anonymizePath, nil).Code)
+	require.NoError(t, td.DB.Model(&policy).Update("status", "cancelled").Error)
+
+	w = do(admin, "POST", anonymizePath, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var anonymized apih.AnonymizeCustomerResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &anonymized))
+	assert.Equal(t, repo.CustomerAnonymization{Quotes: 1, Consents: 1}, anonymized.Anonymized)
+	assert.NotEmpty(t, anonymized.Customer.AnonymizedAt)
+	assert.Equal(t, http.StatusConflict, do(admin, "POST", anonymizePath, nil).Code)
+
+	var stored repo.Customer
+	require.NoError(t, td.DB.First(&stored, customer.ID).Error)
+	assert.Equal(t, repo.AnonymizedCustomerName, stored.Name)
+	assert.Equal(t, fmt.Sprintf("A%010d", customer.ID), stored.TCVKN)
+	assert.Empty(t, stored.Email)
+	assert.Empty(t, stored.Phone)
+	assert.Empty(t, stored.Address)
+	assert.Nil(t, stored.BirthDate)
+	assert.Equal(t, "İstanbul", stored.City)
+	assert.Equal(t, repo.CustomerTypeIndividual, stored.CustomerType)
+
+	// Poliçe ve ödeme tutarları raporlama için kalır
+	require.NoError(t, td.DB.First(&quote, quote.ID).Error)
+	require.NoError(t, td.DB.First(&policy, policy.ID).Error)
+	require.NoError(t, td.DB.First(&payment, payment.ID).Error)
+	assert.Empty(t, quote.VehiclePlate)
+	assert.Equal(t, 1100.0, policy.Premium)
+	assert.Equal(t, 1100.0, payment.Amount)
+	assert.ErrorIs(t, notify(jobs.NotificationService, "email"), jobs.ErrNoAddress)
+	assert.Equal(t, http.StatusConflict, do(agent, "POST", consentsPath, map[string]interface{}{"purpose": "marketing", "text_version": "2026-01"}).Code)
+
+	var entry repo.AuditLog
+	require.NoError(t, td.DB.Where("action = ?", "customer_anonymized").First(&entry).Error)
+	assert.NotContains(t, entry.MetaJSON, "10000000146")
+	assert.Equal(t, customer.ID, *entry.EntityID)
+
+	// Anonimleştirilen müşteri güncellenemez, birleştirilemez, ona poliçe ya da teklif yazılamaz
+	customerPath := fmt.Sprintf("/api/v1/customers/%d", customer.ID)
+	assert.Equal(t, http.StatusConflict, do(admin, "PUT", customerPath, map[string]interface{}{"tc_vkn": "10000000146", "name": "Ali Veli"}).Code)
+	other := repo.Customer{TCVKN: "23456789138", Name: "Veli Kaya"}
+	require.NoError(t, td.DB.Create(&other).Error)
+	assert.Equal(t, http.StatusConflict, do(admin, "POST", fmt.Sprintf("/api/v1/customers/%d/merge", other.ID), map[string]interface{}{"duplicate_id": customer.ID}).Code)
+	assert.Equal(t, http.StatusConflict, do(admin, "POST", customerPath+"/merge", map[string]interface{}{"duplicate_id": other.ID}).Code)
+	w = do(admin, "POST", "/api/v1/policies", map[string]interface{}{
+		"customer_id": customer.ID, "product_id": product.ID, "agent_id": agent.ID, "policy_number": "POL-2",
+		"company_name": "Anadolu", "premium": 1200, "start_date": "2027-01-01", "end_date": "2028-01-01",
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "anonymized")
+	w = do(admin, "POST", "/api/v1/quotes", map[string]interface{}{
+		"customer_id": customer.ID, "product_id": product.ID, "coverage_type": "kasko", "start_date": "2027-01-01", "end_date": "2028-01-01",
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "anonymized")
+	require.NoError(t, td.DB.First(&stored, customer.ID).Error)
+	assert.Equal(t, repo.AnonymizedCustomerName, stored.Name)
+
+	// Silinmiş müşterilerin kişisel verileri de tutulduğu için anonimleştirilebilir
+	deleted := repo.Customer{TCVKN: "12345678950", Name: "Ayşe Yılmaz", Phone: "0532 111 22 33"}
+	require.NoError(t, td.DB.Create(&deleted).Error)
+	require.NoError(t, td.DB.Delete(&deleted).Error)
+	require.Equal(t, http.StatusOK, do(admin, "POST", fmt.Sprintf("/api/v1/customers/%d/anonymize", deleted.ID), nil).Code)
+	var storedDeleted repo.Customer
+	require.NoError(t, td.DB.Unscoped().First(&storedDeleted, deleted.ID).Error)
+	assert.Equal(t, repo.AnonymizedCustomerName, storedDeleted.Name)
+	assert.Empty(t, storedDeleted.Phone)
+	assert.True(t, storedDeleted.DeletedAt.Valid)
+}
+
+func TestCustomerSearch(t *testing.T) {
+	td := setupTestDeps(t)
+
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	branch := repo.Branch{Name: "Kadıköy"}
+	require.NoError(t, td.DB.Create(&branch).Error)
+	admin := repo.User{Email: "admin@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAdmin), IsActive: true}
+	agent1 := repo.User{Email: "agent1@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAgent), BranchID: &branch.ID, IsActive: true}
+	agent2 := repo.User{Email: "agent2@example.com", PasswordHash: hashedPassword, RoleID: roleID(t, td, rbac.RoleAgent), IsActive: true}
+	for _, user := range []*repo.User{&admin, &agent1, &agent2} {
+		require.NoError(t, td.DB.Create(user).Error)
+	}
+
+	kasko := repo.Product{Type: "kasko", Name: "Kasko"}
+	dask := repo.Product{Type: "dask", Name: "DASK"}
+	require.NoError(t, td.DB.Create(&kasko).Error)
+	require.NoError(t, td.DB.Create(&dask).Error)
+
+	date := func(value string) *time.Time {
+		d, err := time.Parse("2006-01-02", value)
+		require.NoError(t, err)
+		return &d
+	}
+	customers := []repo.Customer{
+		{TCVKN: "10000000146", Name: "Ali Yılmaz", Email: "ali@example.com", Phone: "0555 111 22 33", City: "İSTANBUL", District: "Kadıköy", Gender: "male", BirthDate: date("1985-03-10"), CreatedAt: *date("2026-01-10")},
+		{TCVKN: "12345678950", Name: "Ayşe Işık", City: "istanbul", District: "Beşiktaş", Gender: "female", BirthDate: date("2000-06-01"), CreatedAt: *date("2026-02-15")},
+		{TCVKN: "1234567890", CustomerType: repo.CustomerTypeCorporate, Name: "IŞIK Sigorta A.Ş.", TradeTitle: "Işık Sigorta Aracılık A.Ş.", City: "Ankara", CreatedAt: date("2026-03-01").Add(15 * time.Hour)},
+		{TCVKN: "98765432106", Name: "Mehmet Öz", City: "İzmir", Gender: "male", BirthDate: date("1960-01-01"), CreatedAt: *date("2026-03-20")},
+	}
+	for i := range customers {
+		require.NoError(t, td.DB.Create(&customers[i]).Error)
+	}
+	ali, ayse, isik := customers[0], customers[1], customers[2]
+
+	policies := []repo.Policy{
+		{CustomerID: ali.ID, ProductID: kasko.ID, AgentID: agent1.ID, PolicyNumber: "POL-1", CompanyName: "Anadolu", Premium: 1000, Status: "active", StartDate: "2026-01-01", EndDate: "2027-01-01"},
+		{CustomerID: ayse.ID, ProductID: dask.ID, AgentID: agent2.ID, PolicyNumber: "POL-2", CompanyName: "Axa", Premium: 500, Status: "cancelled", StartDate: "2026-01-01", EndDate: "2027-01-01"},
+	}
+	for i := range policies {
+		require.NoError(t, td.DB.Create(&policies[i]).Error)
+	}
+	quote := repo.Quote{CustomerID: isik.ID, ProductID: kasko.ID, AgentID: agent1.ID, CoverageType: "kasko"}
+	require.NoError(t, td.DB.Create(&quote).Error)
+
+	token := issueTokens(t, td, admin).AccessToken
+	do := func(params url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/v1/customers?"+params.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		td.Router.ServeHTTP(w, req)
+		return w
+	}
+	search := func(params url.Values) ([]string, int64) {
+		w := do(params)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var page struct {
+			Data  []apih.CustomerResponse `json:"data"`
+			Total int64                   `json:"total"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		names := []string{}
+		for _, customer := range page.Data {
+			names = append(names, customer.Name)
+		}
+		return names, page.Total
+	}
+	id := func(id uint) string { return strconv.FormatUint(uint64(id), 10) }
+
+	// Varsayılan sıralama en yeni müşteri önce
+	names, total := search(url.Values{})
+	assert.Equal(t, []string{"Mehmet Öz", "IŞIK Sigorta A.Ş.", "Ayşe Işık", "Ali Yılmaz"}, names)
+	assert.Equal(t, int64(4), total)
+
+	names, total = search(url.Values{"pageSize": {"2"}, "page": {"2"}})
+	assert.Equal(t, []string{"Ayşe Işık", "Ali Yılmaz"}, names)
+	assert.Equal(t, int64(4), total)
+
+	for name, tc := range map[string]struct {
+		params url.Values
+		want   []string
+	}{
+		// Türkçe büyük/küçük harf katlama: İ, I, ı ve i eşleşir
+		"city folded":          {url.Values{"city": {"istanbul"}}, []string{"Ayşe Işık", "Ali Yılmaz"}},
+		"city any of":          {url.Values{"city": {"ISTANBUL,ankara"}}, []string{"IŞIK Sigorta A.Ş.", "Ayşe Işık", "Ali Yılmaz"}},
+		"district":             {url.Values{"district": {"BEŞİKTAŞ"}}, []string{"Ayşe Işık"}},
+		"search folded":        {url.Values{"query": {"ışık"}}, []string{"IŞIK Sigorta A.Ş.", "Ayşe Işık"}},
+		"search every word":    {url.Values{"query": {"ali YIL"}}, []string{"Ali Yılmaz"}},
+		"search phone":         {url.Values{"query": {"0555"}}, []string{"Ali Yılmaz"}},
+		"search tc":            {url.Values{"query": {"123456789"}}, []string{"IŞIK Sigorta A.Ş.", "Ayşe Işık"}},
+		"customer type":        {url.Values{"customer_type": {"kurumsal"}}, []string{"IŞIK Sigorta A.Ş."}},
+		"gender and birth":     {url.Values{"gender": {"male"}, "birth_date[gte]": {"1980-01-01"}}, []string{"Ali Yılmaz"}},
+		"birth date range":     {url.Values{"birth_date[gte]": {"1960-01-01"}, "birth_date[lt]": {"1985-03-10"}}, []string{"Mehmet Öz"}},
+		"age from":             {url.Values{"age[gte]": {"60"}}, []string{"Mehmet Öz"}},
+		"age to":               {url.Values{"age[lte]": {"30"}}, []string{"Ayşe Işık"}},
+		"active policy":        {url.Values{"has_active_policy": {"true"}}, []string{"Ali Yılmaz"}},
+		"no active policy":     {url.Values{"has_active_policy": {"false"}}, []string{"Mehmet Öz", "IŞIK Sigorta A.Ş.", "Ayşe Işık"}},
+		"product type":         {url.Values{"product_type": {"dask"}}, []string{"Ayşe Işık"}},
+		"agent":                {url.Values{"agent_id": {id(agent1.ID)}}, []string{"IŞIK Sigorta A.Ş.", "Ali Yılmaz"}},
+		"agents":               {url.Values{"agent_id": {id(agent1.ID) + "," + id(agent2.ID)}}, []string{"IŞIK Sigorta A.Ş.", "Ayşe Işık", "Ali Yılmaz"}},
+		"branch":               {url.Values{"branch_id": {id(branch.ID)}}, []string{"IŞIK Sigorta A.Ş.", "Ali Yılmaz"}},
+		"created range":        {url.Values{"created_at[gte]": {"2026-02-15"}, "created_at[lte]": {"2026-03-01"}}, []string{"IŞIK Sigorta A.Ş.", "Ayşe Işık"}},
+		"created on":           {url.Values{"created_at": {"2026-03-01"}}, []string{"IŞIK Sigorta A.Ş."}},
+		"sort by name":         {url.Values{"sort": {"name"}}, []string{"Ali Yılmaz", "Ayşe Işık", "IŞIK Sigorta A.Ş.", "Mehmet Öz"}},
+		"sort descending":      {url.Values{"sort": {"-name"}, "city": {"istanbul"}}, []string{"Ayşe Işık", "Ali Yılmaz"}},
+		"empty filter ignored": {url.Values{"city": {""}, "unknown": {"x"}}, []string{"Mehmet Öz", "IŞIK Sigorta A.Ş.", "Ayşe Işık", "Ali Yılmaz"}},
+	} {
+		names, total := search(tc.params)
+		assert.Equal(t, tc.want, names, name)
+		assert.Equal(t, int64(len(tc.want)), total, name)
+	}
+
+	for name, params := range map[string]url.Values{
+		"unsortable field":  {"sort": {"tc_vkn"}},
+		"invalid date":      {"birth_date[gte]": {"dün"}},
+		"unknown operator":  {"age[between]": {"3"}},
+		"range on text":     {"city[gte]": {"a"}},
+		"unknown filter":    {"tc_vkn[gte]": {"1"}},
+		"invalid bool":      {"has_active_policy": {"belki"}},
+		"invalid enum":      {"customer_type": {"sirket"}},
+		"invalid id":        {"agent_id": {"abc"}},
+		"many range values": {"created_at[gte]": {"2026-01-01,2026-02-01"}},
+	} {
+		w := do(params)
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+	}
+
+	// Kişisel verileri maskeli gören kullanıcı onlarla arayamaz ve süzemez
+	token = issueTokens(t, td, agent1).AccessToken
+	names, _ = search(url.Values{"query": {"ali"}})
+	assert.Equal(t, []string{"Ali Yılmaz"}, names)
+	for _, query := range []string{"0555", "ali@example", "100000"} {
+		names, total = search(url.Values{"query": {query}})
+		assert.Empty(t, names, query)
+		assert.Zero(t, total, query)
+	}
+	for name, params := range map[string]url.Values{
+		"birth date":      {"birth_date[gte]": {"1980-01-01"}},
+		"age":             {"age": {"40"}},
+		"birth date sort": {"sort": {"birth_date"}},
+	} {
+		w := do(params)
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+		assert.Contains(t, w.Body.String(), "customer:read_pii", name)
+	}
+}
+
+// periodicJobs records the periodic jobs registered with it
+type periodicJobs map[string]string
+
+func (p periodicJobs) Register(cronspec string, task *asynq.Task, opts ...asynq.Option) (string, error) {
+	p[task.Type()] = cronspec
+	return task.Type(), nil
+}
+
+func TestPeriodicJobs(t *testing.T) {
+	registered := periodicJobs{}
+	require.NoError(t, jobs.RegisterPeriodicJobs(registered))
+	assert.Equal(t, periodicJobs{
+		jobs.TypeScrapeAll:       jobs.ScrapeAllSchedule,
+		jobs.TypeCleanupOldData:  jobs.CleanupSchedule,
+		jobs.TypeScoreDuplicates: jobs.ScoreDuplicatesSchedule,
+	}, registered)
+
+	// Zamanlayıcı cron ifadelerini kabul eder; kayıt Redis gerektirmez
+	scheduler := asynq.NewScheduler(asynq.RedisClientOpt{Addr: "localhost:6379"}, nil)
+	assert.NoError(t, jobs.RegisterPeriodicJobs(scheduler))
+}
+
+func TestHealthCheck(t *testing.T) {
+	router := gin.New()
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	req, _ := http.NewRequest("GET", "/health", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+
+	assert.Equal(t, "ok", response["status"])
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"eesigorta/backend/internal/api"
+	"eesigorta/backend/internal/auth"
+	"eesigorta/backend/internal/config"
+	"eesigorta/backend/internal/jobs"
+	"eesigorta/backend/internal/rbac"
+	"eesigorta/backend/internal/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routerDeps are the services the HTTP routes are wired to
+type routerDeps struct {
+	cfg            *config.Config
+	repo           *repo.Repository
+	jwtMgr         *auth.JWTManager
+	totpMgr        *auth.TOTPManager
+	loginGuard     *auth.LoginGuard
+	passwordPolicy *auth.PasswordPolicy
+	rbacMgr        *rbac.RBACManager
+	attempts       auth.AttemptCounter
+	jobs           *jobs.Client
+}
+
+// newRouter registers every route. Each route outside /auth and /me is
+// guarded by the RBAC permission for the resource and action it serves.
+func newRouter(d routerDeps) *gin.Engine {
+	// Initialize handlers
+	authHandler := api.NewAuthHandler(d.repo, d.jwtMgr, d.totpMgr, d.loginGuard, d.passwordPolicy, d.rbacMgr)
+	userHandler := api.NewUserHandler(d.repo, d.passwordPolicy, d.rbacMgr)
+	sessionHandler := api.NewSessionHandler(d.repo)
+	roleHandler := api.NewRoleHandler(d.repo, d.rbacMgr)
+	grantHandler := api.NewGrantHandler(d.repo, d.rbacMgr)
+	customerHandler := api.NewCustomerHandler(d.repo, d.rbacMgr)
+	quoteHandler := api.NewQuoteHandler(d.repo, d.jobs, d.rbacMgr)
+	branchHandler := api.NewBranchHandler(d.repo)
+	agentHandler := api.NewAgentHandler(d.repo)
+	policyHandler := api.NewPolicyHandler(d.repo, d.rbacMgr)
+	reportHandler := api.NewReportHandler(d.repo, d.rbacMgr)
+	scraperHandler := api.NewScraperHandler(d.repo, d.jobs)
+	auditLogHandler := api.NewAuditLogHandler(d.repo)
+	timelineHandler := api.NewTimelineHandler(d.repo)
+	customerImportHandler := api.NewCustomerImportHandler(d.repo, d.jobs, d.rbacMgr)
+
+	rbacMgr := d.rbacMgr
+
+	router := gin.New()
+
+	// Client IPs key the login and rate limits, only trusted proxies may
+	// set them through X-Forwarded-For. Checked by ValidateAPI.
+	if err := router.SetTrustedProxies(d.cfg.App.TrustedProxies); err != nil {
+		log.Fatal("Invalid trusted proxies:", err)
+	}
+
+	// Middleware
+	router.Use(gin.Logger())
+	router.Use(gin.Recovery())
+	router.Use(api.CORSMiddleware())
+	router.Use(api.AuditMiddleware(d.repo))
+
+	// Public keys for services verifying our tokens
+	router.GET("/.well-known/jwks.json", authHandler.GetJWKS)
+
+	// Health check
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	// API routes
+	apiV1 := router.Group("/api/v1")
+	{
+		// Auth routes (no auth required)
+		auth := apiV1.Group("/auth")
+		auth.Use(api.RateLimitMiddleware(d.attempts, "auth", d.cfg.Login.RateLimitPerMin, time.Minute))
+		{
+			auth.POST("/login", authHandler.Login)
+			auth.POST("/refresh", authHandler.RefreshToken)
+			auth.POST("/logout", authHandler.Logout)
+			auth.POST("/2fa/login", authHandler.Verify2FALogin)
+			auth.GET("/password-policy", authHandler.GetPasswordPolicy)
+		}
+
+		// Protected routes
+		protected := apiV1.Group("")
+		protected.Use(api.AuthMiddleware(d.jwtMgr, d.repo))
+		protected.Use(api.PasswordChangeMiddleware(d.repo, "/api/v1/me", "/api/v1/me/password", "/api/v1/me/sessions", "/api/v1/me/sessions/:id"))
+		protected.Use(api.DataScopeMiddleware(d.repo))
+		{
+			// User routes
+			protected.GET("/me", authHandler.GetMe)
+			protected.POST("/auth/check", authHandler.CheckPermissions)
+			protected.PUT("/me/password", authHandler.ChangePassword)
+			protected.GET("/me/sessions", sessionHandler.GetMySessions)
+			protected.DELETE("/me/sessions/:id", sessionHandler.RevokeMySession)
+			protected.POST("/auth/2fa/enable", authHandler.Enable2FA)
+			protected.POST("/auth/2fa/verify", authHandler.Verify2FA)
+			protected.POST("/auth/2fa/disable", authHandler.Disable2FA)
+
+			// User management routes
+			users := protected.Group("/users")
+			users.Use(api.PaginationMiddleware())
+			{
+				users.GET("", api.RBACMiddleware(rbacMgr, rbac.PermissionUserList), userHandler.GetUsers)
+				users.GET("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionUserRead), userHandler.GetUser)
+				users.POST("", api.RBACMiddleware(rbacMgr, rbac.PermissionUserCreate), userHandler.CreateUser)
+				users.PUT("/:id/role", api.RBACMiddleware(rbacMgr, rbac.PermissionUserUpdate), userHandler.UpdateUserRole)
+				users.PUT("/:id/assignment", api.RBACMiddleware(rbacMgr, rbac.PermissionUserUpdate), userHandler.UpdateUserAssignment)
+				users.POST("/:id/deactivate", api.RBACMiddleware(rbacMgr, rbac.PermissionUserUpdate), userHandler.DeactivateUser)
+				users.POST("/:id/activate", api.RBACMiddleware(rbacMgr, rbac.PermissionUserUpdate), userHandler.ActivateUser)
+				users.POST("/:id/password-reset", api.RBACMiddleware(rbacMgr, rbac.PermissionUserUpdate), userHandler.ResetPassword)
+				users.DELETE("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionUserDelete), userHandler.DeleteUser)
+				users.GET("/:id/sessions", api.RBACMiddleware(rbacMgr, rbac.PermissionUserRead), sessionHandler.GetUserSessions)
+				users.DELETE("/:id/sessions/:session_id", api.RBACMiddleware(rbacMgr, rbac.PermissionUserUpdate), sessionHandler.RevokeUserSession)
+				users.POST("/:id/2fa/reset", api.RBACMiddleware(rbacMgr, rbac.PermissionUserUpdate), authHandler.Reset2FA)
+				users.POST("/:id/unlock", api.RBACMiddleware(rbacMgr, rbac.PermissionUserUpdate), authHandler.UnlockUser)
+				users.GET("/:id/grants", api.RBACMiddleware(rbacMgr, rbac.PermissionUserRead), grantHandler.GetUserGrants)
+				users.POST("/:id/grants", api.RBACMiddleware(rbacMgr, rbac.PermissionUserUpdate), grantHandler.CreateUserGrant)
+				users.DELETE("/:id/grants/:grant_id", api.RBACMiddleware(rbacMgr, rbac.PermissionUserUpdate), grantHandler.RevokeUserGrant)
+			}
+
+			// Role management routes
+			roles := protected.Group("/roles")
+			{
+				roles.GET("", api.RBACMiddleware(rbacMgr, rbac.PermissionRoleList), roleHandler.GetRoles)
+				roles.GET("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionRoleRead), roleHandler.GetRole)
+				roles.POST("", api.RBACMiddleware(rbacMgr, rbac.PermissionRoleCreate), roleHandler.CreateRole)
+				roles.PUT("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionRoleUpdate), roleHandler.UpdateRole)
+				roles.DELETE("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionRoleDelete), roleHandler.DeleteRole)
+			}
+			protected.GET("/permissions", api.RBACMiddleware(rbacMgr, rbac.PermissionRoleRead), roleHandler.GetPermissions)
+
+			// Customer routes
+			customers := protected.Group("/customers")
+			customers.Use(api.PaginationMiddleware())
+			{
+				customers.GET("", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerList), customerHandler.GetCustomers)
+				customers.GET("/duplicates", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerList), customerHandler.FindDuplicates)
+				customers.GET("/imports/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerCreate), customerImportHandler.GetCustomerImport)
+				customers.GET("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerRead), customerHandler.GetCustomer)
+				customers.GET("/:id/timeline", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerRead), timelineHandler.GetCustomerTimeline)
+				customers.POST("", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerCreate), customerHandler.CreateCustomer)
+				customers.POST("/import", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerCreate), customerImportHandler.ImportCustomers)
+				customers.PUT("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerUpdate), customerHandler.UpdateCustomer)
+				customers.DELETE("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerDelete), customerHandler.DeleteCustomer)
+				customers.POST("/:id/merge", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerDelete), customerHandler.MergeCustomer)
+				customers.GET("/:id/consents", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerRead), customerHandler.GetConsents)
+				customers.POST("/:id/consents", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerUpdate), customerHandler.GrantConsent)
+				customers.POST("/:id/consents/:consent_id/revoke", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerUpdate), customerHandler.RevokeConsent)
+				customers.GET("/:id/kvkk-export", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerKVKK), customerHandler.ExportCustomerData)
+				customers.POST("/:id/anonymize", api.RBACMiddleware(rbacMgr, rbac.PermissionCustomerKVKK), customerHandler.AnonymizeCustomer)
+			}
+
+			// Quote routes
+			quotes := protected.Group("/quotes")
+			quotes.Use(api.PaginationMiddleware())
+			{
+				quotes.GET("", api.RBACMiddleware(rbacMgr, rbac.PermissionQuoteList), quoteHandler.GetQuotes)
+				quotes.GET("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionQuoteRead), quoteHandler.GetQuote)
+				quotes.POST("", api.RBACMiddleware(rbacMgr, rbac.PermissionQuoteCreate), quoteHandler.CreateQuote)
+				quotes.GET("/:id/comparison", api.RBACMiddleware(rbacMgr, rbac.PermissionQuoteRead), quoteHandler.GetQuoteComparison)
+				quotes.GET("/:id/scraped", api.RBACMiddleware(rbacMgr, rbac.PermissionQuoteRead), quoteHandler.GetScrapedQuotes)
+				quotes.GET("/:id/timeline", api.RBACMiddleware(rbacMgr, rbac.PermissionQuoteRead), timelineHandler.GetQuoteTimeline)
+				quotes.POST("/:id/approve/:scraped_quote_id", api.RBACMiddleware(rbacMgr, rbac.PermissionQuoteUpdate), quoteHandler.ApproveQuote)
+			}
+
+			// Branch routes
+			branches := protected.Group("/branches")
+			branches.Use(api.PaginationMiddleware())
+			{
+				branches.GET("", api.RBACMiddleware(rbacMgr, rbac.PermissionBranchList), branchHandler.GetBranches)
+				branches.GET("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionBranchRead), branchHandler.GetBranch)
+				branches.POST("", api.RBACMiddleware(rbacMgr, rbac.PermissionBranchCreate), branchHandler.CreateBranch)
+				branches.PUT("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionBranchUpdate), branchHandler.UpdateBranch)
+				branches.DELETE("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionBranchDelete), branchHandler.DeleteBranch)
+			}
+
+			// Agent routes
+			agents := protected.Group("/agents")
+			agents.Use(api.PaginationMiddleware())
+			{
+				agents.GET("", api.RBACMiddleware(rbacMgr, rbac.PermissionAgentList), agentHandler.GetAgents)
+				agents.GET("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionAgentRead), agentHandler.GetAgent)
+				agents.POST("", api.RBACMiddleware(rbacMgr, rbac.PermissionAgentCreate), agentHandler.CreateAgent)
+				agents.PUT("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionAgentUpdate), agentHandler.UpdateAgent)
+				agents.DELETE("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionAgentDelete), agentHandler.DeleteAgent)
+			}
+
+			// Policy routes
+			policies := protected.Group("/policies")
+			policies.Use(api.PaginationMiddleware())
+			{
+				policies.GET("", api.RBACMiddleware(rbacMgr, rbac.PermissionPolicyList), policyHandler.GetPolicies)
+				policies.GET("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionPolicyRead), policyHandler.GetPolicy)
+				policies.GET("/:id/timeline", api.RBACMiddleware(rbacMgr, rbac.PermissionPolicyRead), timelineHandler.GetPolicyTimeline)
+				policies.POST("", api.RBACMiddleware(rbacMgr, rbac.PermissionPolicyCreate), policyHandler.CreatePolicy)
+				policies.PUT("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionPolicyUpdate), policyHandler.UpdatePolicy)
+				policies.DELETE("/:id", api.RBACMiddleware(rbacMgr, rbac.PermissionPolicyDelete), policyHandler.DeletePolicy)
+			}
+
+			// Report routes
+			reports := protected.Group("/reports")
+			{
+				reports.GET("/dashboard", api.RBACMiddleware(rbacMgr, rbac.PermissionReportRead), reportHandler.GetDashboardStats)
+				reports.GET("/policy-stats", api.RBACMiddleware(rbacMgr, rbac.PermissionReportRead), reportHandler.GetPolicyStats)
+				reports.GET("/monthly-stats", api.RBACMiddleware(rbacMgr, rbac.PermissionReportRead), reportHandler.GetMonthlyStats)
+				reports.GET("/branch-stats", api.RBACMiddleware(rbacMgr, rbac.PermissionReportRead), reportHandler.GetBranchStats)
+				reports.GET("/agent-stats", api.RBACMiddleware(rbacMgr, rbac.PermissionReportRead), reportHandler.GetAgentStats)
+				reports.GET("/export/policies", api.RBACMiddleware(rbacMgr, rbac.PermissionReportExport), reportHandler.ExportPolicies)
+				reports.GET("/export/customers", api.RBACMiddleware(rbacMgr, rbac.PermissionReportExport), reportHandler.ExportCustomers)
+			}
+
+			// Scraper routes
+			scraper := protected.Group("/scraper")
+			{
+				scraper.GET("/targets", api.RBACMiddleware(rbacMgr, rbac.PermissionScraperRun), scraperHandler.GetTargets)
+				scraper.POST("/run", api.RBACMiddleware(rbacMgr, rbac.PermissionScraperRun), scraperHandler.RunScraper)
+			}
+
+			// Audit log routes
+			auditLogs := protected.Group("/audit-logs")
+			{
+				auditLogs.GET("", api.RBACMiddleware(rbacMgr, rbac.PermissionAuditRead), auditLogHandler.GetAuditLogs)
+				auditLogs.GET("/verify", api.RBACMiddleware(rbacMgr, rbac.PermissionAuditRead), auditLogHandler.VerifyAuditLogs)
+			}
+		}
+	}
+
+	return router
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+
+	"eesigorta/backend/internal/config"
+	"eesigorta/backend/internal/jobs"
+	"eesigorta/backend/internal/repo"
+	"eesigorta/backend/internal/scraper"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+
+	// Initialize database
+	repository, err := repo.NewRepository(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize database:", err)
+	}
+	defer repository.Close()
+
+	// Initialize scraper manager
+	scraperMgr := scraper.NewScraperManager(&cfg.Scraper, repository)
+
+	// Initialize job manager
+	jobMgr := jobs.NewJobManager(cfg, repository, scraperMgr)
+	defer jobMgr.Stop()
+
+	// Run blocks until SIGINT/SIGTERM, then drains in-flight tasks
+	if err := jobMgr.StartWorker(); err != nil {
+		log.Fatal("Failed to start worker:", err)
+	}
+}
@@ -68,7 +68,7 @@ func (h *AgentHandler) GetAgents(c *gin.Context) {
 	var agents []repo.Agent
 	var total int64
 
-	db := h.repo.DB().Model(&repo.Agent{})
+	db := h.repo.ScopedAgents(dataScope(c))
 
 	// Apply search filter
 	if query != "" {
@@ -125,7 +125,7 @@ func (h *AgentHandler) GetAgent(c *gin.Context) {
 	}
 
 	var agent repo.Agent
-	err = h.repo.DB().Preload("Branch").First(&agent, uint(id)).Error
+	err = h.repo.ScopedAgents(dataScope(c)).Preload("Branch").First(&agent, uint(id)).Error
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Agent not found"})
 		return
@@ -143,7 +143,7 @@ func (h *AgentHandler) CreateAgent(c *gin.Context) {
 
 	// Check if branch exists
 	var branch repo.Branch
-	err := h.repo.DB().First(&branch, req.BranchID).Error
+	err := h.repo.ScopedBranches(dataScope(c)).First(&branch, req.BranchID).Error
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Branch not found"})
 		return
@@ -185,17 +185,18 @@ func (h *AgentHandler) UpdateAgent(c *gin.Context) {
 	}
 
 	var agent repo.Agent
-	err = h.repo.DB().First(&agent, uint(id)).Error
+	err = h.repo.ScopedAgents(dataScope(c)).First(&agent, uint(id)).Error
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Agent not found"})
 		return
 	}
+	before := agent
 
 	// Update fields
 	if req.BranchID != nil {
 		// Check if branch exists
 		var branch repo.Branch
-		err = h.repo.DB().First(&branch, *req.BranchID).Error
+		err = h.repo.ScopedBranches(dataScope(c)).First(&branch, *req.BranchID).Error
 		if err != nil {
 			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Branch not found"})
 			return
@@ -224,6 +225,9 @@ func (h *AgentHandler) UpdateAgent(c *gin.Context) {
 		return
 	}
 
+	// Log audit
+	recordUpdate(c, c.GetUint("user_id"), "agent_updated", "agent", &agent.ID, before, agent, nil)
+
 	// Reload with branch
 	h.repo.DB().Preload("Branch").First(&agent, agent.ID)
 
@@ -238,11 +242,15 @@ func (h *AgentHandler) DeleteAgent(c *gin.Context) {
 		return
 	}
 
-	err = h.repo.DB().Delete(&repo.Agent{}, uint(id)).Error
-	if err != nil {
+	result := h.repo.ScopedAgents(dataScope(c)).Where("id = ?", uint(id)).Delete(&repo.Agent{})
+	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete agent"})
 		return
 	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Agent not found"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Agent deleted successfully"})
 }
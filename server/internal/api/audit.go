@@ -0,0 +1,91 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"eesigorta/backend/internal/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditEventsKey holds the events handlers record for AuditMiddleware
+const auditEventsKey = "audit_events"
+
+// auditEvent is something a handler did. AuditMiddleware stores it with the
+// request's route, status and latency once the handler is done.
+type auditEvent struct {
+	UserID   *uint
+	Action   string
+	Entity   string
+	EntityID *uint
+	Meta     map[string]interface{}
+	Changes  map[string]repo.AuditChange
+}
+
+// recordAudit notes an event for AuditMiddleware to store. userID is who
+// acted, which for logins isn't the authenticated user yet.
+func recordAudit(c *gin.Context, userID uint, action, entity string, entityID *uint, meta map[string]interface{}) {
+	addAuditEvent(c, auditEvent{
+		UserID:   &userID,
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		Meta:     meta,
+	})
+}
+
+// recordUpdate is recordAudit for an update, also storing the fields that
+// differ between the record as it was and as it is now
+func recordUpdate(c *gin.Context, userID uint, action, entity string, entityID *uint, before, after interface{}, meta map[string]interface{}) {
+	addAuditEvent(c, auditEvent{
+		UserID:   &userID,
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		Meta:     meta,
+		Changes:  repo.AuditDiff(before, after),
+	})
+}
+
+func addAuditEvent(c *gin.Context, event auditEvent) {
+	// The handler may reuse the variable the ID points to
+	if event.EntityID != nil {
+		id := *event.EntityID
+		event.EntityID = &id
+	}
+	c.Set(auditEventsKey, append(auditEvents(c), event))
+}
+
+func auditEvents(c *gin.Context) []auditEvent {
+	events, _ := c.Get(auditEventsKey)
+	e, _ := events.([]auditEvent)
+	return e
+}
+
+// requestAuditEvent describes a request no handler recorded an event for,
+// from its method and route, e.g. "put" on "branch" 3 for PUT /branches/3
+func requestAuditEvent(c *gin.Context) auditEvent {
+	event := auditEvent{Action: strings.ToLower(c.Request.Method)}
+	if userID, ok := c.Get("user_id"); ok {
+		id := userID.(uint)
+		event.UserID = &id
+	}
+
+	resource := strings.Split(strings.TrimPrefix(c.FullPath(), "/api/v1/"), "/")[0]
+	switch {
+	case strings.HasSuffix(resource, "ies"):
+		resource = strings.TrimSuffix(resource, "ies") + "y"
+	case strings.HasSuffix(resource, "ches"):
+		resource = strings.TrimSuffix(resource, "es")
+	case strings.HasSuffix(resource, "s"):
+		resource = strings.TrimSuffix(resource, "s")
+	}
+	event.Entity = resource
+
+	if id, err := strconv.ParseUint(c.Param("id"), 10, 32); err == nil {
+		entityID := uint(id)
+		event.EntityID = &entityID
+	}
+	return event
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"eesigorta/backend/internal/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditLogHandler struct {
+	repo *repo.Repository
+}
+
+func NewAuditLogHandler(repo *repo.Repository) *AuditLogHandler {
+	return &AuditLogHandler{repo: repo}
+}
+
+type AuditLogQuery struct {
+	UserID   *uint  `form:"user_id"`
+	Entity   string `form:"entity"`
+	EntityID *uint  `form:"entity_id"`
+	Action   string `form:"action"`
+	From     string `form:"from"` // RFC 3339 time or date
+	To       string `form:"to"`   // RFC 3339 time, or date to include the whole day
+	Cursor   string `form:"cursor"`
+	Limit    int    `form:"limit" binding:"omitempty,min=1,max=200"`
+}
+
+type AuditLogResponse struct {
+	ID         uint            `json:"id"`
+	UserID     *uint           `json:"user_id"`
+	UserEmail  string          `json:"user_email,omitempty"`
+	Action     string          `json:"action"`
+	Entity     string          `json:"entity"`
+	EntityID   *uint           `json:"entity_id"`
+	Method     string          `json:"method"`
+	Route      string          `json:"route"`
+	StatusCode int             `json:"status_code"`
+	LatencyMS  int64           `json:"latency_ms"`
+	IP         string          `json:"ip"`
+	UserAgent  string          `json:"user_agent"`
+	Meta       json.RawMessage `json:"meta"`
+	Changes    json.RawMessage `json:"changes"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditLogPage is one page of audit entries. Pass NextCursor as the cursor
+// to get the next, older page; it's empty on the last one.
+type AuditLogPage struct {
+	Data       []AuditLogResponse `json:"data"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+// defaultAuditLogLimit is the page size when the request doesn't set one
+const defaultAuditLogLimit = 50
+
+// GetAuditLogs godoc
+// @Summary List audit log entries
+// @Description Audit entries newest first, filtered by user, entity, action and time range. Pages are linked by cursor.
+// @Tags audit
+// @Produce json
+// @Param user_id query int false "User ID"
+// @Param entity query string false "Entity, e.g. customer"
+// @Param entity_id query int false "Entity ID"
+// @Param action query string false "Action, e.g. customer_updated"
+// @Param from query string false "From (RFC 3339 or YYYY-MM-DD)"
+// @Param to query string false "To (RFC 3339 or YYYY-MM-DD, inclusive)"
+// @Param cursor query string false "Cursor from the previous page"
+// @Param limit query int false "Page size (1-200, default 50)"
+// @Success 200 {object} AuditLogPage
+// @Failure 400 {object} ErrorResponse
+// @Router /audit-logs [get]
+func (h *AuditLogHandler) GetAuditLogs(c *gin.Context) {
+	var req AuditLogQuery
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultAuditLogLimit
+	}
+
+	filter := repo.AuditLogFilter{
+		UserID:   req.UserID,
+		Entity:   req.Entity,
+		EntityID: req.EntityID,
+		Action:   req.Action,
+	}
+	var ok bool
+	if filter.From, ok = parseAuditTime(c, "from", req.From, false); !ok {
+		return
+	}
+	if filter.To, ok = parseAuditTime(c, "to", req.To, true); !ok {
+		return
+	}
+
+	var before uint
+	if req.Cursor != "" {
+		cursor, err := strconv.ParseUint(req.Cursor, 10, 32)
+		if err != nil || cursor == 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid cursor"})
+			return
+		}
+		before = uint(cursor)
+	}
+
+	// One extra entry tells whether there is a next page
+	logs, err := h.repo.GetAuditLogs(filter, before, req.Limit+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	page := AuditLogPage{Data: make([]AuditLogResponse, 0, len(logs))}
+	if len(logs) > req.Limit {
+		logs = logs[:req.Limit]
+		page.NextCursor = strconv.FormatUint(uint64(logs[len(logs)-1].ID), 10)
+	}
+	for i := range logs {
+		page.Data = append(page.Data, auditLogToResponse(&logs[i]))
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// VerifyAuditLogs godoc
+// @Summary Verify the audit log
+// @Description Check the audit log's hash chain and report the first entry that was modified or follows a removed one
+// @Tags audit
+// @Produce json
+// @Success 200 {object} repo.AuditChainReport
+// @Router /audit-logs/verify [get]
+func (h *AuditLogHandler) VerifyAuditLogs(c *gin.Context) {
+	report, err := h.repo.VerifyAuditChain()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to verify audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// parseAuditTime parses a time range bound, writing the error response
+// itself when it's invalid. A plain date as the end of the range includes
+// that whole day.
+func parseAuditTime(c *gin.Context, name, value string, end bool) (*time.Time, bool) {
+	if value == "" {
+		return nil, true
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, true
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid " + name + " time, use RFC 3339 or YYYY-MM-DD"})
+		return nil, false
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, true
+}
+
+func auditLogToResponse(entry *repo.AuditLog) AuditLogResponse {
+	response := AuditLogResponse{
+		ID:         entry.ID,
+		UserID:     entry.UserID,
+		Action:     entry.Action,
+		Entity:     entry.Entity,
+		EntityID:   entry.EntityID,
+		Method:     entry.Method,
+		Route:      entry.Route,
+		StatusCode: entry.StatusCode,
+		LatencyMS:  entry.LatencyMS,
+		IP:         entry.IP,
+		UserAgent:  entry.UserAgent,
+		Meta:       rawJSON(entry.MetaJSON),
+		PrevHash:   entry.PrevHash,
+		Hash:       entry.Hash,
+		CreatedAt:  entry.CreatedAt,
+	}
+	if entry.User != nil {
+		response.UserEmail = entry.User.Email
+	}
+	if entry.ChangesJSON != nil {
+		response.Changes = rawJSON(*entry.ChangesJSON)
+	}
+	return response
+}
+
+// rawJSON passes a stored JSON column through, as null when it's empty or
+// not valid JSON
+func rawJSON(value string) json.RawMessage {
+	if value == "" || !json.Valid([]byte(value)) {
+		return nil
+	}
+	return json.RawMessage(value)
+}
@@ -1,10 +1,12 @@
 package api
 
 import (
+	"log"
 	"net/http"
 	"time"
 
 	"eesigorta/backend/internal/auth"
+	"eesigorta/backend/internal/rbac"
 	"eesigorta/backend/internal/repo"
 
 	"github.com/gin-gonic/gin"
@@ -12,16 +14,22 @@ import (
 )
 
 type AuthHandler struct {
-	repo      *repo.Repository
-	jwtMgr    *auth.JWTManager
-	totpMgr   *auth.TOTPManager
+	repo           *repo.Repository
+	jwtMgr         *auth.JWTManager
+	totpMgr        *auth.TOTPManager
+	loginGuard     *auth.LoginGuard
+	passwordPolicy *auth.PasswordPolicy
+	rbacMgr        *rbac.RBACManager
 }
 
-func NewAuthHandler(repo *repo.Repository, jwtMgr *auth.JWTManager, totpMgr *auth.TOTPManager) *AuthHandler {
+func NewAuthHandler(repo *repo.Repository, jwtMgr *auth.JWTManager, totpMgr *auth.TOTPManager, loginGuard *auth.LoginGuard, passwordPolicy *auth.PasswordPolicy, rbacMgr *rbac.RBACManager) *AuthHandler {
 	return &AuthHandler{
-		repo:    repo,
-		jwtMgr:  jwtMgr,
-		totpMgr: totpMgr,
+		repo:           repo,
+		jwtMgr:         jwtMgr,
+		totpMgr:        totpMgr,
+		loginGuard:     loginGuard,
+		passwordPolicy: passwordPolicy,
+		rbacMgr:        rbacMgr,
 	}
 }
 
@@ -31,22 +39,31 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	TokenPair *auth.TokenPair `json:"token_pair"`
-	User      *UserResponse   `json:"user"`
-	Requires2FA bool          `json:"requires_2fa"`
+	TokenPair   *auth.TokenPair `json:"token_pair,omitempty"`
+	User        *UserResponse   `json:"user"`
+	Requires2FA bool            `json:"requires_2fa"`
+	MFAToken    string          `json:"mfa_token,omitempty"`
 }
 
 type UserResponse struct {
-	ID           uint   `json:"id"`
-	Email        string `json:"email"`
-	Role         string `json:"role"`
-	TwoFAEnabled bool   `json:"two_fa_enabled"`
-	IsActive     bool   `json:"is_active"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID                 uint       `json:"id"`
+	Email              string     `json:"email"`
+	RoleID             uint       `json:"role_id"`
+	Role               string     `json:"role"`
+	BranchID           *uint      `json:"branch_id"`
+	AgentID            *uint      `json:"agent_id"`
+	TwoFAEnabled       bool       `json:"two_fa_enabled"`
+	IsActive           bool       `json:"is_active"`
+	MustChangePassword bool       `json:"must_change_password"`
+	LockedUntil        *time.Time `json:"locked_until,omitempty"`
+	LastLoginAt        *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
 }
 
+// TwoFARequest carries either a 6-digit TOTP code or a recovery code
 type TwoFARequest struct {
-	Code string `json:"code" binding:"required,len=6"`
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
 }
 
 type RefreshTokenRequest struct {
@@ -66,6 +83,37 @@ type Verify2FARequest struct {
 	Code string `json:"code" binding:"required,len=6"`
 }
 
+type Verify2FAResponse struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type Disable2FARequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// MeResponse is the current user together with what they may do and see,
+// so the frontend doesn't have to guess from the role name
+type MeResponse struct {
+	UserResponse
+	Permissions []string       `json:"permissions"`
+	DataScope   repo.DataScope `json:"data_scope"`
+}
+
+type CheckPermissionsRequest struct {
+	Permissions []string `json:"permissions" binding:"required,min=1,max=100"`
+}
+
+type CheckPermissionsResponse struct {
+	Permissions map[string]bool `json:"permissions"`
+}
+
 // Login godoc
 // @Summary Login user
 // @Description Authenticate user with email and password
@@ -76,6 +124,8 @@ type Verify2FARequest struct {
 // @Success 200 {object} LoginResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
+// @Failure 423 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
 // @Router /auth/login [post]
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req LoginRequest
@@ -84,11 +134,30 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	// Slow down repeated failures and refuse IPs that keep failing
+	delay, err := h.loginGuard.Check(c.Request.Context(), req.Email, c.ClientIP())
+	if err == auth.ErrTooManyAttempts {
+		c.JSON(http.StatusTooManyRequests, ErrorResponse{Error: "Too many failed login attempts, try again later"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to check login attempts"})
+		return
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+
 	// Find user
 	var user repo.User
-	err := h.repo.DB().Where("email = ? AND is_active = ?", req.Email, true).First(&user).Error
+	err = h.repo.DB().Preload("Role").Where("email = ? AND is_active = ?", req.Email, true).First(&user).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
+			h.recordLoginFailure(c, req.Email, nil)
 			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
 			return
 		}
@@ -96,34 +165,72 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	if user.IsLocked() {
+		c.JSON(http.StatusLocked, ErrorResponse{Error: "Account is temporarily locked due to failed login attempts"})
+		return
+	}
+
 	// Check password
 	if err := auth.CheckPassword(req.Password, user.PasswordHash); err != nil {
+		h.recordLoginFailure(c, req.Email, &user)
 		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
 		return
 	}
 
-	// Update last login
+	if err := h.loginGuard.Reset(c.Request.Context(), req.Email); err != nil {
+		log.Printf("Failed to reset login attempts for %s: %v", req.Email, err)
+	}
+
+	// An expired password still logs in, but only to change it
+	if !user.MustChangePassword && h.passwordPolicy.Expired(passwordChangedAt(&user)) {
+		user.MustChangePassword = true
+		h.repo.DB().Model(&user).Update("must_change_password", true)
+	}
+
+	// With 2FA on, the password alone only earns a token for the code step
+	if user.TwoFAEnabled {
+		mfaToken, err := h.jwtMgr.GenerateMFAPendingToken(user.ID, user.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate tokens"})
+			return
+		}
+
+		recordAudit(c, user.ID, "login_2fa_pending", "user", &user.ID, map[string]interface{}{
+			"email": user.Email,
+			"ip":    c.ClientIP(),
+		})
+
+		c.JSON(http.StatusOK, LoginResponse{
+			User:        userToResponse(&user),
+			Requires2FA: true,
+			MFAToken:    mfaToken,
+		})
+		return
+	}
+
+	// Update last login. Only the column is written, a full save would put
+	// back the TOTP step the second factor advanced.
 	now := time.Now()
 	user.LastLoginAt = &now
-	h.repo.DB().Save(&user)
+	h.repo.DB().Model(&user).Update("last_login_at", now)
 
 	// Generate tokens
-	tokenPair, err := h.jwtMgr.GenerateTokenPair(user.ID, user.Email, user.Role)
+	tokenPair, err := h.issueTokenPair(c, &user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate tokens"})
 		return
 	}
 
 	// Log audit
-	h.logAudit(c, user.ID, "login", "user", &user.ID, map[string]interface{}{
+	recordAudit(c, user.ID, "login", "user", &user.ID, map[string]interface{}{
 		"email": user.Email,
 		"ip":    c.ClientIP(),
 	})
 
 	response := LoginResponse{
 		TokenPair:   tokenPair,
-		User:        h.userToResponse(&user),
-		Requires2FA: user.TwoFAEnabled,
+		User:        userToResponse(&user),
+		Requires2FA: false,
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -147,12 +254,60 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	tokenPair, err := h.jwtMgr.RefreshToken(req.RefreshToken)
+	claims, err := h.jwtMgr.ValidateRefreshToken(req.RefreshToken)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid refresh token"})
 		return
 	}
 
+	stored, err := h.repo.GetRefreshTokenByJTI(claims.ID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid refresh token"})
+		return
+	}
+
+	// A rotated token being presented again means it was stolen or replayed;
+	// kill the whole family so neither party can keep the session. Tokens of
+	// a session that was signed out are simply refused.
+	if stored.RevokedAt != nil {
+		if stored.ReplacedBy != "" {
+			h.revokeFamilyOnReuse(c, stored)
+		}
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid refresh token"})
+		return
+	}
+
+	var user repo.User
+	err = h.repo.DB().Preload("Role").Where("id = ? AND is_active = ?", stored.UserID, true).First(&user).Error
+	if err != nil {
+		h.repo.RevokeRefreshTokenFamily(stored.FamilyID)
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid refresh token"})
+		return
+	}
+
+	tokenPair, err := h.jwtMgr.GenerateTokenPairInFamily(user.ID, user.Email, user.RoleName(), stored.FamilyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate tokens"})
+		return
+	}
+
+	err = h.repo.RotateRefreshToken(stored, refreshTokenRecord(&user, tokenPair))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			// Lost a race with another refresh of the same token
+			h.revokeFamilyOnReuse(c, stored)
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to rotate refresh token"})
+		return
+	}
+
+	if err := h.extendSession(c, stored.FamilyID, &user, tokenPair); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update session"})
+		return
+	}
+
 	c.JSON(http.StatusOK, tokenPair)
 }
 
@@ -166,6 +321,7 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 // @Success 200 {object} Enable2FAResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
 // @Router /auth/2fa/enable [post]
 func (h *AuthHandler) Enable2FA(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -182,7 +338,7 @@ func (h *AuthHandler) Enable2FA(c *gin.Context) {
 
 	// Get user
 	var user repo.User
-	err := h.repo.DB().First(&user, userID).Error
+	err := h.repo.DB().Preload("Role").First(&user, userID).Error
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
 		return
@@ -194,6 +350,13 @@ func (h *AuthHandler) Enable2FA(c *gin.Context) {
 		return
 	}
 
+	// A new secret would replace the one the user's authenticator holds and
+	// lock them out; 2FA has to be disabled first
+	if user.TwoFAEnabled {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "2FA is already enabled"})
+		return
+	}
+
 	// Generate TOTP secret
 	secret, err := h.totpMgr.GenerateSecret(user.Email)
 	if err != nil {
@@ -202,11 +365,14 @@ func (h *AuthHandler) Enable2FA(c *gin.Context) {
 	}
 
 	// Save secret to user (not enabled yet)
-	user.TwoFASecret = secret.Secret
-	h.repo.DB().Save(&user)
+	err = h.repo.DB().Model(&user).Update("twofa_secret", secret.Secret).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save 2FA secret"})
+		return
+	}
 
 	// Log audit
-	h.logAudit(c, user.ID, "2fa_enable_initiated", "user", &user.ID, map[string]interface{}{
+	recordAudit(c, user.ID, "2fa_enable_initiated", "user", &user.ID, map[string]interface{}{
 		"email": user.Email,
 	})
 
@@ -218,12 +384,12 @@ func (h *AuthHandler) Enable2FA(c *gin.Context) {
 
 // Verify2FA godoc
 // @Summary Verify 2FA setup
-// @Description Verify TOTP code and enable 2FA
+// @Description Verify TOTP code, enable 2FA and issue one-time recovery codes
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param request body Verify2FARequest true "TOTP code"
-// @Success 200 {object} SuccessResponse
+// @Success 200 {object} Verify2FAResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
 // @Router /auth/2fa/verify [post]
@@ -242,49 +408,86 @@ func (h *AuthHandler) Verify2FA(c *gin.Context) {
 
 	// Get user
 	var user repo.User
-	err := h.repo.DB().First(&user, userID).Error
+	err := h.repo.DB().Preload("Role").First(&user, userID).Error
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
 		return
 	}
 
+	if user.TwoFASecret == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "2FA setup has not been started"})
+		return
+	}
+
 	// Verify TOTP code
-	if !h.totpMgr.ValidateCode(user.TwoFASecret, req.Code) {
+	ok, err := h.verifyTOTP(c, &user, req.Code)
+	if err == auth.ErrTooManyAttempts {
+		c.JSON(http.StatusTooManyRequests, ErrorResponse{Error: "Too many failed 2FA attempts, try again later"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to verify 2FA code"})
+		return
+	}
+	if !ok {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid 2FA code"})
 		return
 	}
 
+	// Issue recovery codes; only the hashes are kept
+	recoveryCodes, err := auth.GenerateRecoveryCodes(auth.RecoveryCodeCount)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate recovery codes"})
+		return
+	}
+	codeHashes := make([]string, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hash, err := auth.HashPassword(code)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate recovery codes"})
+			return
+		}
+		codeHashes = append(codeHashes, hash)
+	}
+	if err := h.repo.ReplaceRecoveryCodes(user.ID, codeHashes); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save recovery codes"})
+		return
+	}
+
 	// Enable 2FA
 	user.TwoFAEnabled = true
-	h.repo.DB().Save(&user)
+	h.repo.DB().Model(&user).Update("two_fa_enabled", true)
 
 	// Log audit
-	h.logAudit(c, user.ID, "2fa_enabled", "user", &user.ID, map[string]interface{}{
+	recordAudit(c, user.ID, "2fa_enabled", "user", &user.ID, map[string]interface{}{
 		"email": user.Email,
 	})
 
-	c.JSON(http.StatusOK, SuccessResponse{Message: "2FA enabled successfully"})
+	c.JSON(http.StatusOK, Verify2FAResponse{
+		Message:       "2FA enabled successfully",
+		RecoveryCodes: recoveryCodes,
+	})
 }
 
-// Verify2FALogin godoc
-// @Summary Verify 2FA for login
-// @Description Verify TOTP code during login process
+// Disable2FA godoc
+// @Summary Disable 2FA
+// @Description Turn off 2FA after confirming the password and a TOTP or recovery code
 // @Tags auth
 // @Accept json
 // @Produce json
-// @Param request body TwoFARequest true "TOTP code"
-// @Success 200 {object} LoginResponse
+// @Param request body Disable2FARequest true "Password and 2FA code"
+// @Success 200 {object} SuccessResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
-// @Router /auth/2fa/login [post]
-func (h *AuthHandler) Verify2FALogin(c *gin.Context) {
+// @Router /auth/2fa/disable [post]
+func (h *AuthHandler) Disable2FA(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "User not authenticated"})
 		return
 	}
 
-	var req TwoFARequest
+	var req Disable2FARequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
@@ -292,33 +495,192 @@ func (h *AuthHandler) Verify2FALogin(c *gin.Context) {
 
 	// Get user
 	var user repo.User
-	err := h.repo.DB().First(&user, userID).Error
+	err := h.repo.DB().Preload("Role").First(&user, userID).Error
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
 		return
 	}
 
-	// Verify TOTP code
-	if !h.totpMgr.ValidateCode(user.TwoFASecret, req.Code) {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid 2FA code"})
+	if !user.TwoFAEnabled {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "2FA is not enabled"})
+		return
+	}
+
+	// Verify password
+	if err := auth.CheckPassword(req.Password, user.PasswordHash); err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid password"})
+		return
+	}
+
+	ok, _, err := h.verifySecondFactor(c, &user, req.Code)
+	if err == auth.ErrTooManyAttempts {
+		c.JSON(http.StatusTooManyRequests, ErrorResponse{Error: "Too many failed 2FA attempts, try again later"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to verify 2FA code"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid 2FA code"})
+		return
+	}
+
+	if err := h.clear2FA(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to disable 2FA"})
+		return
+	}
+
+	// Log audit
+	recordAudit(c, user.ID, "2fa_disabled", "user", &user.ID, map[string]interface{}{
+		"email": user.Email,
+	})
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "2FA disabled successfully"})
+}
+
+// Reset2FA godoc
+// @Summary Reset another user's 2FA
+// @Description Admin recovery for a user who lost both their authenticator and recovery codes
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /users/{id}/2fa/reset [post]
+func (h *AuthHandler) Reset2FA(c *gin.Context) {
+	user, ok := findUser(c, h.repo)
+	if !ok || !userInHand(c, h.repo, h.rbacMgr, user) {
+		return
+	}
+
+	if err := h.clear2FA(user); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to reset 2FA"})
+		return
+	}
+
+	// Log audit against the admin, with the target user as the entity
+	adminID := c.GetUint("user_id")
+	recordAudit(c, adminID, "2fa_reset", "user", &user.ID, map[string]interface{}{
+		"email": user.Email,
+	})
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "2FA reset successfully"})
+}
+
+// UnlockUser godoc
+// @Summary Unlock a user account
+// @Description Lift a lockout caused by failed login attempts
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /users/{id}/unlock [post]
+func (h *AuthHandler) UnlockUser(c *gin.Context) {
+	user, ok := findUser(c, h.repo)
+	if !ok || !userInHand(c, h.repo, h.rbacMgr, user) {
+		return
+	}
+
+	if err := h.repo.UnlockUser(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to unlock user"})
+		return
+	}
+	if err := h.loginGuard.Reset(c.Request.Context(), user.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to reset login attempts"})
+		return
+	}
+
+	// Log audit against the admin, with the target user as the entity
+	adminID := c.GetUint("user_id")
+	recordAudit(c, adminID, "account_unlocked", "user", &user.ID, map[string]interface{}{
+		"email": user.Email,
+	})
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "User unlocked successfully"})
+}
+
+// Verify2FALogin godoc
+// @Summary Verify 2FA for login
+// @Description Exchange the mfa_token from /auth/login and a TOTP code for a token pair
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body TwoFARequest true "MFA token and TOTP code"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /auth/2fa/login [post]
+func (h *AuthHandler) Verify2FALogin(c *gin.Context) {
+	var req TwoFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	claims, err := h.jwtMgr.ValidateMFAPendingToken(req.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid or expired MFA token"})
+		return
+	}
+
+	// Get user
+	var user repo.User
+	err = h.repo.DB().Preload("Role").Where("id = ? AND is_active = ?", claims.UserID, true).First(&user).Error
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid or expired MFA token"})
+		return
+	}
+
+	if !user.TwoFAEnabled {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "2FA is not enabled"})
+		return
+	}
+
+	ok, usedRecoveryCode, err := h.verifySecondFactor(c, &user, req.Code)
+	if err == auth.ErrTooManyAttempts {
+		c.JSON(http.StatusTooManyRequests, ErrorResponse{Error: "Too many failed 2FA attempts, try again later"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to verify 2FA code"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid 2FA code"})
 		return
 	}
 
+	if usedRecoveryCode {
+		recordAudit(c, user.ID, "2fa_recovery_code_used", "user", &user.ID, map[string]interface{}{
+			"email": user.Email,
+		})
+	}
+
+	// Update last login. Only the column is written, a full save would put
+	// back the TOTP step the second factor advanced.
+	now := time.Now()
+	user.LastLoginAt = &now
+	h.repo.DB().Model(&user).Update("last_login_at", now)
+
 	// Generate final tokens
-	tokenPair, err := h.jwtMgr.GenerateTokenPair(user.ID, user.Email, user.Role)
+	tokenPair, err := h.issueTokenPair(c, &user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate tokens"})
 		return
 	}
 
 	// Log audit
-	h.logAudit(c, user.ID, "2fa_login_verified", "user", &user.ID, map[string]interface{}{
+	recordAudit(c, user.ID, "2fa_login_verified", "user", &user.ID, map[string]interface{}{
 		"email": user.Email,
 	})
 
 	response := LoginResponse{
 		TokenPair:   tokenPair,
-		User:        h.userToResponse(&user),
+		User:        userToResponse(&user),
 		Requires2FA: false,
 	}
 
@@ -327,18 +689,34 @@ func (h *AuthHandler) Verify2FALogin(c *gin.Context) {
 
 // Logout godoc
 // @Summary Logout user
-// @Description Logout user and invalidate session
+// @Description Logout user and revoke the refresh token family of the session
 // @Tags auth
+// @Accept json
+// @Param request body RefreshTokenRequest false "Refresh token of the session"
 // @Success 200 {object} SuccessResponse
 // @Router /auth/logout [post]
 func (h *AuthHandler) Logout(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if exists {
-	// Log audit
-	uid := userID.(uint)
-	h.logAudit(c, uid, "logout", "user", &uid, map[string]interface{}{
-		"ip": c.ClientIP(),
-	})
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		// Nothing to revoke; the client just drops its tokens
+		c.JSON(http.StatusOK, SuccessResponse{Message: "Logged out successfully"})
+		return
+	}
+
+	claims, err := h.jwtMgr.ValidateRefreshToken(req.RefreshToken)
+	if err == nil {
+		if stored, err := h.repo.GetRefreshTokenByJTI(claims.ID); err == nil {
+			if err := h.repo.RevokeRefreshTokenFamily(stored.FamilyID); err != nil {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke session"})
+				return
+			}
+
+			// Log audit
+			recordAudit(c, stored.UserID, "logout", "user", &stored.UserID, map[string]interface{}{
+				"ip":        c.ClientIP(),
+				"family_id": stored.FamilyID,
+			})
+		}
 	}
 
 	c.JSON(http.StatusOK, SuccessResponse{Message: "Logged out successfully"})
@@ -346,10 +724,10 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 
 // GetMe godoc
 // @Summary Get current user
-// @Description Get current authenticated user information
+// @Description Get current authenticated user information with their effective permissions and data scope
 // @Tags auth
 // @Produce json
-// @Success 200 {object} UserResponse
+// @Success 200 {object} MeResponse
 // @Failure 401 {object} ErrorResponse
 // @Router /me [get]
 func (h *AuthHandler) GetMe(c *gin.Context) {
@@ -360,41 +738,364 @@ func (h *AuthHandler) GetMe(c *gin.Context) {
 	}
 
 	var user repo.User
-	err := h.repo.DB().First(&user, userID).Error
+	err := h.repo.DB().Preload("Role").First(&user, userID).Error
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, h.userToResponse(&user))
+	permissions, err := h.rbacMgr.GetUserPermissions(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Permission check failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, MeResponse{
+		UserResponse: *userToResponse(&user),
+		Permissions:  permissions,
+		DataScope:    dataScope(c),
+	})
 }
 
-func (h *AuthHandler) userToResponse(user *repo.User) *UserResponse {
+// CheckPermissions godoc
+// @Summary Check permissions
+// @Description Tell which of the given permissions the current user holds, e.g. to hide actions they can't perform
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body CheckPermissionsRequest true "Permissions to check"
+// @Success 200 {object} CheckPermissionsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /auth/check [post]
+func (h *AuthHandler) CheckPermissions(c *gin.Context) {
+	var req CheckPermissionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	held, err := h.rbacMgr.GetUserPermissions(c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Permission check failed"})
+		return
+	}
+
+	response := CheckPermissionsResponse{Permissions: make(map[string]bool, len(req.Permissions))}
+	for _, permission := range req.Permissions {
+		response.Permissions[permission] = false
+	}
+	for _, permission := range held {
+		if _, asked := response.Permissions[permission]; asked {
+			response.Permissions[permission] = true
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ChangePassword godoc
+// @Summary Change own password
+// @Description Replace the current user's password after confirming the current one
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ChangePasswordRequest true "Current and new password"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /me/password [put]
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "User not authenticated"})
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	var user repo.User
+	err := h.repo.DB().Preload("Role").First(&user, userID).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+
+	if err := auth.CheckPassword(req.CurrentPassword, user.PasswordHash); err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid current password"})
+		return
+	}
+
+	if err := h.passwordPolicy.Validate(req.NewPassword, user.Email); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	reused, err := h.isRecentPassword(&user, req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to check password history"})
+		return
+	}
+	if reused {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "New password must differ from your recent passwords"})
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to hash password"})
+		return
+	}
+
+	if err := h.repo.SetPassword(&user, passwordHash, false, h.passwordPolicy.HistorySize); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update password"})
+		return
+	}
+
+	// Log audit
+	recordAudit(c, user.ID, "password_changed", "user", &user.ID, map[string]interface{}{
+		"email": user.Email,
+	})
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Password changed successfully"})
+}
+
+// GetPasswordPolicy godoc
+// @Summary Get password policy
+// @Description Rules a new password has to satisfy
+// @Tags auth
+// @Produce json
+// @Success 200 {object} auth.PasswordPolicy
+// @Router /auth/password-policy [get]
+func (h *AuthHandler) GetPasswordPolicy(c *gin.Context) {
+	c.JSON(http.StatusOK, h.passwordPolicy)
+}
+
+// GetJWKS godoc
+// @Summary Get token signing keys
+// @Description Public keys access tokens can be verified with, in JWKS format
+// @Tags auth
+// @Produce json
+// @Success 200 {object} auth.JWKS
+// @Router /.well-known/jwks.json [get]
+func (h *AuthHandler) GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.jwtMgr.JWKS())
+}
+
+// isRecentPassword reports whether password matches the current password or
+// one of the remembered previous ones
+func (h *AuthHandler) isRecentPassword(user *repo.User, password string) (bool, error) {
+	if auth.CheckPassword(password, user.PasswordHash) == nil {
+		return true, nil
+	}
+
+	history, err := h.repo.GetPasswordHistory(user.ID, h.passwordPolicy.HistorySize)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range history {
+		if auth.CheckPassword(password, entry.PasswordHash) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// passwordChangedAt falls back to the creation time for accounts that
+// predate password tracking
+func passwordChangedAt(user *repo.User) time.Time {
+	if user.PasswordChangedAt != nil {
+		return *user.PasswordChangedAt
+	}
+	return user.CreatedAt
+}
+
+func userToResponse(user *repo.User) *UserResponse {
 	return &UserResponse{
-		ID:           user.ID,
-		Email:        user.Email,
-		Role:         user.Role,
-		TwoFAEnabled: user.TwoFAEnabled,
-		IsActive:     user.IsActive,
-		CreatedAt:    user.CreatedAt,
+		ID:                 user.ID,
+		Email:              user.Email,
+		RoleID:             user.RoleID,
+		Role:               user.RoleName(),
+		BranchID:           user.BranchID,
+		AgentID:            user.AgentID,
+		TwoFAEnabled:       user.TwoFAEnabled,
+		IsActive:           user.IsActive,
+		MustChangePassword: user.MustChangePassword,
+		LockedUntil:        user.LockedUntil,
+		LastLoginAt:        user.LastLoginAt,
+		CreatedAt:          user.CreatedAt,
+	}
+}
+
+// verifyTOTP checks a TOTP code under the per-user attempt limit and
+// records the accepted time step so the code can't be replayed
+func (h *AuthHandler) verifyTOTP(c *gin.Context, user *repo.User, code string) (bool, error) {
+	ctx := c.Request.Context()
+	if err := h.totpMgr.CheckAttempts(ctx, user.ID); err != nil {
+		return false, err
+	}
+
+	step, ok := h.totpMgr.ValidateCode(user.TwoFASecret, code, user.TOTPLastStep)
+	if ok {
+		advanced, err := h.repo.AdvanceTOTPStep(user.ID, step)
+		if err != nil {
+			return false, err
+		}
+		ok = advanced
+		if ok {
+			user.TOTPLastStep = step
+		}
+	}
+
+	return ok, h.recordSecondFactorResult(c, user, ok)
+}
+
+// verifySecondFactor accepts a TOTP code or an unused recovery code. A
+// matching recovery code is consumed. Both count towards the attempt limit.
+func (h *AuthHandler) verifySecondFactor(c *gin.Context, user *repo.User, code string) (ok bool, usedRecoveryCode bool, err error) {
+	if len(code) <= 8 {
+		ok, err := h.verifyTOTP(c, user, code)
+		return ok, false, err
+	}
+
+	if err := h.totpMgr.CheckAttempts(c.Request.Context(), user.ID); err != nil {
+		return false, false, err
+	}
+
+	codes, err := h.repo.GetUnusedRecoveryCodes(user.ID)
+	if err != nil {
+		return false, false, err
+	}
+
+	code = auth.NormalizeRecoveryCode(code)
+	for _, rc := range codes {
+		if auth.CheckPassword(code, rc.CodeHash) != nil {
+			continue
+		}
+		ok, err = h.repo.UseRecoveryCode(rc.ID)
+		if err != nil {
+			return false, false, err
+		}
+		break
 	}
+
+	return ok, ok, h.recordSecondFactorResult(c, user, ok)
+}
+
+func (h *AuthHandler) recordSecondFactorResult(c *gin.Context, user *repo.User, ok bool) error {
+	if ok {
+		return h.totpMgr.ResetAttempts(c.Request.Context(), user.ID)
+	}
+	return h.totpMgr.RecordFailedAttempt(c.Request.Context(), user.ID)
 }
 
-func (h *AuthHandler) logAudit(c *gin.Context, userID uint, action, entity string, entityID *uint, meta map[string]interface{}) {
-	auditLog := repo.AuditLog{
-		UserID:    &userID,
-		Action:    action,
-		Entity:    entity,
-		EntityID:  entityID,
-		IP:        c.ClientIP(),
-		UserAgent: c.GetHeader("User-Agent"),
+// clear2FA turns 2FA off and drops the secret and recovery codes
+func (h *AuthHandler) clear2FA(user *repo.User) error {
+	user.TwoFAEnabled = false
+	user.TwoFASecret = ""
+	user.TOTPLastStep = 0
+	if err := h.repo.DB().Save(user).Error; err != nil {
+		return err
 	}
+	return h.repo.DeleteRecoveryCodes(user.ID)
+}
 
-	// Convert meta to JSON string if provided
-	if meta != nil {
-		// Simple JSON conversion for demo
-		auditLog.MetaJSON = `{"meta": "data"}`
+// issueTokenPair starts a new session for the user and records its refresh token
+func (h *AuthHandler) issueTokenPair(c *gin.Context, user *repo.User) (*auth.TokenPair, error) {
+	tokenPair, err := h.jwtMgr.GenerateTokenPair(user.ID, user.Email, user.RoleName())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.repo.CreateRefreshToken(refreshTokenRecord(user, tokenPair)); err != nil {
+		return nil, err
+	}
+
+	if err := h.repo.CreateSession(newSession(c, user.ID, tokenPair)); err != nil {
+		return nil, err
+	}
+
+	return tokenPair, nil
+}
+
+// recordLoginFailure counts a failed password attempt and locks the account
+// once it reaches the threshold. user is nil when the email is unknown.
+func (h *AuthHandler) recordLoginFailure(c *gin.Context, email string, user *repo.User) {
+	var userID *uint
+	if user != nil {
+		userID = &user.ID
+	}
+
+	failures, lock, err := h.loginGuard.RecordFailure(c.Request.Context(), email, c.ClientIP())
+	if err != nil {
+		log.Printf("Failed to record login failure for %s: %v", email, err)
 	}
 
-	h.repo.DB().Create(&auditLog)
+	// Unknown emails have no user to attribute the attempt to
+	addAuditEvent(c, auditEvent{
+		UserID:   userID,
+		Action:   "login_failed",
+		Entity:   "user",
+		EntityID: userID,
+		Meta: map[string]interface{}{
+			"email":    email,
+			"ip":       c.ClientIP(),
+			"failures": failures,
+		},
+	})
+
+	if !lock || user == nil {
+		return
+	}
+
+	until := time.Now().Add(h.loginGuard.LockoutDuration())
+	if err := h.repo.LockUser(user.ID, until); err != nil {
+		log.Printf("Failed to lock user %d: %v", user.ID, err)
+		return
+	}
+
+	recordAudit(c, user.ID, "account_locked", "user", &user.ID, map[string]interface{}{
+		"email":        email,
+		"ip":           c.ClientIP(),
+		"locked_until": until,
+	})
+}
+
+func (h *AuthHandler) revokeFamilyOnReuse(c *gin.Context, token *repo.RefreshToken) {
+	h.repo.RevokeRefreshTokenFamily(token.FamilyID)
+	recordAudit(c, token.UserID, "refresh_token_reuse", "user", &token.UserID, map[string]interface{}{
+		"family_id": token.FamilyID,
+		"jti":       token.JTI,
+	})
+}
+
+// extendSession moves a session's expiry along with its rotated refresh
+// token. Families issued before sessions were tracked get one now.
+func (h *AuthHandler) extendSession(c *gin.Context, familyID string, user *repo.User, tokenPair *auth.TokenPair) error {
+	_, err := h.repo.GetSessionByFamilyID(familyID)
+	if err == gorm.ErrRecordNotFound {
+		return h.repo.CreateSession(newSession(c, user.ID, tokenPair))
+	}
+	if err != nil {
+		return err
+	}
+
+	return h.repo.TouchSession(familyID, map[string]interface{}{
+		"ip":         c.ClientIP(),
+		"expires_at": tokenPair.RefreshExpiresAt,
+	})
+}
+
+func refreshTokenRecord(user *repo.User, tokenPair *auth.TokenPair) *repo.RefreshToken {
+	return &repo.RefreshToken{
+		JTI:       tokenPair.RefreshTokenID,
+		FamilyID:  tokenPair.FamilyID,
+		UserID:    user.ID,
+		ExpiresAt: tokenPair.RefreshExpiresAt,
+	}
 }
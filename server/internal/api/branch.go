@@ -70,7 +70,7 @@ func (h *BranchHandler) GetBranches(c *gin.Context) {
 	var branches []repo.Branch
 	var total int64
 
-	db := h.repo.DB().Model(&repo.Branch{})
+	db := h.repo.ScopedBranches(dataScope(c))
 
 	// Apply search filter
 	if query != "" {
@@ -83,7 +83,7 @@ func (h *BranchHandler) GetBranches(c *gin.Context) {
 
 	// Apply pagination
 	offset := (page - 1) * pageSize
-	err := db.Preload("Manager").Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&branches).Error
+	err := db.Preload("Manager.Role").Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&branches).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
 		return
@@ -120,7 +120,7 @@ func (h *BranchHandler) GetBranch(c *gin.Context) {
 	}
 
 	var branch repo.Branch
-	err = h.repo.DB().Preload("Manager").First(&branch, uint(id)).Error
+	err = h.repo.ScopedBranches(dataScope(c)).Preload("Manager.Role").First(&branch, uint(id)).Error
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Branch not found"})
 		return
@@ -153,7 +153,7 @@ func (h *BranchHandler) CreateBranch(c *gin.Context) {
 	}
 
 	// Reload with manager
-	h.repo.DB().Preload("Manager").First(branch, branch.ID)
+	h.repo.DB().Preload("Manager.Role").First(branch, branch.ID)
 
 	c.JSON(http.StatusCreated, h.branchToResponse(branch))
 }
@@ -173,11 +173,12 @@ func (h *BranchHandler) UpdateBranch(c *gin.Context) {
 	}
 
 	var branch repo.Branch
-	err = h.repo.DB().First(&branch, uint(id)).Error
+	err = h.repo.ScopedBranches(dataScope(c)).First(&branch, uint(id)).Error
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Branch not found"})
 		return
 	}
+	before := branch
 
 	// Update fields
 	if req.Name != "" {
@@ -208,8 +209,11 @@ func (h *BranchHandler) UpdateBranch(c *gin.Context) {
 		return
 	}
 
+	// Log audit
+	recordUpdate(c, c.GetUint("user_id"), "branch_updated", "branch", &branch.ID, before, branch, nil)
+
 	// Reload with manager
-	h.repo.DB().Preload("Manager").First(&branch, branch.ID)
+	h.repo.DB().Preload("Manager.Role").First(&branch, branch.ID)
 
 	c.JSON(http.StatusOK, h.branchToResponse(&branch))
 }
@@ -222,11 +226,15 @@ func (h *BranchHandler) DeleteBranch(c *gin.Context) {
 		return
 	}
 
-	err = h.repo.DB().Delete(&repo.Branch{}, uint(id)).Error
-	if err != nil {
+	result := h.repo.ScopedBranches(dataScope(c)).Where("id = ?", uint(id)).Delete(&repo.Branch{})
+	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete branch"})
 		return
 	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Branch not found"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Branch deleted successfully"})
 }
@@ -253,7 +261,7 @@ func (h *BranchHandler) branchToResponse(branch *repo.Branch) BranchResponse {
 		}{
 			ID:    branch.Manager.ID,
 			Email: branch.Manager.Email,
-			Role:  branch.Manager.Role,
+			Role:  branch.Manager.RoleName(),
 		}
 	}
 
@@ -1,65 +1,94 @@
 package api
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
+	"eesigorta/backend/internal/dedupe"
+	"eesigorta/backend/internal/rbac"
 	"eesigorta/backend/internal/repo"
+	"eesigorta/backend/internal/validation"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type CustomerHandler struct {
-	repo *repo.Repository
+	repo    *repo.Repository
+	rbacMgr *rbac.RBACManager
 }
 
-func NewCustomerHandler(repo *repo.Repository) *CustomerHandler {
-	return &CustomerHandler{repo: repo}
+func NewCustomerHandler(repo *repo.Repository, rbacMgr *rbac.RBACManager) *CustomerHandler {
+	return &CustomerHandler{repo: repo, rbacMgr: rbacMgr}
 }
 
+// CustomerRequest is checked by validation.ValidateCustomer rather than
+// binding tags, so every problem is reported per field
 type CustomerRequest struct {
-	TCVKN      string `json:"tc_vkn" binding:"required"`
-	Name       string `json:"name" binding:"required"`
-	Email      string `json:"email" binding:"omitempty,email"`
-	Phone      string `json:"phone"`
-	Address    string `json:"address"`
-	City       string `json:"city"`
-	District   string `json:"district"`
-	PostalCode string `json:"postal_code"`
-	BirthDate  string `json:"birth_date"`
-	Gender     string `json:"gender"`
+	TCVKN            string `json:"tc_vkn"`
+	CustomerType     string `json:"customer_type"` // derived from tc_vkn when empty
+	Name             string `json:"name"`
+	Email            string `json:"email"`
+	Phone            string `json:"phone"`
+	Address          string `json:"address"`
+	City             string `json:"city"`
+	District         string `json:"district"`
+	PostalCode       string `json:"postal_code"`
+	BirthDate        string `json:"birth_date"`
+	Gender           string `json:"gender"`
+	TaxOffice        string `json:"tax_office"`
+	TradeTitle       string `json:"trade_title"`
+	AuthorizedPerson string `json:"authorized_person"`
 }
 
 type CustomerResponse struct {
-	ID         uint   `json:"id"`
-	TCVKN      string `json:"tc_vkn"`
-	Name       string `json:"name"`
-	Email      string `json:"email"`
-	Phone      string `json:"phone"`
-	Address    string `json:"address"`
-	City       string `json:"city"`
-	District   string `json:"district"`
-	PostalCode string `json:"postal_code"`
-	BirthDate  string `json:"birth_date"`
-	Gender     string `json:"gender"`
-	CreatedAt  string `json:"created_at"`
-	UpdatedAt  string `json:"updated_at"`
+	ID               uint   `json:"id"`
+	TCVKN            string `json:"tc_vkn"`
+	CustomerType     string `json:"customer_type"`
+	Name             string `json:"name"`
+	Email            string `json:"email"`
+	Phone            string `json:"phone"`
+	Address          string `json:"address"`
+	City             string `json:"city"`
+	District         string `json:"district"`
+	PostalCode       string `json:"postal_code"`
+	BirthDate        string `json:"birth_date"`
+	Gender           string `json:"gender"`
+	TaxOffice        string `json:"tax_office"`
+	TradeTitle       string `json:"trade_title"`
+	AuthorizedPerson string `json:"authorized_person"`
+	AnonymizedAt     string `json:"anonymized_at,omitempty"`
+	CreatedAt        string `json:"created_at"`
+	UpdatedAt        string `json:"updated_at"`
 }
 
 // GetCustomers godoc
 // @Summary Get customers
-// @Description Get paginated list of customers with optional search
+// @Description Get paginated list of the customers in the user's data scope, searched and filtered. The search matches the start of words in the name or trade title, and for users with customer:read_pii also in the email, phone or TC/VKN; only they can filter and sort by birth date and age. Text filters and the search ignore case the Turkish way, so istanbul matches İSTANBUL. Filters take comma-separated values to match any of them, dates and age also ranges as field[gt|gte|lt|lte]=value, e.g. birth_date[gte]=1980-01-01.
 // @Tags customers
 // @Produce json
 // @Param query query string false "Search query"
+// @Param customer_type query string false "bireysel or kurumsal"
+// @Param city query string false "City"
+// @Param district query string false "District"
+// @Param gender query string false "Gender"
+// @Param birth_date query string false "Birth date (YYYY-MM-DD), or birth_date[gte] and birth_date[lte] for a range"
+// @Param age query int false "Age in years, or age[gte] and age[lte] for a range"
+// @Param has_active_policy query bool false "Customers with (true) or without (false) an active policy"
+// @Param product_type query string false "Customers with a policy of the product type, e.g. kasko"
+// @Param agent_id query int false "Customers the agent user created or has a quote or policy with"
+// @Param branch_id query int false "Customers the branch's users created or have a quote or policy with"
+// @Param created_at query string false "Created on (YYYY-MM-DD), or created_at[gte] and created_at[lte] for a range"
+// @Param sort query string false "Sort fields, - for descending: name, city, district, birth_date, created_at, updated_at" default(-created_at)
 // @Param page query int false "Page number" default(1)
 // @Param pageSize query int false "Page size" default(20)
 // @Success 200 {object} PaginationResponse
 // @Failure 400 {object} ErrorResponse
 // @Router /customers [get]
 func (h *CustomerHandler) GetCustomers(c *gin.Context) {
-	query := c.Query("query")
 	page := c.GetInt("page")
 	pageSize := c.GetInt("page_size")
 
@@ -71,28 +100,26 @@ func (h *CustomerHandler) GetCustomers(c *gin.Context) {
 		pageSize = 20
 	}
 
-	var customers []repo.Customer
-	var total int64
-
-	db := h.repo.DB().Model(&repo.Customer{})
-
-	// Apply search filter
-	if query != "" {
-		db = db.Where("name ILIKE ? OR email ILIKE ? OR tc_vkn ILIKE ?", 
-			"%"+query+"%", "%"+query+"%", "%"+query+"%")
+	// Users who see personal data masked can't search or filter by it either
+	pii := showPII(c, h.rbacMgr)
+	query, err := h.repo.CustomerFilters(pii).Parse(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
 	}
 
-	// Get total count
-	db.Count(&total)
-
-	// Apply pagination
-	offset := (page - 1) * pageSize
-	err := db.Offset(offset).Limit(pageSize).Find(&customers).Error
+	customers, total, err := h.repo.SearchCustomers(dataScope(c), query, pii, page, pageSize)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
 		return
 	}
 
+	masked := make([]*repo.Customer, 0, len(customers))
+	for i := range customers {
+		masked = append(masked, &customers[i])
+	}
+	protectPII(c, h.rbacMgr, masked...)
+
 	// Convert to response
 	var response []CustomerResponse
 	for _, customer := range customers {
@@ -134,7 +161,7 @@ func (h *CustomerHandler) GetCustomer(c *gin.Context) {
 	}
 
 	var customer repo.Customer
-	err = h.repo.DB().First(&customer, uint(id)).Error
+	err = h.repo.ScopedCustomers(dataScope(c)).First(&customer, uint(id)).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Customer not found"})
@@ -144,6 +171,8 @@ func (h *CustomerHandler) GetCustomer(c *gin.Context) {
 		return
 	}
 
+	protectPII(c, h.rbacMgr, &customer)
+
 	c.JSON(http.StatusOK, h.customerToResponse(&customer))
 }
 
@@ -155,7 +184,7 @@ func (h *CustomerHandler) GetCustomer(c *gin.Context) {
 // @Produce json
 // @Param request body CustomerRequest true "Customer data"
 // @Success 201 {object} CustomerResponse
-// @Failure 400 {object} ErrorResponse
+// @Failure 400 {object} ValidationErrorResponse
 // @Failure 409 {object} ErrorResponse
 // @Router /customers [post]
 func (h *CustomerHandler) CreateCustomer(c *gin.Context) {
@@ -165,39 +194,41 @@ func (h *CustomerHandler) CreateCustomer(c *gin.Context) {
 		return
 	}
 
+	var customer repo.Customer
+	if !applyCustomerRequest(c, &customer, &req) {
+		return
+	}
+
 	// Check if customer already exists
 	var existingCustomer repo.Customer
-	err := h.repo.DB().Where("tc_vkn = ?", req.TCVKN).First(&existingCustomer).Error
+	err := h.repo.DB().Where("tc_vkn = ?", customer.TCVKN).First(&existingCustomer).Error
 	if err == nil {
 		c.JSON(http.StatusConflict, ErrorResponse{Error: "Customer with this TC/VKN already exists"})
 		return
 	}
 
-	// Create customer
-	customer := repo.Customer{
-		TCVKN:      req.TCVKN,
-		Name:       req.Name,
-		Email:      req.Email,
-		Phone:      req.Phone,
-		Address:    req.Address,
-		City:       req.City,
-		District:   req.District,
-		PostalCode: req.PostalCode,
-		Gender:     req.Gender,
-	}
+	// The customer belongs to its creator and their branch, so it stays in
+	// their data scope before any quote or policy is written for it
+	userID, _ := c.Get("user_id")
+	ownerID := userID.(uint)
+	customer.OwnerID = &ownerID
+	customer.BranchID = dataScope(c).BranchID
 
+	// Create customer
 	err = h.repo.DB().Create(&customer).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create customer"})
 		return
 	}
+	h.refreshDuplicates(&customer)
 
 	// Log audit
-	userID, _ := c.Get("user_id")
-	h.logAudit(c, userID.(uint), "customer_created", "customer", &customer.ID, map[string]interface{}{
-		"tc_vkn": customer.TCVKN,
-		"name":   customer.Name,
-	})
+	recordAudit(c, ownerID, "customer_created", "customer", &customer.ID, nil)
+
+	// The caller typed the data in, so echoing it back isn't audited
+	if !showPII(c, h.rbacMgr) {
+		maskCustomer(&customer)
+	}
 
 	c.JSON(http.StatusCreated, h.customerToResponse(&customer))
 }
@@ -211,7 +242,7 @@ func (h *CustomerHandler) CreateCustomer(c *gin.Context) {
 // @Param id path int true "Customer ID"
 // @Param request body CustomerRequest true "Customer data"
 // @Success 200 {object} CustomerResponse
-// @Failure 400 {object} ErrorResponse
+// @Failure 400 {object} ValidationErrorResponse
 // @Failure 404 {object} ErrorResponse
 // @Failure 409 {object} ErrorResponse
 // @Router /customers/{id} [put]
@@ -231,7 +262,7 @@ func (h *CustomerHandler) UpdateCustomer(c *gin.Context) {
 
 	// Find existing customer
 	var customer repo.Customer
-	err = h.repo.DB().First(&customer, uint(id)).Error
+	err = h.repo.ScopedCustomers(dataScope(c)).First(&customer, uint(id)).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Customer not found"})
@@ -241,39 +272,47 @@ func (h *CustomerHandler) UpdateCustomer(c *gin.Context) {
 		return
 	}
 
+	// Anonymization is irreversible, personal data can't be entered again
+	if customer.AnonymizedAt != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Customer is anonymized"})
+		return
+	}
+
+	before := customer
+
+	// Update customer
+	pii := showPII(c, h.rbacMgr)
+	if !pii {
+		keepMaskedPII(&req, &customer)
+	}
+	if !applyCustomerRequest(c, &customer, &req) {
+		return
+	}
+
 	// Check if TC/VKN is being changed and if it conflicts
-	if customer.TCVKN != req.TCVKN {
+	if customer.TCVKN != before.TCVKN {
 		var existingCustomer repo.Customer
-		err = h.repo.DB().Where("tc_vkn = ? AND id != ?", req.TCVKN, uint(id)).First(&existingCustomer).Error
+		err = h.repo.DB().Where("tc_vkn = ? AND id != ?", customer.TCVKN, uint(id)).First(&existingCustomer).Error
 		if err == nil {
 			c.JSON(http.StatusConflict, ErrorResponse{Error: "Customer with this TC/VKN already exists"})
 			return
 		}
 	}
 
-	// Update customer
-	customer.TCVKN = req.TCVKN
-	customer.Name = req.Name
-	customer.Email = req.Email
-	customer.Phone = req.Phone
-	customer.Address = req.Address
-	customer.City = req.City
-	customer.District = req.District
-	customer.PostalCode = req.PostalCode
-	customer.Gender = req.Gender
-
 	err = h.repo.DB().Save(&customer).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update customer"})
 		return
 	}
+	h.refreshDuplicates(&customer)
 
 	// Log audit
 	userID, _ := c.Get("user_id")
-	h.logAudit(c, userID.(uint), "customer_updated", "customer", &customer.ID, map[string]interface{}{
-		"tc_vkn": customer.TCVKN,
-		"name":   customer.Name,
-	})
+	recordUpdate(c, userID.(uint), "customer_updated", "customer", &customer.ID, before, customer, nil)
+
+	if !pii {
+		maskCustomer(&customer)
+	}
 
 	c.JSON(http.StatusOK, h.customerToResponse(&customer))
 }
@@ -296,7 +335,7 @@ func (h *CustomerHandler) DeleteCustomer(c *gin.Context) {
 	}
 
 	var customer repo.Customer
-	err = h.repo.DB().First(&customer, uint(id)).Error
+	err = h.repo.ScopedCustomers(dataScope(c)).First(&customer, uint(id)).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Customer not found"})
@@ -314,42 +353,89 @@ func (h *CustomerHandler) DeleteCustomer(c *gin.Context) {
 
 	// Log audit
 	userID, _ := c.Get("user_id")
-	h.logAudit(c, userID.(uint), "customer_deleted", "customer", &customer.ID, map[string]interface{}{
-		"tc_vkn": customer.TCVKN,
-		"name":   customer.Name,
-	})
+	recordAudit(c, userID.(uint), "customer_deleted", "customer", &customer.ID, nil)
 
 	c.JSON(http.StatusOK, SuccessResponse{Message: "Customer deleted successfully"})
 }
 
+// applyCustomerRequest copies the request onto the customer and validates
+// the result, writing the field-level error response itself when it's
+// invalid
+func applyCustomerRequest(c *gin.Context, customer *repo.Customer, req *CustomerRequest) bool {
+	customer.TCVKN = req.TCVKN
+	customer.CustomerType = req.CustomerType
+	customer.Name = req.Name
+	customer.Email = req.Email
+	customer.Phone = req.Phone
+	customer.Address = req.Address
+	customer.City = req.City
+	customer.District = req.District
+	customer.PostalCode = req.PostalCode
+	customer.Gender = req.Gender
+	customer.TaxOffice = req.TaxOffice
+	customer.TradeTitle = req.TradeTitle
+	customer.AuthorizedPerson = req.AuthorizedPerson
+
+	fields := validation.FieldErrors{}
+	customer.BirthDate = nil
+	if req.BirthDate != "" {
+		birthDate, err := time.Parse("2006-01-02", req.BirthDate)
+		if err != nil {
+			fields.Add("birth_date", "must be a date as YYYY-MM-DD")
+		} else {
+			customer.BirthDate = &birthDate
+		}
+	}
+
+	var invalid validation.FieldErrors
+	if err := validation.ValidateCustomer(customer, time.Now()); errors.As(err, &invalid) {
+		for field, message := range invalid {
+			fields.Add(field, message)
+		}
+	}
+
+	if len(fields) > 0 {
+		c.JSON(http.StatusBadRequest, ValidationErrorResponse{Error: "Validation failed", Fields: fields})
+		return false
+	}
+	return true
+}
+
 func (h *CustomerHandler) customerToResponse(customer *repo.Customer) CustomerResponse {
 	return CustomerResponse{
-		ID:         customer.ID,
-		TCVKN:      customer.TCVKN,
-		Name:       customer.Name,
-		Email:      customer.Email,
-		Phone:      customer.Phone,
-		Address:    customer.Address,
-		City:       customer.City,
-		District:   customer.District,
-		PostalCode: customer.PostalCode,
-		BirthDate:  "", // Format date if needed
-		Gender:     customer.Gender,
-		CreatedAt:  customer.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		UpdatedAt:  customer.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+		ID:               customer.ID,
+		TCVKN:            customer.TCVKN,
+		CustomerType:     customer.CustomerType,
+		Name:             customer.Name,
+		Email:            customer.Email,
+		Phone:            customer.Phone,
+		Address:          customer.Address,
+		City:             customer.City,
+		District:         customer.District,
+		PostalCode:       customer.PostalCode,
+		BirthDate:        formatDate(customer.BirthDate),
+		Gender:           customer.Gender,
+		TaxOffice:        customer.TaxOffice,
+		TradeTitle:       customer.TradeTitle,
+		AuthorizedPerson: customer.AuthorizedPerson,
+		AnonymizedAt:     formatDate(customer.AnonymizedAt),
+		CreatedAt:        customer.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		UpdatedAt:        customer.UpdatedAt.Format("2006-01-02T15:04:05Z"),
 	}
 }
 
-func (h *CustomerHandler) logAudit(c *gin.Context, userID uint, action, entity string, entityID *uint, meta map[string]interface{}) {
-	auditLog := repo.AuditLog{
-		UserID:    &userID,
-		Action:    action,
-		Entity:    entity,
-		EntityID:  entityID,
-		IP:        c.ClientIP(),
-		UserAgent: c.GetHeader("User-Agent"),
-		MetaJSON:  `{"meta": "data"}`,
+// formatDate formats an optional date, leaving it empty when unset
+func formatDate(date *time.Time) string {
+	if date == nil {
+		return ""
 	}
+	return date.Format("2006-01-02")
+}
 
-	h.repo.DB().Create(&auditLog)
+// refreshDuplicates rescores a saved customer's duplicate pairs. A failure
+// doesn't fail the request, the hourly duplicate scoring job retries it.
+func (h *CustomerHandler) refreshDuplicates(customer *repo.Customer) {
+	if err := dedupe.Refresh(h.repo, customer); err != nil {
+		log.Printf("Failed to score duplicates of customer %d: %v", customer.ID, err)
+	}
 }
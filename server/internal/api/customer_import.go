@@ -0,0 +1,267 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"eesigorta/backend/internal/importer"
+	"eesigorta/backend/internal/jobs"
+	"eesigorta/backend/internal/rbac"
+	"eesigorta/backend/internal/repo"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxImportFileSize is the largest spreadsheet accepted
+	maxImportFileSize = 10 << 20
+	// maxImportRows is the most rows one import may have
+	maxImportRows = 50000
+	// syncImportRows is the most rows imported within the request. Larger
+	// files are imported by the worker while the client polls for progress.
+	syncImportRows = 200
+)
+
+type CustomerImportHandler struct {
+	repo    *repo.Repository
+	jobs    *jobs.Client
+	rbacMgr *rbac.RBACManager
+}
+
+func NewCustomerImportHandler(repo *repo.Repository, jobs *jobs.Client, rbacMgr *rbac.RBACManager) *CustomerImportHandler {
+	return &CustomerImportHandler{repo: repo, jobs: jobs, rbacMgr: rbacMgr}
+}
+
+type CustomerImportResponse struct {
+	ID            uint                `json:"id"`
+	FileName      string              `json:"file_name"`
+	Format        string              `json:"format"`
+	DryRun        bool                `json:"dry_run"`
+	Status        string              `json:"status"`
+	TotalRows     int                 `json:"total_rows"`
+	ProcessedRows int                 `json:"processed_rows"`
+	Created       int                 `json:"created"`
+	Updated       int                 `json:"updated"`
+	Unchanged     int                 `json:"unchanged"`
+	Failed        int                 `json:"failed"`
+	Errors        []importer.RowError `json:"errors"`
+	Error         string              `json:"error,omitempty"`
+	StartedAt     *time.Time          `json:"started_at"`
+	FinishedAt    *time.Time          `json:"finished_at"`
+	CreatedAt     time.Time           `json:"created_at"`
+}
+
+// ImportCustomers godoc
+// @Summary Import customers from a spreadsheet
+// @Description Imports customers from a CSV or XLSX file, validating every row like a customer saved through the API. Customers whose TC/VKN already exists are updated with the row's non-empty cells. With dry_run nothing is saved and the response reports what would happen and which rows fail. Files of up to 200 rows are imported within the request; larger ones are imported in the background and answered with 202, poll GET /customers/imports/{id} for progress.
+// @Tags customers
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param file formData file true "CSV or XLSX file, first row holding the column headers"
+// @Param mapping formData string false "JSON object of customer fields to column headers, e.g. {\"tc_vkn\":\"TC No\",\"name\":\"Ad Soyad\"}. Columns headed with a field's name need no mapping."
+// @Param dry_run formData bool false "Validate only"
+// @Success 200 {object} CustomerImportResponse
+// @Success 202 {object} CustomerImportResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /customers/import [post]
+func (h *CustomerImportHandler) ImportCustomers(c *gin.Context) {
+	// Rows whose TC/VKN exists update the customer, which creating alone
+	// doesn't allow. Permissions limited by a grant don't count, the import
+	// works on everything in the user's data scope.
+	canUpdate, err := h.rbacMgr.HasPermission(c.GetUint("user_id"), rbac.PermissionCustomerUpdate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Permission check failed"})
+		return
+	}
+	if !canUpdate {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Importing customers also requires " + rbac.PermissionCustomerUpdate})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileSize+1<<20)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "A CSV or XLSX file of at most 10 MB is required"})
+		return
+	}
+	if fileHeader.Size > maxImportFileSize {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "File is larger than 10 MB"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Could not read file"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Could not read file"})
+		return
+	}
+
+	dryRun := false
+	if value := c.PostForm("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "dry_run must be true or false"})
+			return
+		}
+	}
+
+	mapping, err := importer.ParseMapping(c.PostForm("mapping"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	// Problems with the file as a whole are reported right away, rather
+	// than on an import that fails later
+	format, err := importer.DetectFormat(fileHeader.Filename, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	sheet, err := importer.ReadSheet(format, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if _, err := mapping.Columns(sheet.Header); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	switch {
+	case len(sheet.Rows) == 0:
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "File has no rows below the header"})
+		return
+	case len(sheet.Rows) > maxImportRows:
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("File has %d rows, at most %d can be imported at once", len(sheet.Rows), maxImportRows)})
+		return
+	}
+
+	mappingJSON, _ := json.Marshal(mapping)
+	customerImport := repo.CustomerImport{
+		UserID:      c.GetUint("user_id"),
+		FileName:    fileHeader.Filename,
+		Format:      format,
+		MappingJSON: string(mappingJSON),
+		DryRun:      dryRun,
+		File:        data,
+		Status:      repo.ImportPending,
+		TotalRows:   len(sheet.Rows),
+		ReportJSON:  "[]",
+	}
+	if err := h.repo.CreateCustomerImport(&customerImport); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create import"})
+		return
+	}
+
+	status := http.StatusOK
+	if len(sheet.Rows) <= syncImportRows {
+		if err := importer.Run(h.repo, &customerImport); err != nil {
+			log.Printf("Customer import %d failed: %v", customerImport.ID, err)
+		}
+	} else if err := h.jobs.EnqueueImportCustomers(customerImport.ID); err != nil {
+		log.Printf("Failed to enqueue customer import %d: %v", customerImport.ID, err)
+		customerImport.Status = repo.ImportFailed
+		customerImport.ErrorMsg = "could not be queued"
+		customerImport.File = nil
+		h.repo.UpdateCustomerImport(&customerImport)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to queue import"})
+		return
+	} else {
+		status = http.StatusAccepted
+	}
+
+	// Log audit
+	recordAudit(c, customerImport.UserID, "customers_imported", "customer_import", &customerImport.ID, map[string]interface{}{
+		"file_name":  customerImport.FileName,
+		"dry_run":    customerImport.DryRun,
+		"total_rows": customerImport.TotalRows,
+		"status":     customerImport.Status,
+		"created":    customerImport.Created,
+		"updated":    customerImport.Updated,
+		"failed":     customerImport.Failed,
+	})
+
+	c.JSON(status, h.importToResponse(c, &customerImport))
+}
+
+// GetCustomerImport godoc
+// @Summary Get a customer import
+// @Description Progress and outcome of a customer import, with the rows that failed and why. Users see their own imports, or every import when their data scope is all.
+// @Tags customers
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Import ID"
+// @Success 200 {object} CustomerImportResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /customers/imports/{id} [get]
+func (h *CustomerImportHandler) GetCustomerImport(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid import ID"})
+		return
+	}
+
+	customerImport, err := h.repo.GetCustomerImport(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Import not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+	if customerImport.UserID != c.GetUint("user_id") && dataScope(c).Level != repo.ScopeAll {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Import not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.importToResponse(c, customerImport))
+}
+
+// importToResponse masks the identifiers in the report for users who may
+// not see customers' personal data
+func (h *CustomerImportHandler) importToResponse(c *gin.Context, customerImport *repo.CustomerImport) CustomerImportResponse {
+	report := importer.Report(customerImport)
+	if report == nil {
+		report = []importer.RowError{}
+	}
+	if !showPII(c, h.rbacMgr) {
+		for i := range report {
+			report[i].TCVKN = maskTCVKN(report[i].TCVKN)
+		}
+	}
+
+	return CustomerImportResponse{
+		ID:            customerImport.ID,
+		FileName:      customerImport.FileName,
+		Format:        customerImport.Format,
+		DryRun:        customerImport.DryRun,
+		Status:        customerImport.Status,
+		TotalRows:     customerImport.TotalRows,
+		ProcessedRows: customerImport.ProcessedRows,
+		Created:       customerImport.Created,
+		Updated:       customerImport.Updated,
+		Unchanged:     customerImport.Unchanged,
+		Failed:        customerImport.Failed,
+		Errors:        report,
+		Error:         customerImport.ErrorMsg,
+		StartedAt:     customerImport.StartedAt,
+		FinishedAt:    customerImport.FinishedAt,
+		CreatedAt:     customerImport.CreatedAt,
+	}
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"eesigorta/backend/internal/repo"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// defaultDuplicateScore is the minimum score reported by default
+const defaultDuplicateScore = 50
+
+type DuplicateQuery struct {
+	CustomerID *uint `form:"customer_id"`
+	MinScore   int   `form:"min_score" binding:"omitempty,min=30,max=100"`
+}
+
+// DuplicateMatch pairs two customers that are likely the same person or
+// company. The older record comes first.
+type DuplicateMatch struct {
+	Customer  CustomerResponse `json:"customer"`
+	Duplicate CustomerResponse `json:"duplicate"`
+	Score     int              `json:"score"`
+	Reasons   []string         `json:"reasons"` // phone, email, birth_date, name
+}
+
+type MergeCustomerRequest struct {
+	DuplicateID uint `json:"duplicate_id" binding:"required"`
+}
+
+type MergeCustomerResponse struct {
+	Customer CustomerResponse   `json:"customer"`
+	MergedID uint               `json:"merged_id"`
+	Moved    repo.CustomerMerge `json:"moved"`
+}
+
+// FindDuplicates godoc
+// @Summary Find duplicate customers
+// @Description List pairs of customers in the user's data scope that are likely the same person or company, highest score first. Pairs are scored when a customer is saved, against the customers sharing its normalized phone, email, name or birth date, with the name compared fuzzily.
+// @Tags customers
+// @Produce json
+// @Param customer_id query int false "Only duplicates of this customer"
+// @Param min_score query int false "Minimum score (30-100, default 50)"
+// @Param page query int false "Page number" default(1)
+// @Param pageSize query int false "Page size" default(20)
+// @Success 200 {object} PaginationResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /customers/duplicates [get]
+func (h *CustomerHandler) FindDuplicates(c *gin.Context) {
+	var req DuplicateQuery
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if req.MinScore == 0 {
+		req.MinScore = defaultDuplicateScore
+	}
+
+	page := c.GetInt("page")
+	pageSize := c.GetInt("page_size")
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+
+	pairs, total, err := h.repo.GetCustomerDuplicates(dataScope(c), req.CustomerID, req.MinScore, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	// Customers can appear in several pairs, so each pair has its own copies
+	// to mask
+	shown := make([]*repo.Customer, 0, 2*len(pairs))
+	for i := range pairs {
+		shown = append(shown, &pairs[i].Customer, &pairs[i].Duplicate)
+	}
+	protectPII(c, h.rbacMgr, shown...)
+	matches := make([]DuplicateMatch, 0, len(pairs))
+	for _, pair := range pairs {
+		matches = append(matches, DuplicateMatch{
+			Customer:  h.customerToResponse(&pair.Customer),
+			Duplicate: h.customerToResponse(&pair.Duplicate),
+			Score:     pair.Score,
+			Reasons:   strings.Split(pair.Reasons, ","),
+		})
+	}
+
+	c.JSON(http.StatusOK, PaginationResponse{
+		Data:       matches,
+		Total:      total,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: int((total + int64(pageSize) - 1) / int64(pageSize)),
+	})
+}
+
+// MergeCustomer godoc
+// @Summary Merge a duplicate customer
+// @Description Move the duplicate's quotes and policies, with their payments, and its consents onto this customer and delete the duplicate. Anonymized customers can't be merged.
+// @Tags customers
+// @Accept json
+// @Produce json
+// @Param id path int true "Surviving customer ID"
+// @Param request body MergeCustomerRequest true "Duplicate to merge"
+// @Success 200 {object} MergeCustomerResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /customers/{id}/merge [post]
+func (h *CustomerHandler) MergeCustomer(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid customer ID"})
+		return
+	}
+
+	var req MergeCustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if req.DuplicateID == uint(id) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "A customer can't be merged into itself"})
+		return
+	}
+
+	// Both customers must be in the user's data scope
+	scope := dataScope(c)
+	var survivor, duplicate repo.Customer
+	if err := h.repo.ScopedCustomers(scope).First(&survivor, uint(id)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Customer not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+	if err := h.repo.ScopedCustomers(scope).First(&duplicate, req.DuplicateID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Duplicate customer not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	moved, err := h.repo.MergeCustomers(survivor.ID, duplicate.ID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Duplicate customer not found"})
+			return
+		}
+		if err == repo.ErrCustomerAnonymized {
+			c.JSON(http.StatusConflict, ErrorResponse{Error: "Anonymized customers can't be merged"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to merge customers"})
+		return
+	}
+
+	// Log audit
+	userID := c.GetUint("user_id")
+	recordAudit(c, userID, "customer_merged", "customer", &survivor.ID, map[string]interface{}{
+		"merged_id": duplicate.ID,
+		"quotes":    moved.Quotes,
+		"policies":  moved.Policies,
+		"payments":  moved.Payments,
+		"consents":  moved.Consents,
+	})
+	recordAudit(c, userID, "customer_merged_into", "customer", &duplicate.ID, map[string]interface{}{
+		"survivor_id": survivor.ID,
+		"quotes":      moved.Quotes,
+		"policies":    moved.Policies,
+		"payments":    moved.Payments,
+		"consents":    moved.Consents,
+	})
+
+	protectPII(c, h.rbacMgr, &survivor)
+
+	c.JSON(http.StatusOK, MergeCustomerResponse{
+		Customer: h.customerToResponse(&survivor),
+		MergedID: duplicate.ID,
+		Moved:    *moved,
+	})
+}
@@ -0,0 +1,291 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"eesigorta/backend/internal/rbac"
+	"eesigorta/backend/internal/repo"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxGrantDuration caps how long a permission grant may run. Longer needs
+// belong in the user's role.
+const maxGrantDuration = 90 * 24 * time.Hour
+
+type GrantHandler struct {
+	repo    *repo.Repository
+	rbacMgr *rbac.RBACManager
+}
+
+func NewGrantHandler(repo *repo.Repository, rbacMgr *rbac.RBACManager) *GrantHandler {
+	return &GrantHandler{repo: repo, rbacMgr: rbacMgr}
+}
+
+type CreateGrantRequest struct {
+	Permission  string    `json:"permission" binding:"required"`
+	BranchID    *uint     `json:"branch_id"`
+	AgentUserID *uint     `json:"agent_user_id"`
+	ExpiresAt   time.Time `json:"expires_at" binding:"required"`
+	Reason      string    `json:"reason" binding:"required,max=500"`
+}
+
+type GrantResponse struct {
+	ID          uint       `json:"id"`
+	UserID      uint       `json:"user_id"`
+	Permission  string     `json:"permission"`
+	BranchID    *uint      `json:"branch_id"`
+	AgentUserID *uint      `json:"agent_user_id"`
+	Reason      string     `json:"reason"`
+	GrantedByID uint       `json:"granted_by_id"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	RevokedByID *uint      `json:"revoked_by_id"`
+	Active      bool       `json:"active"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// GetUserGrants godoc
+// @Summary List a user's permission grants
+// @Description Permissions given to a user on top of their role, newest first, including recently expired and revoked ones
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} GrantResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /users/{id}/grants [get]
+func (h *GrantHandler) GetUserGrants(c *gin.Context) {
+	user, ok := findUser(c, h.repo)
+	if !ok {
+		return
+	}
+
+	grants, err := h.repo.GetPermissionGrants(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	now := time.Now()
+	response := make([]GrantResponse, 0, len(grants))
+	for i := range grants {
+		response = append(response, grantToResponse(&grants[i], now))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// CreateUserGrant godoc
+// @Summary Grant a user a permission
+// @Description Give a user a permission until it expires, e.g. while covering for a colleague. Read, list, update and delete permissions on customers, policies, quotes, agents and branches can be limited to a branch or an agent user; the user then has the permission on that branch's or agent's records only. Only permissions the granting user holds outright can be granted.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body CreateGrantRequest true "Grant data"
+// @Success 201 {object} GrantResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /users/{id}/grants [post]
+func (h *GrantHandler) CreateUserGrant(c *gin.Context) {
+	var req CreateGrantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	user, ok := findUser(c, h.repo)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if !req.ExpiresAt.After(now) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Expiry must be in the future"})
+		return
+	}
+	if req.ExpiresAt.Sub(now) > maxGrantDuration {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Grants can run for at most 90 days"})
+		return
+	}
+	if req.BranchID != nil && req.AgentUserID != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "A grant is limited to either a branch or an agent, not both"})
+		return
+	}
+	if (req.BranchID != nil || req.AgentUserID != nil) && !rbac.IsScopedPermission(req.Permission) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Only read, list, update and delete permissions on customers, policies, quotes, agents and branches can be limited to a branch or an agent"})
+		return
+	}
+
+	permissions, err := h.repo.GetPermissionsByName([]string{req.Permission})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+	if len(permissions) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Unknown permission: " + req.Permission})
+		return
+	}
+
+	if !handsOnAccess(c, h.rbacMgr, []string{req.Permission}, "") {
+		return
+	}
+	if !h.targetInScope(c, req.Permission, req.BranchID, req.AgentUserID) {
+		return
+	}
+
+	granterID := c.GetUint("user_id")
+	grant := repo.PermissionGrant{
+		UserID:      user.ID,
+		Permission:  req.Permission,
+		BranchID:    req.BranchID,
+		AgentUserID: req.AgentUserID,
+		Reason:      req.Reason,
+		GrantedByID: granterID,
+		ExpiresAt:   req.ExpiresAt,
+	}
+	if err := h.repo.CreatePermissionGrant(&grant); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create grant"})
+		return
+	}
+
+	// Log audit
+	recordAudit(c, granterID, "permission_granted", "permission_grant", &grant.ID, map[string]interface{}{
+		"user_id":       user.ID,
+		"email":         user.Email,
+		"permission":    grant.Permission,
+		"branch_id":     grant.BranchID,
+		"agent_user_id": grant.AgentUserID,
+		"expires_at":    grant.ExpiresAt,
+		"reason":        grant.Reason,
+	})
+
+	c.JSON(http.StatusCreated, grantToResponse(&grant, now))
+}
+
+// RevokeUserGrant godoc
+// @Summary Revoke a permission grant
+// @Description End a user's permission grant before it expires
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param grant_id path int true "Grant ID"
+// @Success 200 {object} GrantResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /users/{id}/grants/{grant_id} [delete]
+func (h *GrantHandler) RevokeUserGrant(c *gin.Context) {
+	user, ok := findUser(c, h.repo)
+	if !ok {
+		return
+	}
+
+	grantID, err := strconv.ParseUint(c.Param("grant_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid grant ID"})
+		return
+	}
+
+	grant, err := h.repo.GetPermissionGrant(user.ID, uint(grantID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Grant not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	now := time.Now()
+	if !grant.Active(now) {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Grant has already ended"})
+		return
+	}
+
+	revokerID := c.GetUint("user_id")
+	if err := h.repo.RevokePermissionGrant(grant, revokerID, now); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusConflict, ErrorResponse{Error: "Grant has already ended"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke grant"})
+		return
+	}
+
+	// Log audit
+	recordAudit(c, revokerID, "permission_revoked", "permission_grant", &grant.ID, map[string]interface{}{
+		"user_id":    user.ID,
+		"email":      user.Email,
+		"permission": grant.Permission,
+	})
+
+	c.JSON(http.StatusOK, grantToResponse(grant, now))
+}
+
+// targetInScope checks that the branch or agent user a grant is limited to
+// is one the granting user reaches with the permission themselves, so a
+// grant never opens up records its granter can't access. It writes the
+// error response itself.
+func (h *GrantHandler) targetInScope(c *gin.Context, permission string, branchID, agentUserID *uint) bool {
+	scope := dataScope(c).ForPermission(permission, true)
+
+	if branchID != nil {
+		var count int64
+		if err := h.repo.ScopedBranches(scope).Where("branches.id = ?", *branchID).Count(&count).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+			return false
+		}
+		if count == 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Branch not found"})
+			return false
+		}
+	}
+
+	if agentUserID != nil {
+		var agentUser repo.User
+		err := h.repo.DB().First(&agentUser, *agentUserID).Error
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Agent user not found"})
+				return false
+			}
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+			return false
+		}
+
+		inScope, err := h.repo.UserInScope(scope, agentUser.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+			return false
+		}
+		if !inScope {
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: "Agent is outside your data scope"})
+			return false
+		}
+	}
+
+	return true
+}
+
+func grantToResponse(grant *repo.PermissionGrant, now time.Time) GrantResponse {
+	return GrantResponse{
+		ID:          grant.ID,
+		UserID:      grant.UserID,
+		Permission:  grant.Permission,
+		BranchID:    grant.BranchID,
+		AgentUserID: grant.AgentUserID,
+		Reason:      grant.Reason,
+		GrantedByID: grant.GrantedByID,
+		ExpiresAt:   grant.ExpiresAt,
+		RevokedAt:   grant.RevokedAt,
+		RevokedByID: grant.RevokedByID,
+		Active:      grant.Active(now),
+		CreatedAt:   grant.CreatedAt,
+	}
+}
@@ -0,0 +1,314 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"eesigorta/backend/internal/repo"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ConsentRequest struct {
+	Purpose     string `json:"purpose" binding:"required,oneof=marketing data_sharing abroad_transfer"`
+	Channel     string `json:"channel" binding:"omitempty,oneof=sms email call all"` // all when empty
+	TextVersion string `json:"text_version" binding:"required,max=50"`
+	// GrantedAt is for consents given earlier, e.g. on a signed form; now
+	// when empty
+	GrantedAt *time.Time `json:"granted_at"`
+}
+
+type AnonymizeCustomerResponse struct {
+	Customer   CustomerResponse           `json:"customer"`
+	Anonymized repo.CustomerAnonymization `json:"anonymized"`
+}
+
+// GetConsents godoc
+// @Summary List a customer's KVKK consents
+// @Description Every consent the customer gave, revoked ones included, newest first
+// @Tags customers
+// @Produce json
+// @Param id path int true "Customer ID"
+// @Success 200 {array} repo.CustomerConsent
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /customers/{id}/consents [get]
+func (h *CustomerHandler) GetConsents(c *gin.Context) {
+	customer, ok := h.consentCustomer(c)
+	if !ok {
+		return
+	}
+
+	consents, err := h.repo.GetCustomerConsents(customer.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, consents)
+}
+
+// GrantConsent godoc
+// @Summary Record a KVKK consent
+// @Description Record the customer's explicit consent for a purpose, to the given version of the consent text. Marketing consent is per channel (sms, email, call) or for all of them; other purposes cover all channels.
+// @Tags customers
+// @Accept json
+// @Produce json
+// @Param id path int true "Customer ID"
+// @Param request body ConsentRequest true "Consent"
+// @Success 201 {object} repo.CustomerConsent
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /customers/{id}/consents [post]
+func (h *CustomerHandler) GrantConsent(c *gin.Context) {
+	customer, ok := h.consentCustomer(c)
+	if !ok {
+		return
+	}
+
+	var req ConsentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if req.Channel == "" {
+		req.Channel = repo.ConsentChannelAll
+	}
+	if req.Purpose != repo.ConsentMarketing && req.Channel != repo.ConsentChannelAll {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Only marketing consent is given per channel"})
+		return
+	}
+
+	now := time.Now()
+	grantedAt := now
+	if req.GrantedAt != nil {
+		if req.GrantedAt.After(now) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "granted_at can't be in the future"})
+			return
+		}
+		grantedAt = *req.GrantedAt
+	}
+
+	covered, err := h.repo.HasConsent(customer.ID, req.Purpose, req.Channel)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+	if covered {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Customer already has a consent covering this purpose and channel, revoke it first"})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	consent := repo.CustomerConsent{
+		CustomerID:  customer.ID,
+		Purpose:     req.Purpose,
+		Channel:     req.Channel,
+		TextVersion: req.TextVersion,
+		GrantedAt:   grantedAt,
+		RecordedBy:  &userID,
+	}
+	if err := h.repo.CreateCustomerConsent(&consent); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to record consent"})
+		return
+	}
+
+	// Log audit
+	recordAudit(c, userID, "consent_granted", "customer", &customer.ID, map[string]interface{}{
+		"consent_id":   consent.ID,
+		"purpose":      consent.Purpose,
+		"channel":      consent.Channel,
+		"text_version": consent.TextVersion,
+	})
+
+	c.JSON(http.StatusCreated, consent)
+}
+
+// RevokeConsent godoc
+// @Summary Revoke a KVKK consent
+// @Description Record that the customer withdrew a consent. The consent is kept, with the time it was revoked.
+// @Tags customers
+// @Produce json
+// @Param id path int true "Customer ID"
+// @Param consent_id path int true "Consent ID"
+// @Success 200 {object} repo.CustomerConsent
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /customers/{id}/consents/{consent_id}/revoke [post]
+func (h *CustomerHandler) RevokeConsent(c *gin.Context) {
+	customer, ok := h.consentCustomer(c)
+	if !ok {
+		return
+	}
+	consentID, err := strconv.ParseUint(c.Param("consent_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid consent ID"})
+		return
+	}
+
+	consent, err := h.repo.GetCustomerConsent(customer.ID, uint(consentID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Consent not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+	if consent.RevokedAt != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Consent is already revoked"})
+		return
+	}
+
+	now := time.Now()
+	consent.RevokedAt = &now
+	if err := h.repo.UpdateCustomerConsent(consent); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke consent"})
+		return
+	}
+
+	// Log audit
+	recordAudit(c, c.GetUint("user_id"), "consent_revoked", "customer", &customer.ID, map[string]interface{}{
+		"consent_id": consent.ID,
+		"purpose":    consent.Purpose,
+		"channel":    consent.Channel,
+	})
+
+	c.JSON(http.StatusOK, consent)
+}
+
+// consentCustomer loads the customer whose consents are asked for. Consents
+// of anonymized customers can still be listed, not recorded.
+func (h *CustomerHandler) consentCustomer(c *gin.Context) (*repo.Customer, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid customer ID"})
+		return nil, false
+	}
+
+	var customer repo.Customer
+	if err := h.repo.ScopedCustomers(dataScope(c)).First(&customer, uint(id)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Customer not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return nil, false
+	}
+	if customer.AnonymizedAt != nil && c.Request.Method != http.MethodGet {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Customer is anonymized"})
+		return nil, false
+	}
+	return &customer, true
+}
+
+// customerInScope checks a customer, deleted ones included, is in the user's
+// data scope, writing a not found response when it isn't
+func (h *CustomerHandler) customerInScope(c *gin.Context, id uint) bool {
+	inScope, err := h.repo.CustomerInScope(dataScope(c), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return false
+	}
+	if !inScope {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Customer not found"})
+		return false
+	}
+	return true
+}
+
+// ExportCustomerData godoc
+// @Summary Export a customer's personal data (KVKK)
+// @Description Everything held on the customer as JSON, for a data subject access request: the customer, consents, quotes and offers, policies, payments and the activity timeline. Deleted customers and records are included.
+// @Tags customers
+// @Produce json
+// @Param id path int true "Customer ID"
+// @Success 200 {object} repo.CustomerDataExport
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /customers/{id}/kvkk-export [get]
+func (h *CustomerHandler) ExportCustomerData(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid customer ID"})
+		return
+	}
+	if !h.customerInScope(c, uint(id)) {
+		return
+	}
+
+	export, err := h.repo.ExportCustomerData(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Customer not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	// Log audit
+	recordAudit(c, c.GetUint("user_id"), "customer_data_exported", "customer", &export.Customer.ID, map[string]interface{}{
+		"quotes":   len(export.Quotes),
+		"policies": len(export.Policies),
+		"payments": len(export.Payments),
+	})
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="kvkk-customer-%d.json"`, export.Customer.ID))
+	c.JSON(http.StatusOK, export)
+}
+
+// AnonymizeCustomer godoc
+// @Summary Anonymize a customer (KVKK)
+// @Description Irreversibly replace the customer's personal data, for a data subject erasure request. Policies and payments are kept for reporting, plates and notes are removed from quotes and consents are revoked. Customers with an active policy can't be anonymized. Deleted customers can.
+// @Tags customers
+// @Produce json
+// @Param id path int true "Customer ID"
+// @Success 200 {object} AnonymizeCustomerResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /customers/{id}/anonymize [post]
+func (h *CustomerHandler) AnonymizeCustomer(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid customer ID"})
+		return
+	}
+	if !h.customerInScope(c, uint(id)) {
+		return
+	}
+
+	customer, anonymization, err := h.repo.AnonymizeCustomer(uint(id))
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Customer not found"})
+		return
+	case errors.Is(err, repo.ErrCustomerHasActivePolicies):
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Customer has active policies and can't be anonymized until they end or are cancelled"})
+		return
+	case errors.Is(err, repo.ErrCustomerAnonymized):
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Customer is already anonymized"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to anonymize customer"})
+		return
+	}
+
+	// Log audit. The personal data is gone, so none of it goes in here.
+	recordAudit(c, c.GetUint("user_id"), "customer_anonymized", "customer", &customer.ID, map[string]interface{}{
+		"quotes":   anonymization.Quotes,
+		"consents": anonymization.Consents,
+	})
+
+	c.JSON(http.StatusOK, AnonymizeCustomerResponse{
+		Customer:   h.customerToResponse(customer),
+		Anonymized: *anonymization,
+	})
+}
@@ -1,11 +1,15 @@
 package api
 
 import (
+	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"eesigorta/backend/internal/auth"
 	"eesigorta/backend/internal/rbac"
+	"eesigorta/backend/internal/repo"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,6 +18,13 @@ type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
+// ValidationErrorResponse is an ErrorResponse that also says what's wrong
+// with each invalid field, keyed by the field's JSON name
+type ValidationErrorResponse struct {
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields"`
+}
+
 type SuccessResponse struct {
 	Message string `json:"message"`
 }
@@ -26,8 +37,13 @@ type PaginationResponse struct {
 	TotalPages int         `json:"total_pages"`
 }
 
-// AuthMiddleware validates JWT token and sets user context
-func AuthMiddleware(jwtMgr *auth.JWTManager) gin.HandlerFunc {
+// sessionTouchInterval limits how often a request updates its session's
+// last-seen time
+const sessionTouchInterval = time.Minute
+
+// AuthMiddleware validates JWT token and sets user context. Tokens of a
+// revoked or expired session are rejected even if the JWT itself is valid.
+func AuthMiddleware(jwtMgr *auth.JWTManager, repository *repo.Repository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.GetHeader("Authorization")
 		if token == "" {
@@ -42,22 +58,71 @@ func AuthMiddleware(jwtMgr *auth.JWTManager) gin.HandlerFunc {
 		}
 
 		claims, err := jwtMgr.ValidateToken(token)
+		if err == nil && (claims.TokenType != auth.TokenTypeAccess || claims.FamilyID == "") {
+			err = auth.ErrInvalidToken
+		}
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid token"})
 			c.Abort()
 			return
 		}
 
+		session, err := repository.GetSessionByFamilyID(claims.FamilyID)
+		if err != nil || session.RevokedAt != nil || session.ExpiresAt.Before(time.Now()) {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Session has been revoked"})
+			c.Abort()
+			return
+		}
+
+		if time.Since(session.LastSeenAt) > sessionTouchInterval {
+			repository.TouchSession(session.FamilyID, map[string]interface{}{"ip": c.ClientIP()})
+		}
+
 		// Set user context
 		c.Set("user_id", claims.UserID)
 		c.Set("user_email", claims.Email)
 		c.Set("user_role", claims.Role)
+		c.Set("session_id", session.ID)
+
+		c.Next()
+	}
+}
+
+// PasswordChangeMiddleware confines users whose password was reset or has
+// expired to the routes needed to set a new one
+func PasswordChangeMiddleware(repository *repo.Repository, allowedPaths ...string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(allowedPaths))
+	for _, path := range allowedPaths {
+		allowed[path] = true
+	}
+
+	return func(c *gin.Context) {
+		if allowed[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		var user repo.User
+		err := repository.DB().Select("id", "must_change_password").First(&user, c.GetUint("user_id")).Error
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "User not found"})
+			c.Abort()
+			return
+		}
+
+		if user.MustChangePassword {
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: "Password change required"})
+			c.Abort()
+			return
+		}
 
 		c.Next()
 	}
 }
 
-// RBACMiddleware checks if user has required permission
+// RBACMiddleware checks if user has required permission, outright or through
+// grants limited to a branch or an agent user. It narrows the data scope
+// DataScopeMiddleware set to what the permission reaches.
 func RBACMiddleware(rbacMgr *rbac.RBACManager, permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, exists := c.Get("user_id")
@@ -74,32 +139,125 @@ func RBACMiddleware(rbacMgr *rbac.RBACManager, permission string) gin.HandlerFun
 			return
 		}
 
-		if !hasPermission {
+		scope := dataScope(c)
+		if _, delegated := scope.Delegations[permission]; !hasPermission && !delegated {
 			c.JSON(http.StatusForbidden, ErrorResponse{Error: "Insufficient permissions"})
 			c.Abort()
 			return
 		}
 
+		c.Set("data_scope", scope.ForPermission(permission, hasPermission))
 		c.Next()
 	}
 }
 
-// RateLimitMiddleware implements basic rate limiting
-func RateLimitMiddleware() gin.HandlerFunc {
-	// Simple in-memory rate limiter for demo
-	// In production, use Redis-based rate limiting
+// DataScopeMiddleware loads which branch's or whose records the user may
+// see, for handlers to pass on to the scoped repository queries
+func DataScopeMiddleware(repository *repo.Repository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// For now, just pass through
+		scope, err := repository.GetDataScope(c.GetUint("user_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to load data scope"})
+			c.Abort()
+			return
+		}
+
+		c.Set("data_scope", scope)
+		c.Next()
+	}
+}
+
+// dataScope is the scope set by DataScopeMiddleware. Without it nothing is
+// visible.
+func dataScope(c *gin.Context) repo.DataScope {
+	scope, _ := c.Get("data_scope")
+	s, _ := scope.(repo.DataScope)
+	return s
+}
+
+// RateLimitMiddleware allows each client IP at most limit requests per
+// window. scope keeps the counts of differently limited route groups apart.
+func RateLimitMiddleware(counter auth.AttemptCounter, scope string, limit int, window time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "ratelimit:" + scope + ":" + c.ClientIP()
+		count, err := counter.Incr(c.Request.Context(), key, window)
+		if err != nil {
+			// Don't take the API down with the counter store
+			c.Next()
+			return
+		}
+
+		if count > int64(limit) {
+			c.Header("Retry-After", strconv.Itoa(int(window.Seconds())))
+			c.JSON(http.StatusTooManyRequests, ErrorResponse{Error: "Rate limit exceeded"})
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
 
-// AuditMiddleware logs API requests
-func AuditMiddleware() gin.HandlerFunc {
+// auditedMethods are the methods that change data. Every request with one
+// of them is audited, even if it failed.
+var auditedMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// AuditMiddleware stores the audit events handlers recorded with
+// recordAudit, along with the request's route, status code and latency. A
+// data-changing request that recorded nothing still gets an entry naming its
+// method and route.
+func AuditMiddleware(repository *repo.Repository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Log request
+		start := time.Now()
 		c.Next()
-		// Log response
+
+		events := auditEvents(c)
+		if len(events) == 0 {
+			if !auditedMethods[c.Request.Method] || c.FullPath() == "" {
+				return
+			}
+			events = []auditEvent{requestAuditEvent(c)}
+		}
+
+		latency := time.Since(start).Milliseconds()
+		logs := make([]repo.AuditLog, 0, len(events))
+		for _, event := range events {
+			auditLog := repo.AuditLog{
+				UserID:     event.UserID,
+				Action:     event.Action,
+				Entity:     event.Entity,
+				EntityID:   event.EntityID,
+				Method:     c.Request.Method,
+				Route:      c.FullPath(),
+				StatusCode: c.Writer.Status(),
+				LatencyMS:  latency,
+				IP:         c.ClientIP(),
+				UserAgent:  c.GetHeader("User-Agent"),
+				MetaJSON:   "{}",
+			}
+			if event.Meta != nil {
+				if meta, err := json.Marshal(event.Meta); err == nil {
+					auditLog.MetaJSON = string(meta)
+				}
+			}
+			if len(event.Changes) > 0 {
+				if changes, err := json.Marshal(event.Changes); err == nil {
+					changesJSON := string(changes)
+					auditLog.ChangesJSON = &changesJSON
+				}
+			}
+			logs = append(logs, auditLog)
+		}
+
+		// The response is already written, so a failure can only be logged
+		if err := repository.CreateAuditLogs(logs); err != nil {
+			log.Printf("Failed to write %d audit log entries for %s %s: %v", len(logs), c.Request.Method, c.FullPath(), err)
+		}
 	}
 }
 
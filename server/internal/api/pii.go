@@ -0,0 +1,131 @@
+package api
+
+import (
+	"log"
+	"strings"
+
+	"eesigorta/backend/internal/rbac"
+	"eesigorta/backend/internal/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+// showPII reports whether the current user may see customers' personal data
+// unmasked. A failed check masks.
+func showPII(c *gin.Context, rbacMgr *rbac.RBACManager) bool {
+	allowed, err := rbacMgr.HasPermission(c.GetUint("user_id"), rbac.PermissionCustomerReadPII)
+	if err != nil {
+		log.Printf("Failed to check PII permission, masking: %v", err)
+		return false
+	}
+	return allowed
+}
+
+// protectPII masks the personal data of customers about to be returned,
+// unless the user may read it; then the unmasked read is audited instead,
+// as one entry listing the customers shown. The entry names the customer
+// as its entity when there's only one. Customers that weren't loaded (ID 0)
+// are skipped.
+func protectPII(c *gin.Context, rbacMgr *rbac.RBACManager, customers ...*repo.Customer) {
+	if !showPII(c, rbacMgr) {
+		for _, customer := range customers {
+			maskCustomer(customer)
+		}
+		return
+	}
+
+	ids := make([]uint, 0, len(customers))
+	seen := make(map[uint]bool, len(customers))
+	for _, customer := range customers {
+		if customer.ID == 0 || seen[customer.ID] {
+			continue
+		}
+		seen[customer.ID] = true
+		ids = append(ids, customer.ID)
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	var entityID *uint
+	if len(ids) == 1 {
+		entityID = &ids[0]
+	}
+	recordAudit(c, c.GetUint("user_id"), "customer_pii_viewed", "customer", entityID, map[string]interface{}{
+		"route":        c.Request.Method + " " + c.FullPath(),
+		"customer_ids": ids,
+	})
+}
+
+// maskCustomer hides a customer's personal data in place. Birth dates are
+// dropped entirely.
+func maskCustomer(customer *repo.Customer) {
+	customer.TCVKN = maskTCVKN(customer.TCVKN)
+	customer.Email = maskEmail(customer.Email)
+	customer.Phone = maskPhone(customer.Phone)
+	if customer.Address != "" {
+		customer.Address = "***"
+	}
+	customer.BirthDate = nil
+}
+
+// keepMaskedPII fills in the stored personal data a user who only sees it
+// masked left out of an update or sent back as they were shown it, so
+// editing other fields doesn't overwrite the data with masks or blanks
+func keepMaskedPII(req *CustomerRequest, stored *repo.Customer) {
+	keep := func(value *string, storedValue, masked string) {
+		if *value == "" || *value == masked {
+			*value = storedValue
+		}
+	}
+	shown := *stored
+	maskCustomer(&shown)
+	keep(&req.TCVKN, stored.TCVKN, shown.TCVKN)
+	keep(&req.Email, stored.Email, shown.Email)
+	keep(&req.Phone, stored.Phone, shown.Phone)
+	keep(&req.Address, stored.Address, shown.Address)
+	if req.BirthDate == "" {
+		req.BirthDate = formatDate(stored.BirthDate)
+	}
+}
+
+// maskTCVKN keeps the first three and last two digits, e.g. 123******90
+func maskTCVKN(value string) string {
+	if len(value) <= 5 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:3] + strings.Repeat("*", len(value)-5) + value[len(value)-2:]
+}
+
+// maskEmail keeps the first letter and the domain, e.g. a*******@email.com
+func maskEmail(value string) string {
+	at := strings.LastIndex(value, "@")
+	if at < 1 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:1] + strings.Repeat("*", at-1) + value[at:]
+}
+
+// maskPhone keeps the layout, the first digit and the last two digits, e.g.
+// 0*** *** ** 33
+func maskPhone(value string) string {
+	digits := 0
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+
+	var masked strings.Builder
+	seen := 0
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			seen++
+			if seen > 1 && seen <= digits-2 {
+				r = '*'
+			}
+		}
+		masked.WriteRune(r)
+	}
+	return masked.String()
+}
@@ -5,17 +5,19 @@ import (
 	"strconv"
 	"time"
 
+	"eesigorta/backend/internal/rbac"
 	"eesigorta/backend/internal/repo"
 
 	"github.com/gin-gonic/gin"
 )
 
 type PolicyHandler struct {
-	repo *repo.Repository
+	repo    *repo.Repository
+	rbacMgr *rbac.RBACManager
 }
 
-func NewPolicyHandler(repo *repo.Repository) *PolicyHandler {
-	return &PolicyHandler{repo: repo}
+func NewPolicyHandler(repo *repo.Repository, rbacMgr *rbac.RBACManager) *PolicyHandler {
+	return &PolicyHandler{repo: repo, rbacMgr: rbacMgr}
 }
 
 type PolicyResponse struct {
@@ -91,7 +93,7 @@ func (h *PolicyHandler) GetPolicies(c *gin.Context) {
 	var policies []repo.Policy
 	var total int64
 
-	db := h.repo.DB().Model(&repo.Policy{})
+	db := h.repo.ScopedPolicies(dataScope(c))
 
 	// Apply search filter
 	if query != "" {
@@ -119,13 +121,19 @@ func (h *PolicyHandler) GetPolicies(c *gin.Context) {
 
 	// Apply pagination
 	offset := (page - 1) * pageSize
-	err := db.Preload("Customer").Preload("Product").Preload("Agent").Preload("Quote").
+	err := db.Preload("Customer").Preload("Product").Preload("Agent.Role").Preload("Quote").
 		Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&policies).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
 		return
 	}
 
+	customers := make([]*repo.Customer, 0, len(policies))
+	for i := range policies {
+		customers = append(customers, &policies[i].Customer)
+	}
+	protectPII(c, h.rbacMgr, customers...)
+
 	// Convert to response
 	var response []PolicyResponse
 	for _, policy := range policies {
@@ -157,13 +165,15 @@ func (h *PolicyHandler) GetPolicy(c *gin.Context) {
 	}
 
 	var policy repo.Policy
-	err = h.repo.DB().Preload("Customer").Preload("Product").Preload("Agent").Preload("Quote").
+	err = h.repo.ScopedPolicies(dataScope(c)).Preload("Customer").Preload("Product").Preload("Agent.Role").Preload("Quote").
 		First(&policy, uint(id)).Error
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Policy not found"})
 		return
 	}
 
+	protectPII(c, h.rbacMgr, &policy.Customer)
+
 	c.JSON(http.StatusOK, h.policyToResponse(&policy))
 }
 
@@ -192,11 +202,15 @@ func (h *PolicyHandler) CreatePolicy(c *gin.Context) {
 
 	// Check if customer exists
 	var customer repo.Customer
-	err = h.repo.DB().First(&customer, req.CustomerID).Error
+	err = h.repo.ScopedCustomers(dataScope(c)).First(&customer, req.CustomerID).Error
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Customer not found"})
 		return
 	}
+	if customer.AnonymizedAt != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Customer is anonymized"})
+		return
+	}
 
 	// Check if product exists
 	var product repo.Product
@@ -213,6 +227,9 @@ func (h *PolicyHandler) CreatePolicy(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Agent not found"})
 		return
 	}
+	if !h.agentInScope(c, agent.ID) {
+		return
+	}
 
 	// Generate policy number
 	policyNumber := h.generatePolicyNumber()
@@ -237,8 +254,11 @@ func (h *PolicyHandler) CreatePolicy(c *gin.Context) {
 	}
 
 	// Reload with relations
-	h.repo.DB().Preload("Customer").Preload("Product").Preload("Agent").Preload("Quote").
+	h.repo.DB().Preload("Customer").Preload("Product").Preload("Agent.Role").Preload("Quote").
 		First(policy, policy.ID)
+	if !showPII(c, h.rbacMgr) {
+		maskCustomer(&policy.Customer)
+	}
 
 	c.JSON(http.StatusCreated, h.policyToResponse(policy))
 }
@@ -258,21 +278,26 @@ func (h *PolicyHandler) UpdatePolicy(c *gin.Context) {
 	}
 
 	var policy repo.Policy
-	err = h.repo.DB().First(&policy, uint(id)).Error
+	err = h.repo.ScopedPolicies(dataScope(c)).First(&policy, uint(id)).Error
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Policy not found"})
 		return
 	}
+	before := policy
 
 	// Update fields
 	if req.CustomerID != nil {
 		// Check if customer exists
 		var customer repo.Customer
-		err = h.repo.DB().First(&customer, *req.CustomerID).Error
+		err = h.repo.ScopedCustomers(dataScope(c)).First(&customer, *req.CustomerID).Error
 		if err != nil {
 			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Customer not found"})
 			return
 		}
+		if customer.AnonymizedAt != nil && customer.ID != policy.CustomerID {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Customer is anonymized"})
+			return
+		}
 		policy.CustomerID = *req.CustomerID
 	}
 	if req.ProductID != nil {
@@ -293,6 +318,9 @@ func (h *PolicyHandler) UpdatePolicy(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Agent not found"})
 			return
 		}
+		if !h.agentInScope(c, agent.ID) {
+			return
+		}
 		policy.AgentID = *req.AgentID
 	}
 	if req.QuoteID != nil {
@@ -320,9 +348,17 @@ func (h *PolicyHandler) UpdatePolicy(c *gin.Context) {
 		return
 	}
 
+	// Log audit
+	recordUpdate(c, c.GetUint("user_id"), "policy_updated", "policy", &policy.ID, before, policy, map[string]interface{}{
+		"policy_number": policy.PolicyNumber,
+	})
+
 	// Reload with relations
-	h.repo.DB().Preload("Customer").Preload("Product").Preload("Agent").Preload("Quote").
+	h.repo.DB().Preload("Customer").Preload("Product").Preload("Agent.Role").Preload("Quote").
 		First(&policy, policy.ID)
+	if !showPII(c, h.rbacMgr) {
+		maskCustomer(&policy.Customer)
+	}
 
 	c.JSON(http.StatusOK, h.policyToResponse(&policy))
 }
@@ -335,15 +371,34 @@ func (h *PolicyHandler) DeletePolicy(c *gin.Context) {
 		return
 	}
 
-	err = h.repo.DB().Delete(&repo.Policy{}, uint(id)).Error
-	if err != nil {
+	result := h.repo.ScopedPolicies(dataScope(c)).Where("id = ?", uint(id)).Delete(&repo.Policy{})
+	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete policy"})
 		return
 	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Policy not found"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Policy deleted successfully"})
 }
 
+// agentInScope stops users from handing policies to agents outside their
+// own data scope, writing the error response itself
+func (h *PolicyHandler) agentInScope(c *gin.Context, agentID uint) bool {
+	inScope, err := h.repo.UserInScope(dataScope(c), agentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return false
+	}
+	if !inScope {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Agent is outside your data scope"})
+		return false
+	}
+	return true
+}
+
 func (h *PolicyHandler) generatePolicyNumber() string {
 	// Generate a unique policy number
 	// Format: POL-YYYY-NNNNNN
@@ -408,7 +463,7 @@ func (h *PolicyHandler) policyToResponse(policy *repo.Policy) PolicyResponse {
 		}{
 			ID:    policy.Agent.ID,
 			Email: policy.Agent.Email,
-			Role:  policy.Agent.Role,
+			Role:  policy.Agent.RoleName(),
 		}
 	}
 
@@ -1,20 +1,25 @@
 package api
 
 import (
+	"log"
 	"net/http"
 	"strconv"
 
+	"eesigorta/backend/internal/jobs"
+	"eesigorta/backend/internal/rbac"
 	"eesigorta/backend/internal/repo"
 
 	"github.com/gin-gonic/gin"
 )
 
 type QuoteHandler struct {
-	repo *repo.Repository
+	repo    *repo.Repository
+	jobs    *jobs.Client
+	rbacMgr *rbac.RBACManager
 }
 
-func NewQuoteHandler(repo *repo.Repository) *QuoteHandler {
-	return &QuoteHandler{repo: repo}
+func NewQuoteHandler(repo *repo.Repository, jobs *jobs.Client, rbacMgr *rbac.RBACManager) *QuoteHandler {
+	return &QuoteHandler{repo: repo, jobs: jobs, rbacMgr: rbacMgr}
 }
 
 type QuoteRequest struct {
@@ -59,12 +64,18 @@ func (h *QuoteHandler) GetQuotes(c *gin.Context) {
 	page, _ := c.Get("page")
 	pageSize, _ := c.Get("page_size")
 
-	quotes, total, err := h.repo.GetQuotes(page.(int), pageSize.(int))
+	quotes, total, err := h.repo.GetQuotes(dataScope(c), page.(int), pageSize.(int))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 		return
 	}
 
+	customers := make([]*repo.Customer, 0, len(quotes))
+	for i := range quotes {
+		customers = append(customers, &quotes[i].Customer)
+	}
+	protectPII(c, h.rbacMgr, customers...)
+
 	totalPages := int(total) / pageSize.(int)
 	if int(total)%pageSize.(int) > 0 {
 		totalPages++
@@ -96,12 +107,14 @@ func (h *QuoteHandler) GetQuote(c *gin.Context) {
 		return
 	}
 
-	quote, err := h.repo.GetQuoteByID(uint(id))
+	quote, err := h.repo.GetQuoteByID(dataScope(c), uint(id))
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Quote not found"})
 		return
 	}
 
+	protectPII(c, h.rbacMgr, &quote.Customer)
+
 	c.JSON(http.StatusOK, quote)
 }
 
@@ -114,6 +127,7 @@ func (h *QuoteHandler) GetQuote(c *gin.Context) {
 // @Security BearerAuth
 // @Param quote body QuoteRequest true "Quote data"
 // @Success 201 {object} repo.Quote
+// @Failure 400 {object} ErrorResponse
 // @Router /quotes [post]
 func (h *QuoteHandler) CreateQuote(c *gin.Context) {
 	var req QuoteRequest
@@ -122,6 +136,17 @@ func (h *QuoteHandler) CreateQuote(c *gin.Context) {
 		return
 	}
 
+	// Quotes can only be asked for customers in the user's data scope
+	var customer repo.Customer
+	if err := h.repo.ScopedCustomers(dataScope(c)).First(&customer, req.CustomerID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Customer not found"})
+		return
+	}
+	if customer.AnonymizedAt != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Customer is anonymized"})
+		return
+	}
+
 	userID, _ := c.Get("user_id")
 
 	quote := &repo.Quote{
@@ -144,8 +169,10 @@ func (h *QuoteHandler) CreateQuote(c *gin.Context) {
 		return
 	}
 
-	// TODO: Trigger async scraping job here
-	// asynqClient.Enqueue(tasks.NewScrapeQuoteTask(quote.ID))
+	// Scraping runs in the worker; the quote stays pending until it is picked up
+	if err := h.jobs.EnqueueScrapeQuote(quote.ID); err != nil {
+		log.Printf("Failed to enqueue scrape job for quote %d: %v", quote.ID, err)
+	}
 
 	c.JSON(http.StatusCreated, quote)
 }
@@ -167,7 +194,7 @@ func (h *QuoteHandler) GetQuoteComparison(c *gin.Context) {
 		return
 	}
 
-	scrapedQuotes, err := h.repo.GetScrapedQuotesByQuoteID(uint(id))
+	scrapedQuotes, err := h.repo.GetScrapedQuotesByQuoteID(dataScope(c), uint(id))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 		return
@@ -201,7 +228,7 @@ func (h *QuoteHandler) ApproveQuote(c *gin.Context) {
 	}
 
 	// Get quote
-	quote, err := h.repo.GetQuoteByID(uint(quoteID))
+	quote, err := h.repo.GetQuoteByID(dataScope(c), uint(quoteID))
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Quote not found"})
 		return
@@ -209,7 +236,7 @@ func (h *QuoteHandler) ApproveQuote(c *gin.Context) {
 
 	// Get scraped quote
 	scrapedQuote, err := h.repo.GetScrapedQuoteByID(uint(scrapedQuoteID))
-	if err != nil {
+	if err != nil || scrapedQuote.QuoteID != quote.ID {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Scraped quote not found"})
 		return
 	}
@@ -237,6 +264,14 @@ func (h *QuoteHandler) ApproveQuote(c *gin.Context) {
 	quote.Status = "approved"
 	h.repo.UpdateQuote(quote)
 
+	// Log audit
+	recordAudit(c, c.GetUint("user_id"), "quote_approved", "quote", &quote.ID, map[string]interface{}{
+		"scraped_quote_id": scrapedQuote.ID,
+		"policy_id":        policy.ID,
+		"company_name":     scrapedQuote.CompanyName,
+		"final_price":      scrapedQuote.FinalPrice,
+	})
+
 	c.JSON(http.StatusOK, policy)
 }
 
@@ -266,7 +301,7 @@ func (h *QuoteHandler) GetScrapedQuotes(c *gin.Context) {
 		return
 	}
 
-	scrapedQuotes, err := h.repo.GetScrapedQuotesByQuoteID(uint(quoteID))
+	scrapedQuotes, err := h.repo.GetScrapedQuotesByQuoteID(dataScope(c), uint(quoteID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch scraped quotes"})
 		return
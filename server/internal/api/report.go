@@ -4,17 +4,19 @@ import (
 	"net/http"
 	"time"
 
+	"eesigorta/backend/internal/rbac"
 	"eesigorta/backend/internal/repo"
 
 	"github.com/gin-gonic/gin"
 )
 
 type ReportHandler struct {
-	repo *repo.Repository
+	repo    *repo.Repository
+	rbacMgr *rbac.RBACManager
 }
 
-func NewReportHandler(repo *repo.Repository) *ReportHandler {
-	return &ReportHandler{repo: repo}
+func NewReportHandler(repo *repo.Repository, rbacMgr *rbac.RBACManager) *ReportHandler {
+	return &ReportHandler{repo: repo, rbacMgr: rbacMgr}
 }
 
 type DashboardStats struct {
@@ -51,38 +53,39 @@ type ReportRequest struct {
 
 func (h *ReportHandler) GetDashboardStats(c *gin.Context) {
 	var stats DashboardStats
+	scope := dataScope(c)
 
 	// Total customers
-	h.repo.DB().Model(&repo.Customer{}).Count(&stats.TotalCustomers)
+	h.repo.ScopedCustomers(scope).Count(&stats.TotalCustomers)
 
 	// Total policies
-	h.repo.DB().Model(&repo.Policy{}).Count(&stats.TotalPolicies)
+	h.repo.ScopedPolicies(scope).Count(&stats.TotalPolicies)
 
 	// Total quotes
-	h.repo.DB().Model(&repo.Quote{}).Count(&stats.TotalQuotes)
+	h.repo.ScopedQuotes(scope).Count(&stats.TotalQuotes)
 
 	// Total premium
-	h.repo.DB().Model(&repo.Policy{}).Select("COALESCE(SUM(premium), 0)").Scan(&stats.TotalPremium)
+	h.repo.ScopedPolicies(scope).Select("COALESCE(SUM(premium), 0)").Scan(&stats.TotalPremium)
 
 	// Active policies
-	h.repo.DB().Model(&repo.Policy{}).Where("status = ?", "active").Count(&stats.ActivePolicies)
+	h.repo.ScopedPolicies(scope).Where("status = ?", "active").Count(&stats.ActivePolicies)
 
 	// Expired policies
-	h.repo.DB().Model(&repo.Policy{}).Where("status = ?", "expired").Count(&stats.ExpiredPolicies)
+	h.repo.ScopedPolicies(scope).Where("status = ?", "expired").Count(&stats.ExpiredPolicies)
 
 	// Cancelled policies
-	h.repo.DB().Model(&repo.Policy{}).Where("status = ?", "cancelled").Count(&stats.CancelledPolicies)
+	h.repo.ScopedPolicies(scope).Where("status = ?", "cancelled").Count(&stats.CancelledPolicies)
 
 	// Monthly premium (current month)
 	now := time.Now()
 	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
-	h.repo.DB().Model(&repo.Policy{}).
+	h.repo.ScopedPolicies(scope).
 		Where("created_at >= ? AND status = ?", startOfMonth, "active").
 		Select("COALESCE(SUM(premium), 0)").Scan(&stats.MonthlyPremium)
 
 	// Yearly premium (current year)
 	startOfYear := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
-	h.repo.DB().Model(&repo.Policy{}).
+	h.repo.ScopedPolicies(scope).
 		Where("created_at >= ? AND status = ?", startOfYear, "active").
 		Select("COALESCE(SUM(premium), 0)").Scan(&stats.YearlyPremium)
 
@@ -93,7 +96,7 @@ func (h *ReportHandler) GetPolicyStats(c *gin.Context) {
 	var stats []PolicyStats
 
 	// Get policy counts by status
-	h.repo.DB().Model(&repo.Policy{}).
+	h.repo.ScopedPolicies(dataScope(c)).
 		Select("status, COUNT(*) as count, COALESCE(SUM(premium), 0) as amount").
 		Group("status").
 		Scan(&stats)
@@ -105,7 +108,7 @@ func (h *ReportHandler) GetMonthlyStats(c *gin.Context) {
 	var stats []MonthlyStats
 
 	// Get monthly policy counts for the last 12 months
-	h.repo.DB().Model(&repo.Policy{}).
+	h.repo.ScopedPolicies(dataScope(c)).
 		Select("TO_CHAR(created_at, 'YYYY-MM') as month, COUNT(*) as count, COALESCE(SUM(premium), 0) as amount").
 		Where("created_at >= ?", time.Now().AddDate(0, -12, 0)).
 		Group("TO_CHAR(created_at, 'YYYY-MM')").
@@ -123,10 +126,11 @@ func (h *ReportHandler) GetBranchStats(c *gin.Context) {
 		TotalPremium float64 `json:"total_premium"`
 	}
 
-	h.repo.DB().Table("policies").
+	// A policy's agent is a user, who belongs to a branch
+	h.repo.ScopedPolicies(dataScope(c)).
 		Select("branches.id as branch_id, branches.name as branch_name, COUNT(policies.id) as policy_count, COALESCE(SUM(policies.premium), 0) as total_premium").
-		Joins("JOIN agents ON policies.agent_id = agents.id").
-		Joins("JOIN branches ON agents.branch_id = branches.id").
+		Joins("JOIN users ON policies.agent_id = users.id").
+		Joins("JOIN branches ON users.branch_id = branches.id").
 		Group("branches.id, branches.name").
 		Scan(&stats)
 
@@ -142,11 +146,13 @@ func (h *ReportHandler) GetAgentStats(c *gin.Context) {
 		TotalPremium float64 `json:"total_premium"`
 	}
 
-	h.repo.DB().Table("policies").
-		Select("agents.id as agent_id, agents.name as agent_name, branches.name as branch_name, COUNT(policies.id) as policy_count, COALESCE(SUM(policies.premium), 0) as total_premium").
-		Joins("JOIN agents ON policies.agent_id = agents.id").
-		Joins("JOIN branches ON agents.branch_id = branches.id").
-		Group("agents.id, agents.name, branches.name").
+	// Named after the user's agent record, falling back to their email
+	h.repo.ScopedPolicies(dataScope(c)).
+		Select("users.id as agent_id, COALESCE(agents.name, users.email) as agent_name, COALESCE(branches.name, '') as branch_name, COUNT(policies.id) as policy_count, COALESCE(SUM(policies.premium), 0) as total_premium").
+		Joins("JOIN users ON policies.agent_id = users.id").
+		Joins("LEFT JOIN agents ON users.agent_id = agents.id").
+		Joins("LEFT JOIN branches ON users.branch_id = branches.id").
+		Group("users.id, agents.name, users.email, branches.name").
 		Scan(&stats)
 
 	c.JSON(http.StatusOK, stats)
@@ -160,8 +166,8 @@ func (h *ReportHandler) ExportPolicies(c *gin.Context) {
 	}
 
 	// Build query
-	db := h.repo.DB().Model(&repo.Policy{}).
-		Preload("Customer").Preload("Product").Preload("Agent")
+	db := h.repo.ScopedPolicies(dataScope(c)).
+		Preload("Customer").Preload("Product").Preload("Agent.Role")
 
 	// Apply date filters
 	if req.StartDate != "" {
@@ -177,8 +183,8 @@ func (h *ReportHandler) ExportPolicies(c *gin.Context) {
 
 	// Apply branch filter
 	if req.BranchID != nil {
-		db = db.Joins("JOIN agents ON policies.agent_id = agents.id").
-			Where("agents.branch_id = ?", *req.BranchID)
+		db = db.Where("policies.agent_id IN (?)",
+			h.repo.DB().Unscoped().Model(&repo.User{}).Select("id").Where("branch_id = ?", *req.BranchID))
 	}
 
 	// Apply agent filter
@@ -193,6 +199,12 @@ func (h *ReportHandler) ExportPolicies(c *gin.Context) {
 		return
 	}
 
+	customers := make([]*repo.Customer, 0, len(policies))
+	for i := range policies {
+		customers = append(customers, &policies[i].Customer)
+	}
+	protectPII(c, h.rbacMgr, customers...)
+
 	// Convert to response format
 	var response []PolicyResponse
 	for _, policy := range policies {
@@ -224,7 +236,7 @@ func (h *ReportHandler) ExportCustomers(c *gin.Context) {
 	}
 
 	// Build query
-	db := h.repo.DB().Model(&repo.Customer{})
+	db := h.repo.ScopedCustomers(dataScope(c))
 
 	// Apply date filters
 	if req.StartDate != "" {
@@ -245,6 +257,12 @@ func (h *ReportHandler) ExportCustomers(c *gin.Context) {
 		return
 	}
 
+	masked := make([]*repo.Customer, 0, len(customers))
+	for i := range customers {
+		masked = append(masked, &customers[i])
+	}
+	protectPII(c, h.rbacMgr, masked...)
+
 	// Convert to response format
 	var response []CustomerResponse
 	for _, customer := range customers {
@@ -321,7 +339,7 @@ func (h *ReportHandler) policyToResponse(policy *repo.Policy) PolicyResponse {
 		}{
 			ID:    policy.Agent.ID,
 			Email: policy.Agent.Email,
-			Role:  policy.Agent.Role,
+			Role:  policy.Agent.RoleName(),
 		}
 	}
 
@@ -330,18 +348,23 @@ func (h *ReportHandler) policyToResponse(policy *repo.Policy) PolicyResponse {
 
 func (h *ReportHandler) customerToResponse(customer *repo.Customer) CustomerResponse {
 	return CustomerResponse{
-		ID:         customer.ID,
-		TCVKN:      customer.TCVKN,
-		Name:       customer.Name,
-		Email:      customer.Email,
-		Phone:      customer.Phone,
-		Address:    customer.Address,
-		City:       customer.City,
-		District:   customer.District,
-		PostalCode: customer.PostalCode,
-		BirthDate:  customer.BirthDate.Format("2006-01-02"),
-		Gender:     customer.Gender,
-		CreatedAt:  customer.CreatedAt.Format("2006-01-02 15:04:05"),
-		UpdatedAt:  customer.UpdatedAt.Format("2006-01-02 15:04:05"),
+		ID:               customer.ID,
+		TCVKN:            customer.TCVKN,
+		CustomerType:     customer.CustomerType,
+		Name:             customer.Name,
+		Email:            customer.Email,
+		Phone:            customer.Phone,
+		Address:          customer.Address,
+		City:             customer.City,
+		District:         customer.District,
+		PostalCode:       customer.PostalCode,
+		BirthDate:        formatDate(customer.BirthDate),
+		Gender:           customer.Gender,
+		TaxOffice:        customer.TaxOffice,
+		TradeTitle:       customer.TradeTitle,
+		AuthorizedPerson: customer.AuthorizedPerson,
+		AnonymizedAt:     formatDate(customer.AnonymizedAt),
+		CreatedAt:        customer.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt:        customer.UpdatedAt.Format("2006-01-02 15:04:05"),
 	}
 }
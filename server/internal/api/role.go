@@ -0,0 +1,433 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"eesigorta/backend/internal/rbac"
+	"eesigorta/backend/internal/repo"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RoleHandler struct {
+	repo    *repo.Repository
+	rbacMgr *rbac.RBACManager
+}
+
+func NewRoleHandler(repo *repo.Repository, rbacMgr *rbac.RBACManager) *RoleHandler {
+	return &RoleHandler{repo: repo, rbacMgr: rbacMgr}
+}
+
+type CreateRoleRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	DataScope   string   `json:"data_scope" binding:"omitempty,oneof=all branch own"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+type UpdateRoleRequest struct {
+	Description *string  `json:"description"`
+	DataScope   *string  `json:"data_scope" binding:"omitempty,oneof=all branch own"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+type RoleResponse struct {
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	IsSystem    bool      `json:"is_system"`
+	DataScope   string    `json:"data_scope"`
+	Permissions []string  `json:"permissions"`
+	UserCount   int64     `json:"user_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// roleNamePattern keeps custom role names in the same shape as the system
+// ones, e.g. "branch_manager"
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,49}$`)
+
+// GetRoles godoc
+// @Summary List roles
+// @Description Get every role with its permissions and number of users
+// @Tags roles
+// @Produce json
+// @Success 200 {array} RoleResponse
+// @Router /roles [get]
+func (h *RoleHandler) GetRoles(c *gin.Context) {
+	roles, err := h.repo.GetRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	counts, err := h.repo.CountUsersByRole()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	response := make([]RoleResponse, 0, len(roles))
+	for i := range roles {
+		response = append(response, roleToResponse(&roles[i], counts[roles[i].ID]))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetRole godoc
+// @Summary Get role by ID
+// @Description Get a role with its permissions
+// @Tags roles
+// @Produce json
+// @Param id path int true "Role ID"
+// @Success 200 {object} RoleResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /roles/{id} [get]
+func (h *RoleHandler) GetRole(c *gin.Context) {
+	role, ok := h.findRole(c)
+	if !ok {
+		return
+	}
+
+	counts, err := h.repo.CountUsersByRole()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, roleToResponse(role, counts[role.ID]))
+}
+
+// GetPermissions godoc
+// @Summary List permissions
+// @Description Get every permission that can be granted to a role
+// @Tags roles
+// @Produce json
+// @Success 200 {array} repo.Permission
+// @Router /permissions [get]
+func (h *RoleHandler) GetPermissions(c *gin.Context) {
+	permissions, err := h.repo.GetPermissions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, permissions)
+}
+
+// CreateRole godoc
+// @Summary Create role
+// @Description Create a custom role with the given permissions
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Param request body CreateRoleRequest true "Role data"
+// @Success 201 {object} RoleResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /roles [post]
+func (h *RoleHandler) CreateRole(c *gin.Context) {
+	var req CreateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if !roleNamePattern.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Role name must be 2-50 lowercase letters, digits or underscores"})
+		return
+	}
+
+	// Deleted roles are removed for good, so any match is a live role
+	var existing repo.Role
+	err := h.repo.DB().Where("name = ?", req.Name).First(&existing).Error
+	if err == nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Role with this name already exists"})
+		return
+	}
+
+	permissions, ok := h.findPermissions(c, req.Permissions)
+	if !ok {
+		return
+	}
+
+	// Custom roles see only their own records unless told otherwise
+	if req.DataScope == "" {
+		req.DataScope = repo.ScopeOwn
+	}
+	if !handsOnAccess(c, h.rbacMgr, req.Permissions, req.DataScope) {
+		return
+	}
+
+	role := repo.Role{
+		Name:        req.Name,
+		Description: req.Description,
+		DataScope:   req.DataScope,
+	}
+	if err := h.repo.CreateRole(&role, permissions); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create role"})
+		return
+	}
+	role.Permissions = permissions
+
+	h.invalidate(c)
+
+	// Log audit
+	recordAudit(c, c.GetUint("user_id"), "role_created", "role", &role.ID, map[string]interface{}{
+		"name":        role.Name,
+		"data_scope":  role.DataScope,
+		"permissions": req.Permissions,
+	})
+
+	c.JSON(http.StatusCreated, roleToResponse(&role, 0))
+}
+
+// UpdateRole godoc
+// @Summary Update role
+// @Description Change a custom role's description and data scope and replace its permissions. System roles can't be edited.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Param id path int true "Role ID"
+// @Param request body UpdateRoleRequest true "Role data"
+// @Success 200 {object} RoleResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /roles/{id} [put]
+func (h *RoleHandler) UpdateRole(c *gin.Context) {
+	var req UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	role, ok := h.findRole(c)
+	if !ok {
+		return
+	}
+
+	if role.IsSystem {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "System roles cannot be modified"})
+		return
+	}
+
+	permissions, ok := h.findPermissions(c, req.Permissions)
+	if !ok {
+		return
+	}
+
+	scopeLevel := role.DataScope
+	if req.DataScope != nil {
+		scopeLevel = *req.DataScope
+	}
+	if !handsOnAccess(c, h.rbacMgr, req.Permissions, scopeLevel) {
+		return
+	}
+
+	before := roleToResponse(role, 0)
+	if req.Description != nil {
+		role.Description = *req.Description
+	}
+	role.DataScope = scopeLevel
+	if err := h.repo.UpdateRole(role, permissions); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update role"})
+		return
+	}
+	role.Permissions = permissions
+
+	h.invalidate(c)
+
+	// Log audit
+	recordUpdate(c, c.GetUint("user_id"), "role_updated", "role", &role.ID, before, roleToResponse(role, 0), map[string]interface{}{
+		"name": role.Name,
+	})
+
+	counts, err := h.repo.CountUsersByRole()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, roleToResponse(role, counts[role.ID]))
+}
+
+// DeleteRole godoc
+// @Summary Delete role
+// @Description Delete a custom role that no user holds. System roles can't be deleted.
+// @Tags roles
+// @Param id path int true "Role ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /roles/{id} [delete]
+func (h *RoleHandler) DeleteRole(c *gin.Context) {
+	role, ok := h.findRole(c)
+	if !ok {
+		return
+	}
+
+	if role.IsSystem {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "System roles cannot be deleted"})
+		return
+	}
+
+	// Soft-deleted users keep their role so they can be restored
+	var users int64
+	if err := h.repo.DB().Unscoped().Model(&repo.User{}).Where("role_id = ?", role.ID).Count(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+	if users > 0 {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "Role is still assigned to users"})
+		return
+	}
+
+	if err := h.repo.DeleteRole(role.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete role"})
+		return
+	}
+
+	h.invalidate(c)
+
+	// Log audit
+	recordAudit(c, c.GetUint("user_id"), "role_deleted", "role", &role.ID, map[string]interface{}{
+		"name": role.Name,
+	})
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Role deleted successfully"})
+}
+
+// findRole loads the role named by the :id path parameter, writing the
+// error response itself when it can't
+func (h *RoleHandler) findRole(c *gin.Context) (*repo.Role, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid role ID"})
+		return nil, false
+	}
+
+	role, err := h.repo.GetRoleByID(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Role not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return nil, false
+	}
+
+	return role, true
+}
+
+// findPermissions resolves permission names, rejecting the request if any
+// of them doesn't exist
+func (h *RoleHandler) findPermissions(c *gin.Context, names []string) ([]repo.Permission, bool) {
+	if len(names) == 0 {
+		return nil, true
+	}
+
+	permissions, err := h.repo.GetPermissionsByName(names)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return nil, false
+	}
+
+	found := make(map[string]bool, len(permissions))
+	for _, permission := range permissions {
+		found[permission.Name] = true
+	}
+	var unknown []string
+	for _, name := range names {
+		if !found[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Unknown permissions: " + strings.Join(unknown, ", ")})
+		return nil, false
+	}
+
+	return permissions, true
+}
+
+// invalidate drops cached role permissions on every API instance. The
+// change is already stored, so a failed broadcast only delays it on other
+// instances until their cache expires.
+func (h *RoleHandler) invalidate(c *gin.Context) {
+	if err := h.rbacMgr.Invalidate(c.Request.Context()); err != nil {
+		log.Printf("Failed to broadcast RBAC cache invalidation: %v", err)
+	}
+}
+
+func roleToResponse(role *repo.Role, userCount int64) RoleResponse {
+	return RoleResponse{
+		ID:          role.ID,
+		Name:        role.Name,
+		Description: role.Description,
+		IsSystem:    role.IsSystem,
+		DataScope:   role.DataScope,
+		Permissions: permissionNames(role.Permissions),
+		UserCount:   userCount,
+		CreatedAt:   role.CreatedAt,
+	}
+}
+
+// scopeRank orders data scopes from narrowest to widest
+var scopeRank = map[string]int{repo.ScopeOwn: 0, repo.ScopeBranch: 1, repo.ScopeAll: 2}
+
+// handsOnAccess checks that the current user holds every permission and at
+// least the data scope they are passing on through a role or grant. Roles
+// and grants hand on access, they don't create it, so nobody can give more
+// than they have. Permissions held only through grants limited to a branch
+// or an agent user don't count, they reach too few records to pass on. An
+// empty scopeLevel skips the scope check. It writes the error response
+// itself.
+func handsOnAccess(c *gin.Context, rbacMgr *rbac.RBACManager, permissions []string, scopeLevel string) bool {
+	held, err := rbacMgr.GetHeldPermissions(c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Permission check failed"})
+		return false
+	}
+
+	holds := make(map[string]bool, len(held))
+	for _, permission := range held {
+		holds[permission] = true
+	}
+	var missing []string
+	for _, permission := range permissions {
+		if !holds[permission] {
+			missing = append(missing, permission)
+		}
+	}
+	if len(missing) > 0 {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "You can only pass on permissions you hold, missing: " + strings.Join(missing, ", ")})
+		return false
+	}
+
+	if scopeLevel != "" && scopeRank[scopeLevel] > scopeRank[dataScope(c).Level] {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "You can only pass on a data scope as wide as your own"})
+		return false
+	}
+
+	return true
+}
+
+func permissionNames(permissions []repo.Permission) []string {
+	names := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		names = append(names, permission.Name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+
+	"eesigorta/backend/internal/jobs"
+	"eesigorta/backend/internal/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ScraperHandler struct {
+	repo *repo.Repository
+	jobs *jobs.Client
+}
+
+func NewScraperHandler(repo *repo.Repository, jobs *jobs.Client) *ScraperHandler {
+	return &ScraperHandler{repo: repo, jobs: jobs}
+}
+
+type RunScraperRequest struct {
+	TargetID *uint `json:"target_id"`
+	Force    bool  `json:"force"`
+}
+
+// GetTargets godoc
+// @Summary List scraper targets
+// @Description Get all configured scraper targets
+// @Tags scraper
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} repo.ScraperTarget
+// @Failure 500 {object} ErrorResponse
+// @Router /scraper/targets [get]
+func (h *ScraperHandler) GetTargets(c *gin.Context) {
+	targets, err := h.repo.GetScraperTargets()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch scraper targets"})
+		return
+	}
+	c.JSON(http.StatusOK, targets)
+}
+
+// RunScraper godoc
+// @Summary Run scraper
+// @Description Enqueue a scrape of a single target, or of all enabled targets when no target_id is given
+// @Tags scraper
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body RunScraperRequest false "Scrape options"
+// @Success 202 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Router /scraper/run [post]
+func (h *ScraperHandler) RunScraper(c *gin.Context) {
+	var req RunScraperRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	if req.TargetID == nil {
+		if err := h.jobs.EnqueueScrapeAll(req.Force); err != nil {
+			c.JSON(http.StatusServiceUnavailable, ErrorResponse{Error: "Failed to enqueue scrape job"})
+			return
+		}
+		c.JSON(http.StatusAccepted, SuccessResponse{Message: "Scrape of all targets enqueued"})
+		return
+	}
+
+	target, err := h.repo.GetScraperTargetByID(*req.TargetID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Scraper target not found"})
+		return
+	}
+
+	if err := h.jobs.EnqueueScrapeTarget(target.ID); err != nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{Error: "Failed to enqueue scrape job"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, SuccessResponse{Message: "Scrape of " + target.Name + " enqueued"})
+}
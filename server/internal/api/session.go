@@ -0,0 +1,213 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"eesigorta/backend/internal/auth"
+	"eesigorta/backend/internal/repo"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SessionHandler struct {
+	repo *repo.Repository
+}
+
+func NewSessionHandler(repo *repo.Repository) *SessionHandler {
+	return &SessionHandler{repo: repo}
+}
+
+type SessionResponse struct {
+	ID         uint      `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// GetMySessions godoc
+// @Summary List own sessions
+// @Description Devices the current user is logged in on
+// @Tags sessions
+// @Produce json
+// @Success 200 {array} SessionResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /me/sessions [get]
+func (h *SessionHandler) GetMySessions(c *gin.Context) {
+	h.listSessions(c, c.GetUint("user_id"))
+}
+
+// RevokeMySession godoc
+// @Summary Sign out a session
+// @Description Revoke one of the current user's sessions, e.g. a lost device
+// @Tags sessions
+// @Produce json
+// @Param id path int true "Session ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /me/sessions/{id} [delete]
+func (h *SessionHandler) RevokeMySession(c *gin.Context) {
+	h.revokeSession(c, c.GetUint("user_id"), c.Param("id"))
+}
+
+// GetUserSessions godoc
+// @Summary List a user's sessions
+// @Description Devices any user is logged in on
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} SessionResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /users/{id}/sessions [get]
+func (h *SessionHandler) GetUserSessions(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
+		return
+	}
+
+	h.listSessions(c, uint(userID))
+}
+
+// RevokeUserSession godoc
+// @Summary Sign out a user's session
+// @Description Revoke a session of any user
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param session_id path int true "Session ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /users/{id}/sessions/{session_id} [delete]
+func (h *SessionHandler) RevokeUserSession(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
+		return
+	}
+
+	h.revokeSession(c, uint(userID), c.Param("session_id"))
+}
+
+func (h *SessionHandler) listSessions(c *gin.Context, userID uint) {
+	sessions, err := h.repo.GetActiveSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	currentID := c.GetUint("session_id")
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			ID:         session.ID,
+			Device:     session.Device,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.ID == currentID,
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *SessionHandler) revokeSession(c *gin.Context, userID uint, sessionParam string) {
+	sessionID, err := strconv.ParseUint(sessionParam, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid session ID"})
+		return
+	}
+
+	session, err := h.repo.GetUserSession(userID, uint(sessionID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Session not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	if err := h.repo.RevokeRefreshTokenFamily(session.FamilyID); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke session"})
+		return
+	}
+
+	// Log audit
+	recordAudit(c, c.GetUint("user_id"), "session_revoked", "user", &userID, map[string]interface{}{
+		"session_id": session.ID,
+		"device":     session.Device,
+	})
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Session revoked successfully"})
+}
+
+// newSession describes the device a token pair was just issued to
+func newSession(c *gin.Context, userID uint, tokenPair *auth.TokenPair) *repo.Session {
+	userAgent := c.GetHeader("User-Agent")
+	return &repo.Session{
+		FamilyID:   tokenPair.FamilyID,
+		UserID:     userID,
+		Device:     describeDevice(userAgent),
+		UserAgent:  userAgent,
+		IP:         c.ClientIP(),
+		ExpiresAt:  tokenPair.RefreshExpiresAt,
+		LastSeenAt: time.Now(),
+	}
+}
+
+// describeDevice turns a User-Agent into a short label like "Chrome on
+// Windows". Order matters: Edge and Opera also claim to be Chrome, and
+// Chrome claims to be Safari.
+func describeDevice(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	os := ""
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			os = o.name
+			break
+		}
+	}
+
+	if os == "" {
+		return browser
+	}
+	return browser + " on " + os
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"eesigorta/backend/internal/repo"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TimelineHandler struct {
+	repo *repo.Repository
+}
+
+func NewTimelineHandler(repo *repo.Repository) *TimelineHandler {
+	return &TimelineHandler{repo: repo}
+}
+
+// GetCustomerTimeline godoc
+// @Summary Customer activity timeline
+// @Description Everything that happened around a customer, newest first: profile edits, quotes, offers, approvals, policies and payments. Only quotes and policies in the user's data scope are included.
+// @Tags customers
+// @Produce json
+// @Param id path int true "Customer ID"
+// @Param types query string false "Comma-separated event types, e.g. quote_created,payment_received"
+// @Param page query int false "Page number" default(1)
+// @Param pageSize query int false "Page size" default(20)
+// @Success 200 {object} PaginationResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /customers/{id}/timeline [get]
+func (h *TimelineHandler) GetCustomerTimeline(c *gin.Context) {
+	id, ok := timelineID(c, "customer")
+	if !ok {
+		return
+	}
+
+	scope := dataScope(c)
+	var customer repo.Customer
+	if err := h.repo.ScopedCustomers(scope).First(&customer, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Customer not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	subject := repo.TimelineSubject{CustomerID: &customer.ID}
+	err := h.repo.ScopedQuotes(scope).Where("quotes.customer_id = ?", customer.ID).Pluck("quotes.id", &subject.QuoteIDs).Error
+	if err == nil {
+		err = h.repo.ScopedPolicies(scope).Where("policies.customer_id = ?", customer.ID).Pluck("policies.id", &subject.PolicyIDs).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	h.respond(c, subject)
+}
+
+// GetPolicyTimeline godoc
+// @Summary Policy activity timeline
+// @Description The policy's quote and offers, its issue, updates, renewals, cancellation and payments, newest first
+// @Tags policies
+// @Produce json
+// @Param id path int true "Policy ID"
+// @Param types query string false "Comma-separated event types, e.g. policy_renewed,payment_received"
+// @Param page query int false "Page number" default(1)
+// @Param pageSize query int false "Page size" default(20)
+// @Success 200 {object} PaginationResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /policies/{id}/timeline [get]
+func (h *TimelineHandler) GetPolicyTimeline(c *gin.Context) {
+	id, ok := timelineID(c, "policy")
+	if !ok {
+		return
+	}
+
+	var policy repo.Policy
+	if err := h.repo.ScopedPolicies(dataScope(c)).First(&policy, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Policy not found"})
+		return
+	}
+
+	subject := repo.TimelineSubject{PolicyIDs: []uint{policy.ID}}
+	if policy.QuoteID != nil {
+		subject.QuoteIDs = []uint{*policy.QuoteID}
+	}
+
+	h.respond(c, subject)
+}
+
+// GetQuoteTimeline godoc
+// @Summary Quote activity timeline
+// @Description The quote, its offers and approval, and the policies issued from it with their payments, newest first
+// @Tags quotes
+// @Produce json
+// @Param id path int true "Quote ID"
+// @Param types query string false "Comma-separated event types, e.g. offer_received"
+// @Param page query int false "Page number" default(1)
+// @Param pageSize query int false "Page size" default(20)
+// @Success 200 {object} PaginationResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /quotes/{id}/timeline [get]
+func (h *TimelineHandler) GetQuoteTimeline(c *gin.Context) {
+	id, ok := timelineID(c, "quote")
+	if !ok {
+		return
+	}
+
+	scope := dataScope(c)
+	var quote repo.Quote
+	if err := h.repo.ScopedQuotes(scope).First(&quote, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Quote not found"})
+		return
+	}
+
+	subject := repo.TimelineSubject{QuoteIDs: []uint{quote.ID}}
+	err := h.repo.ScopedPolicies(scope).Where("policies.quote_id = ?", quote.ID).Pluck("policies.id", &subject.PolicyIDs).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	h.respond(c, subject)
+}
+
+// respond writes one page of the subject's timeline, filtered by the types
+// query parameter
+func (h *TimelineHandler) respond(c *gin.Context, subject repo.TimelineSubject) {
+	var types []string
+	if param := c.Query("types"); param != "" {
+		for _, t := range strings.Split(param, ",") {
+			t = strings.TrimSpace(t)
+			if !slices.Contains(repo.TimelineTypes, t) {
+				c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Unknown timeline event type: " + t})
+				return
+			}
+			types = append(types, t)
+		}
+	}
+
+	events, err := h.repo.GetTimeline(subject, types)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	page := c.GetInt("page")
+	pageSize := c.GetInt("page_size")
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+
+	if events == nil {
+		events = []repo.TimelineEvent{}
+	}
+	total := len(events)
+	start := min((page-1)*pageSize, total)
+	end := min(start+pageSize, total)
+
+	c.JSON(http.StatusOK, PaginationResponse{
+		Data:       events[start:end],
+		Total:      int64(total),
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: (total + pageSize - 1) / pageSize,
+	})
+}
+
+// timelineID parses the :id path parameter, writing the error response
+// itself when it's invalid
+func timelineID(c *gin.Context, entity string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid " + entity + " ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
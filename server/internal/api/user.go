@@ -0,0 +1,605 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"eesigorta/backend/internal/auth"
+	"eesigorta/backend/internal/rbac"
+	"eesigorta/backend/internal/repo"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type UserHandler struct {
+	repo           *repo.Repository
+	passwordPolicy *auth.PasswordPolicy
+	rbacMgr        *rbac.RBACManager
+}
+
+func NewUserHandler(repo *repo.Repository, passwordPolicy *auth.PasswordPolicy, rbacMgr *rbac.RBACManager) *UserHandler {
+	return &UserHandler{repo: repo, passwordPolicy: passwordPolicy, rbacMgr: rbacMgr}
+}
+
+type CreateUserRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+	RoleID   uint   `json:"role_id" binding:"required"`
+	BranchID *uint  `json:"branch_id"`
+	AgentID  *uint  `json:"agent_id"`
+}
+
+type UpdateUserRoleRequest struct {
+	RoleID uint `json:"role_id" binding:"required"`
+}
+
+// UpdateUserAssignmentRequest links a user to a branch and to their agent
+// record. Both replace the current values, so null clears them.
+type UpdateUserAssignmentRequest struct {
+	BranchID *uint `json:"branch_id"`
+	AgentID  *uint `json:"agent_id"`
+}
+
+type PasswordResetResponse struct {
+	Message           string `json:"message"`
+	TemporaryPassword string `json:"temporary_password"`
+}
+
+// GetUsers godoc
+// @Summary List users
+// @Description Get paginated list of users, optionally filtered by email, role and status
+// @Tags users
+// @Produce json
+// @Param query query string false "Email search"
+// @Param role_id query int false "Role ID"
+// @Param is_active query bool false "Active status"
+// @Param page query int false "Page number" default(1)
+// @Param pageSize query int false "Page size" default(20)
+// @Success 200 {object} PaginationResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /users [get]
+func (h *UserHandler) GetUsers(c *gin.Context) {
+	query := c.Query("query")
+	page := c.GetInt("page")
+	pageSize := c.GetInt("page_size")
+
+	// Set defaults
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+
+	var users []repo.User
+	var total int64
+
+	db := h.repo.DB().Model(&repo.User{}).Preload("Role")
+
+	// Apply filters
+	if query != "" {
+		db = db.Where("LOWER(email) LIKE ?", "%"+strings.ToLower(query)+"%")
+	}
+	if roleID := c.Query("role_id"); roleID != "" {
+		id, err := strconv.ParseUint(roleID, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid role_id filter"})
+			return
+		}
+		db = db.Where("role_id = ?", id)
+	}
+	if isActive := c.Query("is_active"); isActive != "" {
+		active, err := strconv.ParseBool(isActive)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid is_active filter"})
+			return
+		}
+		db = db.Where("is_active = ?", active)
+	}
+
+	// Get total count
+	db.Count(&total)
+
+	// Apply pagination
+	offset := (page - 1) * pageSize
+	err := db.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&users).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return
+	}
+
+	// Convert to response
+	response := make([]*UserResponse, 0, len(users))
+	for i := range users {
+		response = append(response, userToResponse(&users[i]))
+	}
+
+	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
+
+	c.JSON(http.StatusOK, PaginationResponse{
+		Data:       response,
+		Total:      total,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: totalPages,
+	})
+}
+
+// GetUser godoc
+// @Summary Get user by ID
+// @Description Get user details by ID
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /users/{id} [get]
+func (h *UserHandler) GetUser(c *gin.Context) {
+	user, ok := findUser(c, h.repo)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, userToResponse(user))
+}
+
+// CreateUser godoc
+// @Summary Create user
+// @Description Create a new user with the given role
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body CreateUserRequest true "User data"
+// @Success 201 {object} UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /users [post]
+func (h *UserHandler) CreateUser(c *gin.Context) {
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	role, ok := h.findRole(c, req.RoleID)
+	if !ok {
+		return
+	}
+
+	branchID, agentID, ok := h.resolveAssignment(c, req.BranchID, req.AgentID)
+	if !ok {
+		return
+	}
+
+	if err := h.passwordPolicy.Validate(req.Password, req.Email); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	// Check if user already exists, including soft-deleted ones since the
+	// unique index still covers them
+	var existingUser repo.User
+	err := h.repo.DB().Unscoped().Where("email = ?", req.Email).First(&existingUser).Error
+	if err == nil {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: "User with this email already exists"})
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to hash password"})
+		return
+	}
+
+	user := repo.User{
+		Email:        req.Email,
+		PasswordHash: passwordHash,
+		RoleID:       role.ID,
+		BranchID:     branchID,
+		AgentID:      agentID,
+		IsActive:     true,
+	}
+
+	err = h.repo.DB().Create(&user).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create user"})
+		return
+	}
+
+	if err := h.repo.SetPassword(&user, passwordHash, false, h.passwordPolicy.HistorySize); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to record password"})
+		return
+	}
+	user.Role = role
+
+	// Log audit
+	recordAudit(c, c.GetUint("user_id"), "user_created", "user", &user.ID, map[string]interface{}{
+		"email": user.Email,
+		"role":  role.Name,
+	})
+
+	c.JSON(http.StatusCreated, userToResponse(&user))
+}
+
+// UpdateUserRole godoc
+// @Summary Change a user's role
+// @Description Assign a different role to a user
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body UpdateUserRoleRequest true "New role"
+// @Success 200 {object} UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /users/{id}/role [put]
+func (h *UserHandler) UpdateUserRole(c *gin.Context) {
+	var req UpdateUserRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	user, ok := findUser(c, h.repo)
+	if !ok {
+		return
+	}
+
+	if user.ID == c.GetUint("user_id") {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "You cannot change your own role"})
+		return
+	}
+	if !userInHand(c, h.repo, h.rbacMgr, user) {
+		return
+	}
+
+	role, ok := h.findRole(c, req.RoleID)
+	if !ok {
+		return
+	}
+
+	// Updated through a bare model, gorm would otherwise set role_id back
+	// from the preloaded Role
+	oldRole := user.RoleName()
+	err := h.repo.DB().Model(&repo.User{ID: user.ID}).Update("role_id", role.ID).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update role"})
+		return
+	}
+	user.RoleID, user.Role = role.ID, role
+
+	// Log audit
+	recordAudit(c, c.GetUint("user_id"), "user_role_changed", "user", &user.ID, map[string]interface{}{
+		"email":    user.Email,
+		"old_role": oldRole,
+		"new_role": role.Name,
+	})
+
+	c.JSON(http.StatusOK, userToResponse(user))
+}
+
+// UpdateUserAssignment godoc
+// @Summary Assign a user to a branch and agent
+// @Description Set the branch and agent record whose data the user sees under a branch or own data scope. The branch defaults to the agent's branch.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body UpdateUserAssignmentRequest true "Branch and agent"
+// @Success 200 {object} UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /users/{id}/assignment [put]
+func (h *UserHandler) UpdateUserAssignment(c *gin.Context) {
+	var req UpdateUserAssignmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	user, ok := findUser(c, h.repo)
+	if !ok {
+		return
+	}
+
+	if user.ID == c.GetUint("user_id") {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "You cannot change your own assignment"})
+		return
+	}
+	if !userInHand(c, h.repo, h.rbacMgr, user) {
+		return
+	}
+
+	branchID, agentID, ok := h.resolveAssignment(c, req.BranchID, req.AgentID)
+	if !ok {
+		return
+	}
+
+	oldBranchID, oldAgentID := user.BranchID, user.AgentID
+	err := h.repo.DB().Model(user).Updates(map[string]interface{}{
+		"branch_id": branchID,
+		"agent_id":  agentID,
+	}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update assignment"})
+		return
+	}
+	user.BranchID, user.AgentID = branchID, agentID
+
+	// Log audit
+	recordAudit(c, c.GetUint("user_id"), "user_assignment_changed", "user", &user.ID, map[string]interface{}{
+		"email":         user.Email,
+		"old_branch_id": oldBranchID,
+		"new_branch_id": branchID,
+		"old_agent_id":  oldAgentID,
+		"new_agent_id":  agentID,
+	})
+
+	c.JSON(http.StatusOK, userToResponse(user))
+}
+
+// DeactivateUser godoc
+// @Summary Deactivate user
+// @Description Block a user from logging in and end their sessions
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /users/{id}/deactivate [post]
+func (h *UserHandler) DeactivateUser(c *gin.Context) {
+	h.setActive(c, false)
+}
+
+// ActivateUser godoc
+// @Summary Reactivate user
+// @Description Allow a deactivated user to log in again
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /users/{id}/activate [post]
+func (h *UserHandler) ActivateUser(c *gin.Context) {
+	h.setActive(c, true)
+}
+
+// DeleteUser godoc
+// @Summary Delete user
+// @Description Soft delete a user and end their sessions
+// @Tags users
+// @Param id path int true "User ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /users/{id} [delete]
+func (h *UserHandler) DeleteUser(c *gin.Context) {
+	user, ok := findUser(c, h.repo)
+	if !ok {
+		return
+	}
+
+	if user.ID == c.GetUint("user_id") {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "You cannot delete your own account"})
+		return
+	}
+	if !userInHand(c, h.repo, h.rbacMgr, user) {
+		return
+	}
+
+	if err := h.repo.RevokeUserRefreshTokens(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke sessions"})
+		return
+	}
+
+	err := h.repo.DB().Delete(user).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete user"})
+		return
+	}
+
+	// Log audit
+	recordAudit(c, c.GetUint("user_id"), "user_deleted", "user", &user.ID, map[string]interface{}{
+		"email": user.Email,
+	})
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "User deleted successfully"})
+}
+
+// ResetPassword godoc
+// @Summary Reset a user's password
+// @Description Replace the password with a one-time temporary password, end the user's sessions and require a change on next login
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} PasswordResetResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /users/{id}/password-reset [post]
+func (h *UserHandler) ResetPassword(c *gin.Context) {
+	user, ok := findUser(c, h.repo)
+	if !ok || !userInHand(c, h.repo, h.rbacMgr, user) {
+		return
+	}
+
+	temporaryPassword, err := auth.GenerateTemporaryPassword()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate password"})
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(temporaryPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to hash password"})
+		return
+	}
+
+	err = h.repo.SetPassword(user, passwordHash, true, h.passwordPolicy.HistorySize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to reset password"})
+		return
+	}
+
+	if err := h.repo.RevokeUserRefreshTokens(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke sessions"})
+		return
+	}
+
+	// Log audit; the temporary password itself is never logged
+	recordAudit(c, c.GetUint("user_id"), "password_reset", "user", &user.ID, map[string]interface{}{
+		"email": user.Email,
+	})
+
+	c.JSON(http.StatusOK, PasswordResetResponse{
+		Message:           "Password reset successfully",
+		TemporaryPassword: temporaryPassword,
+	})
+}
+
+func (h *UserHandler) setActive(c *gin.Context, active bool) {
+	user, ok := findUser(c, h.repo)
+	if !ok {
+		return
+	}
+
+	if !active && user.ID == c.GetUint("user_id") {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "You cannot deactivate your own account"})
+		return
+	}
+	if !userInHand(c, h.repo, h.rbacMgr, user) {
+		return
+	}
+
+	err := h.repo.DB().Model(user).Update("is_active", active).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update user"})
+		return
+	}
+
+	action := "user_activated"
+	if !active {
+		action = "user_deactivated"
+		if err := h.repo.RevokeUserRefreshTokens(user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke sessions"})
+			return
+		}
+	}
+
+	// Log audit
+	recordAudit(c, c.GetUint("user_id"), action, "user", &user.ID, map[string]interface{}{
+		"email": user.Email,
+	})
+
+	c.JSON(http.StatusOK, userToResponse(user))
+}
+
+// findUser loads the user named by the :id path parameter with their role,
+// writing the error response itself when it can't
+func findUser(c *gin.Context, repository *repo.Repository) (*repo.User, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
+		return nil, false
+	}
+
+	var user repo.User
+	err = repository.DB().Preload("Role").First(&user, uint(id)).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return nil, false
+	}
+
+	return &user, true
+}
+
+// userInHand checks that the current user may act on the target user: the
+// target must be in their data scope, and their role may hold no permission
+// or data scope the current user lacks, or managing them would reach past
+// the current user's own access. It writes the error response itself.
+func userInHand(c *gin.Context, repository *repo.Repository, rbacMgr *rbac.RBACManager, user *repo.User) bool {
+	inScope, err := repository.UserInScope(dataScope(c), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return false
+	}
+	if !inScope {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return false
+	}
+
+	role, err := repository.GetRoleByID(user.RoleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return false
+	}
+	return handsOnAccess(c, rbacMgr, permissionNames(role.Permissions), role.DataScope)
+}
+
+// findRole loads the role a user is being assigned. The current user must
+// hold the role's permissions and data scope, the same rule grants follow.
+// It writes the error response itself when the role can't be assigned.
+func (h *UserHandler) findRole(c *gin.Context, id uint) (*repo.Role, bool) {
+	role, err := h.repo.GetRoleByID(id)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Unknown role"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+		return nil, false
+	}
+	if !handsOnAccess(c, h.rbacMgr, permissionNames(role.Permissions), role.DataScope) {
+		return nil, false
+	}
+	return role, true
+}
+
+// resolveAssignment checks the branch and agent a user is being linked to.
+// A user linked to an agent record works in that agent's branch, which must
+// be one the current user sees. It writes the error response itself when
+// the assignment is invalid.
+func (h *UserHandler) resolveAssignment(c *gin.Context, branchID, agentID *uint) (*uint, *uint, bool) {
+	if agentID != nil {
+		var agent repo.Agent
+		err := h.repo.DB().First(&agent, *agentID).Error
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Agent not found"})
+				return nil, nil, false
+			}
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+			return nil, nil, false
+		}
+
+		if branchID == nil {
+			branchID = &agent.BranchID
+		} else if *branchID != agent.BranchID {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Agent belongs to a different branch"})
+			return nil, nil, false
+		}
+	}
+
+	if branchID != nil {
+		var branch repo.Branch
+		err := h.repo.ScopedBranches(dataScope(c)).First(&branch, *branchID).Error
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Branch not found"})
+				return nil, nil, false
+			}
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Database error"})
+			return nil, nil, false
+		}
+	}
+
+	return branchID, agentID, true
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AttemptCounter counts failed attempts per key within a fixed window. It
+// backs brute-force protection for login and 2FA codes.
+type AttemptCounter interface {
+	// Incr records an attempt and returns the count in the current window.
+	// The window starts at the first attempt after the previous one expired.
+	Incr(ctx context.Context, key string, window time.Duration) (int64, error)
+	// Count returns the attempts in the current window, 0 once it expired
+	Count(ctx context.Context, key string) (int64, error)
+	Reset(ctx context.Context, key string) error
+}
+
+type attemptEntry struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// MemoryAttemptCounter is an in-process AttemptCounter for tests and single
+// instance deployments
+type MemoryAttemptCounter struct {
+	mu      sync.Mutex
+	entries map[string]*attemptEntry
+}
+
+func NewMemoryAttemptCounter() *MemoryAttemptCounter {
+	return &MemoryAttemptCounter{entries: make(map[string]*attemptEntry)}
+}
+
+func (m *MemoryAttemptCounter) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := m.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		entry = &attemptEntry{expiresAt: now.Add(window)}
+		m.entries[key] = entry
+	}
+	entry.count++
+	return entry.count, nil
+}
+
+func (m *MemoryAttemptCounter) Count(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok {
+		return 0, nil
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(m.entries, key)
+		return 0, nil
+	}
+	return entry.count, nil
+}
+
+func (m *MemoryAttemptCounter) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+// RedisAttemptCounter shares attempt counts between API instances
+type RedisAttemptCounter struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisAttemptCounter(client *redis.Client) *RedisAttemptCounter {
+	return &RedisAttemptCounter{client: client, prefix: "attempts:"}
+}
+
+func (r *RedisAttemptCounter) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	key = r.prefix + key
+
+	pipe := r.client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	// NX keeps the window fixed from the first attempt
+	pipe.ExpireNX(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (r *RedisAttemptCounter) Count(ctx context.Context, key string) (int64, error) {
+	count, err := r.client.Get(ctx, r.prefix+key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return count, err
+}
+
+func (r *RedisAttemptCounter) Reset(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.prefix+key).Err()
+}
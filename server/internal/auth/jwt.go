@@ -1,10 +1,14 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
 
+	"eesigorta/backend/internal/config"
+
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -14,16 +18,30 @@ var (
 	ErrExpiredToken = errors.New("token has expired")
 )
 
+// Token types carried in the "typ" claim so a refresh token can't be used
+// as an access token and vice versa
+const (
+	TokenTypeAccess     = "access"
+	TokenTypeRefresh    = "refresh"
+	TokenTypeMFAPending = "mfa_pending"
+)
+
+// mfaPendingTTL bounds how long a user has to enter their 2FA code after
+// the password step
+const mfaPendingTTL = 5 * time.Minute
+
 type JWTManager struct {
-	secret        string
-	accessTTL     time.Duration
-	refreshTTL    time.Duration
+	keys       *KeySet
+	accessTTL  time.Duration
+	refreshTTL time.Duration
 }
 
 type Claims struct {
-	UserID uint   `json:"user_id"`
-	Email  string `json:"email"`
-	Role   string `json:"role"`
+	UserID    uint   `json:"user_id"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	TokenType string `json:"typ"`
+	FamilyID  string `json:"fid,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -31,26 +49,74 @@ type TokenPair struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresAt    int64  `json:"expires_at"`
+
+	// Refresh token metadata the caller persists for rotation; not sent to clients
+	RefreshTokenID   string    `json:"-"`
+	FamilyID         string    `json:"-"`
+	RefreshExpiresAt time.Time `json:"-"`
 }
 
+// NewJWTManager signs with a shared HS256 secret
 func NewJWTManager(secret string, accessTTL, refreshTTL time.Duration) *JWTManager {
+	return NewJWTManagerWithKeys(NewHMACKeySet(secret), accessTTL, refreshTTL)
+}
+
+func NewJWTManagerWithKeys(keys *KeySet, accessTTL, refreshTTL time.Duration) *JWTManager {
 	return &JWTManager{
-		secret:     secret,
+		keys:       keys,
 		accessTTL:  accessTTL,
 		refreshTTL: refreshTTL,
 	}
 }
 
+// NewJWTManagerFromConfig uses the asymmetric keys in cfg.KeyDir when set
+// and falls back to the HS256 secret otherwise
+func NewJWTManagerFromConfig(cfg config.JWTConfig) (*JWTManager, error) {
+	if cfg.KeyDir == "" {
+		return NewJWTManager(cfg.Secret, cfg.AccessTTL, cfg.RefreshTTL), nil
+	}
+
+	keys, err := LoadKeySet(cfg.KeyDir, cfg.ActiveKID)
+	if err != nil {
+		return nil, err
+	}
+	return NewJWTManagerWithKeys(keys, cfg.AccessTTL, cfg.RefreshTTL), nil
+}
+
+// JWKS returns the public keys tokens can be verified with
+func (j *JWTManager) JWKS() JWKS {
+	return j.keys.JWKS()
+}
+
+// GenerateTokenPair issues a new pair that starts a new refresh token family
 func (j *JWTManager) GenerateTokenPair(userID uint, email, role string) (*TokenPair, error) {
+	familyID, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+	return j.GenerateTokenPairInFamily(userID, email, role, familyID)
+}
+
+// GenerateTokenPairInFamily issues a new pair whose refresh token belongs to
+// an existing family, used when rotating a refresh token
+func (j *JWTManager) GenerateTokenPairInFamily(userID uint, email, role, familyID string) (*TokenPair, error) {
+	refreshTokenID, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+
 	now := time.Now()
 	accessExpiresAt := now.Add(j.accessTTL)
 	refreshExpiresAt := now.Add(j.refreshTTL)
 
-	// Generate access token
+	// Generate access token; its fid ties it to the session so revoking the
+	// session also rejects access tokens that haven't expired yet
 	accessClaims := &Claims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
+		UserID:    userID,
+		Email:     email,
+		Role:      role,
+		TokenType: TokenTypeAccess,
+		FamilyID:  familyID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(accessExpiresAt),
 			IssuedAt:  jwt.NewNumericDate(now),
@@ -59,18 +125,20 @@ func (j *JWTManager) GenerateTokenPair(userID uint, email, role string) (*TokenP
 		},
 	}
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessTokenString, err := accessToken.SignedString([]byte(j.secret))
+	accessTokenString, err := j.keys.sign(accessClaims)
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign access token: %w", err)
 	}
 
 	// Generate refresh token
 	refreshClaims := &Claims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
+		UserID:    userID,
+		Email:     email,
+		Role:      role,
+		TokenType: TokenTypeRefresh,
+		FamilyID:  familyID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        refreshTokenID,
 			ExpiresAt: jwt.NewNumericDate(refreshExpiresAt),
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
@@ -78,26 +146,23 @@ func (j *JWTManager) GenerateTokenPair(userID uint, email, role string) (*TokenP
 		},
 	}
 
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshTokenString, err := refreshToken.SignedString([]byte(j.secret))
+	refreshTokenString, err := j.keys.sign(refreshClaims)
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign refresh token: %w", err)
 	}
 
 	return &TokenPair{
-		AccessToken:  accessTokenString,
-		RefreshToken: refreshTokenString,
-		ExpiresAt:    accessExpiresAt.Unix(),
+		AccessToken:      accessTokenString,
+		RefreshToken:     refreshTokenString,
+		ExpiresAt:        accessExpiresAt.Unix(),
+		RefreshTokenID:   refreshTokenID,
+		FamilyID:         familyID,
+		RefreshExpiresAt: refreshExpiresAt,
 	}, nil
 }
 
 func (j *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(j.secret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, j.keys.keyFunc)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
@@ -115,13 +180,63 @@ func (j *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
-func (j *JWTManager) RefreshToken(refreshTokenString string) (*TokenPair, error) {
+// GenerateMFAPendingToken issues a short-lived token proving the password
+// step succeeded. It is only accepted by the 2FA login exchange.
+func (j *JWTManager) GenerateMFAPendingToken(userID uint, email string) (string, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID:    userID,
+		Email:     email,
+		TokenType: TokenTypeMFAPending,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(mfaPendingTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Subject:   fmt.Sprintf("%d", userID),
+		},
+	}
+
+	tokenString, err := j.keys.sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign mfa token: %w", err)
+	}
+	return tokenString, nil
+}
+
+func (j *JWTManager) ValidateMFAPendingToken(tokenString string) (*Claims, error) {
+	claims, err := j.ValidateToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.TokenType != TokenTypeMFAPending {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// ValidateRefreshToken checks the signature and expiry of a refresh token.
+// Whether it has been rotated or revoked is up to the caller's token store.
+func (j *JWTManager) ValidateRefreshToken(refreshTokenString string) (*Claims, error) {
 	claims, err := j.ValidateToken(refreshTokenString)
 	if err != nil {
 		return nil, err
 	}
 
-	return j.GenerateTokenPair(claims.UserID, claims.Email, claims.Role)
+	if claims.TokenType != TokenTypeRefresh || claims.ID == "" || claims.FamilyID == "" {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
 }
 
 func HashPassword(password string) (string, error) {
@@ -135,3 +250,19 @@ func HashPassword(password string) (string, error) {
 func CheckPassword(password, hashedPassword string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
+
+// temporaryPasswordAlphabet leaves out characters that are easy to misread
+const temporaryPasswordAlphabet = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// GenerateTemporaryPassword returns a random password for an admin-triggered
+// reset. The user is expected to replace it on their next login.
+func GenerateTemporaryPassword() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate temporary password: %w", err)
+	}
+	for i := range b {
+		b[i] = temporaryPasswordAlphabet[int(b[i])%len(temporaryPasswordAlphabet)]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"eesigorta/backend/internal/config"
+)
+
+// baseLoginDelay is the first delay once DelayAfter failures are reached;
+// it doubles with each further failure up to MaxDelay
+const baseLoginDelay = 500 * time.Millisecond
+
+// LoginGuard tracks failed password attempts per email and per IP. Repeated
+// failures slow responses down, then lock the account; an IP failing against
+// many accounts is refused outright.
+type LoginGuard struct {
+	cfg      config.LoginConfig
+	attempts AttemptCounter
+}
+
+func NewLoginGuard(cfg config.LoginConfig, attempts AttemptCounter) *LoginGuard {
+	return &LoginGuard{cfg: cfg, attempts: attempts}
+}
+
+// Check returns how long to delay a login attempt, or ErrTooManyAttempts if
+// the IP has used up its failures for the current window
+func (g *LoginGuard) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	ipCount, err := g.attempts.Count(ctx, loginIPKey(ip))
+	if err != nil {
+		return 0, err
+	}
+	if ipCount >= int64(g.cfg.MaxIPFailures) {
+		return 0, ErrTooManyAttempts
+	}
+
+	count, err := g.attempts.Count(ctx, loginEmailKey(email))
+	if err != nil {
+		return 0, err
+	}
+	return g.delay(count), nil
+}
+
+// RecordFailure counts a failed attempt and reports whether the account has
+// now reached the lockout threshold
+func (g *LoginGuard) RecordFailure(ctx context.Context, email, ip string) (failures int64, lock bool, err error) {
+	if _, err := g.attempts.Incr(ctx, loginIPKey(ip), g.cfg.FailureWindow); err != nil {
+		return 0, false, err
+	}
+
+	failures, err = g.attempts.Incr(ctx, loginEmailKey(email), g.cfg.FailureWindow)
+	if err != nil {
+		return 0, false, err
+	}
+	return failures, failures >= int64(g.cfg.MaxFailures), nil
+}
+
+// Reset clears the failures of an email after a successful login or an
+// admin unlock. IP failures are left to expire on their own.
+func (g *LoginGuard) Reset(ctx context.Context, email string) error {
+	return g.attempts.Reset(ctx, loginEmailKey(email))
+}
+
+// LockoutDuration is how long an account stays locked once it hits the
+// failure threshold
+func (g *LoginGuard) LockoutDuration() time.Duration {
+	return g.cfg.LockoutDuration
+}
+
+func (g *LoginGuard) delay(failures int64) time.Duration {
+	over := failures - int64(g.cfg.DelayAfter)
+	if over <= 0 {
+		return 0
+	}
+
+	delay := baseLoginDelay
+	for i := int64(1); i < over && delay < g.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > g.cfg.MaxDelay {
+		delay = g.cfg.MaxDelay
+	}
+	return delay
+}
+
+func loginEmailKey(email string) string {
+	return fmt.Sprintf("login:email:%s", strings.ToLower(email))
+}
+
+func loginIPKey(ip string) string {
+	return fmt.Sprintf("login:ip:%s", ip)
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"eesigorta/backend/internal/config"
+)
+
+// PasswordPolicy describes the rules a new password must satisfy. It is
+// served as-is to clients so they can show the rules before submitting.
+type PasswordPolicy struct {
+	MinLength     int  `json:"min_length"`
+	RequireUpper  bool `json:"require_upper"`
+	RequireLower  bool `json:"require_lower"`
+	RequireDigit  bool `json:"require_digit"`
+	RequireSymbol bool `json:"require_symbol"`
+	HistorySize   int  `json:"history_size"`
+	MaxAgeDays    int  `json:"max_age_days"`
+
+	maxAge time.Duration
+}
+
+func NewPasswordPolicy(cfg config.PasswordConfig) *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:     cfg.MinLength,
+		RequireUpper:  cfg.RequireUpper,
+		RequireLower:  cfg.RequireLower,
+		RequireDigit:  cfg.RequireDigit,
+		RequireSymbol: cfg.RequireSymbol,
+		HistorySize:   cfg.HistorySize,
+		MaxAgeDays:    int(cfg.MaxAge / (24 * time.Hour)),
+		maxAge:        cfg.MaxAge,
+	}
+}
+
+// PasswordPolicyError lists every rule a password broke
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the policy: " + strings.Join(e.Violations, "; ")
+}
+
+// Validate checks a password for the account with the given email. It
+// returns a *PasswordPolicyError if any rule is broken.
+func (p *PasswordPolicy) Validate(password, email string) error {
+	var violations []string
+
+	if len([]rune(password)) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters", p.MinLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	lower := strings.ToLower(password)
+	if local, _, ok := strings.Cut(strings.ToLower(email), "@"); ok && len(local) >= 3 && strings.Contains(lower, local) {
+		violations = append(violations, "must not contain your email address")
+	}
+	if _, common := commonPasswords[lower]; common {
+		violations = append(violations, "is too common")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// Expired reports whether a password set at changedAt is past the maximum age
+func (p *PasswordPolicy) Expired(changedAt time.Time) bool {
+	return p.maxAge > 0 && time.Since(changedAt) > p.maxAge
+}
+
+// commonPasswords are rejected regardless of the other rules. Compared in
+// lower case, so variants like "Password1" are caught too.
+var commonPasswords = map[string]struct{}{
+	"123456": {}, "12345678": {}, "123456789": {}, "1234567890": {},
+	"12345678910": {}, "qwerty": {}, "qwerty123": {}, "qwertyuiop": {},
+	"1q2w3e4r": {}, "1q2w3e4r5t": {}, "password": {}, "password1": {},
+	"password123": {}, "passw0rd": {}, "p@ssw0rd": {}, "p@ssword1": {},
+	"admin": {}, "admin123": {}, "admin1234": {}, "administrator": {},
+	"welcome": {}, "welcome1": {}, "welcome123": {}, "letmein": {},
+	"iloveyou": {}, "sunshine": {}, "football": {}, "monkey": {},
+	"dragon": {}, "master": {}, "abc123": {}, "abcd1234": {},
+	"111111": {}, "000000": {}, "changeme": {}, "changeme123": {},
+	"sifre": {}, "sifre123": {}, "şifre": {}, "şifre123": {},
+	"parola": {}, "parola123": {}, "galatasaray": {}, "fenerbahce": {},
+	"besiktas": {}, "trabzonspor": {}, "istanbul": {}, "ankara": {},
+	"turkiye": {}, "türkiye": {}, "eesigorta": {}, "sigorta": {},
+	"sigorta123": {}, "qwerty1234": {}, "asdfghjkl": {}, "zxcvbnm": {},
+}
@@ -1,22 +1,42 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base32"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
+	"eesigorta/backend/internal/config"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/hotp"
 	"github.com/pquerna/otp/totp"
 )
 
+var ErrTooManyAttempts = errors.New("too many failed attempts")
+
 type TOTPManager struct {
-	issuer string
+	issuer        string
+	period        uint
+	skew          uint
+	digits        otp.Digits
+	maxAttempts   int64
+	attemptWindow time.Duration
+	attempts      AttemptCounter
 }
 
-func NewTOTPManager(issuer string) *TOTPManager {
+func NewTOTPManager(cfg config.TOTPConfig, attempts AttemptCounter) *TOTPManager {
 	return &TOTPManager{
-		issuer: issuer,
+		issuer:        cfg.Issuer,
+		period:        cfg.Period,
+		skew:          cfg.Skew,
+		digits:        otp.Digits(cfg.Digits),
+		maxAttempts:   int64(cfg.MaxAttempts),
+		attemptWindow: cfg.AttemptWindow,
+		attempts:      attempts,
 	}
 }
 
@@ -32,33 +52,114 @@ func (t *TOTPManager) GenerateSecret(email string) (*TOTPSecret, error) {
 		return nil, fmt.Errorf("failed to generate random secret: %w", err)
 	}
 
-	secret := base32.StdEncoding.EncodeToString(secretBytes)
-	secret = strings.TrimRight(secret, "=")
-
-	// Generate QR code URL
+	// Generate QR code URL; the library base32-encodes the raw bytes itself
 	key, err := totp.Generate(totp.GenerateOpts{
 		Issuer:      t.issuer,
 		AccountName: email,
-		Secret:      []byte(secret),
+		Secret:      secretBytes,
+		Period:      t.period,
+		Digits:      t.digits,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate TOTP key: %w", err)
 	}
 
 	return &TOTPSecret{
-		Secret:    secret,
+		Secret:    key.Secret(),
 		QRCodeURL: key.URL(),
 	}, nil
 }
 
-func (t *TOTPManager) ValidateCode(secret, code string) bool {
-	return totp.Validate(code, secret)
+// ValidateCode checks a code against the time steps within the configured
+// skew. Steps at or before lastStep were already used and are rejected, so
+// a code can't be replayed inside its window. On success it returns the
+// matched step for the caller to persist.
+func (t *TOTPManager) ValidateCode(secret, code string, lastStep int64) (int64, bool) {
+	if secret == "" || len(code) != t.digits.Length() {
+		return 0, false
+	}
+
+	current := time.Now().Unix() / int64(t.period)
+	opts := hotp.ValidateOpts{Digits: t.digits, Algorithm: otp.AlgorithmSHA1}
+
+	for offset := -int64(t.skew); offset <= int64(t.skew); offset++ {
+		step := current + offset
+		if step <= lastStep {
+			continue
+		}
+		if ok, err := hotp.ValidateCustom(code, uint64(step), secret, opts); err == nil && ok {
+			return step, true
+		}
+	}
+
+	return 0, false
 }
 
 func (t *TOTPManager) GenerateCode(secret string) (string, error) {
-	code, err := totp.GenerateCode(secret, time.Now())
+	code, err := totp.GenerateCodeCustom(secret, time.Now(), totp.ValidateOpts{
+		Period:    t.period,
+		Digits:    t.digits,
+		Algorithm: otp.AlgorithmSHA1,
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to generate TOTP code: %w", err)
 	}
 	return code, nil
 }
+
+// CheckAttempts returns ErrTooManyAttempts once a user has used up their
+// failed 2FA attempts for the current window
+func (t *TOTPManager) CheckAttempts(ctx context.Context, userID uint) error {
+	count, err := t.attempts.Count(ctx, totpAttemptKey(userID))
+	if err != nil {
+		return err
+	}
+	if count >= t.maxAttempts {
+		return ErrTooManyAttempts
+	}
+	return nil
+}
+
+func (t *TOTPManager) RecordFailedAttempt(ctx context.Context, userID uint) error {
+	_, err := t.attempts.Incr(ctx, totpAttemptKey(userID), t.attemptWindow)
+	return err
+}
+
+func (t *TOTPManager) ResetAttempts(ctx context.Context, userID uint) error {
+	return t.attempts.Reset(ctx, totpAttemptKey(userID))
+}
+
+func totpAttemptKey(userID uint) string {
+	return fmt.Sprintf("totp:%d", userID)
+}
+
+// RecoveryCodeCount is how many one-time recovery codes a user gets when
+// 2FA is enabled
+const RecoveryCodeCount = 10
+
+// GenerateRecoveryCodes returns n random one-time codes formatted as
+// xxxxx-xxxxx. Callers store them hashed with HashPassword and show the
+// plaintext to the user once.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode makes user input comparable to a generated code,
+// tolerating case and a missing dash
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) == 10 && !strings.Contains(code, "-") {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
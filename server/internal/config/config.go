@@ -2,8 +2,10 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -15,6 +17,9 @@ type Config struct {
 	Redis    RedisConfig
 	JWT      JWTConfig
 	TOTP     TOTPConfig
+	Login    LoginConfig
+	Password PasswordConfig
+	RBAC     RBACConfig
 	Scraper  ScraperConfig
 	MinIO    MinIOConfig
 }
@@ -22,6 +27,10 @@ type Config struct {
 type AppConfig struct {
 	Env  string
 	Port string
+	// TrustedProxies are the IPs or CIDRs of the proxies whose
+	// X-Forwarded-For is believed. Empty trusts none, so the client IP is
+	// the connection's address and can't be forged with the header.
+	TrustedProxies []string
 }
 
 type DatabaseConfig struct {
@@ -40,13 +49,58 @@ type RedisConfig struct {
 }
 
 type JWTConfig struct {
-	Secret        string
-	AccessTTL     time.Duration
-	RefreshTTL    time.Duration
+	Secret              string
+	AccessTTL           time.Duration
+	RefreshTTL          time.Duration
+	KeyDir              string // RS256/EdDSA keys as <kid>.pem; HS256 with Secret when empty
+	ActiveKID           string // key new tokens are signed with
+	RejectDefaultSecret bool   // refuse to start in production with a placeholder Secret
+}
+
+// DefaultJWTSecret is the placeholder secret used when JWT_SECRET is unset
+const DefaultJWTSecret = "change-me-in-production"
+
+// placeholderJWTSecrets are secrets shipped in defaults and examples
+var placeholderJWTSecrets = map[string]bool{
+	DefaultJWTSecret: true,
+	"your-super-secret-jwt-key-change-in-production": true,
 }
 
 type TOTPConfig struct {
-	Issuer string
+	Issuer        string
+	Period        uint // seconds per time step
+	Skew          uint // time steps accepted either side of now
+	Digits        int
+	MaxAttempts   int // failed codes allowed per user within AttemptWindow
+	AttemptWindow time.Duration
+}
+
+// LoginConfig holds brute-force protection thresholds for password logins
+type LoginConfig struct {
+	MaxFailures     int           // failures per email before the account is locked
+	FailureWindow   time.Duration // window failures are counted in
+	LockoutDuration time.Duration
+	DelayAfter      int // failures before responses start slowing down
+	MaxDelay        time.Duration
+	MaxIPFailures   int // failures per IP before the IP is refused
+	RateLimitPerMin int // requests per IP per minute on /auth routes
+}
+
+// PasswordConfig is the policy applied whenever a password is set
+type PasswordConfig struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	HistorySize   int           // previous passwords that can't be reused
+	MaxAge        time.Duration // 0 disables expiry
+}
+
+// RBACConfig controls the in-memory role permission cache
+type RBACConfig struct {
+	CacheTTL time.Duration // reload interval as a safety net; 0 caches until invalidated
+	PubSub   bool          // share invalidations between API instances over Redis
 }
 
 type ScraperConfig struct {
@@ -86,8 +140,9 @@ func Load() (*Config, error) {
 
 	config := &Config{
 		App: AppConfig{
-			Env:  getEnv("APP_ENV", "dev"),
-			Port: getEnv("APP_PORT", "8080"),
+			Env:            getEnv("APP_ENV", "dev"),
+			Port:           getEnv("APP_PORT", "8080"),
+			TrustedProxies: getEnvAsList("TRUSTED_PROXIES"),
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("POSTGRES_HOST", "localhost"),
@@ -103,12 +158,42 @@ func Load() (*Config, error) {
 			DB:       getEnvAsInt("REDIS_DB", 0),
 		},
 		JWT: JWTConfig{
-			Secret:        getEnv("JWT_SECRET", "change-me-in-production"),
-			AccessTTL:     time.Duration(getEnvAsInt("JWT_ACCESS_TTL_MIN", 15)) * time.Minute,
-			RefreshTTL:    time.Duration(getEnvAsInt("JWT_REFRESH_TTL_H", 168)) * time.Hour,
+			Secret:              getEnv("JWT_SECRET", DefaultJWTSecret),
+			AccessTTL:           time.Duration(getEnvAsInt("JWT_ACCESS_TTL_MIN", 15)) * time.Minute,
+			RefreshTTL:          time.Duration(getEnvAsInt("JWT_REFRESH_TTL_H", 168)) * time.Hour,
+			KeyDir:              getEnv("JWT_KEY_DIR", ""),
+			ActiveKID:           getEnv("JWT_ACTIVE_KID", ""),
+			RejectDefaultSecret: getEnvAsBool("JWT_REJECT_DEFAULT_SECRET", true),
 		},
 		TOTP: TOTPConfig{
-			Issuer: getEnv("TOTP_ISSUER", "EESigorta"),
+			Issuer:        getEnv("TOTP_ISSUER", "EESigorta"),
+			Period:        uint(getEnvAsInt("TOTP_PERIOD_S", 30)),
+			Skew:          uint(getEnvAsInt("TOTP_SKEW", 1)),
+			Digits:        getEnvAsInt("TOTP_DIGITS", 6),
+			MaxAttempts:   getEnvAsInt("TOTP_MAX_ATTEMPTS", 5),
+			AttemptWindow: time.Duration(getEnvAsInt("TOTP_ATTEMPT_WINDOW_MIN", 15)) * time.Minute,
+		},
+		Login: LoginConfig{
+			MaxFailures:     getEnvAsInt("LOGIN_MAX_FAILURES", 5),
+			FailureWindow:   time.Duration(getEnvAsInt("LOGIN_FAILURE_WINDOW_MIN", 15)) * time.Minute,
+			LockoutDuration: time.Duration(getEnvAsInt("LOGIN_LOCKOUT_MIN", 15)) * time.Minute,
+			DelayAfter:      getEnvAsInt("LOGIN_DELAY_AFTER", 2),
+			MaxDelay:        time.Duration(getEnvAsInt("LOGIN_MAX_DELAY_S", 8)) * time.Second,
+			MaxIPFailures:   getEnvAsInt("LOGIN_MAX_IP_FAILURES", 50),
+			RateLimitPerMin: getEnvAsInt("AUTH_RATE_LIMIT_PER_MIN", 30),
+		},
+		Password: PasswordConfig{
+			MinLength:     getEnvAsInt("PASSWORD_MIN_LENGTH", 10),
+			RequireUpper:  getEnvAsBool("PASSWORD_REQUIRE_UPPER", true),
+			RequireLower:  getEnvAsBool("PASSWORD_REQUIRE_LOWER", true),
+			RequireDigit:  getEnvAsBool("PASSWORD_REQUIRE_DIGIT", true),
+			RequireSymbol: getEnvAsBool("PASSWORD_REQUIRE_SYMBOL", false),
+			HistorySize:   getEnvAsInt("PASSWORD_HISTORY_SIZE", 5),
+			MaxAge:        time.Duration(getEnvAsInt("PASSWORD_MAX_AGE_DAYS", 0)) * 24 * time.Hour,
+		},
+		RBAC: RBACConfig{
+			CacheTTL: time.Duration(getEnvAsInt("RBAC_CACHE_TTL_S", 300)) * time.Second,
+			PubSub:   getEnvAsBool("RBAC_CACHE_PUBSUB", true),
 		},
 		Scraper: ScraperConfig{
 			RespectRobots:   getEnvAsBool("SCRAPER_RESPECT_ROBOTS", true),
@@ -128,9 +213,27 @@ func Load() (*Config, error) {
 	return config, nil
 }
 
+// ValidateAPI checks settings the API server can't safely run without
+func (c *Config) ValidateAPI() error {
+	if c.App.Env == "production" && c.JWT.RejectDefaultSecret &&
+		c.JWT.KeyDir == "" && placeholderJWTSecrets[c.JWT.Secret] {
+		return fmt.Errorf("JWT_SECRET is still the default value; set a real secret or JWT_KEY_DIR before running in production")
+	}
+	if c.JWT.KeyDir != "" && c.JWT.ActiveKID == "" {
+		return fmt.Errorf("JWT_ACTIVE_KID is required when JWT_KEY_DIR is set")
+	}
+	for _, proxy := range c.App.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("TRUSTED_PROXIES: %q is not an IP or CIDR", proxy)
+		}
+	}
+	return nil
+}
+
 func setDefaults() {
 	viper.SetDefault("APP_ENV", "dev")
 	viper.SetDefault("APP_PORT", "8080")
+	viper.SetDefault("TRUSTED_PROXIES", "")
 	viper.SetDefault("POSTGRES_HOST", "localhost")
 	viper.SetDefault("POSTGRES_PORT", "5432")
 	viper.SetDefault("POSTGRES_USER", "ees_user")
@@ -140,10 +243,34 @@ func setDefaults() {
 	viper.SetDefault("REDIS_ADDR", "localhost:6379")
 	viper.SetDefault("REDIS_PASSWORD", "")
 	viper.SetDefault("REDIS_DB", 0)
-	viper.SetDefault("JWT_SECRET", "change-me-in-production")
+	viper.SetDefault("JWT_SECRET", DefaultJWTSecret)
 	viper.SetDefault("JWT_ACCESS_TTL_MIN", 15)
 	viper.SetDefault("JWT_REFRESH_TTL_H", 168)
+	viper.SetDefault("JWT_KEY_DIR", "")
+	viper.SetDefault("JWT_ACTIVE_KID", "")
+	viper.SetDefault("JWT_REJECT_DEFAULT_SECRET", true)
 	viper.SetDefault("TOTP_ISSUER", "EESigorta")
+	viper.SetDefault("TOTP_PERIOD_S", 30)
+	viper.SetDefault("TOTP_SKEW", 1)
+	viper.SetDefault("TOTP_DIGITS", 6)
+	viper.SetDefault("TOTP_MAX_ATTEMPTS", 5)
+	viper.SetDefault("TOTP_ATTEMPT_WINDOW_MIN", 15)
+	viper.SetDefault("LOGIN_MAX_FAILURES", 5)
+	viper.SetDefault("LOGIN_FAILURE_WINDOW_MIN", 15)
+	viper.SetDefault("LOGIN_LOCKOUT_MIN", 15)
+	viper.SetDefault("LOGIN_DELAY_AFTER", 2)
+	viper.SetDefault("LOGIN_MAX_DELAY_S", 8)
+	viper.SetDefault("LOGIN_MAX_IP_FAILURES", 50)
+	viper.SetDefault("AUTH_RATE_LIMIT_PER_MIN", 30)
+	viper.SetDefault("PASSWORD_MIN_LENGTH", 10)
+	viper.SetDefault("PASSWORD_REQUIRE_UPPER", true)
+	viper.SetDefault("PASSWORD_REQUIRE_LOWER", true)
+	viper.SetDefault("PASSWORD_REQUIRE_DIGIT", true)
+	viper.SetDefault("PASSWORD_REQUIRE_SYMBOL", false)
+	viper.SetDefault("PASSWORD_HISTORY_SIZE", 5)
+	viper.SetDefault("PASSWORD_MAX_AGE_DAYS", 0)
+	viper.SetDefault("RBAC_CACHE_TTL_S", 300)
+	viper.SetDefault("RBAC_CACHE_PUBSUB", true)
 	viper.SetDefault("SCRAPER_RESPECT_ROBOTS", true)
 	viper.SetDefault("SCRAPER_DEFAULT_DELAY_MS", 1250)
 	viper.SetDefault("SCRAPER_MAX_RETRY", 5)
@@ -171,6 +298,17 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvAsList splits a comma-separated variable, empty when unset
+func getEnvAsList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func getEnvAsBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolValue, err := strconv.ParseBool(value); err == nil {
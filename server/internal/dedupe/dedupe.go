@@ -0,0 +1,193 @@
+// Package dedupe finds customers that are likely the same person or
+// company, matched by normalized phone, email, similar name and birth date.
+// A customer's pairs are scored whenever it's saved and stored, so listing
+// duplicates is a query rather than a comparison of every customer.
+package dedupe
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"eesigorta/backend/internal/repo"
+	"eesigorta/backend/internal/scraper"
+)
+
+// Match weights, out of 100. A name alone isn't a likely match, but a name
+// together with any other signal is.
+const (
+	phoneScore     = 30
+	emailScore     = 30
+	birthDateScore = 15
+	nameScore      = 40
+
+	// minNameSimilarity is how close two names must be to count, e.g.
+	// "Mehmet Yılmaz" and "Mehmet Yilmaz" or "Yılmaz Mehmet"
+	minNameSimilarity = 0.8
+
+	// MinScore is the lowest score a pair is stored with. Pairs sharing only
+	// a birth date score less and are too many to keep.
+	MinScore = phoneScore
+
+	// maxNameGroup skips names shared by more customers than this, e.g.
+	// common ones, which would only add noise
+	maxNameGroup = 200
+
+	// backfillBatch is how many customers Backfill loads at once
+	backfillBatch = 500
+)
+
+// keys is a customer's data normalized for comparison
+type keys struct {
+	repo.DuplicateKeys
+	birthDate string
+}
+
+func keysOf(customer *repo.Customer) keys {
+	k := keys{}
+	k.Email = strings.ToLower(strings.TrimSpace(customer.Email))
+	// Shorter numbers are too incomplete to match on
+	if phone := scraper.NormalizePhone(customer.Phone); len(phone) >= 10 {
+		k.Phone = phone
+	}
+	if customer.BirthDate != nil {
+		k.birthDate = customer.BirthDate.Format("2006-01-02")
+	}
+
+	// Name parts are sorted, so a surname typed first still matches
+	parts := strings.Fields(foldTurkish(customer.Name))
+	sort.Strings(parts)
+	k.Name = strings.Join(parts, " ")
+	return k
+}
+
+// Refresh rescores the customer against the customers sharing its phone,
+// email, name or birth date and stores the pairs scoring at least MinScore,
+// replacing the ones it had. Call it after the customer is saved.
+func Refresh(repository *repo.Repository, customer *repo.Customer) error {
+	k := keysOf(customer)
+	candidates, err := repository.DuplicateCandidates(customer, k.DuplicateKeys, maxNameGroup)
+	if err != nil {
+		return err
+	}
+
+	var pairs []repo.CustomerDuplicate
+	for i := range candidates {
+		other := keysOf(&candidates[i])
+		score, reasons := scorePair(&k, &other)
+		if score < MinScore {
+			continue
+		}
+		// The older customer comes first
+		pair := repo.CustomerDuplicate{CustomerID: customer.ID, DuplicateID: candidates[i].ID, Score: score, Reasons: strings.Join(reasons, ",")}
+		if pair.DuplicateID < pair.CustomerID {
+			pair.CustomerID, pair.DuplicateID = pair.DuplicateID, pair.CustomerID
+		}
+		pairs = append(pairs, pair)
+	}
+	return repository.ReplaceCustomerDuplicates(customer.ID, k.DuplicateKeys, pairs)
+}
+
+// Backfill scores the customers saved since their duplicates were last
+// scored, e.g. ones saved before pairs were stored or whose refresh failed
+func Backfill(repository *repo.Repository) error {
+	var afterID uint
+	checked := 0
+	for {
+		customers, err := repository.CustomersToCheckForDuplicates(afterID, backfillBatch)
+		if err != nil {
+			return err
+		}
+		if len(customers) == 0 {
+			break
+		}
+		for i := range customers {
+			if err := Refresh(repository, &customers[i]); err != nil {
+				return err
+			}
+		}
+		checked += len(customers)
+		afterID = customers[len(customers)-1].ID
+	}
+	if checked > 0 {
+		log.Printf("Scored duplicates of %d customers", checked)
+	}
+	return nil
+}
+
+// scorePair scores how likely two customers are the same, out of 100, and
+// names the fields that matched
+func scorePair(a, b *keys) (int, []string) {
+	score := 0
+	reasons := []string{}
+	if a.Phone != "" && a.Phone == b.Phone {
+		score += phoneScore
+		reasons = append(reasons, "phone")
+	}
+	if a.Email != "" && a.Email == b.Email {
+		score += emailScore
+		reasons = append(reasons, "email")
+	}
+	if a.birthDate != "" && a.birthDate == b.birthDate {
+		score += birthDateScore
+		reasons = append(reasons, "birth_date")
+	}
+	if similarity := nameSimilarity(a.Name, b.Name); similarity >= minNameSimilarity {
+		score += int(math.Round(similarity * nameScore))
+		reasons = append(reasons, "name")
+	}
+	return min(score, 100), reasons
+}
+
+// nameSimilarity is 1 for equal names and falls towards 0 with the edit
+// distance between them
+func nameSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// turkishFold maps Turkish letters to their closest ASCII ones, since the
+// same name gets typed both ways
+var turkishFold = strings.NewReplacer(
+	"ç", "c", "ğ", "g", "ı", "i", "ö", "o", "ş", "s", "ü", "u",
+	"â", "a", "î", "i", "û", "u",
+)
+
+// foldTurkish lowercases a name the Turkish way (I to ı, İ to i), folds it
+// to ASCII and drops punctuation
+func foldTurkish(name string) string {
+	lower := strings.ToLowerSpecial(unicode.TurkishCase, name)
+	folded := turkishFold.Replace(lower)
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return ' '
+	}, folded)
+}
@@ -0,0 +1,341 @@
+// Package filter parses and applies the filters and sort order of list
+// endpoints. Each list has a Schema naming the fields it can be filtered
+// and sorted by, and every list reads them from the query string the same
+// way:
+//
+//	city=İstanbul               equal, case-insensitively for text fields
+//	city=İstanbul,Ankara        any of the values
+//	birth_date[gte]=1980-01-01  ranges with gt, gte, lt and lte
+//	query=ali veli              free text search
+//	sort=-created_at,name       sort by the fields, descending with a -
+//
+// Parameters the schema doesn't know, like page, are left to the handler.
+package filter
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Kind is the type of a field's values, it decides how they're parsed and
+// which operators they take
+type Kind int
+
+const (
+	Text Kind = iota // compared with Turkish-aware case folding
+	Enum             // compared exactly
+	ID               // positive integer, equal only
+	Int
+	Bool
+	Date // YYYY-MM-DD or RFC 3339
+)
+
+// Op is a comparison operator
+type Op string
+
+const (
+	OpEq  Op = "eq"
+	OpIn  Op = "in" // comma-separated values
+	OpGt  Op = "gt"
+	OpGte Op = "gte"
+	OpLt  Op = "lt"
+	OpLte Op = "lte"
+)
+
+var operators = map[Op]string{OpEq: "=", OpIn: "IN", OpGt: ">", OpGte: ">=", OpLt: "<", OpLte: "<="}
+
+// Field is a field a list can be filtered or sorted by
+type Field struct {
+	Kind Kind
+	// Column is the column compared and sorted by, for fields stored in the
+	// list's table
+	Column string
+	// Where applies a condition on the field instead of Column, for fields
+	// looked up elsewhere, e.g. in a related table
+	Where func(db *gorm.DB, cond Condition) *gorm.DB
+	// Values limits an Enum field, any value is accepted when empty
+	Values   []string
+	Sortable bool
+	// Denied, when set, rejects filtering and sorting by the field with
+	// this message, e.g. for data the user may not see
+	Denied string
+}
+
+// Condition is one filter of a request. Value is a string, int64, uint,
+// bool or time.Time as the field's kind has it, or a slice of them for
+// OpIn. Text values are already folded.
+type Condition struct {
+	Field string
+	Op    Op
+	Value interface{}
+}
+
+// SQL gives the condition's comparison of the expression, e.g. "city = ?"
+func (c Condition) SQL(expr string) string {
+	if c.Op == OpIn {
+		return expr + " IN ?"
+	}
+	return expr + " " + operators[c.Op] + " ?"
+}
+
+// Sort is one field to sort a list by
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// Query is the parsed filters, search and sort order of a list request
+type Query struct {
+	Search     string
+	Conditions []Condition
+	Sort       []Sort
+}
+
+// Schema describes how one list is filtered, searched and sorted
+type Schema struct {
+	Fields map[string]Field
+	// Search applies the free text search, a list without it ignores the
+	// query parameter
+	Search func(db *gorm.DB, term string) *gorm.DB
+	// DefaultSort orders requests that don't set a sort
+	DefaultSort []Sort
+	// Key is a unique column ending every order, so pages don't overlap
+	Key string
+}
+
+// Error is a filter or sort parameter that can't be applied
+type Error struct {
+	Param   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Param + ": " + e.Message
+}
+
+// Parse reads the filters, search and sort order from a request's query
+// string. Empty filters are ignored, so forms can send every field.
+func (s Schema) Parse(values url.Values) (Query, error) {
+	var q Query
+	q.Search = strings.TrimSpace(values.Get("query"))
+
+	params := make([]string, 0, len(values))
+	for param := range values {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+
+	for _, param := range params {
+		if param == "sort" {
+			sorts, err := s.parseSort(values.Get(param))
+			if err != nil {
+				return Query{}, err
+			}
+			q.Sort = sorts
+			continue
+		}
+
+		name, op := param, OpEq
+		if open := strings.IndexByte(param, '['); open > 0 && strings.HasSuffix(param, "]") {
+			name, op = param[:open], Op(param[open+1:len(param)-1])
+		}
+		field, ok := s.Fields[name]
+		if !ok {
+			if name != param {
+				return Query{}, &Error{Param: param, Message: "unknown filter"}
+			}
+			continue
+		}
+		if field.Denied != "" {
+			return Query{}, &Error{Param: param, Message: field.Denied}
+		}
+
+		var raw []string
+		for _, value := range values[param] {
+			for _, part := range strings.Split(value, ",") {
+				if part = strings.TrimSpace(part); part != "" {
+					raw = append(raw, part)
+				}
+			}
+		}
+		if len(raw) == 0 {
+			continue
+		}
+
+		conds, err := parseCondition(name, field, op, raw)
+		if err != nil {
+			return Query{}, &Error{Param: param, Message: err.Error()}
+		}
+		q.Conditions = append(q.Conditions, conds...)
+	}
+
+	return q, nil
+}
+
+func (s Schema) parseSort(value string) ([]Sort, error) {
+	var sorts []Sort
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		sort := Sort{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+		field, ok := s.Fields[sort.Field]
+		if ok && field.Denied != "" {
+			return nil, &Error{Param: "sort", Message: fmt.Sprintf("can't sort by %q: %s", sort.Field, field.Denied)}
+		}
+		if !ok || !field.Sortable || field.Column == "" {
+			return nil, &Error{Param: "sort", Message: fmt.Sprintf("can't sort by %q, sortable fields are %s", sort.Field, strings.Join(s.sortable(), ", "))}
+		}
+		sorts = append(sorts, sort)
+	}
+	return sorts, nil
+}
+
+func (s Schema) sortable() []string {
+	var names []string
+	for name, field := range s.Fields {
+		if field.Sortable && field.Column != "" && field.Denied == "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseCondition parses the values of one filter. Dates without a time
+// cover the whole day, so created_at[lte]=2026-01-31 includes that day and
+// created_at=2026-01-31 is any time on it.
+func parseCondition(name string, field Field, op Op, raw []string) ([]Condition, error) {
+	if _, ok := operators[op]; !ok || op == OpIn {
+		return nil, fmt.Errorf("unknown operator %q, use gt, gte, lt or lte", op)
+	}
+	ranged := field.Kind == Int || field.Kind == Date
+	if op != OpEq && !ranged {
+		return nil, fmt.Errorf("only takes equality")
+	}
+	if len(raw) > 1 && (op != OpEq || field.Kind == Bool || field.Kind == Date) {
+		return nil, fmt.Errorf("takes a single value")
+	}
+
+	parsed := make([]interface{}, 0, len(raw))
+	dateOnly := false
+	for _, value := range raw {
+		v, err := parseValue(field, value)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, v)
+		dateOnly = field.Kind == Date && !strings.Contains(value, "T")
+	}
+
+	if dateOnly {
+		day := parsed[0].(time.Time)
+		next := day.AddDate(0, 0, 1)
+		switch op {
+		case OpEq:
+			return []Condition{{Field: name, Op: OpGte, Value: day}, {Field: name, Op: OpLt, Value: next}}, nil
+		case OpGt:
+			return []Condition{{Field: name, Op: OpGte, Value: next}}, nil
+		case OpLte:
+			return []Condition{{Field: name, Op: OpLt, Value: next}}, nil
+		}
+	}
+	if len(parsed) > 1 {
+		return []Condition{{Field: name, Op: OpIn, Value: parsed}}, nil
+	}
+	return []Condition{{Field: name, Op: op, Value: parsed[0]}}, nil
+}
+
+func parseValue(field Field, value string) (interface{}, error) {
+	switch field.Kind {
+	case Text:
+		return Fold(value), nil
+	case Enum:
+		if len(field.Values) > 0 && !contains(field.Values, value) {
+			return nil, fmt.Errorf("must be one of %s", strings.Join(field.Values, ", "))
+		}
+		return value, nil
+	case ID:
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("%q is not an ID", value)
+		}
+		return uint(id), nil
+	case Int:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", value)
+		}
+		return n, nil
+	case Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("must be true or false")
+		}
+		return b, nil
+	case Date:
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a date, use YYYY-MM-DD or RFC 3339", value)
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("can't be filtered")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply narrows a query to the request's search and filters
+func (s Schema) Apply(db *gorm.DB, q Query) *gorm.DB {
+	if q.Search != "" && s.Search != nil {
+		db = s.Search(db, q.Search)
+	}
+	for _, cond := range q.Conditions {
+		field := s.Fields[cond.Field]
+		switch {
+		case field.Where != nil:
+			db = field.Where(db, cond)
+		case field.Kind == Text:
+			db = db.Where(cond.SQL(FoldColumn(db, field.Column)), cond.Value)
+		default:
+			db = db.Where(cond.SQL(field.Column), cond.Value)
+		}
+	}
+	return db
+}
+
+// Order sorts a query as the request asks, or by the schema's default
+func (s Schema) Order(db *gorm.DB, q Query) *gorm.DB {
+	sorts := q.Sort
+	if len(sorts) == 0 {
+		sorts = s.DefaultSort
+	}
+	for _, sort := range sorts {
+		column := s.Fields[sort.Field].Column
+		if sort.Desc {
+			column += " DESC"
+		}
+		db = db.Order(column)
+	}
+	if s.Key != "" {
+		db = db.Order(s.Key)
+	}
+	return db
+}
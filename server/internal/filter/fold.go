@@ -0,0 +1,59 @@
+package filter
+
+import (
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// dotted folds the Turkish i's before lowering, since neither İ nor I lower
+// to the i a user types everywhere
+var dotted = strings.NewReplacer("İ", "i", "I", "i", "ı", "i")
+
+// Fold lowers a value for matching the Turkish way: İ, I, ı and i are all
+// i, so "İSTANBUL", "Istanbul" and "istanbul" match. The tr_fold function
+// of the database schema folds columns the same way.
+func Fold(value string) string {
+	return strings.ToLower(dotted.Replace(value))
+}
+
+// sqliteFold are the replacements SQLite needs, its lower() only knows
+// ASCII letters
+var sqliteFold = [][2]string{
+	{"İ", "i"}, {"I", "i"}, {"ı", "i"},
+	{"Ç", "ç"}, {"Ğ", "ğ"}, {"Ö", "ö"}, {"Ş", "ş"}, {"Ü", "ü"},
+}
+
+// FoldColumn is the SQL expression folding a column like Fold. Postgres has
+// tr_fold, served by expression indexes; other databases, used in tests and
+// development, replace the letters inline.
+func FoldColumn(db *gorm.DB, column string) string {
+	if db.Dialector.Name() == "postgres" {
+		return "tr_fold(" + column + ")"
+	}
+	expr := column
+	for _, r := range sqliteFold {
+		expr = "REPLACE(" + expr + ", '" + r[0] + "', '" + r[1] + "')"
+	}
+	return "LOWER(" + expr + ")"
+}
+
+// Terms splits a search into folded words, dropping the punctuation
+// full-text queries give a meaning to. Characters addresses are written
+// with, like @ . + and -, are kept.
+func Terms(search string) []string {
+	return strings.FieldsFunc(Fold(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("@.+-_", r)
+	})
+}
+
+// PrefixTSQuery is a Postgres tsquery matching documents with words
+// starting with every term, so "ali yıl" finds Ali Yılmaz
+func PrefixTSQuery(terms []string) string {
+	lexemes := make([]string, len(terms))
+	for i, term := range terms {
+		lexemes[i] = "'" + term + "':*"
+	}
+	return strings.Join(lexemes, " & ")
+}
@@ -0,0 +1,386 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"eesigorta/backend/internal/dedupe"
+	"eesigorta/backend/internal/repo"
+	"eesigorta/backend/internal/validation"
+)
+
+// progressEvery is how many rows go by between progress updates of a
+// running import
+const progressEvery = 100
+
+// Fields are the customer fields a column can be mapped to
+var Fields = []string{
+	"tc_vkn", "customer_type", "name", "email", "phone", "address", "city",
+	"district", "postal_code", "birth_date", "gender", "tax_office",
+	"trade_title", "authorized_person",
+}
+
+// Mapping maps customer fields to the column headers holding them. Columns
+// headed with a field's own name are picked up without being mapped.
+type Mapping map[string]string
+
+// ParseMapping reads a mapping given as a JSON object, empty meaning none
+func ParseMapping(data string) (Mapping, error) {
+	mapping := Mapping{}
+	if strings.TrimSpace(data) == "" {
+		return mapping, nil
+	}
+	if err := json.Unmarshal([]byte(data), &mapping); err != nil {
+		return nil, errors.New("mapping must be a JSON object of field names to column headers")
+	}
+	return mapping, nil
+}
+
+// Columns resolves the mapping against a header into the column index of
+// each field. Every mapped column must exist and tc_vkn must have one.
+func (m Mapping) Columns(header []string) (map[string]int, error) {
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		key := headerKey(name)
+		if _, ok := index[key]; !ok && key != "" {
+			index[key] = i
+		}
+	}
+
+	columns := make(map[string]int)
+	for field, name := range m {
+		if !slices.Contains(Fields, field) {
+			return nil, fmt.Errorf("unknown field %q in mapping, fields are %s", field, strings.Join(Fields, ", "))
+		}
+		i, ok := index[headerKey(name)]
+		if !ok {
+			return nil, fmt.Errorf("column %q mapped to %s isn't in the file", name, field)
+		}
+		columns[field] = i
+	}
+	for _, field := range Fields {
+		if _, mapped := m[field]; mapped {
+			continue
+		}
+		if i, ok := index[headerKey(field)]; ok {
+			columns[field] = i
+		}
+	}
+
+	if _, ok := columns["tc_vkn"]; !ok {
+		return nil, errors.New("no column holds tc_vkn, map one to it")
+	}
+	return columns, nil
+}
+
+// headerKey compares headers case-insensitively, whether they were typed
+// with Turkish casing or not
+func headerKey(name string) string {
+	return foldCase(strings.TrimSpace(name))
+}
+
+// foldCase lowercases with Turkish casing, then drops the dot distinction
+// so "CITY", "City" and "cıty" all come out as "city"
+func foldCase(value string) string {
+	return strings.ReplaceAll(strings.ToLowerSpecial(unicode.TurkishCase, value), "ı", "i")
+}
+
+// RowError is a row that couldn't be imported and why. Fields holds
+// validation problems, Error anything else.
+type RowError struct {
+	Line   int                    `json:"line"`
+	TCVKN  string                 `json:"tc_vkn,omitempty"`
+	Fields validation.FieldErrors `json:"fields,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// Report reads the failed rows stored on an import
+func Report(customerImport *repo.CustomerImport) []RowError {
+	var report []RowError
+	if customerImport.ReportJSON != "" {
+		json.Unmarshal([]byte(customerImport.ReportJSON), &report)
+	}
+	return report
+}
+
+// Run imports the customers in an import's file, upserting by TC/VKN, and
+// stores the outcome on the import. Each row is validated like a customer
+// saved through the API and saved on its own, so a bad row only fails
+// itself. A dry run validates without saving anything. The error is for
+// imports that couldn't run at all; the import is marked failed then.
+func Run(repository *repo.Repository, customerImport *repo.CustomerImport) error {
+	started := time.Now()
+	customerImport.Status = repo.ImportRunning
+	customerImport.StartedAt = &started
+	if err := repository.UpdateCustomerImport(customerImport); err != nil {
+		return err
+	}
+
+	err := run(repository, customerImport)
+	finished := time.Now()
+	customerImport.FinishedAt = &finished
+	customerImport.File = nil
+	customerImport.Status = repo.ImportCompleted
+	if err != nil {
+		customerImport.Status = repo.ImportFailed
+		customerImport.ErrorMsg = err.Error()
+	}
+	if saveErr := repository.UpdateCustomerImport(customerImport); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+func run(repository *repo.Repository, customerImport *repo.CustomerImport) error {
+	sheet, err := ReadSheet(customerImport.Format, customerImport.File)
+	if err != nil {
+		return err
+	}
+	mapping, err := ParseMapping(customerImport.MappingJSON)
+	if err != nil {
+		return err
+	}
+	columns, err := mapping.Columns(sheet.Header)
+	if err != nil {
+		return err
+	}
+
+	identifiers := make([]string, 0, len(sheet.Rows))
+	for _, row := range sheet.Rows {
+		if tcvkn := normalizeTCVKN(row.Value(columns["tc_vkn"])); tcvkn != "" {
+			identifiers = append(identifiers, tcvkn)
+		}
+	}
+	found, err := repository.GetCustomersByTCVKN(identifiers)
+	if err != nil {
+		return fmt.Errorf("failed to load existing customers: %w", err)
+	}
+	existing := make(map[string]*repo.Customer, len(found))
+	ids := make([]uint, 0, len(found))
+	for i := range found {
+		existing[found[i].TCVKN] = &found[i]
+		ids = append(ids, found[i].ID)
+	}
+
+	// The import acts for the user who started it: new customers are theirs
+	// and existing ones outside their data scope can't be updated
+	scope, err := repository.GetDataScope(customerImport.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to load the data scope: %w", err)
+	}
+	var scopedIDs []uint
+	if len(ids) > 0 {
+		err = repository.ScopedCustomers(scope).Unscoped().Where("customers.id IN ?", ids).Pluck("customers.id", &scopedIDs).Error
+		if err != nil {
+			return fmt.Errorf("failed to load existing customers: %w", err)
+		}
+	}
+
+	customerImport.TotalRows = len(sheet.Rows)
+	customerImport.ProcessedRows = 0
+	customerImport.Created, customerImport.Updated, customerImport.Unchanged, customerImport.Failed = 0, 0, 0, 0
+
+	var report []RowError
+	seen := make(map[string]int)
+	now := time.Now()
+
+	for i, row := range sheet.Rows {
+		tcvkn := normalizeTCVKN(row.Value(columns["tc_vkn"]))
+		rowErr := RowError{Line: row.Line, TCVKN: tcvkn}
+		errs := validation.FieldErrors{}
+
+		customer := repo.Customer{OwnerID: &customerImport.UserID, BranchID: scope.BranchID}
+		current, exists := existing[tcvkn]
+		if exists {
+			customer = *current
+		}
+		if line, ok := seen[tcvkn]; ok && tcvkn != "" {
+			errs.Add("tc_vkn", fmt.Sprintf("is already in row %d", line))
+		} else if exists && !slices.Contains(scopedIDs, current.ID) {
+			errs.Add("tc_vkn", "belongs to a customer outside your data scope")
+		} else if exists && current.DeletedAt.Valid {
+			errs.Add("tc_vkn", "belongs to a deleted customer")
+		}
+		if tcvkn != "" {
+			seen[tcvkn] = row.Line
+		}
+
+		applyRow(&customer, row, columns, exists, errs)
+		if len(errs) == 0 {
+			if err := validation.ValidateCustomer(&customer, now); err != nil {
+				var fieldErrs validation.FieldErrors
+				if !errors.As(err, &fieldErrs) {
+					return err
+				}
+				errs = fieldErrs
+			}
+		}
+
+		switch {
+		case len(errs) > 0:
+			rowErr.Fields = errs
+			report = append(report, rowErr)
+			customerImport.Failed++
+
+		case exists:
+			changes := repo.AuditDiff(current, customer)
+			if len(changes) == 0 {
+				customerImport.Unchanged++
+				break
+			}
+			if !customerImport.DryRun {
+				// The row is saved together with its audit entry or not at all
+				if err := repository.SaveCustomerAudited(&customer, importAuditLog(customerImport, row, "customer_updated", changes)); err != nil {
+					rowErr.Error = "could not be saved"
+					report = append(report, rowErr)
+					customerImport.Failed++
+					log.Printf("Import %d: failed to update customer %d from row %d: %v", customerImport.ID, customer.ID, row.Line, err)
+					break
+				}
+				refreshDuplicates(repository, customerImport, &customer)
+				*current = customer
+			}
+			customerImport.Updated++
+
+		default:
+			if !customerImport.DryRun {
+				if err := repository.SaveCustomerAudited(&customer, importAuditLog(customerImport, row, "customer_created", nil)); err != nil {
+					rowErr.Error = "could not be saved"
+					report = append(report, rowErr)
+					customerImport.Failed++
+					log.Printf("Import %d: failed to create customer from row %d: %v", customerImport.ID, row.Line, err)
+					break
+				}
+				refreshDuplicates(repository, customerImport, &customer)
+			}
+			customerImport.Created++
+		}
+
+		customerImport.ProcessedRows = i + 1
+		if customerImport.ProcessedRows%progressEvery == 0 {
+			if err := repository.UpdateCustomerImportProgress(customerImport.ID, customerImport.ProcessedRows); err != nil {
+				log.Printf("Import %d: failed to record progress: %v", customerImport.ID, err)
+			}
+		}
+	}
+
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	customerImport.ReportJSON = string(reportJSON)
+	return nil
+}
+
+// applyRow sets the customer's mapped fields from the row. Updates leave a
+// field alone when its cell is empty, so a file with a few columns filled
+// in doesn't wipe out the rest.
+func applyRow(customer *repo.Customer, row Row, columns map[string]int, update bool, errs validation.FieldErrors) {
+	targets := map[string]*string{
+		"tc_vkn":            &customer.TCVKN,
+		"customer_type":     &customer.CustomerType,
+		"name":              &customer.Name,
+		"email":             &customer.Email,
+		"phone":             &customer.Phone,
+		"address":           &customer.Address,
+		"city":              &customer.City,
+		"district":          &customer.District,
+		"postal_code":       &customer.PostalCode,
+		"gender":            &customer.Gender,
+		"tax_office":        &customer.TaxOffice,
+		"trade_title":       &customer.TradeTitle,
+		"authorized_person": &customer.AuthorizedPerson,
+	}
+
+	for field, column := range columns {
+		value := row.Value(column)
+		if value == "" && update {
+			continue
+		}
+
+		switch field {
+		case "tc_vkn":
+			customer.TCVKN = normalizeTCVKN(value)
+		case "customer_type", "gender":
+			*targets[field] = foldCase(value)
+		case "birth_date":
+			if value == "" {
+				customer.BirthDate = nil
+				continue
+			}
+			birthDate, err := parseDate(value)
+			if err != nil {
+				errs.Add("birth_date", err.Error())
+				continue
+			}
+			customer.BirthDate = &birthDate
+		default:
+			*targets[field] = value
+		}
+	}
+}
+
+// normalizeTCVKN drops the spaces and, for identifiers a spreadsheet turned
+// into numbers, the fraction
+func normalizeTCVKN(value string) string {
+	value = strings.Join(strings.Fields(value), "")
+	return strings.TrimSuffix(value, ".0")
+}
+
+// dateLayouts are the date formats accepted besides Excel serial dates
+var dateLayouts = []string{"2006-01-02", "2.1.2006", "2/1/2006"}
+
+// parseDate reads a date as written in Turkey or as ISO 8601, or as the
+// serial number Excel stores dates as when the cell isn't formatted
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	if serial, err := strconv.ParseFloat(value, 64); err == nil && serial >= 1 && serial < 2958466 {
+		excelEpoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+		return excelEpoch.AddDate(0, 0, int(serial)), nil
+	}
+	return time.Time{}, errors.New("must be a date as YYYY-MM-DD or DD.MM.YYYY")
+}
+
+// refreshDuplicates rescores a saved customer's duplicate pairs. A failure
+// doesn't fail the row, the hourly duplicate scoring job retries it.
+func refreshDuplicates(repository *repo.Repository, customerImport *repo.CustomerImport, customer *repo.Customer) {
+	if err := dedupe.Refresh(repository, customer); err != nil {
+		log.Printf("Import %d: failed to score duplicates of customer %d: %v", customerImport.ID, customer.ID, err)
+	}
+}
+
+// importAuditLog records a customer an import saved, as the importing user.
+// The entry is given the customer's ID when it's saved.
+func importAuditLog(customerImport *repo.CustomerImport, row Row, action string, changes map[string]repo.AuditChange) repo.AuditLog {
+	userID := customerImport.UserID
+	meta, _ := json.Marshal(map[string]interface{}{
+		"source":    "import",
+		"import_id": customerImport.ID,
+		"line":      row.Line,
+	})
+
+	auditLog := repo.AuditLog{
+		UserID:   &userID,
+		Action:   action,
+		Entity:   "customer",
+		MetaJSON: string(meta),
+	}
+	if len(changes) > 0 {
+		if data, err := json.Marshal(changes); err == nil {
+			changesJSON := string(data)
+			auditLog.ChangesJSON = &changesJSON
+		}
+	}
+	return auditLog
+}
@@ -0,0 +1,345 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Spreadsheet formats that can be imported
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// Row is a data row with its line number as the user sees it in the
+// spreadsheet, the header being line 1
+type Row struct {
+	Line   int
+	Values []string
+}
+
+// Value is the cell in the given column, empty when the row is shorter
+func (r Row) Value(column int) string {
+	if column < 0 || column >= len(r.Values) {
+		return ""
+	}
+	return strings.TrimSpace(r.Values[column])
+}
+
+// Sheet is a spreadsheet's header and data rows. Blank rows are left out.
+type Sheet struct {
+	Header []string
+	Rows   []Row
+}
+
+func (s *Sheet) add(line int, values []string) {
+	blank := true
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			blank = false
+			break
+		}
+	}
+	if blank {
+		return
+	}
+
+	if s.Header == nil {
+		s.Header = make([]string, len(values))
+		for i, value := range values {
+			s.Header[i] = strings.TrimSpace(value)
+		}
+		return
+	}
+	s.Rows = append(s.Rows, Row{Line: line, Values: values})
+}
+
+// DetectFormat tells CSV and XLSX files apart by their extension, or by
+// their content when the name doesn't say: XLSX files are zip archives
+func DetectFormat(name string, data []byte) (string, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".csv", ".txt":
+		return FormatCSV, nil
+	case ".xlsx":
+		return FormatXLSX, nil
+	case ".xls":
+		return "", errors.New("old .xls files aren't supported, save the file as .xlsx or .csv")
+	}
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return FormatXLSX, nil
+	}
+	return FormatCSV, nil
+}
+
+// ReadSheet reads a CSV file or the first worksheet of an XLSX file
+func ReadSheet(format string, data []byte) (*Sheet, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(data)
+	case FormatXLSX:
+		return readXLSX(data)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// readCSV reads comma, semicolon or tab separated values. Excel in Turkish
+// saves with semicolons and, unless told otherwise, in Windows-1254.
+func readCSV(data []byte) (*Sheet, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		data = decodeWindows1254(data)
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = csvDelimiter(data)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	sheet := &Sheet{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		sheet.add(line, record)
+	}
+	return sheet, nil
+}
+
+// csvDelimiter picks the separator used most in the first line
+func csvDelimiter(data []byte) rune {
+	first := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		first = data[:i]
+	}
+
+	delimiter, most := ',', bytes.Count(first, []byte{','})
+	for _, candidate := range []rune{';', '\t'} {
+		if n := bytes.Count(first, []byte(string(candidate))); n > most {
+			delimiter, most = candidate, n
+		}
+	}
+	return delimiter
+}
+
+// windows1254 maps the bytes where Windows-1254 differs from Latin-1
+var windows1254 = map[byte]rune{
+	0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡',
+	0x88: 'ˆ', 0x89: '‰', 0x8A: 'Š', 0x8B: '‹', 0x8C: 'Œ', 0x91: '‘', 0x92: '’',
+	0x93: '“', 0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—', 0x98: '˜', 0x99: '™',
+	0x9A: 'š', 0x9B: '›', 0x9C: 'œ', 0x9F: 'Ÿ',
+	0xD0: 'Ğ', 0xDD: 'İ', 0xDE: 'Ş', 0xF0: 'ğ', 0xFD: 'ı', 0xFE: 'ş',
+}
+
+func decodeWindows1254(data []byte) []byte {
+	var decoded bytes.Buffer
+	decoded.Grow(len(data) + len(data)/4)
+	for _, b := range data {
+		if r, ok := windows1254[b]; ok {
+			decoded.WriteRune(r)
+		} else {
+			decoded.WriteRune(rune(b))
+		}
+	}
+	return decoded.Bytes()
+}
+
+// XLSX parts, only as far as reading cell values goes
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		RelationID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+// xlsxText is plain text, or rich text split into runs
+type xlsxText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.Text
+	}
+	var text strings.Builder
+	for _, run := range t.Runs {
+		text.WriteString(run.Text)
+	}
+	return text.String()
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Number int `xml:"r,attr"`
+		Cells  []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func readXLSX(data []byte) (*Sheet, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, errors.New("invalid XLSX file")
+	}
+	parts := make(map[string]*zip.File, len(archive.File))
+	for _, file := range archive.File {
+		parts[file.Name] = file
+	}
+
+	sheetPath, err := firstWorksheet(parts)
+	if err != nil {
+		return nil, err
+	}
+
+	var shared xlsxSharedStrings
+	if part, ok := parts["xl/sharedStrings.xml"]; ok {
+		if err := decodeXMLPart(part, &shared); err != nil {
+			return nil, err
+		}
+	}
+
+	var worksheet xlsxWorksheet
+	if err := decodeXMLPart(parts[sheetPath], &worksheet); err != nil {
+		return nil, err
+	}
+
+	sheet := &Sheet{}
+	for i, row := range worksheet.Rows {
+		line := row.Number
+		if line == 0 {
+			line = i + 1
+		}
+
+		var values []string
+		for j, cell := range row.Cells {
+			column := j
+			if cell.Ref != "" {
+				column = columnIndex(cell.Ref)
+			}
+			for len(values) <= column {
+				values = append(values, "")
+			}
+
+			switch cell.Type {
+			case "s":
+				index, err := strconv.Atoi(cell.Value)
+				if err != nil || index < 0 || index >= len(shared.Items) {
+					return nil, fmt.Errorf("invalid XLSX file: bad shared string in cell %s", cell.Ref)
+				}
+				values[column] = shared.Items[index].String()
+			case "inlineStr":
+				values[column] = cell.Inline.String()
+			case "b":
+				values[column] = map[string]string{"1": "TRUE", "0": "FALSE"}[cell.Value]
+			case "", "n":
+				values[column] = formatXLSXNumber(cell.Value)
+			default:
+				values[column] = cell.Value
+			}
+		}
+		sheet.add(line, values)
+	}
+	return sheet, nil
+}
+
+// firstWorksheet finds the part holding the workbook's first sheet
+func firstWorksheet(parts map[string]*zip.File) (string, error) {
+	const fallback = "xl/worksheets/sheet1.xml"
+
+	var workbook xlsxWorkbook
+	var relationships xlsxRelationships
+	workbookPart, ok := parts["xl/workbook.xml"]
+	relsPart, relsOK := parts["xl/_rels/workbook.xml.rels"]
+	if ok && relsOK && decodeXMLPart(workbookPart, &workbook) == nil &&
+		decodeXMLPart(relsPart, &relationships) == nil && len(workbook.Sheets) > 0 {
+		for _, relationship := range relationships.Relationships {
+			if relationship.ID != workbook.Sheets[0].RelationID {
+				continue
+			}
+			target := relationship.Target
+			if strings.HasPrefix(target, "/") {
+				target = strings.TrimPrefix(target, "/")
+			} else {
+				target = path.Join("xl", target)
+			}
+			if _, ok := parts[target]; ok {
+				return target, nil
+			}
+		}
+	}
+
+	if _, ok := parts[fallback]; ok {
+		return fallback, nil
+	}
+	return "", errors.New("invalid XLSX file: no worksheet found")
+}
+
+func decodeXMLPart(part *zip.File, v interface{}) error {
+	reader, err := part.Open()
+	if err != nil {
+		return fmt.Errorf("invalid XLSX file: %w", err)
+	}
+	defer reader.Close()
+
+	if err := xml.NewDecoder(reader).Decode(v); err != nil {
+		return fmt.Errorf("invalid XLSX file: %s: %w", part.Name, err)
+	}
+	return nil
+}
+
+// columnIndex turns a cell reference such as "AB12" into its 0-based column
+func columnIndex(ref string) int {
+	column := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = column*26 + int(r-'A'+1)
+	}
+	return column - 1
+}
+
+// formatXLSXNumber writes whole numbers without a fraction or exponent, so
+// identifiers and phone numbers stored as numbers read back as typed
+func formatXLSXNumber(value string) string {
+	if !strings.ContainsAny(value, ".eE") {
+		return value
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f != math.Trunc(f) || math.Abs(f) >= 1e15 {
+		return value
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
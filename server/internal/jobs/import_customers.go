@@ -0,0 +1,76 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"eesigorta/backend/internal/importer"
+	"eesigorta/backend/internal/repo"
+
+	"github.com/hibiken/asynq"
+)
+
+const (
+	TypeImportCustomers = "customers:import"
+)
+
+type ImportCustomersPayload struct {
+	ImportID uint `json:"import_id"`
+}
+
+// NewImportCustomersTask creates a new task to run a customer import
+func NewImportCustomersTask(importID uint) (*asynq.Task, error) {
+	payload, err := json.Marshal(ImportCustomersPayload{ImportID: importID})
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeImportCustomers, payload), nil
+}
+
+// EnqueueImportCustomers queues a customer import too large to run within
+// the request. It isn't retried: rows saved before a failure stay saved, so
+// the user re-submits the file instead, which upserts the same rows again.
+func (c *Client) EnqueueImportCustomers(importID uint) error {
+	task, err := NewImportCustomersTask(importID)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.client.Enqueue(task, asynq.Queue("default"), asynq.MaxRetry(0), asynq.Timeout(time.Hour))
+	return err
+}
+
+// HandleImportCustomers adapts HandleImportCustomersTask to the asynq handler signature
+func (jm *JobManager) HandleImportCustomers(ctx context.Context, t *asynq.Task) error {
+	return HandleImportCustomersTask(ctx, t, jm.repo)
+}
+
+// HandleImportCustomersTask runs a queued customer import
+func HandleImportCustomersTask(ctx context.Context, t *asynq.Task, repository *repo.Repository) error {
+	var payload ImportCustomersPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+
+	customerImport, err := repository.GetCustomerImport(payload.ImportID)
+	if err != nil {
+		return fmt.Errorf("failed to get customer import: %w", err)
+	}
+	if customerImport.Status == repo.ImportCompleted || customerImport.Status == repo.ImportFailed {
+		log.Printf("Customer import %d already %s, skipping", customerImport.ID, customerImport.Status)
+		return nil
+	}
+
+	log.Printf("Starting customer import %d (%d rows, dry run: %v)", customerImport.ID, customerImport.TotalRows, customerImport.DryRun)
+	if err := importer.Run(repository, customerImport); err != nil {
+		log.Printf("Customer import %d failed: %v", customerImport.ID, err)
+		return err
+	}
+
+	log.Printf("Customer import %d completed: %d created, %d updated, %d unchanged, %d failed",
+		customerImport.ID, customerImport.Created, customerImport.Updated, customerImport.Unchanged, customerImport.Failed)
+	return nil
+}
@@ -51,9 +51,33 @@ type CleanupOldDataPayload struct {
 	DaysOld int `json:"days_old"`
 }
 
+// Client enqueues background jobs. The API server only needs this half of
+// the job system; the worker gets one through JobManager so handlers can
+// fan out follow-up jobs.
+type Client struct {
+	client *asynq.Client
+}
+
+func NewClient(cfg *config.Config) *Client {
+	return &Client{client: asynq.NewClient(redisClientOpt(cfg))}
+}
+
+func (c *Client) Close() error {
+	return c.client.Close()
+}
+
+func redisClientOpt(cfg *config.Config) asynq.RedisClientOpt {
+	return asynq.RedisClientOpt{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	}
+}
+
 type JobManager struct {
-	client    *asynq.Client
+	*Client
 	server    *asynq.Server
+	scheduler *asynq.Scheduler
 	repo      *repo.Repository
 	scraper   *scraper.ScraperManager
 	headless  *scraper.HeadlessScraper
@@ -62,13 +86,8 @@ type JobManager struct {
 
 func NewJobManager(cfg *config.Config, repo *repo.Repository, scraperMgr *scraper.ScraperManager) *JobManager {
 	// Redis client for asynq
-	redisOpt := asynq.RedisClientOpt{
-		Addr:     cfg.Redis.Addr,
-		Password: cfg.Redis.Password,
-		DB:       cfg.Redis.DB,
-	}
+	redisOpt := redisClientOpt(cfg)
 
-	client := asynq.NewClient(redisOpt)
 	server := asynq.NewServer(redisOpt, asynq.Config{
 		Concurrency: 10,
 		Queues: map[string]int{
@@ -79,6 +98,9 @@ func NewJobManager(cfg *config.Config, repo *repo.Repository, scraperMgr *scrape
 		RetryDelayFunc: asynq.DefaultRetryDelayFunc,
 	})
 
+	// Periodic jobs are enqueued by the workers' schedulers
+	scheduler := asynq.NewScheduler(redisOpt, &asynq.SchedulerOpts{Location: time.Local})
+
 	// Initialize headless scraper
 	headlessCfg := &scraper.HeadlessConfig{
 		Enabled:      cfg.Scraper.HeadlessEnabled,
@@ -91,8 +113,9 @@ func NewJobManager(cfg *config.Config, repo *repo.Repository, scraperMgr *scrape
 	headlessScraper, _ := scraper.NewHeadlessScraper(headlessCfg)
 
 	return &JobManager{
-		client:   client,
+		Client:   NewClient(cfg),
 		server:   server,
+		scheduler: scheduler,
 		repo:    repo,
 		scraper: scraperMgr,
 		headless: headlessScraper,
@@ -110,19 +133,32 @@ func (jm *JobManager) StartWorker() error {
 	mux.HandleFunc(TypeDedupeData, jm.HandleDedupeData)
 	mux.HandleFunc(TypeExportCSV, jm.HandleExportCSV)
 	mux.HandleFunc(TypeCleanupOldData, jm.HandleCleanupOldData)
+	mux.HandleFunc(TypeScrapeQuote, jm.HandleScrapeQuote)
+	mux.HandleFunc(TypeImportCustomers, jm.HandleImportCustomers)
+	mux.HandleFunc(TypeScoreDuplicates, jm.HandleScoreDuplicates)
+	mux.HandleFunc(TypeSendNotification, jm.HandleSendNotification)
+
+	if err := RegisterPeriodicJobs(jm.scheduler); err != nil {
+		return err
+	}
+	if err := jm.scheduler.Start(); err != nil {
+		return fmt.Errorf("failed to start scheduler: %w", err)
+	}
+	defer jm.scheduler.Shutdown()
 
 	log.Println("Starting job worker...")
 	return jm.server.Run(mux)
 }
 
 func (jm *JobManager) Stop() error {
-	jm.client.Close()
+	jm.Client.Close()
+	jm.scheduler.Shutdown()
 	jm.server.Shutdown()
 	return jm.headless.Close()
 }
 
 // Enqueue jobs
-func (jm *JobManager) EnqueueScrapeTarget(targetID uint) error {
+func (c *Client) EnqueueScrapeTarget(targetID uint) error {
 	payload := ScrapeTargetPayload{TargetID: targetID}
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
@@ -130,11 +166,11 @@ func (jm *JobManager) EnqueueScrapeTarget(targetID uint) error {
 	}
 
 	task := asynq.NewTask(TypeScrapeTarget, payloadBytes)
-	_, err = jm.client.Enqueue(task, asynq.Queue("default"))
+	_, err = c.client.Enqueue(task, asynq.Queue("default"))
 	return err
 }
 
-func (jm *JobManager) EnqueueScrapeAll(force bool) error {
+func (c *Client) EnqueueScrapeAll(force bool) error {
 	payload := ScrapeAllPayload{Force: force}
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
@@ -142,11 +178,11 @@ func (jm *JobManager) EnqueueScrapeAll(force bool) error {
 	}
 
 	task := asynq.NewTask(TypeScrapeAll, payloadBytes)
-	_, err = jm.client.Enqueue(task, asynq.Queue("default"))
+	_, err = c.client.Enqueue(task, asynq.Queue("default"))
 	return err
 }
 
-func (jm *JobManager) EnqueueEnrichData(targetID uint) error {
+func (c *Client) EnqueueEnrichData(targetID uint) error {
 	payload := EnrichDataPayload{TargetID: targetID}
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
@@ -154,11 +190,11 @@ func (jm *JobManager) EnqueueEnrichData(targetID uint) error {
 	}
 
 	task := asynq.NewTask(TypeEnrichData, payloadBytes)
-	_, err = jm.client.Enqueue(task, asynq.Queue("low"))
+	_, err = c.client.Enqueue(task, asynq.Queue("low"))
 	return err
 }
 
-func (jm *JobManager) EnqueueDedupeData(targetID uint) error {
+func (c *Client) EnqueueDedupeData(targetID uint) error {
 	payload := DedupeDataPayload{TargetID: targetID}
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
@@ -166,11 +202,11 @@ func (jm *JobManager) EnqueueDedupeData(targetID uint) error {
 	}
 
 	task := asynq.NewTask(TypeDedupeData, payloadBytes)
-	_, err = jm.client.Enqueue(task, asynq.Queue("low"))
+	_, err = c.client.Enqueue(task, asynq.Queue("low"))
 	return err
 }
 
-func (jm *JobManager) EnqueueExportCSV(exportType string, filters map[string]interface{}, userID uint) error {
+func (c *Client) EnqueueExportCSV(exportType string, filters map[string]interface{}, userID uint) error {
 	payload := ExportCSVPayload{
 		Type:    exportType,
 		Filters: filters,
@@ -182,7 +218,7 @@ func (jm *JobManager) EnqueueExportCSV(exportType string, filters map[string]int
 	}
 
 	task := asynq.NewTask(TypeExportCSV, payloadBytes)
-	_, err = jm.client.Enqueue(task, asynq.Queue("low"))
+	_, err = c.client.Enqueue(task, asynq.Queue("low"))
 	return err
 }
 
@@ -381,6 +417,26 @@ func (jm *JobManager) HandleCleanupOldData(ctx context.Context, t *asynq.Task) e
 	log.Printf("Cleanup completed: removed %d scraped rows and %d scraper runs", 
 		result.RowsAffected, result.RowsAffected)
 
+	// Expired refresh tokens can no longer be rotated or replayed
+	removed, err := jm.repo.DeleteExpiredRefreshTokens(time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to cleanup refresh tokens: %w", err)
+	}
+	log.Printf("Removed %d expired refresh tokens", removed)
+
+	removed, err = jm.repo.DeleteExpiredSessions(time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to cleanup sessions: %w", err)
+	}
+	log.Printf("Removed %d expired sessions", removed)
+
+	// Ended grants already stopped counting, the audit log keeps their history
+	removed, err = jm.repo.DeleteOldPermissionGrants(cutoffDate)
+	if err != nil {
+		return fmt.Errorf("failed to cleanup permission grants: %w", err)
+	}
+	log.Printf("Removed %d ended permission grants", removed)
+
 	return nil
 }
 
@@ -401,24 +457,42 @@ func (jm *JobManager) normalizeCity(city string) string {
 	return city
 }
 
-// Schedule periodic jobs
-func (jm *JobManager) SchedulePeriodicJobs() error {
-	// Schedule daily scrape all job at 2 AM
-	_, err := jm.client.Enqueue(
+// Periodic jobs, as cron specs in the worker's local time
+const (
+	ScrapeAllSchedule       = "0 2 * * *"  // daily at 02:00
+	CleanupSchedule         = "0 3 * * 0"  // Sundays at 03:00
+	ScoreDuplicatesSchedule = "15 * * * *" // hourly, at a quarter past
+)
+
+// PeriodicJobRegistrar is the part of asynq.Scheduler periodic jobs are
+// registered with
+type PeriodicJobRegistrar interface {
+	Register(cronspec string, task *asynq.Task, opts ...asynq.Option) (string, error)
+}
+
+// RegisterPeriodicJobs registers the daily scrape, the weekly cleanup of
+// old scraped data, expired refresh tokens and sessions and ended grants,
+// and the hourly scoring of customers whose duplicates weren't scored.
+// Every worker runs a scheduler, so the tasks are unique for an hour and
+// only one worker enqueues each run.
+func RegisterPeriodicJobs(scheduler PeriodicJobRegistrar) error {
+	if _, err := scheduler.Register(ScrapeAllSchedule,
 		asynq.NewTask(TypeScrapeAll, []byte(`{"force": false}`)),
-		asynq.ProcessIn(24*time.Hour),
-		asynq.Queue("default"),
-	)
-	if err != nil {
-		return err
+		asynq.Queue("default"), asynq.Unique(time.Hour)); err != nil {
+		return fmt.Errorf("failed to schedule %s: %w", TypeScrapeAll, err)
 	}
 
-	// Schedule weekly cleanup job
-	_, err = jm.client.Enqueue(
+	if _, err := scheduler.Register(CleanupSchedule,
 		asynq.NewTask(TypeCleanupOldData, []byte(`{"days_old": 30}`)),
-		asynq.ProcessIn(7*24*time.Hour),
-		asynq.Queue("low"),
-	)
+		asynq.Queue("low"), asynq.Unique(time.Hour)); err != nil {
+		return fmt.Errorf("failed to schedule %s: %w", TypeCleanupOldData, err)
+	}
 
-	return err
+	if _, err := scheduler.Register(ScoreDuplicatesSchedule,
+		asynq.NewTask(TypeScoreDuplicates, nil),
+		asynq.Queue("low"), asynq.Unique(time.Hour)); err != nil {
+		return fmt.Errorf("failed to schedule %s: %w", TypeScoreDuplicates, err)
+	}
+
+	return nil
 }
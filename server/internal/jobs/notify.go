@@ -0,0 +1,131 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"eesigorta/backend/internal/repo"
+
+	"github.com/hibiken/asynq"
+)
+
+const (
+	TypeSendNotification = "notification:send"
+)
+
+// Notification categories. Service messages are about the customer's own
+// quotes and policies; marketing messages need their consent for the channel.
+const (
+	NotificationService   = "service"
+	NotificationMarketing = "marketing"
+)
+
+type SendNotificationPayload struct {
+	CustomerID uint   `json:"customer_id"`
+	Category   string `json:"category"`
+	Channel    string `json:"channel"` // sms, email
+	Subject    string `json:"subject"`
+	Body       string `json:"body"`
+}
+
+var (
+	// ErrNoMarketingConsent is returned for marketing notifications to
+	// customers who haven't consented to marketing over the channel
+	ErrNoMarketingConsent = errors.New("customer has no marketing consent for the channel")
+	// ErrNoAddress is returned when the customer has no phone number or
+	// email address for the channel
+	ErrNoAddress = errors.New("customer has no address for the channel")
+	// ErrUnknownCategory is returned for notifications that are neither
+	// service nor marketing, which would skip the marketing consent check
+	ErrUnknownCategory = errors.New("unknown notification category")
+)
+
+func checkCategory(category string) error {
+	if category != NotificationService && category != NotificationMarketing {
+		return fmt.Errorf("%w %q", ErrUnknownCategory, category)
+	}
+	return nil
+}
+
+// NewSendNotificationTask creates a new task to notify a customer
+func NewSendNotificationTask(payload SendNotificationPayload) (*asynq.Task, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeSendNotification, data), nil
+}
+
+// EnqueueNotification queues a notification to a customer. Consent is
+// checked when it's sent, so a consent revoked in the meantime still holds.
+func (c *Client) EnqueueNotification(payload SendNotificationPayload) error {
+	if err := checkCategory(payload.Category); err != nil {
+		return err
+	}
+
+	task, err := NewSendNotificationTask(payload)
+	if err != nil {
+		return err
+	}
+
+	queue := "default"
+	if payload.Category == NotificationMarketing {
+		queue = "low"
+	}
+	_, err = c.client.Enqueue(task, asynq.Queue(queue), asynq.MaxRetry(3))
+	return err
+}
+
+// HandleSendNotification adapts HandleSendNotificationTask to the asynq handler signature
+func (jm *JobManager) HandleSendNotification(ctx context.Context, t *asynq.Task) error {
+	return HandleSendNotificationTask(ctx, t, jm.repo)
+}
+
+// HandleSendNotificationTask sends a notification to a customer, unless
+// it's marketing the customer hasn't consented to. Notifications that can't
+// be sent aren't retried.
+func HandleSendNotificationTask(ctx context.Context, t *asynq.Task, repository *repo.Repository) error {
+	var payload SendNotificationPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+	if err := checkCategory(payload.Category); err != nil {
+		return fmt.Errorf("%w: %w", err, asynq.SkipRetry)
+	}
+
+	customer, err := repository.GetCustomerByID(payload.CustomerID)
+	if err != nil {
+		return fmt.Errorf("failed to get customer: %w", err)
+	}
+
+	if payload.Category == NotificationMarketing {
+		consented, err := repository.HasConsent(customer.ID, repo.ConsentMarketing, payload.Channel)
+		if err != nil {
+			return fmt.Errorf("failed to check consent: %w", err)
+		}
+		if !consented {
+			log.Printf("Blocked marketing %s to customer %d: no consent", payload.Channel, customer.ID)
+			return fmt.Errorf("%w: %w", ErrNoMarketingConsent, asynq.SkipRetry)
+		}
+	}
+
+	var address string
+	switch payload.Channel {
+	case repo.ConsentChannelSMS:
+		address = customer.Phone
+	case repo.ConsentChannelEmail:
+		address = customer.Email
+	default:
+		return fmt.Errorf("unknown notification channel %q: %w", payload.Channel, asynq.SkipRetry)
+	}
+	if address == "" {
+		return fmt.Errorf("%w: %w", ErrNoAddress, asynq.SkipRetry)
+	}
+
+	// No SMS or email provider is wired up yet, so the message is only logged
+	log.Printf("Sending %s %s notification to customer %d: %s", payload.Category, payload.Channel, customer.ID, payload.Subject)
+	return nil
+}
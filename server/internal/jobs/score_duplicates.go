@@ -0,0 +1,30 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+
+	"eesigorta/backend/internal/dedupe"
+	"eesigorta/backend/internal/repo"
+
+	"github.com/hibiken/asynq"
+)
+
+const (
+	TypeScoreDuplicates = "customers:duplicates"
+)
+
+// HandleScoreDuplicates adapts HandleScoreDuplicatesTask to the asynq handler signature
+func (jm *JobManager) HandleScoreDuplicates(ctx context.Context, t *asynq.Task) error {
+	return HandleScoreDuplicatesTask(ctx, t, jm.repo)
+}
+
+// HandleScoreDuplicatesTask scores the duplicates of customers saved since
+// they were last scored: ones saved before duplicate pairs were stored, and
+// ones whose pairs failed to refresh when they were saved
+func HandleScoreDuplicatesTask(ctx context.Context, t *asynq.Task, repository *repo.Repository) error {
+	if err := dedupe.Backfill(repository); err != nil {
+		return fmt.Errorf("failed to score duplicate customers: %w", err)
+	}
+	return nil
+}
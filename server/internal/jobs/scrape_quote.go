@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"eesigorta/backend/internal/repo"
@@ -30,6 +31,23 @@ func NewScrapeQuoteTask(quoteID uint) (*asynq.Task, error) {
 	return asynq.NewTask(TypeScrapeQuote, payload), nil
 }
 
+// EnqueueScrapeQuote queues a quote for scraping. Quotes are user-facing, so
+// they go to the critical queue ahead of bulk target scrapes.
+func (c *Client) EnqueueScrapeQuote(quoteID uint) error {
+	task, err := NewScrapeQuoteTask(quoteID)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.client.Enqueue(task, asynq.Queue("critical"), asynq.MaxRetry(3))
+	return err
+}
+
+// HandleScrapeQuote adapts HandleScrapeQuoteTask to the asynq handler signature
+func (jm *JobManager) HandleScrapeQuote(ctx context.Context, t *asynq.Task) error {
+	return HandleScrapeQuoteTask(ctx, t, jm.repo)
+}
+
 // HandleScrapeQuoteTask handles the scraping of insurance quotes
 func HandleScrapeQuoteTask(ctx context.Context, t *asynq.Task, repository *repo.Repository) error {
 	var payload ScrapeQuotePayload
@@ -40,7 +58,7 @@ func HandleScrapeQuoteTask(ctx context.Context, t *asynq.Task, repository *repo.
 	log.Printf("Processing scrape quote task for Quote ID: %d", payload.QuoteID)
 
 	// Get quote from database
-	quote, err := repository.GetQuoteByID(payload.QuoteID)
+	quote, err := repository.GetQuoteByID(repo.GlobalScope, payload.QuoteID)
 	if err != nil {
 		return fmt.Errorf("failed to get quote: %w", err)
 	}
@@ -55,26 +73,35 @@ func HandleScrapeQuoteTask(ctx context.Context, t *asynq.Task, repository *repo.
 		return fmt.Errorf("failed to get customer: %w", err)
 	}
 
+	// Customer stores a single full name; insurer forms want it split
+	firstName, lastName := customer.Name, ""
+	if i := strings.LastIndex(customer.Name, " "); i > 0 {
+		firstName, lastName = customer.Name[:i], customer.Name[i+1:]
+	}
+
+	var birthDate string
+	if customer.BirthDate != nil {
+		birthDate = customer.BirthDate.Format("2006-01-02")
+	}
+
 	// Convert customer to CustomerData for scraper
 	customerData := &scraper.CustomerData{
-		FirstName:     customer.FirstName,
-		LastName:      customer.LastName,
-		Email:         customer.Email,
-		Phone:         customer.Phone,
-		TCKN:          customer.TCKN,
-		BirthDate:     customer.BirthDate.Format("2006-01-02"),
-		Gender:        customer.Gender,
-		Address:       customer.Address,
-		City:          customer.City,
-		District:      customer.District,
-		PostalCode:    customer.PostalCode,
-		VehicleBrand:  quote.VehicleBrand,
-		VehicleModel:  quote.VehicleModel,
-		VehicleYear:   quote.VehicleYear,
-		VehiclePlate:  quote.VehiclePlate,
-		LicensePlate:  quote.LicensePlate,
-		EngineNumber:  quote.EngineNumber,
-		ChassisNumber: quote.ChassisNumber,
+		FirstName:    firstName,
+		LastName:     lastName,
+		Email:        customer.Email,
+		Phone:        customer.Phone,
+		TCKN:         customer.TCVKN,
+		BirthDate:    birthDate,
+		Gender:       customer.Gender,
+		Address:      customer.Address,
+		City:         customer.City,
+		District:     customer.District,
+		PostalCode:   customer.PostalCode,
+		VehicleBrand: quote.VehicleBrand,
+		VehicleModel: quote.VehicleModel,
+		VehicleYear:  quote.VehicleYear,
+		VehiclePlate: quote.VehiclePlate,
+		LicensePlate: quote.VehiclePlate,
 	}
 
 	// Get all active scraper targets for insurance companies
@@ -162,7 +189,7 @@ func handleScrapeQuoteTaskSimulation(ctx context.Context, t *asynq.Task, reposit
 	log.Printf("Processing scrape quote task (simulation) for Quote ID: %d", payload.QuoteID)
 
 	// Get quote from database
-	quote, err := repository.GetQuoteByID(payload.QuoteID)
+	quote, err := repository.GetQuoteByID(repo.GlobalScope, payload.QuoteID)
 	if err != nil {
 		return fmt.Errorf("failed to get quote: %w", err)
 	}
@@ -0,0 +1,143 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// invalidationChannel is the Redis channel API instances announce role and
+// permission changes on
+const invalidationChannel = "rbac:invalidate"
+
+// permissionCache holds every role's permission set, loaded from the
+// database in one query and kept until invalidated or older than ttl
+type permissionCache struct {
+	db  *gorm.DB
+	ttl time.Duration
+
+	mu       sync.RWMutex
+	roles    map[uint]map[string]struct{}
+	loadedAt time.Time
+}
+
+func newPermissionCache(db *gorm.DB, ttl time.Duration) *permissionCache {
+	return &permissionCache{db: db, ttl: ttl}
+}
+
+// role returns the permission set of a role. The set is shared and must not
+// be modified.
+func (c *permissionCache) role(id uint) (map[string]struct{}, error) {
+	c.mu.RLock()
+	if c.fresh() {
+		permissions := c.roles[id]
+		c.mu.RUnlock()
+		return permissions, nil
+	}
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another request may have reloaded while we waited for the lock
+	if !c.fresh() {
+		if err := c.load(); err != nil {
+			return nil, err
+		}
+	}
+	return c.roles[id], nil
+}
+
+func (c *permissionCache) fresh() bool {
+	return c.roles != nil && (c.ttl <= 0 || time.Since(c.loadedAt) < c.ttl)
+}
+
+func (c *permissionCache) load() error {
+	var rows []struct {
+		RoleID     uint
+		Permission string
+	}
+	err := c.db.Table("role_permissions rp").
+		Select("rp.role_id, p.name AS permission").
+		Joins("JOIN roles r ON r.id = rp.role_id AND r.deleted_at IS NULL").
+		Joins("JOIN permissions p ON p.id = rp.permission_id AND p.deleted_at IS NULL").
+		Scan(&rows).Error
+	if err != nil {
+		return fmt.Errorf("failed to load role permissions: %w", err)
+	}
+
+	roles := make(map[uint]map[string]struct{})
+	for _, row := range rows {
+		if roles[row.RoleID] == nil {
+			roles[row.RoleID] = make(map[string]struct{})
+		}
+		roles[row.RoleID][row.Permission] = struct{}{}
+	}
+
+	c.roles = roles
+	c.loadedAt = time.Now()
+	return nil
+}
+
+func (c *permissionCache) invalidate() {
+	c.mu.Lock()
+	c.roles = nil
+	c.mu.Unlock()
+}
+
+// sortedPermissions lists a permission set in a stable order
+func sortedPermissions(set map[string]struct{}) []string {
+	permissions := make([]string, 0, len(set))
+	for permission := range set {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+// Invalidate drops the cached role permissions. Call it after changing
+// roles, permissions or their assignments. With Redis invalidation enabled
+// every other API instance drops its cache too.
+func (r *RBACManager) Invalidate(ctx context.Context) error {
+	r.cache.invalidate()
+
+	if r.redis == nil {
+		return nil
+	}
+	if err := r.redis.Publish(ctx, invalidationChannel, "roles").Err(); err != nil {
+		return fmt.Errorf("failed to publish rbac invalidation: %w", err)
+	}
+	return nil
+}
+
+// EnableRedisInvalidation shares invalidations between API instances. It
+// subscribes in the background until ctx is done; go-redis reconnects on
+// its own if Redis restarts.
+func (r *RBACManager) EnableRedisInvalidation(ctx context.Context, client *redis.Client) {
+	r.redis = client
+
+	pubsub := client.Subscribe(ctx, invalidationChannel)
+	messages := pubsub.Channel()
+	go func() {
+		defer pubsub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-messages:
+				if !ok {
+					return
+				}
+				r.cache.invalidate()
+			}
+		}
+	}()
+
+	log.Printf("RBAC cache invalidation subscribed on %s", invalidationChannel)
+}
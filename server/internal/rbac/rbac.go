@@ -3,19 +3,25 @@ package rbac
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
+	"eesigorta/backend/internal/config"
 	"eesigorta/backend/internal/repo"
 
+	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
 type RBACManager struct {
-	db *gorm.DB
+	db    *gorm.DB
+	cache *permissionCache
+	redis *redis.Client // publishes invalidations when set
 }
 
-func NewRBACManager(db *gorm.DB) *RBACManager {
-	return &RBACManager{db: db}
+func NewRBACManager(db *gorm.DB, cfg config.RBACConfig) *RBACManager {
+	return &RBACManager{db: db, cache: newPermissionCache(db, cfg.CacheTTL)}
 }
 
 // Permission constants
@@ -48,6 +54,13 @@ const (
 	PermissionCustomerDelete = "customer:delete"
 	PermissionCustomerList   = "customer:list"
 
+	// Customer personal data (TC/VKN, contact details, birth date) unmasked
+	PermissionCustomerReadPII = "customer:read_pii"
+
+	// KVKK data subject requests: exporting everything held on a customer
+	// and anonymizing them
+	PermissionCustomerKVKK = "customer:kvkk"
+
 	// Policy permissions
 	PermissionPolicyCreate = "policy:create"
 	PermissionPolicyRead   = "policy:read"
@@ -69,6 +82,16 @@ const (
 	// Scraper permissions
 	PermissionScraperRun    = "scraper:run"
 	PermissionScraperManage = "scraper:manage"
+
+	// Role permissions
+	PermissionRoleCreate = "role:create"
+	PermissionRoleRead   = "role:read"
+	PermissionRoleUpdate = "role:update"
+	PermissionRoleDelete = "role:delete"
+	PermissionRoleList   = "role:list"
+
+	// Audit log permissions
+	PermissionAuditRead = "audit:read"
 )
 
 // Role constants
@@ -86,15 +109,17 @@ func (r *RBACManager) InitializeRoles() error {
 			PermissionUserCreate, PermissionUserRead, PermissionUserUpdate, PermissionUserDelete, PermissionUserList,
 			PermissionBranchCreate, PermissionBranchRead, PermissionBranchUpdate, PermissionBranchDelete, PermissionBranchList,
 			PermissionAgentCreate, PermissionAgentRead, PermissionAgentUpdate, PermissionAgentDelete, PermissionAgentList,
-			PermissionCustomerCreate, PermissionCustomerRead, PermissionCustomerUpdate, PermissionCustomerDelete, PermissionCustomerList,
+			PermissionCustomerCreate, PermissionCustomerRead, PermissionCustomerUpdate, PermissionCustomerDelete, PermissionCustomerList, PermissionCustomerReadPII, PermissionCustomerKVKK,
 			PermissionPolicyCreate, PermissionPolicyRead, PermissionPolicyUpdate, PermissionPolicyDelete, PermissionPolicyList,
 			PermissionQuoteCreate, PermissionQuoteRead, PermissionQuoteUpdate, PermissionQuoteDelete, PermissionQuoteList,
 			PermissionReportRead, PermissionReportExport,
 			PermissionScraperRun, PermissionScraperManage,
+			PermissionRoleCreate, PermissionRoleRead, PermissionRoleUpdate, PermissionRoleDelete, PermissionRoleList,
+			PermissionAuditRead,
 		},
 		RoleBranchManager: {
 			PermissionAgentCreate, PermissionAgentRead, PermissionAgentUpdate, PermissionAgentDelete, PermissionAgentList,
-			PermissionCustomerCreate, PermissionCustomerRead, PermissionCustomerUpdate, PermissionCustomerDelete, PermissionCustomerList,
+			PermissionCustomerCreate, PermissionCustomerRead, PermissionCustomerUpdate, PermissionCustomerDelete, PermissionCustomerList, PermissionCustomerReadPII,
 			PermissionPolicyCreate, PermissionPolicyRead, PermissionPolicyUpdate, PermissionPolicyDelete, PermissionPolicyList,
 			PermissionQuoteCreate, PermissionQuoteRead, PermissionQuoteUpdate, PermissionQuoteDelete, PermissionQuoteList,
 			PermissionReportRead, PermissionReportExport,
@@ -113,6 +138,14 @@ func (r *RBACManager) InitializeRoles() error {
 		},
 	}
 
+	// Which records each system role sees; viewers keep a read-only global view
+	roleDataScopes := map[string]string{
+		RoleAdmin:         repo.ScopeAll,
+		RoleBranchManager: repo.ScopeBranch,
+		RoleAgent:         repo.ScopeOwn,
+		RoleViewer:        repo.ScopeAll,
+	}
+
 	// Create permissions
 	for _, permissions := range rolePermissions {
 		for _, permissionName := range permissions {
@@ -146,10 +179,16 @@ func (r *RBACManager) InitializeRoles() error {
 				Name:        roleName,
 				Description: fmt.Sprintf("System role: %s", roleName),
 				IsSystem:    true,
+				DataScope:   roleDataScopes[roleName],
 			}
 			if err := r.db.Create(&role).Error; err != nil {
 				return fmt.Errorf("failed to create role %s: %w", roleName, err)
 			}
+		} else if role.DataScope != roleDataScopes[roleName] {
+			// Roles created before data scopes existed got the column default
+			if err := r.db.Model(&role).Update("data_scope", roleDataScopes[roleName]).Error; err != nil {
+				return fmt.Errorf("failed to set data scope of role %s: %w", roleName, err)
+			}
 		}
 
 		// Assign permissions to role
@@ -173,40 +212,140 @@ func (r *RBACManager) InitializeRoles() error {
 		}
 	}
 
+	if err := r.migrateUserRoles(); err != nil {
+		return err
+	}
+
+	// Seeding runs at startup on every instance, so a local reset is enough
+	r.cache.invalidate()
 	return nil
 }
 
-func (r *RBACManager) HasPermission(userID uint, permission string) (bool, error) {
-	var count int64
-	err := r.db.Table("role_permissions rp").
-		Joins("JOIN roles r ON r.id = rp.role_id").
-		Joins("JOIN permissions p ON p.id = rp.permission_id").
-		Joins("JOIN users u ON u.role = r.name").
-		Where("u.id = ? AND p.name = ?", userID, permission).
-		Count(&count).Error
+// migrateUserRoles attaches users created before roles were referenced by
+// ID to the role named in the legacy users.role column, falling back to
+// viewer for names that don't match a role, and then drops the column
+func (r *RBACManager) migrateUserRoles() error {
+	migrator := r.db.Migrator()
+	if !migrator.HasColumn(&repo.User{}, "role") {
+		return nil
+	}
 
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`UPDATE users SET role_id = (SELECT id FROM roles WHERE roles.name = users.role AND roles.deleted_at IS NULL)
+			WHERE role_id IS NULL OR role_id = 0`).Error
+		if err != nil {
+			return fmt.Errorf("failed to migrate user roles: %w", err)
+		}
+
+		err = tx.Exec(`UPDATE users SET role_id = (SELECT id FROM roles WHERE name = ?)
+			WHERE role_id IS NULL OR role_id = 0`, RoleViewer).Error
+		if err != nil {
+			return fmt.Errorf("failed to migrate user roles: %w", err)
+		}
+
+		if err := tx.Migrator().DropColumn(&repo.User{}, "role"); err != nil {
+			return fmt.Errorf("failed to drop legacy role column: %w", err)
+		}
+		return nil
+	})
+}
+
+// scopedResources are the resources whose records are limited by the data
+// scope, and scopedActions what can be done to an existing record of them
+var (
+	scopedResources = []string{"customer", "policy", "quote", "agent", "branch"}
+	scopedActions   = []string{"read", "list", "update", "delete"}
+)
+
+// IsScopedPermission reports whether a grant of the permission can be
+// limited to a branch or an agent user: it must act on existing records the
+// data scope limits. Creating records and reading personal data aren't
+// tied to any record's branch or agent.
+func IsScopedPermission(permission string) bool {
+	resource, action, ok := strings.Cut(permission, ":")
+	return ok && slices.Contains(scopedResources, resource) && slices.Contains(scopedActions, action)
+}
+
+// HasPermission checks the user's current role against the cached role
+// permissions, so role changes apply from the next request on. Permissions
+// the role lacks may still come from an active grant to the user. Grants
+// limited to a branch or an agent user don't count here, they only open up
+// those records through the user's data scope.
+func (r *RBACManager) HasPermission(userID uint, permission string) (bool, error) {
+	permissions, err := r.userRolePermissions(userID)
 	if err != nil {
 		return false, fmt.Errorf("failed to check permission: %w", err)
 	}
 
-	return count > 0, nil
+	if _, ok := permissions[permission]; ok {
+		return true, nil
+	}
+
+	// Grants are few and short-lived, so they are read fresh instead of cached
+	var grants int64
+	err = r.db.Model(&repo.PermissionGrant{}).
+		Scopes(repo.ActiveGrants(time.Now()), repo.UnlimitedGrants).
+		Where("user_id = ? AND permission = ?", userID, permission).
+		Count(&grants).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check permission grants: %w", err)
+	}
+	return grants > 0, nil
 }
 
+// GetUserPermissions lists the user's effective permissions: those of their
+// role and of their active grants, limited ones included
 func (r *RBACManager) GetUserPermissions(userID uint) ([]string, error) {
-	var permissions []string
-	err := r.db.Table("role_permissions rp").
-		Select("p.name").
-		Joins("JOIN roles r ON r.id = rp.role_id").
-		Joins("JOIN permissions p ON p.id = rp.permission_id").
-		Joins("JOIN users u ON u.role = r.name").
-		Where("u.id = ?", userID).
-		Pluck("p.name", &permissions).Error
+	return r.userPermissions(userID, true)
+}
+
+// GetHeldPermissions lists the permissions the user holds over their whole
+// data scope: those of their role and of grants not limited to a branch or
+// an agent user
+func (r *RBACManager) GetHeldPermissions(userID uint) ([]string, error) {
+	return r.userPermissions(userID, false)
+}
 
+func (r *RBACManager) userPermissions(userID uint, limited bool) ([]string, error) {
+	permissions, err := r.userRolePermissions(userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user permissions: %w", err)
 	}
 
-	return permissions, nil
+	grants := r.db.Model(&repo.PermissionGrant{}).
+		Scopes(repo.ActiveGrants(time.Now())).
+		Where("user_id = ?", userID)
+	if !limited {
+		grants = grants.Scopes(repo.UnlimitedGrants)
+	}
+	var granted []string
+	err = grants.Distinct().Pluck("permission", &granted).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get permission grants: %w", err)
+	}
+
+	effective := make(map[string]struct{}, len(permissions)+len(granted))
+	for permission := range permissions {
+		effective[permission] = struct{}{}
+	}
+	for _, permission := range granted {
+		effective[permission] = struct{}{}
+	}
+	return sortedPermissions(effective), nil
+}
+
+// userRolePermissions returns the permission set of the user's role, or an
+// empty set for unknown users
+func (r *RBACManager) userRolePermissions(userID uint) (map[string]struct{}, error) {
+	var roleIDs []uint
+	if err := r.db.Model(&repo.User{}).Where("id = ?", userID).Pluck("role_id", &roleIDs).Error; err != nil {
+		return nil, err
+	}
+	if len(roleIDs) == 0 {
+		return nil, nil
+	}
+
+	return r.cache.role(roleIDs[0])
 }
 
 func (r *RBACManager) RequirePermission(permission string) func(ctx context.Context) error {
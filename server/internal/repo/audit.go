@@ -0,0 +1,276 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// auditChainLock is the Postgres advisory lock serializing audit log writes
+// across API instances, so each entry chains onto the one before it
+const auditChainLock = 7_114_110_001
+
+// auditChainMu does the same within one instance, for databases without
+// advisory locks
+var auditChainMu sync.Mutex
+
+// AuditLogFilter narrows an audit log query. Zero fields don't filter.
+type AuditLogFilter struct {
+	UserID   *uint
+	Entity   string
+	EntityID *uint
+	Action   string
+	From     *time.Time
+	To       *time.Time
+}
+
+// AuditChange is the old and new value of a field an update changed.
+// Changes of personal data are redacted: only the field is recorded.
+type AuditChange struct {
+	Old      interface{} `json:"old"`
+	New      interface{} `json:"new"`
+	Redacted bool        `json:"redacted,omitempty"`
+}
+
+// customerPersonalData are the customer fields anonymization erases. Audit
+// entries outlive anonymization, so they don't hold their values.
+var customerPersonalData = map[string]bool{
+	"tc_vkn": true, "name": true, "email": true, "phone": true, "address": true,
+	"district": true, "postal_code": true, "birth_date": true, "gender": true,
+	"tax_office": true, "trade_title": true, "authorized_person": true,
+}
+
+// AuditChainReport is the result of checking the audit log's hash chain
+type AuditChainReport struct {
+	Valid    bool   `json:"valid"`
+	Checked  int64  `json:"checked"`
+	Unhashed int64  `json:"unhashed"` // entries written before chaining began
+	BrokenAt *uint  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	// LastHash is the hash of the newest entry. Noting it down elsewhere
+	// makes removing entries from the end detectable too.
+	LastHash string `json:"last_hash,omitempty"`
+}
+
+// ComputeHash hashes the entry together with the hash of the entry before
+// it. JSON columns are hashed in a canonical form, since the database may
+// store them reformatted.
+func (l *AuditLog) ComputeHash() string {
+	var changes interface{}
+	if l.ChangesJSON != nil {
+		changes = canonicalJSON(*l.ChangesJSON)
+	}
+
+	payload, _ := json.Marshal([]interface{}{
+		l.PrevHash,
+		l.UserID,
+		l.Action,
+		l.Entity,
+		l.EntityID,
+		l.Method,
+		l.Route,
+		l.StatusCode,
+		l.LatencyMS,
+		l.IP,
+		l.UserAgent,
+		canonicalJSON(l.MetaJSON),
+		changes,
+		l.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+func canonicalJSON(value string) interface{} {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		return value
+	}
+	return parsed
+}
+
+// CreateAuditLogs stores the audit entries of one request together,
+// chaining each onto the hash of the entry before it
+func (r *Repository) CreateAuditLogs(logs []AuditLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	auditChainMu.Lock()
+	defer auditChainMu.Unlock()
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return createAuditLogs(tx, logs)
+	})
+}
+
+// SaveCustomerAudited creates or updates a customer and writes its audit
+// entry in one transaction, so neither is stored without the other. The
+// entry is given the customer's ID.
+func (r *Repository) SaveCustomerAudited(customer *Customer, auditLog AuditLog) error {
+	auditChainMu.Lock()
+	defer auditChainMu.Unlock()
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(customer).Error; err != nil {
+			return err
+		}
+		auditLog.EntityID = &customer.ID
+		return createAuditLogs(tx, []AuditLog{auditLog})
+	})
+}
+
+// createAuditLogs chains and stores audit entries within a transaction.
+// Callers hold auditChainMu.
+func createAuditLogs(tx *gorm.DB, logs []AuditLog) error {
+	if tx.Dialector.Name() == "postgres" {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLock).Error; err != nil {
+			return err
+		}
+	}
+
+	var last AuditLog
+	if err := tx.Select("hash").Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+		return err
+	}
+
+	// Postgres keeps microseconds, the hash must survive the round trip
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	prevHash := last.Hash
+	for i := range logs {
+		logs[i].CreatedAt = now
+		logs[i].PrevHash = prevHash
+		logs[i].Hash = logs[i].ComputeHash()
+		prevHash = logs[i].Hash
+	}
+	return tx.Create(&logs).Error
+}
+
+// GetAuditLogs lists audit entries newest first. Only entries older than
+// the before ID are listed when it's set, so the last ID of a page is the
+// cursor of the next one.
+func (r *Repository) GetAuditLogs(filter AuditLogFilter, before uint, limit int) ([]AuditLog, error) {
+	db := r.db.Preload("User")
+	if filter.UserID != nil {
+		db = db.Where("user_id = ?", *filter.UserID)
+	}
+	if filter.Entity != "" {
+		db = db.Where("entity = ?", filter.Entity)
+	}
+	if filter.EntityID != nil {
+		db = db.Where("entity_id = ?", *filter.EntityID)
+	}
+	if filter.Action != "" {
+		db = db.Where("action = ?", filter.Action)
+	}
+	if filter.From != nil {
+		db = db.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		db = db.Where("created_at < ?", *filter.To)
+	}
+	if before > 0 {
+		db = db.Where("id < ?", before)
+	}
+
+	var logs []AuditLog
+	if err := db.Order("id DESC").Limit(limit).Find(&logs).Error; err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// VerifyAuditChain walks the audit log in write order and reports the first
+// entry that was changed, or that follows a removed or reordered entry.
+// Entries from before chaining began are counted but can't be checked.
+func (r *Repository) VerifyAuditChain() (*AuditChainReport, error) {
+	report := &AuditChainReport{Valid: true}
+	prevHash := ""
+	chained := false
+
+	var batch []AuditLog
+	err := r.db.Order("id").FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			entry := &batch[i]
+			if entry.Hash == "" && !chained {
+				report.Unhashed++
+				continue
+			}
+			chained = true
+			report.Checked++
+
+			switch {
+			case entry.PrevHash != prevHash:
+				report.Reason = "previous entry was removed or reordered"
+			case entry.Hash != entry.ComputeHash():
+				report.Reason = "entry was modified"
+			default:
+				prevHash = entry.Hash
+				report.LastHash = entry.Hash
+				continue
+			}
+
+			brokenAt := entry.ID
+			report.Valid = false
+			report.BrokenAt = &brokenAt
+			return errStopVerify
+		}
+		return nil
+	}).Error
+	if err != nil && !errors.Is(err, errStopVerify) {
+		return nil, err
+	}
+	return report, nil
+}
+
+// errStopVerify ends the batch walk at the first break
+var errStopVerify = errors.New("audit chain broken")
+
+// AuditDiff compares two versions of a record by their JSON fields.
+// Timestamps maintained by the database are left out, and so are the
+// values of a customer's personal data.
+func AuditDiff(before, after interface{}) map[string]AuditChange {
+	previous, current := jsonFields(before), jsonFields(after)
+	var redacted map[string]bool
+	switch before.(type) {
+	case Customer, *Customer:
+		redacted = customerPersonalData
+	}
+
+	changes := make(map[string]AuditChange)
+	for field, value := range current {
+		if field == "updated_at" {
+			continue
+		}
+		if !reflect.DeepEqual(previous[field], value) {
+			changes[field] = AuditChange{Old: previous[field], New: value}
+		}
+	}
+	for field, value := range previous {
+		if _, ok := current[field]; !ok {
+			changes[field] = AuditChange{Old: value}
+		}
+	}
+	for field := range changes {
+		if redacted[field] {
+			changes[field] = AuditChange{Redacted: true}
+		}
+	}
+	return changes
+}
+
+func jsonFields(v interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(data, &fields)
+	return fields
+}
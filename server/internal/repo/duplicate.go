@@ -0,0 +1,124 @@
+package repo
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DuplicateKeys are a customer's phone, email and name normalized to match
+// other customers on. An empty key matches nothing.
+type DuplicateKeys struct {
+	Phone string
+	Email string
+	Name  string
+}
+
+// DuplicateCandidates loads the customers sharing the customer's phone,
+// email, name or birth date, the only ones worth scoring against it. A name
+// shared by more than maxNameGroup customers, e.g. a common one, is left
+// out, it would only add noise.
+func (r *Repository) DuplicateCandidates(customer *Customer, keys DuplicateKeys, maxNameGroup int64) ([]Customer, error) {
+	conds := r.db.Where("1 = 0")
+	if keys.Phone != "" {
+		conds = conds.Or("customers.phone_key = ?", keys.Phone)
+	}
+	if keys.Email != "" {
+		conds = conds.Or("customers.email_key = ?", keys.Email)
+	}
+	if keys.Name != "" {
+		var named int64
+		if err := r.db.Model(&Customer{}).Where("name_key = ?", keys.Name).Count(&named).Error; err != nil {
+			return nil, err
+		}
+		if named <= maxNameGroup {
+			conds = conds.Or("customers.name_key = ?", keys.Name)
+		}
+	}
+	if customer.BirthDate != nil {
+		day := customer.BirthDate.Truncate(24 * time.Hour)
+		conds = conds.Or("customers.birth_date >= ? AND customers.birth_date < ?", day, day.AddDate(0, 0, 1))
+	}
+
+	var candidates []Customer
+	err := r.db.Where(conds).
+		Where("customers.id <> ? AND customers.anonymized_at IS NULL", customer.ID).
+		Order("customers.id").
+		Find(&candidates).Error
+	return candidates, err
+}
+
+// ReplaceCustomerDuplicates stores the customer's keys and replaces every
+// scored pair it's in, in one transaction
+func (r *Repository) ReplaceCustomerDuplicates(customerID uint, keys DuplicateKeys, pairs []CustomerDuplicate) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// UpdateColumns leaves updated_at alone, the customer itself
+		// didn't change
+		if err := tx.Model(&Customer{}).Where("id = ?", customerID).UpdateColumns(map[string]interface{}{
+			"phone_key":             keys.Phone,
+			"email_key":             keys.Email,
+			"name_key":              keys.Name,
+			"duplicates_checked_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		if err := deleteCustomerDuplicates(tx, customerID); err != nil {
+			return err
+		}
+		if len(pairs) == 0 {
+			return nil
+		}
+		return tx.Create(&pairs).Error
+	})
+}
+
+func deleteCustomerDuplicates(tx *gorm.DB, customerID uint) error {
+	return tx.Where("customer_id = ? OR duplicate_id = ?", customerID, customerID).Delete(&CustomerDuplicate{}).Error
+}
+
+// CustomersToCheckForDuplicates loads a batch of the customers saved since
+// their duplicates were last scored, or never scored, after the given ID
+func (r *Repository) CustomersToCheckForDuplicates(afterID uint, limit int) ([]Customer, error) {
+	var customers []Customer
+	err := r.db.Where("id > ? AND anonymized_at IS NULL", afterID).
+		Where("duplicates_checked_at IS NULL OR duplicates_checked_at < updated_at").
+		Order("id").
+		Limit(limit).
+		Find(&customers).Error
+	return customers, err
+}
+
+// GetCustomerDuplicates lists a page of the scored pairs with both
+// customers in the scope, highest score first. Pairs with a deleted or
+// anonymized customer are left out. customerID, when set, limits them to
+// that customer's.
+func (r *Repository) GetCustomerDuplicates(scope DataScope, customerID *uint, minScore, page, pageSize int) ([]CustomerDuplicate, int64, error) {
+	db := r.db.Model(&CustomerDuplicate{}).
+		Joins("JOIN customers c ON c.id = customer_duplicates.customer_id AND c.deleted_at IS NULL AND c.anonymized_at IS NULL").
+		Joins("JOIN customers d ON d.id = customer_duplicates.duplicate_id AND d.deleted_at IS NULL AND d.anonymized_at IS NULL").
+		Where("customer_duplicates.score >= ?", minScore)
+	if customerID != nil {
+		db = db.Where("customer_duplicates.customer_id = ? OR customer_duplicates.duplicate_id = ?", *customerID, *customerID)
+	}
+	if scope.Level != ScopeAll {
+		db = db.Where("customer_duplicates.customer_id IN (?) AND customer_duplicates.duplicate_id IN (?)",
+			r.ScopedCustomers(scope).Select("customers.id"),
+			r.ScopedCustomers(scope).Select("customers.id"))
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var pairs []CustomerDuplicate
+	err := db.Preload("Customer").Preload("Duplicate").
+		Order("customer_duplicates.score DESC, customer_duplicates.customer_id, customer_duplicates.duplicate_id").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&pairs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return pairs, total, nil
+}
@@ -0,0 +1,217 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AnonymizedCustomerName replaces the name of anonymized customers
+const AnonymizedCustomerName = "Anonim Müşteri"
+
+var (
+	// ErrCustomerHasActivePolicies stops anonymizing a customer an active
+	// policy still insures
+	ErrCustomerHasActivePolicies = errors.New("customer has active policies")
+	// ErrCustomerAnonymized is returned for customers already anonymized,
+	// which can't be changed or merged
+	ErrCustomerAnonymized = errors.New("customer is already anonymized")
+)
+
+// Consent methods
+func (r *Repository) GetCustomerConsents(customerID uint) ([]CustomerConsent, error) {
+	var consents []CustomerConsent
+	if err := r.db.Where("customer_id = ?", customerID).Order("granted_at DESC, id DESC").Find(&consents).Error; err != nil {
+		return nil, err
+	}
+	return consents, nil
+}
+
+func (r *Repository) GetCustomerConsent(customerID, id uint) (*CustomerConsent, error) {
+	var consent CustomerConsent
+	if err := r.db.Where("customer_id = ?", customerID).First(&consent, id).Error; err != nil {
+		return nil, err
+	}
+	return &consent, nil
+}
+
+func (r *Repository) CreateCustomerConsent(consent *CustomerConsent) error {
+	return r.db.Create(consent).Error
+}
+
+func (r *Repository) UpdateCustomerConsent(consent *CustomerConsent) error {
+	return r.db.Save(consent).Error
+}
+
+// HasConsent reports whether the customer has an unrevoked consent for the
+// purpose covering the channel, given either for it or for all channels
+func (r *Repository) HasConsent(customerID uint, purpose, channel string) (bool, error) {
+	var count int64
+	err := r.db.Model(&CustomerConsent{}).
+		Where("customer_id = ? AND purpose = ? AND revoked_at IS NULL", customerID, purpose).
+		Where("channel IN ?", []string{channel, ConsentChannelAll}).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// CustomerDataExport is everything held on a customer, as a KVKK data
+// subject may ask for it (md. 11). Related records are given as stored,
+// without the records they point to.
+type CustomerDataExport struct {
+	ExportedAt time.Time                `json:"exported_at"`
+	Customer   Customer                 `json:"customer"`
+	Consents   []CustomerConsent        `json:"consents"`
+	Quotes     []map[string]interface{} `json:"quotes"`
+	Offers     []map[string]interface{} `json:"offers"`
+	Policies   []map[string]interface{} `json:"policies"`
+	Payments   []map[string]interface{} `json:"payments"`
+	Timeline   []TimelineEvent          `json:"timeline"`
+}
+
+// ExportCustomerData collects everything held on a customer. Deleted
+// customers and records are included, since they're still held.
+func (r *Repository) ExportCustomerData(customerID uint) (*CustomerDataExport, error) {
+	export := &CustomerDataExport{ExportedAt: time.Now()}
+	if err := r.db.Unscoped().First(&export.Customer, customerID).Error; err != nil {
+		return nil, err
+	}
+
+	var quotes []Quote
+	var offers []ScrapedQuote
+	var policies []Policy
+	var payments []Payment
+	db := r.db.Unscoped().Session(&gorm.Session{})
+	err := db.Where("customer_id = ?", customerID).Order("id").Find(&quotes).Error
+	if err == nil {
+		err = db.Where("quote_id IN (?)", db.Model(&Quote{}).Select("id").Where("customer_id = ?", customerID)).Order("id").Find(&offers).Error
+	}
+	if err == nil {
+		err = db.Where("customer_id = ?", customerID).Order("id").Find(&policies).Error
+	}
+	if err == nil {
+		err = db.Where("policy_id IN (?)", db.Model(&Policy{}).Select("id").Where("customer_id = ?", customerID)).Order("id").Find(&payments).Error
+	}
+	if err == nil {
+		export.Consents, err = r.GetCustomerConsents(customerID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	subject := TimelineSubject{CustomerID: &export.Customer.ID}
+	export.Quotes = make([]map[string]interface{}, 0, len(quotes))
+	for _, quote := range quotes {
+		export.Quotes = append(export.Quotes, exportRecord(quote, "customer", "product", "agent"))
+		subject.QuoteIDs = append(subject.QuoteIDs, quote.ID)
+	}
+	export.Offers = make([]map[string]interface{}, 0, len(offers))
+	for _, offer := range offers {
+		export.Offers = append(export.Offers, exportRecord(offer, "quote"))
+	}
+	export.Policies = make([]map[string]interface{}, 0, len(policies))
+	for _, policy := range policies {
+		export.Policies = append(export.Policies, exportRecord(policy, "customer", "product", "agent", "quote"))
+		subject.PolicyIDs = append(subject.PolicyIDs, policy.ID)
+	}
+	export.Payments = make([]map[string]interface{}, 0, len(payments))
+	for _, payment := range payments {
+		export.Payments = append(export.Payments, exportRecord(payment, "account", "policy"))
+	}
+
+	if export.Timeline, err = r.GetTimeline(subject, nil); err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// exportRecord gives a record's JSON fields without the related records
+// it embeds
+func exportRecord(record interface{}, related ...string) map[string]interface{} {
+	fields := jsonFields(record)
+	for _, field := range related {
+		delete(fields, field)
+	}
+	return fields
+}
+
+// CustomerAnonymization counts what anonymizing a customer changed besides
+// the customer itself
+type CustomerAnonymization struct {
+	Quotes   int64 `json:"quotes"`
+	Consents int64 `json:"consents"`
+}
+
+// AnonymizeCustomer irreversibly replaces a customer's personal data, as a
+// KVKK erasure request asks, in one transaction. The customer's type and
+// city stay, and so do their policies and payments, for reporting; vehicle
+// plates and notes go from their quotes and their consents are revoked.
+// Customers an active policy still insures can't be anonymized.
+func (r *Repository) AnonymizeCustomer(customerID uint) (*Customer, *CustomerAnonymization, error) {
+	var customer Customer
+	anonymization := &CustomerAnonymization{}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().First(&customer, customerID).Error; err != nil {
+			return err
+		}
+		if customer.AnonymizedAt != nil {
+			return ErrCustomerAnonymized
+		}
+
+		var active int64
+		if err := tx.Model(&Policy{}).Where("customer_id = ? AND status = ?", customerID, "active").Count(&active).Error; err != nil {
+			return err
+		}
+		if active > 0 {
+			return ErrCustomerHasActivePolicies
+		}
+
+		now := time.Now()
+		// Save would insert a deleted customer anew, so the columns are
+		// updated explicitly
+		anonymized := map[string]interface{}{
+			"tc_vkn":            fmt.Sprintf("A%010d", customer.ID),
+			"name":              AnonymizedCustomerName,
+			"email":             "",
+			"phone":             "",
+			"address":           "",
+			"district":          "",
+			"postal_code":       "",
+			"birth_date":        nil,
+			"gender":            "",
+			"tax_office":        "",
+			"trade_title":       "",
+			"authorized_person": "",
+			"phone_key":         "",
+			"email_key":         "",
+			"name_key":          "",
+			"anonymized_at":     now,
+		}
+		if err := tx.Unscoped().Model(&customer).Updates(anonymized).Error; err != nil {
+			return err
+		}
+		if err := deleteCustomerDuplicates(tx, customerID); err != nil {
+			return err
+		}
+
+		result := tx.Unscoped().Model(&Quote{}).Where("customer_id = ?", customerID).
+			Updates(map[string]interface{}{"vehicle_plate": "", "additional_info": ""})
+		if result.Error != nil {
+			return result.Error
+		}
+		anonymization.Quotes = result.RowsAffected
+
+		result = tx.Model(&CustomerConsent{}).Where("customer_id = ? AND revoked_at IS NULL", customerID).Update("revoked_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		anonymization.Consents = result.RowsAffected
+
+		return tx.Unscoped().First(&customer, customerID).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &customer, anonymization, nil
+}
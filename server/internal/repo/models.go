@@ -8,17 +8,83 @@ import (
 
 // User represents a system user
 type User struct {
-	ID              uint           `json:"id" gorm:"primaryKey"`
-	Email           string         `json:"email" gorm:"uniqueIndex;not null"`
-	PasswordHash    string         `json:"-" gorm:"not null"`
-	Role            string         `json:"role" gorm:"not null;default:'viewer'"`
-	TwoFAEnabled    bool           `json:"two_fa_enabled" gorm:"default:false"`
-	TwoFASecret     string         `json:"-" gorm:"column:twofa_secret"`
-	IsActive        bool           `json:"is_active" gorm:"default:true"`
-	LastLoginAt     *time.Time     `json:"last_login_at"`
-	CreatedAt       time.Time      `json:"created_at"`
-	UpdatedAt       time.Time      `json:"updated_at"`
-	DeletedAt       gorm.DeletedAt `json:"-" gorm:"index"`
+	ID                 uint           `json:"id" gorm:"primaryKey"`
+	Email              string         `json:"email" gorm:"uniqueIndex;not null"`
+	PasswordHash       string         `json:"-" gorm:"not null"`
+	RoleID             uint           `json:"role_id" gorm:"index"`
+	Role               *Role          `json:"role,omitempty" gorm:"foreignKey:RoleID"`
+	BranchID           *uint          `json:"branch_id" gorm:"index"`
+	AgentID            *uint          `json:"agent_id" gorm:"index"`
+	TwoFAEnabled       bool           `json:"two_fa_enabled" gorm:"default:false"`
+	TwoFASecret        string         `json:"-" gorm:"column:twofa_secret"`
+	TOTPLastStep       int64          `json:"-" gorm:"column:totp_last_step;default:0"`
+	IsActive           bool           `json:"is_active" gorm:"default:true"`
+	LockedUntil        *time.Time     `json:"locked_until"`
+	MustChangePassword bool           `json:"must_change_password" gorm:"default:false"`
+	PasswordChangedAt  *time.Time     `json:"password_changed_at"`
+	LastLoginAt        *time.Time     `json:"last_login_at"`
+	CreatedAt          time.Time      `json:"created_at"`
+	UpdatedAt          time.Time      `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// RoleName is the name of the user's role, or "" if Role wasn't preloaded
+func (u *User) RoleName() string {
+	if u.Role == nil {
+		return ""
+	}
+	return u.Role.Name
+}
+
+// IsLocked reports whether failed logins have the account locked right now
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
+}
+
+// RefreshToken records an issued refresh token for rotation and revocation.
+// Tokens rotated from the same login share a FamilyID.
+type RefreshToken struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	JTI        string     `json:"jti" gorm:"column:jti;uniqueIndex;not null"`
+	FamilyID   string     `json:"family_id" gorm:"index;not null"`
+	UserID     uint       `json:"user_id" gorm:"index;not null"`
+	User       User       `json:"-" gorm:"foreignKey:UserID"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	ReplacedBy string     `json:"replaced_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Session is one login of a user on a device. It spans every refresh token
+// rotated from that login, which share its FamilyID.
+type Session struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	FamilyID   string     `json:"-" gorm:"uniqueIndex;not null"`
+	UserID     uint       `json:"user_id" gorm:"index;not null"`
+	Device     string     `json:"device"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// RecoveryCode is a one-time 2FA backup code, stored as a bcrypt hash
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"index;not null"`
+	CodeHash  string     `json:"-" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// PasswordHistory keeps earlier password hashes so they can't be reused
+type PasswordHistory struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	UserID       uint      `json:"user_id" gorm:"index;not null"`
+	PasswordHash string    `json:"-" gorm:"not null"`
+	CreatedAt    time.Time `json:"created_at"`
 }
 
 // Branch represents a branch office
@@ -52,22 +118,124 @@ type Agent struct {
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
+// Customer types. Individuals are identified by their TC Kimlik No,
+// companies by their VKN and also have a tax office, trade title and
+// authorized person.
+const (
+	CustomerTypeIndividual = "bireysel"
+	CustomerTypeCorporate  = "kurumsal"
+)
+
 // Customer represents a customer
 type Customer struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	TCVKN       string         `json:"tc_vkn" gorm:"uniqueIndex;not null"`
-	Name        string         `json:"name" gorm:"not null"`
-	Email       string         `json:"email"`
-	Phone       string         `json:"phone"`
-	Address     string         `json:"address"`
-	City        string         `json:"city"`
-	District    string         `json:"district"`
-	PostalCode  string         `json:"postal_code"`
-	BirthDate   *time.Time     `json:"birth_date"`
-	Gender      string         `json:"gender"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+	ID               uint           `json:"id" gorm:"primaryKey"`
+	TCVKN            string         `json:"tc_vkn" gorm:"column:tc_vkn;uniqueIndex;not null"`
+	CustomerType     string         `json:"customer_type" gorm:"not null;default:bireysel"`
+	Name             string         `json:"name" gorm:"not null"`
+	Email            string         `json:"email"`
+	Phone            string         `json:"phone"`
+	Address          string         `json:"address"`
+	City             string         `json:"city"`
+	District         string         `json:"district"`
+	PostalCode       string         `json:"postal_code"`
+	BirthDate        *time.Time     `json:"birth_date"`
+	Gender           string         `json:"gender"`
+	TaxOffice        string         `json:"tax_office"`
+	TradeTitle       string         `json:"trade_title"`
+	AuthorizedPerson string         `json:"authorized_person"`
+	OwnerID          *uint          `json:"owner_id" gorm:"index"`  // user who created the customer
+	BranchID         *uint          `json:"branch_id" gorm:"index"` // owner's branch when created
+	AnonymizedAt     *time.Time     `json:"anonymized_at"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Phone, email and name normalized to find duplicates by, see
+	// DuplicateKeys. DuplicatesCheckedAt is when the customer's duplicate
+	// pairs were last scored.
+	PhoneKey            string     `json:"-" gorm:"index"`
+	EmailKey            string     `json:"-" gorm:"index"`
+	NameKey             string     `json:"-" gorm:"index"`
+	DuplicatesCheckedAt *time.Time `json:"-"`
+}
+
+// CustomerDuplicate is a scored pair of customers likely to be the same
+// person or company. CustomerID is the older customer's.
+type CustomerDuplicate struct {
+	CustomerID  uint     `json:"customer_id" gorm:"primaryKey;autoIncrement:false"`
+	DuplicateID uint     `json:"duplicate_id" gorm:"primaryKey;autoIncrement:false;index"`
+	Score       int      `json:"score" gorm:"not null;index"`
+	Reasons     string   `json:"reasons" gorm:"not null"` // comma separated: phone, email, birth_date, name
+	Customer    Customer `json:"-" gorm:"foreignKey:CustomerID"`
+	Duplicate   Customer `json:"-" gorm:"foreignKey:DuplicateID"`
+}
+
+// KVKK consent purposes. Marketing covers commercial electronic messages,
+// data_sharing passing customer data to insurers and reinsurers beyond what
+// a quote or policy requires, abroad_transfer storing or sending it abroad.
+const (
+	ConsentMarketing      = "marketing"
+	ConsentDataSharing    = "data_sharing"
+	ConsentAbroadTransfer = "abroad_transfer"
+)
+
+// Consent channels. Marketing consent is given per messaging channel, as
+// IYS records it; ConsentChannelAll covers every channel and is what the
+// other purposes are recorded with.
+const (
+	ConsentChannelSMS   = "sms"
+	ConsentChannelEmail = "email"
+	ConsentChannelCall  = "call"
+	ConsentChannelAll   = "all"
+)
+
+// CustomerConsent is a customer's explicit consent (açık rıza) for a purpose
+// over a channel, given to the version of the consent text they were shown.
+// Revoking keeps the record; consenting again adds a new one.
+type CustomerConsent struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	CustomerID  uint       `json:"customer_id" gorm:"not null;index"`
+	Purpose     string     `json:"purpose" gorm:"not null"`
+	Channel     string     `json:"channel" gorm:"not null"`
+	TextVersion string     `json:"text_version" gorm:"not null"`
+	GrantedAt   time.Time  `json:"granted_at" gorm:"not null"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	RecordedBy  *uint      `json:"recorded_by"` // the user who recorded the grant
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Customer import statuses
+const (
+	ImportPending   = "pending"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+// CustomerImport is a spreadsheet of customers being imported and its
+// outcome. The file is kept only until the import has run.
+type CustomerImport struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	UserID        uint       `json:"user_id" gorm:"not null;index"`
+	FileName      string     `json:"file_name" gorm:"not null"`
+	Format        string     `json:"format" gorm:"not null"` // csv, xlsx
+	MappingJSON   string     `json:"mapping_json" gorm:"type:jsonb"`
+	DryRun        bool       `json:"dry_run"`
+	File          []byte     `json:"-"`
+	Status        string     `json:"status" gorm:"not null;default:'pending'"`
+	TotalRows     int        `json:"total_rows"`
+	ProcessedRows int        `json:"processed_rows"`
+	Created       int        `json:"created"`
+	Updated       int        `json:"updated"`
+	Unchanged     int        `json:"unchanged"`
+	Failed        int        `json:"failed"`
+	ReportJSON    string     `json:"report_json" gorm:"type:jsonb"` // rows that failed and why
+	ErrorMsg      string     `json:"error_msg"`
+	StartedAt     *time.Time `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
 }
 
 // Product represents an insurance product
@@ -176,16 +344,23 @@ type Payment struct {
 
 // AuditLog represents audit trail
 type AuditLog struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	UserID    *uint     `json:"user_id"`
-	User      *User     `json:"user,omitempty" gorm:"foreignKey:UserID"`
-	Action    string    `json:"action" gorm:"not null"`
-	Entity    string    `json:"entity" gorm:"not null"`
-	EntityID  *uint     `json:"entity_id"`
-	IP        string    `json:"ip"`
-	UserAgent string    `json:"user_agent"`
-	MetaJSON  string    `json:"meta_json" gorm:"type:jsonb"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	UserID      *uint     `json:"user_id"`
+	User        *User     `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Action      string    `json:"action" gorm:"not null"`
+	Entity      string    `json:"entity" gorm:"not null"`
+	EntityID    *uint     `json:"entity_id"`
+	Method      string    `json:"method"`
+	Route       string    `json:"route"`
+	StatusCode  int       `json:"status_code"`
+	LatencyMS   int64     `json:"latency_ms"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	MetaJSON    string    `json:"meta_json" gorm:"type:jsonb"`
+	ChangesJSON *string   `json:"changes_json" gorm:"type:jsonb"` // field: {old, new} for updates
+	PrevHash    string    `json:"prev_hash"`
+	Hash        string    `json:"hash"`
+	CreatedAt   time.Time `json:"created_at"`
 }
 
 // ScraperTarget represents a scraping target
@@ -260,8 +435,33 @@ type Role struct {
 	Name        string         `json:"name" gorm:"uniqueIndex;not null"`
 	Description string         `json:"description"`
 	IsSystem    bool           `json:"is_system" gorm:"default:false"`
+	DataScope   string         `json:"data_scope" gorm:"not null;default:'own'"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
 	Permissions []Permission   `json:"permissions" gorm:"many2many:role_permissions;"`
 }
+
+// PermissionGrant gives one user a permission on top of their role, e.g.
+// while covering for a colleague on leave. A grant limited to a branch or an
+// agent's portfolio also lets the user see that branch's or agent's records.
+type PermissionGrant struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	UserID      uint       `json:"user_id" gorm:"index;not null"`
+	User        *User      `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Permission  string     `json:"permission" gorm:"not null"`
+	BranchID    *uint      `json:"branch_id"`
+	AgentUserID *uint      `json:"agent_user_id"`
+	Reason      string     `json:"reason"`
+	GrantedByID uint       `json:"granted_by_id"`
+	ExpiresAt   time.Time  `json:"expires_at" gorm:"index;not null"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	RevokedByID *uint      `json:"revoked_by_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Active reports whether the grant is neither revoked nor expired at now
+func (g *PermissionGrant) Active(now time.Time) bool {
+	return g.RevokedAt == nil && g.ExpiresAt.After(now)
+}
@@ -3,6 +3,7 @@ package repo
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"eesigorta/backend/internal/config"
 
@@ -35,9 +36,16 @@ func NewRepository(cfg *config.Config) (*Repository, error) {
 	// Auto-migrate tables
 	if err := db.AutoMigrate(
 		&User{},
+		&RefreshToken{},
+		&Session{},
+		&RecoveryCode{},
+		&PasswordHistory{},
 		&Branch{},
 		&Agent{},
 		&Customer{},
+		&CustomerDuplicate{},
+		&CustomerConsent{},
+		&CustomerImport{},
 		&Product{},
 		&Quote{},
 		&ScrapedQuote{},
@@ -51,15 +59,25 @@ func NewRepository(cfg *config.Config) (*Repository, error) {
 		&Permission{},
 		&Role{},
 		&RolePermission{},
+		&PermissionGrant{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
+	if err := migrateSearch(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate customer search: %w", err)
+	}
 
 	log.Println("Database connected and migrated successfully")
 
 	return &Repository{db: db}, nil
 }
 
+// NewRepositoryWithDB wraps an already opened and migrated database, e.g.
+// an in-memory SQLite one in tests
+func NewRepositoryWithDB(db *gorm.DB) *Repository {
+	return &Repository{db: db}
+}
+
 func (r *Repository) DB() *gorm.DB {
 	return r.db
 }
@@ -73,17 +91,17 @@ func (r *Repository) Close() error {
 }
 
 // Quote methods
-func (r *Repository) GetQuotes(page, pageSize int) ([]Quote, int64, error) {
+func (r *Repository) GetQuotes(scope DataScope, page, pageSize int) ([]Quote, int64, error) {
 	var quotes []Quote
 	var total int64
 
 	offset := (page - 1) * pageSize
 
-	if err := r.db.Model(&Quote{}).Count(&total).Error; err != nil {
+	if err := r.ScopedQuotes(scope).Count(&total).Error; err != nil {
 		return nil, 0, err
 	}
 
-	if err := r.db.Preload("Customer").Preload("Product").Preload("Agent").
+	if err := r.ScopedQuotes(scope).Preload("Customer").Preload("Product").Preload("Agent.Role").
 		Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&quotes).Error; err != nil {
 		return nil, 0, err
 	}
@@ -91,9 +109,9 @@ func (r *Repository) GetQuotes(page, pageSize int) ([]Quote, int64, error) {
 	return quotes, total, nil
 }
 
-func (r *Repository) GetQuoteByID(id uint) (*Quote, error) {
+func (r *Repository) GetQuoteByID(scope DataScope, id uint) (*Quote, error) {
 	var quote Quote
-	if err := r.db.Preload("Customer").Preload("Product").Preload("Agent").
+	if err := r.ScopedQuotes(scope).Preload("Customer").Preload("Product").Preload("Agent.Role").
 		First(&quote, id).Error; err != nil {
 		return nil, err
 	}
@@ -113,9 +131,9 @@ func (r *Repository) DeleteQuote(id uint) error {
 }
 
 // ScrapedQuote methods
-func (r *Repository) GetScrapedQuotesByQuoteID(quoteID uint) ([]ScrapedQuote, error) {
+func (r *Repository) GetScrapedQuotesByQuoteID(scope DataScope, quoteID uint) ([]ScrapedQuote, error) {
 	var scrapedQuotes []ScrapedQuote
-	if err := r.db.Where("quote_id = ?", quoteID).Order("final_price ASC").Find(&scrapedQuotes).Error; err != nil {
+	if err := r.db.Where("quote_id = ? AND quote_id IN (?)", quoteID, r.ScopedQuotes(scope).Select("quotes.id")).Order("final_price ASC").Find(&scrapedQuotes).Error; err != nil {
 		return nil, err
 	}
 	return scrapedQuotes, nil
@@ -134,17 +152,17 @@ func (r *Repository) CreateScrapedQuote(scrapedQuote *ScrapedQuote) error {
 }
 
 // Policy methods
-func (r *Repository) GetPolicies(page, pageSize int) ([]Policy, int64, error) {
+func (r *Repository) GetPolicies(scope DataScope, page, pageSize int) ([]Policy, int64, error) {
 	var policies []Policy
 	var total int64
 
 	offset := (page - 1) * pageSize
 
-	if err := r.db.Model(&Policy{}).Count(&total).Error; err != nil {
+	if err := r.ScopedPolicies(scope).Count(&total).Error; err != nil {
 		return nil, 0, err
 	}
 
-	if err := r.db.Preload("Customer").Preload("Product").Preload("Agent").
+	if err := r.ScopedPolicies(scope).Preload("Customer").Preload("Product").Preload("Agent.Role").
 		Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&policies).Error; err != nil {
 		return nil, 0, err
 	}
@@ -152,9 +170,9 @@ func (r *Repository) GetPolicies(page, pageSize int) ([]Policy, int64, error) {
 	return policies, total, nil
 }
 
-func (r *Repository) GetPolicyByID(id uint) (*Policy, error) {
+func (r *Repository) GetPolicyByID(scope DataScope, id uint) (*Policy, error) {
 	var policy Policy
-	if err := r.db.Preload("Customer").Preload("Product").Preload("Agent").
+	if err := r.ScopedPolicies(scope).Preload("Customer").Preload("Product").Preload("Agent.Role").
 		First(&policy, id).Error; err != nil {
 		return nil, err
 	}
@@ -217,8 +235,7 @@ func (r *Repository) GetCustomers(page, pageSize int) ([]Customer, int64, error)
 		return nil, 0, err
 	}
 
-	if err := r.db.Preload("Agent").Preload("Branch").
-		Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&customers).Error; err != nil {
+	if err := r.db.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&customers).Error; err != nil {
 		return nil, 0, err
 	}
 
@@ -227,8 +244,7 @@ func (r *Repository) GetCustomers(page, pageSize int) ([]Customer, int64, error)
 
 func (r *Repository) GetCustomerByID(id uint) (*Customer, error) {
 	var customer Customer
-	if err := r.db.Preload("Agent").Preload("Branch").
-		First(&customer, id).Error; err != nil {
+	if err := r.db.First(&customer, id).Error; err != nil {
 		return nil, err
 	}
 	return &customer, nil
@@ -246,18 +262,121 @@ func (r *Repository) DeleteCustomer(id uint) error {
 	return r.db.Delete(&Customer{}, id).Error
 }
 
+// CustomerMerge counts the records a customer merge moved
+type CustomerMerge struct {
+	Quotes   int64 `json:"quotes"`
+	Policies int64 `json:"policies"`
+	Payments int64 `json:"payments"` // moved along with their policies
+	Consents int64 `json:"consents"`
+}
+
+// MergeCustomers moves every quote, policy and consent of the duplicate,
+// deleted quotes and policies included, onto the survivor and soft-deletes
+// the duplicate along with its duplicate pairs, all in one transaction. It
+// returns gorm.ErrRecordNotFound when the duplicate is already gone, e.g.
+// merged by someone else in the meantime, and ErrCustomerAnonymized when
+// either customer is anonymized.
+func (r *Repository) MergeCustomers(survivorID, duplicateID uint) (*CustomerMerge, error) {
+	merge := &CustomerMerge{}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var anonymized int64
+		if err := tx.Unscoped().Model(&Customer{}).
+			Where("id IN ? AND anonymized_at IS NOT NULL", []uint{survivorID, duplicateID}).
+			Count(&anonymized).Error; err != nil {
+			return err
+		}
+		if anonymized > 0 {
+			return ErrCustomerAnonymized
+		}
+
+		policies := tx.Unscoped().Model(&Policy{}).Select("id").Where("customer_id = ?", duplicateID)
+		if err := tx.Unscoped().Model(&Payment{}).Where("policy_id IN (?)", policies).Count(&merge.Payments).Error; err != nil {
+			return err
+		}
+
+		result := tx.Unscoped().Model(&Quote{}).Where("customer_id = ?", duplicateID).Update("customer_id", survivorID)
+		if result.Error != nil {
+			return result.Error
+		}
+		merge.Quotes = result.RowsAffected
+
+		result = tx.Unscoped().Model(&Policy{}).Where("customer_id = ?", duplicateID).Update("customer_id", survivorID)
+		if result.Error != nil {
+			return result.Error
+		}
+		merge.Policies = result.RowsAffected
+
+		result = tx.Model(&CustomerConsent{}).Where("customer_id = ?", duplicateID).Update("customer_id", survivorID)
+		if result.Error != nil {
+			return result.Error
+		}
+		merge.Consents = result.RowsAffected
+
+		result = tx.Delete(&Customer{}, duplicateID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return deleteCustomerDuplicates(tx, duplicateID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merge, nil
+}
+
+// Customer import methods
+func (r *Repository) CreateCustomerImport(customerImport *CustomerImport) error {
+	return r.db.Create(customerImport).Error
+}
+
+func (r *Repository) GetCustomerImport(id uint) (*CustomerImport, error) {
+	var customerImport CustomerImport
+	if err := r.db.First(&customerImport, id).Error; err != nil {
+		return nil, err
+	}
+	return &customerImport, nil
+}
+
+func (r *Repository) UpdateCustomerImport(customerImport *CustomerImport) error {
+	return r.db.Save(customerImport).Error
+}
+
+// UpdateCustomerImportProgress records how many rows a running import has
+// gone through, without touching the rest of the record
+func (r *Repository) UpdateCustomerImportProgress(id uint, processed int) error {
+	return r.db.Model(&CustomerImport{}).Where("id = ?", id).Update("processed_rows", processed).Error
+}
+
+// GetCustomersByTCVKN loads the customers with the given identifiers,
+// deleted ones included since their identifiers can't be reused
+func (r *Repository) GetCustomersByTCVKN(identifiers []string) ([]Customer, error) {
+	var customers []Customer
+	for start := 0; start < len(identifiers); start += 500 {
+		var batch []Customer
+		chunk := identifiers[start:min(start+500, len(identifiers))]
+		if err := r.db.Unscoped().Where("tc_vkn IN ?", chunk).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		customers = append(customers, batch...)
+	}
+	return customers, nil
+}
+
 // Branch methods
-func (r *Repository) GetBranches(page, pageSize int) ([]Branch, int64, error) {
+func (r *Repository) GetBranches(scope DataScope, page, pageSize int) ([]Branch, int64, error) {
 	var branches []Branch
 	var total int64
 
 	offset := (page - 1) * pageSize
 
-	if err := r.db.Model(&Branch{}).Count(&total).Error; err != nil {
+	if err := r.ScopedBranches(scope).Count(&total).Error; err != nil {
 		return nil, 0, err
 	}
 
-	if err := r.db.Preload("Manager").
+	if err := r.ScopedBranches(scope).Preload("Manager.Role").
 		Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&branches).Error; err != nil {
 		return nil, 0, err
 	}
@@ -265,9 +384,9 @@ func (r *Repository) GetBranches(page, pageSize int) ([]Branch, int64, error) {
 	return branches, total, nil
 }
 
-func (r *Repository) GetBranchByID(id uint) (*Branch, error) {
+func (r *Repository) GetBranchByID(scope DataScope, id uint) (*Branch, error) {
 	var branch Branch
-	if err := r.db.Preload("Manager").First(&branch, id).Error; err != nil {
+	if err := r.ScopedBranches(scope).Preload("Manager.Role").First(&branch, id).Error; err != nil {
 		return nil, err
 	}
 	return &branch, nil
@@ -286,17 +405,17 @@ func (r *Repository) DeleteBranch(id uint) error {
 }
 
 // Agent methods
-func (r *Repository) GetAgents(page, pageSize int) ([]Agent, int64, error) {
+func (r *Repository) GetAgents(scope DataScope, page, pageSize int) ([]Agent, int64, error) {
 	var agents []Agent
 	var total int64
 
 	offset := (page - 1) * pageSize
 
-	if err := r.db.Model(&Agent{}).Count(&total).Error; err != nil {
+	if err := r.ScopedAgents(scope).Count(&total).Error; err != nil {
 		return nil, 0, err
 	}
 
-	if err := r.db.Preload("Branch").
+	if err := r.ScopedAgents(scope).Preload("Branch").
 		Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&agents).Error; err != nil {
 		return nil, 0, err
 	}
@@ -304,9 +423,9 @@ func (r *Repository) GetAgents(page, pageSize int) ([]Agent, int64, error) {
 	return agents, total, nil
 }
 
-func (r *Repository) GetAgentByID(id uint) (*Agent, error) {
+func (r *Repository) GetAgentByID(scope DataScope, id uint) (*Agent, error) {
 	var agent Agent
-	if err := r.db.Preload("Branch").First(&agent, id).Error; err != nil {
+	if err := r.ScopedAgents(scope).Preload("Branch").First(&agent, id).Error; err != nil {
 		return nil, err
 	}
 	return &agent, nil
@@ -324,3 +443,390 @@ func (r *Repository) DeleteAgent(id uint) error {
 	return r.db.Delete(&Agent{}, id).Error
 }
 
+// RefreshToken methods
+func (r *Repository) CreateRefreshToken(token *RefreshToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *Repository) GetRefreshTokenByJTI(jti string) (*RefreshToken, error) {
+	var token RefreshToken
+	if err := r.db.Where("jti = ?", jti).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RotateRefreshToken revokes the old token and stores its replacement in one
+// transaction. It fails with gorm.ErrRecordNotFound if the old token was
+// revoked concurrently, so the same token can't be rotated twice.
+func (r *Repository) RotateRefreshToken(old *RefreshToken, replacement *RefreshToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", old.ID).
+			Updates(map[string]interface{}{"revoked_at": time.Now(), "replaced_by": replacement.JTI})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Create(replacement).Error
+	})
+}
+
+// RevokeRefreshTokenFamily ends a session: its refresh tokens can no longer
+// be rotated and its access tokens are rejected
+func (r *Repository) RevokeRefreshTokenFamily(familyID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", familyID).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Session{}).
+			Where("family_id = ? AND revoked_at IS NULL", familyID).
+			Update("revoked_at", now).Error
+	})
+}
+
+// RevokeUserRefreshTokens ends every session of a user, e.g. after a
+// password reset or deactivation
+func (r *Repository) RevokeUserRefreshTokens(userID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error
+	})
+}
+
+func (r *Repository) DeleteExpiredRefreshTokens(before time.Time) (int64, error) {
+	result := r.db.Where("expires_at < ?", before).Delete(&RefreshToken{})
+	return result.RowsAffected, result.Error
+}
+
+// Session methods
+func (r *Repository) CreateSession(session *Session) error {
+	return r.db.Create(session).Error
+}
+
+func (r *Repository) GetSessionByFamilyID(familyID string) (*Session, error) {
+	var session Session
+	if err := r.db.Where("family_id = ?", familyID).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetUserSession loads a session only if it belongs to the user and hasn't
+// been revoked, so ending a session twice finds nothing
+func (r *Repository) GetUserSession(userID, sessionID uint) (*Session, error) {
+	var session Session
+	if err := r.db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetActiveSessions lists a user's sessions that are neither revoked nor
+// expired, most recently used first
+func (r *Repository) GetActiveSessions(userID uint) ([]Session, error) {
+	var sessions []Session
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// TouchSession records activity on a session. updates holds the columns to
+// change besides last_seen_at, e.g. a new ip or expires_at after a refresh.
+func (r *Repository) TouchSession(familyID string, updates map[string]interface{}) error {
+	if updates == nil {
+		updates = map[string]interface{}{}
+	}
+	updates["last_seen_at"] = time.Now()
+	return r.db.Model(&Session{}).Where("family_id = ?", familyID).Updates(updates).Error
+}
+
+func (r *Repository) DeleteExpiredSessions(before time.Time) (int64, error) {
+	result := r.db.Where("expires_at < ?", before).Delete(&Session{})
+	return result.RowsAffected, result.Error
+}
+
+// AdvanceTOTPStep records the last accepted TOTP time step. It reports
+// false if an equal or later step was already stored, which means the code
+// was replayed by a concurrent request.
+func (r *Repository) AdvanceTOTPStep(userID uint, step int64) (bool, error) {
+	result := r.db.Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// SetPassword stores a new password hash, records it in the history and
+// trims the history to the newest keep entries
+func (r *Repository) SetPassword(user *User, passwordHash string, mustChange bool, keep int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(user).Updates(map[string]interface{}{
+			"password_hash":        passwordHash,
+			"must_change_password": mustChange,
+			"password_changed_at":  now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Create(&PasswordHistory{UserID: user.ID, PasswordHash: passwordHash}).Error; err != nil {
+			return err
+		}
+
+		var stale []uint
+		err = tx.Model(&PasswordHistory{}).
+			Where("user_id = ?", user.ID).
+			Order("created_at DESC, id DESC").
+			Offset(keep).
+			Pluck("id", &stale).Error
+		if err != nil || len(stale) == 0 {
+			return err
+		}
+		return tx.Delete(&PasswordHistory{}, stale).Error
+	})
+}
+
+// GetPasswordHistory returns the newest limit password hashes of a user
+func (r *Repository) GetPasswordHistory(userID uint, limit int) ([]PasswordHistory, error) {
+	var history []PasswordHistory
+	err := r.db.Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&history).Error
+	return history, err
+}
+
+// LockUser blocks password logins for the user until the given time
+func (r *Repository) LockUser(userID uint, until time.Time) error {
+	return r.db.Model(&User{}).Where("id = ?", userID).Update("locked_until", until).Error
+}
+
+func (r *Repository) UnlockUser(userID uint) error {
+	return r.db.Model(&User{}).Where("id = ?", userID).Update("locked_until", nil).Error
+}
+
+// RecoveryCode methods
+
+// ReplaceRecoveryCodes discards any existing codes for the user and stores
+// the new hashes
+func (r *Repository) ReplaceRecoveryCodes(userID uint, codeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for _, hash := range codeHashes {
+			if err := tx.Create(&RecoveryCode{UserID: userID, CodeHash: hash}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *Repository) GetUnusedRecoveryCodes(userID uint) ([]RecoveryCode, error) {
+	var codes []RecoveryCode
+	if err := r.db.Where("user_id = ? AND used_at IS NULL", userID).Find(&codes).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode marks a code as used. It reports false if the code was
+// already consumed, so a code can't be redeemed twice by concurrent logins.
+func (r *Repository) UseRecoveryCode(id uint) (bool, error) {
+	result := r.db.Model(&RecoveryCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *Repository) DeleteRecoveryCodes(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+}
+
+// Role methods
+
+func (r *Repository) GetRoles() ([]Role, error) {
+	var roles []Role
+	if err := r.db.Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r *Repository) GetRoleByID(id uint) (*Role, error) {
+	var role Role
+	if err := r.db.Preload("Permissions").First(&role, id).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// CountUsersByRole returns the number of users holding each role
+func (r *Repository) CountUsersByRole() (map[uint]int64, error) {
+	var rows []struct {
+		RoleID uint
+		Count  int64
+	}
+	err := r.db.Model(&User{}).Select("role_id, COUNT(*) AS count").Group("role_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		counts[row.RoleID] = row.Count
+	}
+	return counts, nil
+}
+
+func (r *Repository) GetPermissions() ([]Permission, error) {
+	var permissions []Permission
+	if err := r.db.Order("resource, action").Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+func (r *Repository) GetPermissionsByName(names []string) ([]Permission, error) {
+	var permissions []Permission
+	if err := r.db.Where("name IN ?", names).Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// CreateRole stores a role together with its permission set
+func (r *Repository) CreateRole(role *Role, permissions []Permission) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Permissions").Create(role).Error; err != nil {
+			return err
+		}
+		return replaceRolePermissions(tx, role.ID, permissions)
+	})
+}
+
+// UpdateRole saves a role's description and data scope and replaces its
+// permission set
+func (r *Repository) UpdateRole(role *Role, permissions []Permission) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(role).Updates(map[string]interface{}{
+			"description": role.Description,
+			"data_scope":  role.DataScope,
+		}).Error; err != nil {
+			return err
+		}
+		return replaceRolePermissions(tx, role.ID, permissions)
+	})
+}
+
+// DeleteRole removes a role and its permission assignments for good, so its
+// name can be reused
+func (r *Repository) DeleteRole(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", id).Delete(&RolePermission{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&Role{}, id).Error
+	})
+}
+
+func replaceRolePermissions(tx *gorm.DB, roleID uint, permissions []Permission) error {
+	if err := tx.Where("role_id = ?", roleID).Delete(&RolePermission{}).Error; err != nil {
+		return err
+	}
+	for _, permission := range permissions {
+		if err := tx.Create(&RolePermission{RoleID: roleID, PermissionID: permission.ID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PermissionGrant methods
+
+// ActiveGrants limits a permission grant query to grants neither revoked nor
+// expired at now
+func ActiveGrants(now time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("permission_grants.revoked_at IS NULL AND permission_grants.expires_at > ?", now)
+	}
+}
+
+// UnlimitedGrants limits a permission grant query to grants not limited to a
+// branch or an agent user
+func UnlimitedGrants(db *gorm.DB) *gorm.DB {
+	return db.Where("permission_grants.branch_id IS NULL AND permission_grants.agent_user_id IS NULL")
+}
+
+func (r *Repository) CreatePermissionGrant(grant *PermissionGrant) error {
+	return r.db.Create(grant).Error
+}
+
+// GetPermissionGrants lists a user's grants, newest first, including revoked
+// and expired ones the cleanup job hasn't removed yet
+func (r *Repository) GetPermissionGrants(userID uint) ([]PermissionGrant, error) {
+	var grants []PermissionGrant
+	if err := r.db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+func (r *Repository) GetPermissionGrant(userID, id uint) (*PermissionGrant, error) {
+	var grant PermissionGrant
+	if err := r.db.Where("user_id = ?", userID).First(&grant, id).Error; err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// GetActivePermissionGrants lists the grants a user holds at now
+func (r *Repository) GetActivePermissionGrants(userID uint, now time.Time) ([]PermissionGrant, error) {
+	var grants []PermissionGrant
+	if err := r.db.Scopes(ActiveGrants(now)).Where("user_id = ?", userID).Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// RevokePermissionGrant ends a grant early. It fails with
+// gorm.ErrRecordNotFound if the grant was already revoked.
+func (r *Repository) RevokePermissionGrant(grant *PermissionGrant, revokedByID uint, now time.Time) error {
+	result := r.db.Model(grant).Where("revoked_at IS NULL").Updates(map[string]interface{}{
+		"revoked_at":    now,
+		"revoked_by_id": revokedByID,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	grant.RevokedAt = &now
+	grant.RevokedByID = &revokedByID
+	return nil
+}
+
+// DeleteOldPermissionGrants removes grants that expired or were revoked
+// before the cutoff. The audit log keeps their history.
+func (r *Repository) DeleteOldPermissionGrants(before time.Time) (int64, error) {
+	result := r.db.Where("expires_at < ? OR revoked_at < ?", before, before).Delete(&PermissionGrant{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,252 @@
+package repo
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Data scope levels a role can have. They decide which quotes, policies,
+// agents and branches the role's users see.
+const (
+	ScopeAll    = "all"    // every record in the company
+	ScopeBranch = "branch" // records of the user's branch
+	ScopeOwn    = "own"    // records the user is the agent of
+)
+
+// DataScope limits queries to the records one user may see. The zero value
+// matches nothing, so a scope that failed to load never widens access.
+// Active grants limited to a branch or an agent add their records on top of
+// the role's level, for the granted permission only: ForPermission picks
+// them for the permission a request was let in with.
+type DataScope struct {
+	Level              string `json:"level"`
+	UserID             uint   `json:"user_id"`
+	BranchID           *uint  `json:"branch_id"`
+	AgentID            *uint  `json:"agent_id"`
+	DelegatedBranchIDs []uint `json:"delegated_branch_ids,omitempty"`
+	DelegatedUserIDs   []uint `json:"delegated_user_ids,omitempty"`
+	// Delegations are the branches and agent users of the limited grants,
+	// by permission
+	Delegations map[string]Delegation `json:"delegations,omitempty"`
+}
+
+// Delegation is what a user's limited grants of one permission open up
+type Delegation struct {
+	BranchIDs []uint `json:"branch_ids,omitempty"`
+	UserIDs   []uint `json:"user_ids,omitempty"`
+}
+
+// ForPermission is the scope of a request let in with the permission: the
+// role's level when the user holds the permission outright, nothing
+// otherwise, plus what the permission's limited grants delegate
+func (s DataScope) ForPermission(permission string, held bool) DataScope {
+	scoped := s
+	if !held {
+		scoped.Level = ""
+	}
+	delegation := s.Delegations[permission]
+	scoped.DelegatedBranchIDs = delegation.BranchIDs
+	scoped.DelegatedUserIDs = delegation.UserIDs
+	return scoped
+}
+
+// GlobalScope is for background jobs and other work done on behalf of the
+// system rather than a user
+var GlobalScope = DataScope{Level: ScopeAll}
+
+// GetDataScope loads the scope of a user from their role and assignment
+func (r *Repository) GetDataScope(userID uint) (DataScope, error) {
+	var row struct {
+		DataScope string
+		BranchID  *uint
+		AgentID   *uint
+	}
+	result := r.db.Model(&User{}).
+		Select("roles.data_scope, users.branch_id, users.agent_id").
+		Joins("JOIN roles ON roles.id = users.role_id AND roles.deleted_at IS NULL").
+		Where("users.id = ?", userID).
+		Scan(&row)
+	if result.Error != nil {
+		return DataScope{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return DataScope{}, gorm.ErrRecordNotFound
+	}
+
+	scope := DataScope{
+		Level:    row.DataScope,
+		UserID:   userID,
+		BranchID: row.BranchID,
+		AgentID:  row.AgentID,
+	}
+
+	grants, err := r.GetActivePermissionGrants(userID, time.Now())
+	if err != nil {
+		return DataScope{}, err
+	}
+	for _, grant := range grants {
+		if grant.BranchID == nil && grant.AgentUserID == nil {
+			continue
+		}
+		if scope.Delegations == nil {
+			scope.Delegations = make(map[string]Delegation)
+		}
+		delegation := scope.Delegations[grant.Permission]
+		if grant.BranchID != nil {
+			delegation.BranchIDs = appendUnique(delegation.BranchIDs, *grant.BranchID)
+		}
+		if grant.AgentUserID != nil {
+			delegation.UserIDs = appendUnique(delegation.UserIDs, *grant.AgentUserID)
+		}
+		scope.Delegations[grant.Permission] = delegation
+	}
+
+	return scope, nil
+}
+
+func appendUnique(ids []uint, id uint) []uint {
+	for _, existing := range ids {
+		if existing == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
+
+// ScopedQuotes starts a quote query limited to the scope
+func (r *Repository) ScopedQuotes(scope DataScope) *gorm.DB {
+	return r.byAgentUser(r.db.Model(&Quote{}), scope, "quotes.agent_id")
+}
+
+// ScopedPolicies starts a policy query limited to the scope
+func (r *Repository) ScopedPolicies(scope DataScope) *gorm.DB {
+	return r.byAgentUser(r.db.Model(&Policy{}), scope, "policies.agent_id")
+}
+
+// ScopedAgents starts an agent query limited to the scope. An "own" scope
+// sees only the agent record linked to the user.
+func (r *Repository) ScopedAgents(scope DataScope) *gorm.DB {
+	db := r.db.Model(&Agent{})
+	if scope.Level == ScopeAll {
+		return db
+	}
+
+	conds := r.db.Where("1 = 0")
+	switch scope.Level {
+	case ScopeBranch:
+		if scope.BranchID != nil {
+			conds = conds.Or("agents.branch_id = ?", *scope.BranchID)
+		}
+	case ScopeOwn:
+		if scope.AgentID != nil {
+			conds = conds.Or("agents.id = ?", *scope.AgentID)
+		}
+	}
+	if len(scope.DelegatedBranchIDs) > 0 {
+		conds = conds.Or("agents.branch_id IN ?", scope.DelegatedBranchIDs)
+	}
+	if len(scope.DelegatedUserIDs) > 0 {
+		conds = conds.Or("agents.id IN (?)",
+			r.db.Unscoped().Model(&User{}).Select("agent_id").Where("id IN ?", scope.DelegatedUserIDs))
+	}
+	return db.Where(conds)
+}
+
+// ScopedBranches starts a branch query limited to the scope. Users below
+// the global view see only the branch they are assigned to and those they
+// were granted access to.
+func (r *Repository) ScopedBranches(scope DataScope) *gorm.DB {
+	db := r.db.Model(&Branch{})
+	if scope.Level == ScopeAll {
+		return db
+	}
+
+	conds := r.db.Where("1 = 0")
+	if scope.BranchID != nil && (scope.Level == ScopeBranch || scope.Level == ScopeOwn) {
+		conds = conds.Or("branches.id = ?", *scope.BranchID)
+	}
+	if len(scope.DelegatedBranchIDs) > 0 {
+		conds = conds.Or("branches.id IN ?", scope.DelegatedBranchIDs)
+	}
+	if len(scope.DelegatedUserIDs) > 0 {
+		conds = conds.Or("branches.id IN (?)",
+			r.db.Unscoped().Model(&User{}).Select("branch_id").Where("id IN ?", scope.DelegatedUserIDs))
+	}
+	return db.Where(conds)
+}
+
+// ScopedCustomers starts a customer query limited to the scope: customers
+// created by a user or in a branch of the scope, and customers with a quote
+// or policy in it. Customers created before ownership was recorded are
+// only reached through their quotes and policies.
+func (r *Repository) ScopedCustomers(scope DataScope) *gorm.DB {
+	db := r.db.Model(&Customer{})
+	if scope.Level == ScopeAll {
+		return db
+	}
+
+	conds := r.agentUserConds(scope, "customers.owner_id").
+		Or("customers.id IN (?)", r.ScopedQuotes(scope).Select("quotes.customer_id")).
+		Or("customers.id IN (?)", r.ScopedPolicies(scope).Select("policies.customer_id"))
+	if scope.Level == ScopeBranch && scope.BranchID != nil {
+		conds = conds.Or("customers.branch_id = ?", *scope.BranchID)
+	}
+	if len(scope.DelegatedBranchIDs) > 0 {
+		conds = conds.Or("customers.branch_id IN ?", scope.DelegatedBranchIDs)
+	}
+	return db.Where(conds)
+}
+
+// CustomerInScope reports whether a customer, deleted or not, is inside the
+// scope
+func (r *Repository) CustomerInScope(scope DataScope, id uint) (bool, error) {
+	var count int64
+	err := r.ScopedCustomers(scope).Unscoped().Where("customers.id = ?", id).Count(&count).Error
+	return count > 0, err
+}
+
+// UserInScope reports whether records of the given agent user are inside
+// the scope, e.g. before a policy is assigned to them
+func (r *Repository) UserInScope(scope DataScope, userID uint) (bool, error) {
+	var count int64
+	err := r.byAgentUser(r.db.Unscoped().Model(&User{}), scope, "users.id").
+		Where("users.id = ?", userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// byAgentUser limits a query on a column holding the agent user of a record
+func (r *Repository) byAgentUser(db *gorm.DB, scope DataScope, column string) *gorm.DB {
+	if scope.Level == ScopeAll {
+		return db
+	}
+	return db.Where(r.agentUserConds(scope, column))
+}
+
+// agentUserConds are the conditions matching a column holding a user of the
+// scope, for queries that widen them with other ways into the scope
+func (r *Repository) agentUserConds(scope DataScope, column string) *gorm.DB {
+	conds := r.db.Where("1 = 0")
+	switch scope.Level {
+	case ScopeBranch:
+		if scope.BranchID != nil {
+			conds = conds.Or(column+" IN (?)", r.branchUsers(*scope.BranchID))
+		}
+	case ScopeOwn:
+		conds = conds.Or(column+" = ?", scope.UserID)
+	}
+	if len(scope.DelegatedUserIDs) > 0 {
+		conds = conds.Or(column+" IN ?", scope.DelegatedUserIDs)
+	}
+	if len(scope.DelegatedBranchIDs) > 0 {
+		conds = conds.Or(column+" IN (?)", r.branchUsers(scope.DelegatedBranchIDs...))
+	}
+	return conds
+}
+
+// branchUsers selects the IDs of the users of the branches. Deleted users
+// still count, their records stay with the branch.
+func (r *Repository) branchUsers(branchIDs ...uint) *gorm.DB {
+	return r.db.Unscoped().Model(&User{}).Select("id").Where("branch_id IN ?", branchIDs)
+}
@@ -0,0 +1,201 @@
+package repo
+
+import (
+	"time"
+
+	"eesigorta/backend/internal/filter"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// searchSchema is what customer search and text filters need on Postgres
+// beyond the tables AutoMigrate creates: tr_fold, the search vector columns
+// and their indexes. Each statement can run again, so it runs at every
+// start, on databases created from migrations/0001_init.sql too.
+var searchSchema = []string{
+	// Must match filter.Fold
+	`CREATE OR REPLACE FUNCTION tr_fold(value TEXT) RETURNS TEXT AS $$
+		SELECT lower(translate(value, 'İIı', 'iii'))
+	$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE`,
+	`ALTER TABLE customers ADD COLUMN IF NOT EXISTS search_vector TSVECTOR GENERATED ALWAYS AS (
+		to_tsvector('simple', tr_fold(
+			coalesce(name, '') || ' ' || coalesce(trade_title, '') || ' ' ||
+			coalesce(email, '') || ' ' || coalesce(phone, '') || ' ' || tc_vkn
+		))
+	) STORED`,
+	`ALTER TABLE customers ADD COLUMN IF NOT EXISTS name_search_vector TSVECTOR GENERATED ALWAYS AS (
+		to_tsvector('simple', tr_fold(coalesce(name, '') || ' ' || coalesce(trade_title, '')))
+	) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_customers_search_vector ON customers USING GIN (search_vector)`,
+	`CREATE INDEX IF NOT EXISTS idx_customers_name_search_vector ON customers USING GIN (name_search_vector)`,
+	`CREATE INDEX IF NOT EXISTS idx_customers_city ON customers (tr_fold(city))`,
+	`CREATE INDEX IF NOT EXISTS idx_customers_district ON customers (tr_fold(district))`,
+}
+
+func migrateSearch(db *gorm.DB) error {
+	for _, statement := range searchSchema {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CustomerFilters is the filter schema of the customer list. agent_id and
+// branch_id find the customers the agent user or the branch's users created
+// or have a quote or policy with. Users who may not see personal data
+// (pii false) can't search by it or filter on birth dates.
+func (r *Repository) CustomerFilters(pii bool) filter.Schema {
+	schema := filter.Schema{
+		Fields: map[string]filter.Field{
+			"name":          {Kind: filter.Text, Column: "customers.name", Sortable: true},
+			"customer_type": {Kind: filter.Enum, Column: "customers.customer_type", Values: []string{CustomerTypeIndividual, CustomerTypeCorporate}},
+			"city":          {Kind: filter.Text, Column: "customers.city", Sortable: true},
+			"district":      {Kind: filter.Text, Column: "customers.district", Sortable: true},
+			"gender":        {Kind: filter.Enum, Column: "customers.gender"},
+			"birth_date":    {Kind: filter.Date, Column: "customers.birth_date", Sortable: true},
+			"age":           {Kind: filter.Int, Where: r.customerAge},
+			"has_active_policy": {Kind: filter.Bool, Where: func(db *gorm.DB, cond filter.Condition) *gorm.DB {
+				active := r.db.Model(&Policy{}).Select("customer_id").Where("status = ?", "active")
+				if cond.Value.(bool) {
+					return db.Where("customers.id IN (?)", active)
+				}
+				return db.Where("customers.id NOT IN (?)", active)
+			}},
+			"product_type": {Kind: filter.Enum, Where: func(db *gorm.DB, cond filter.Condition) *gorm.DB {
+				return db.Where("customers.id IN (?)", r.db.Model(&Policy{}).Select("policies.customer_id").
+					Joins("JOIN products ON products.id = policies.product_id").
+					Where(cond.SQL("products.type"), cond.Value))
+			}},
+			"agent_id": {Kind: filter.ID, Where: func(db *gorm.DB, cond filter.Condition) *gorm.DB {
+				return r.customersOfAgents(db, r.db.Unscoped().Model(&User{}).Select("id").Where(cond.SQL("id"), cond.Value))
+			}},
+			"branch_id": {Kind: filter.ID, Where: func(db *gorm.DB, cond filter.Condition) *gorm.DB {
+				return r.customersOfAgents(db, r.db.Unscoped().Model(&User{}).Select("id").Where(cond.SQL("branch_id"), cond.Value))
+			}},
+			"created_at": {Kind: filter.Date, Column: "customers.created_at", Sortable: true},
+			"updated_at": {Kind: filter.Date, Column: "customers.updated_at", Sortable: true},
+		},
+		Search: func(db *gorm.DB, search string) *gorm.DB {
+			return r.searchCustomers(db, search, pii)
+		},
+		DefaultSort: []filter.Sort{{Field: "created_at", Desc: true}},
+		Key:         "customers.id",
+	}
+	if !pii {
+		for _, name := range []string{"birth_date", "age"} {
+			field := schema.Fields[name]
+			field.Denied = "needs the customer:read_pii permission"
+			schema.Fields[name] = field
+		}
+	}
+	return schema
+}
+
+// customersOfAgents limits a customer query to customers created by the
+// agent users or with a quote or policy of them
+func (r *Repository) customersOfAgents(db *gorm.DB, agents *gorm.DB) *gorm.DB {
+	return db.Where("customers.owner_id IN (?) OR customers.id IN (?) OR customers.id IN (?)",
+		agents,
+		r.db.Model(&Quote{}).Select("customer_id").Where("agent_id IN (?)", agents),
+		r.db.Model(&Policy{}).Select("customer_id").Where("agent_id IN (?)", agents))
+}
+
+// customerAge filters on age in whole years as of today, through the birth
+// date so the birth date index serves it
+func (r *Repository) customerAge(db *gorm.DB, cond filter.Condition) *gorm.DB {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	// born is the birth date range of the customers who are age years old
+	born := func(age int64) (from, to time.Time) {
+		to = today.AddDate(-int(age), 0, 0)
+		return to.AddDate(-1, 0, 1), to
+	}
+
+	switch cond.Op {
+	case filter.OpIn:
+		conds := r.db.Where("1 = 0")
+		for _, age := range cond.Value.([]interface{}) {
+			from, to := born(age.(int64))
+			conds = conds.Or("customers.birth_date BETWEEN ? AND ?", from, to)
+		}
+		return db.Where(conds)
+	case filter.OpGt:
+		_, to := born(cond.Value.(int64) + 1)
+		return db.Where("customers.birth_date <= ?", to)
+	case filter.OpGte:
+		_, to := born(cond.Value.(int64))
+		return db.Where("customers.birth_date <= ?", to)
+	case filter.OpLt:
+		from, _ := born(cond.Value.(int64) - 1)
+		return db.Where("customers.birth_date >= ?", from)
+	case filter.OpLte:
+		from, _ := born(cond.Value.(int64))
+		return db.Where("customers.birth_date >= ?", from)
+	}
+	from, to := born(cond.Value.(int64))
+	return db.Where("customers.birth_date BETWEEN ? AND ?", from, to)
+}
+
+// searchVector is the column customer searches match: search_vector holds
+// the email, phone and TC/VKN too, name_search_vector only the name and
+// trade title
+func searchVector(pii bool) string {
+	if pii {
+		return "customers.search_vector"
+	}
+	return "customers.name_search_vector"
+}
+
+// searchCustomers finds customers by words of their name or trade title,
+// and with pii also of their email, phone or TC/VKN, each matching the
+// start of a word. Postgres serves it from the search vector columns and
+// their GIN indexes.
+func (r *Repository) searchCustomers(db *gorm.DB, search string, pii bool) *gorm.DB {
+	terms := filter.Terms(search)
+	if len(terms) == 0 {
+		return db
+	}
+	if r.db.Dialector.Name() == "postgres" {
+		return db.Where(searchVector(pii)+" @@ to_tsquery('simple', ?)", filter.PrefixTSQuery(terms))
+	}
+
+	for _, term := range terms {
+		like := "%" + term + "%"
+		conds := r.db.Where(filter.FoldColumn(db, "customers.name")+" LIKE ?", like).
+			Or(filter.FoldColumn(db, "customers.trade_title")+" LIKE ?", like)
+		if pii {
+			conds = conds.Or("LOWER(customers.email) LIKE ? OR customers.phone LIKE ? OR customers.tc_vkn LIKE ?", like, like, like)
+		}
+		db = db.Where(conds)
+	}
+	return db
+}
+
+// SearchCustomers lists a page of the customers in the scope matching the
+// query, parsed with CustomerFilters(pii). Searches the request doesn't
+// sort are ordered by relevance on Postgres.
+func (r *Repository) SearchCustomers(scope DataScope, q filter.Query, pii bool, page, pageSize int) ([]Customer, int64, error) {
+	schema := r.CustomerFilters(pii)
+	db := schema.Apply(r.ScopedCustomers(scope), q)
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if terms := filter.Terms(q.Search); len(terms) > 0 && len(q.Sort) == 0 && r.db.Dialector.Name() == "postgres" {
+		db = db.Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "ts_rank(" + searchVector(pii) + ", to_tsquery('simple', ?)) DESC",
+			Vars:               []interface{}{filter.PrefixTSQuery(terms)},
+			WithoutParentheses: true,
+		}})
+	}
+
+	var customers []Customer
+	if err := schema.Order(db, q).Offset((page - 1) * pageSize).Limit(pageSize).Find(&customers).Error; err != nil {
+		return nil, 0, err
+	}
+	return customers, total, nil
+}
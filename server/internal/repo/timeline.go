@@ -0,0 +1,237 @@
+package repo
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Timeline event types
+const (
+	TimelineCustomerCreated = "customer_created"
+	TimelineProfileUpdated  = "profile_updated"
+	TimelineQuoteCreated    = "quote_created"
+	TimelineOfferReceived   = "offer_received"
+	TimelineQuoteApproved   = "quote_approved"
+	TimelinePolicyIssued    = "policy_issued"
+	TimelinePolicyUpdated   = "policy_updated"
+	TimelinePolicyRenewed   = "policy_renewed"
+	TimelinePolicyCancelled = "policy_cancelled"
+	TimelinePaymentReceived = "payment_received"
+)
+
+// TimelineTypes lists every timeline event type
+var TimelineTypes = []string{
+	TimelineCustomerCreated, TimelineProfileUpdated,
+	TimelineQuoteCreated, TimelineOfferReceived, TimelineQuoteApproved,
+	TimelinePolicyIssued, TimelinePolicyUpdated, TimelinePolicyRenewed, TimelinePolicyCancelled,
+	TimelinePaymentReceived,
+}
+
+// TimelineEvent is one entry of an activity timeline
+type TimelineEvent struct {
+	Type     string                 `json:"type"`
+	At       time.Time              `json:"at"`
+	Entity   string                 `json:"entity"`
+	EntityID uint                   `json:"entity_id"`
+	UserID   *uint                  `json:"user_id,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// TimelineSubject names the records a timeline is built from: a customer's
+// profile, and the quotes and policies the viewer may see. Payments and
+// offers follow from the policies and quotes.
+type TimelineSubject struct {
+	CustomerID *uint
+	QuoteIDs   []uint
+	PolicyIDs  []uint
+}
+
+// GetTimeline collects the subject's events of the given types, newest
+// first. Every type is included when types is empty.
+func (r *Repository) GetTimeline(subject TimelineSubject, types []string) ([]TimelineEvent, error) {
+	want := make(map[string]bool, len(types))
+	for _, t := range types {
+		want[t] = true
+	}
+	wanted := func(ts ...string) bool {
+		if len(want) == 0 {
+			return true
+		}
+		for _, t := range ts {
+			if want[t] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var events []TimelineEvent
+	add := func(event TimelineEvent) {
+		if wanted(event.Type) {
+			events = append(events, event)
+		}
+	}
+
+	if subject.CustomerID != nil && wanted(TimelineCustomerCreated) {
+		var customer Customer
+		if err := r.db.Unscoped().First(&customer, *subject.CustomerID).Error; err != nil {
+			return nil, err
+		}
+		add(TimelineEvent{Type: TimelineCustomerCreated, At: customer.CreatedAt, Entity: "customer", EntityID: customer.ID})
+	}
+
+	if len(subject.QuoteIDs) > 0 && wanted(TimelineQuoteCreated) {
+		var quotes []Quote
+		if err := r.db.Where("id IN ?", subject.QuoteIDs).Find(&quotes).Error; err != nil {
+			return nil, err
+		}
+		for _, quote := range quotes {
+			agentID := quote.AgentID
+			add(TimelineEvent{
+				Type: TimelineQuoteCreated, At: quote.CreatedAt, Entity: "quote", EntityID: quote.ID, UserID: &agentID,
+				Data: map[string]interface{}{
+					"coverage_type": quote.CoverageType,
+					"vehicle_plate": quote.VehiclePlate,
+					"status":        quote.Status,
+				},
+			})
+		}
+	}
+
+	if len(subject.QuoteIDs) > 0 && wanted(TimelineOfferReceived) {
+		var offers []ScrapedQuote
+		if err := r.db.Where("quote_id IN ? AND status = ?", subject.QuoteIDs, "scraped").Find(&offers).Error; err != nil {
+			return nil, err
+		}
+		for _, offer := range offers {
+			at := offer.ScrapedAt
+			if at.IsZero() {
+				at = offer.CreatedAt
+			}
+			add(TimelineEvent{
+				Type: TimelineOfferReceived, At: at, Entity: "quote", EntityID: offer.QuoteID,
+				Data: map[string]interface{}{
+					"scraped_quote_id": offer.ID,
+					"company_name":     offer.CompanyName,
+					"final_price":      offer.FinalPrice,
+				},
+			})
+		}
+	}
+
+	if len(subject.PolicyIDs) > 0 && wanted(TimelinePolicyIssued) {
+		var policies []Policy
+		if err := r.db.Where("id IN ?", subject.PolicyIDs).Find(&policies).Error; err != nil {
+			return nil, err
+		}
+		for _, policy := range policies {
+			agentID := policy.AgentID
+			add(TimelineEvent{
+				Type: TimelinePolicyIssued, At: policy.CreatedAt, Entity: "policy", EntityID: policy.ID, UserID: &agentID,
+				Data: map[string]interface{}{
+					"policy_number": policy.PolicyNumber,
+					"company_name":  policy.CompanyName,
+					"premium":       policy.Premium,
+					"start_date":    policy.StartDate,
+					"end_date":      policy.EndDate,
+				},
+			})
+		}
+	}
+
+	if len(subject.PolicyIDs) > 0 && wanted(TimelinePaymentReceived) {
+		var payments []Payment
+		if err := r.db.Where("policy_id IN ?", subject.PolicyIDs).Find(&payments).Error; err != nil {
+			return nil, err
+		}
+		for _, payment := range payments {
+			add(TimelineEvent{
+				Type: TimelinePaymentReceived, At: payment.PaidAt, Entity: "policy", EntityID: *payment.PolicyID,
+				Data: map[string]interface{}{
+					"payment_id": payment.ID,
+					"amount":     payment.Amount,
+					"method":     payment.Method,
+				},
+			})
+		}
+	}
+
+	// The rest is only in the audit log
+	var logs []AuditLog
+	audited := r.db.Where("1 = 0")
+	if subject.CustomerID != nil && wanted(TimelineProfileUpdated) {
+		audited = audited.Or("entity = ? AND entity_id = ? AND action = ?", "customer", *subject.CustomerID, "customer_updated")
+	}
+	if len(subject.QuoteIDs) > 0 && wanted(TimelineQuoteApproved) {
+		audited = audited.Or("entity = ? AND entity_id IN ? AND action = ?", "quote", subject.QuoteIDs, "quote_approved")
+	}
+	if len(subject.PolicyIDs) > 0 && wanted(TimelinePolicyUpdated, TimelinePolicyRenewed, TimelinePolicyCancelled) {
+		audited = audited.Or("entity = ? AND entity_id IN ? AND action = ?", "policy", subject.PolicyIDs, "policy_updated")
+	}
+	if err := r.db.Where(audited).Where("status_code < ?", 400).Find(&logs).Error; err != nil {
+		return nil, err
+	}
+	for _, entry := range logs {
+		if entry.EntityID == nil {
+			continue
+		}
+		add(auditTimelineEvent(&entry))
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].At.After(events[j].At)
+	})
+	return events, nil
+}
+
+// auditTimelineEvent turns an audit entry into a timeline event. Policy
+// updates that cancel or extend the policy are told apart by their changes.
+// Profile edits list the changed fields without their values, which may be
+// personal data.
+func auditTimelineEvent(entry *AuditLog) TimelineEvent {
+	event := TimelineEvent{
+		At:       entry.CreatedAt,
+		Entity:   entry.Entity,
+		EntityID: *entry.EntityID,
+		UserID:   entry.UserID,
+	}
+
+	var changes map[string]AuditChange
+	if entry.ChangesJSON != nil {
+		json.Unmarshal([]byte(*entry.ChangesJSON), &changes)
+	}
+
+	switch entry.Action {
+	case "customer_updated":
+		fields := make([]string, 0, len(changes))
+		for field := range changes {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		event.Type = TimelineProfileUpdated
+		event.Data = map[string]interface{}{"fields": fields}
+
+	case "quote_approved":
+		event.Type = TimelineQuoteApproved
+		json.Unmarshal([]byte(entry.MetaJSON), &event.Data)
+
+	default:
+		event.Type = TimelinePolicyUpdated
+		status, endDate := changes["status"], changes["end_date"]
+		oldEnd, _ := endDate.Old.(string)
+		newEnd, _ := endDate.New.(string)
+		switch {
+		case status.New == "cancelled":
+			event.Type = TimelinePolicyCancelled
+		case newEnd > oldEnd && oldEnd != "":
+			event.Type = TimelinePolicyRenewed
+		}
+
+		event.Data = make(map[string]interface{}, len(changes))
+		for field, change := range changes {
+			event.Data[field] = map[string]interface{}{"old": change.Old, "new": change.New}
+		}
+	}
+	return event
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/go-rod/rod"
 	"github.com/go-rod/rod/lib/launcher"
+	"github.com/go-rod/rod/lib/proto"
 )
 
 type HeadlessScraper struct {
@@ -33,7 +34,6 @@ func NewHeadlessScraper(cfg *HeadlessConfig) (*HeadlessScraper, error) {
 
 	// Launch browser
 	var browser *rod.Browser
-	var err error
 
 	if cfg.Headless {
 		browser = rod.New().MustConnect()
@@ -70,16 +70,13 @@ func (hs *HeadlessScraper) ScrapeTarget(target *repo.ScraperTarget) (*ScrapeStat
 
 	// Set user agent
 	if hs.config.UserAgent != "" {
-		page.MustSetUserAgent(&rod.UserAgent{
+		page.MustSetUserAgent(&proto.NetworkSetUserAgentOverride{
 			UserAgent: hs.config.UserAgent,
 		})
 	}
 
 	// Set viewport
-	page.MustSetViewport(&rod.Viewport{
-		Width:  hs.config.WindowWidth,
-		Height: hs.config.WindowHeight,
-	})
+	page.MustSetViewport(hs.config.WindowWidth, hs.config.WindowHeight, 1, false)
 
 	// Set timeout
 	ctx, cancel := context.WithTimeout(context.Background(), hs.config.Timeout)
@@ -162,7 +159,7 @@ func (hs *HeadlessScraper) extractDataWithJS(page *rod.Page, target *repo.Scrape
 	}
 
 	// Convert result to map
-	data, ok := result.Value.(map[string]interface{})
+	data, ok := result.Value.Val().(map[string]interface{})
 	if !ok {
 		return nil, fmt.Errorf("unexpected result type from JavaScript")
 	}
@@ -180,15 +177,15 @@ func (hs *HeadlessScraper) Close() error {
 // Anti-bot strategies
 func (hs *HeadlessScraper) ApplyAntiBotStrategies(page *rod.Page) error {
 	// Random mouse movements
-	page.MustMouseMove(100, 100)
+	page.Mouse.MustMoveTo(100, 100)
 	time.Sleep(100 * time.Millisecond)
-	page.MustMouseMove(200, 200)
+	page.Mouse.MustMoveTo(200, 200)
 	time.Sleep(100 * time.Millisecond)
 
 	// Random scroll
-	page.MustScroll(0, 300)
+	page.Mouse.MustScroll(0, 300)
 	time.Sleep(500 * time.Millisecond)
-	page.MustScroll(0, -300)
+	page.Mouse.MustScroll(0, -300)
 
 	// Random delay
 	time.Sleep(time.Duration(1000+rand.Intn(2000)) * time.Millisecond)
@@ -10,9 +10,11 @@ import (
 	"time"
 
 	"eesigorta/backend/internal/repo"
+	"eesigorta/backend/internal/validation"
 
 	"github.com/go-rod/rod"
 	"github.com/go-rod/rod/lib/launcher"
+	"github.com/go-rod/rod/lib/proto"
 )
 
 // InsuranceScraper handles scraping insurance company websites
@@ -81,7 +83,6 @@ func NewInsuranceScraper(cfg *InsuranceScraperConfig) (*InsuranceScraper, error)
 	}
 
 	var browser *rod.Browser
-	var err error
 
 	if cfg.Headless {
 		browser = rod.New().MustConnect()
@@ -112,11 +113,8 @@ func (is *InsuranceScraper) ScrapeInsuranceQuote(target *repo.ScraperTarget, cus
 	}
 
 	// Set user agent and viewport
-	page.MustSetUserAgent(&rod.UserAgent{UserAgent: is.config.UserAgent})
-	page.MustSetViewport(&rod.Viewport{
-		Width:  is.config.WindowWidth,
-		Height: is.config.WindowHeight,
-	})
+	page.MustSetUserAgent(&proto.NetworkSetUserAgentOverride{UserAgent: is.config.UserAgent})
+	page.MustSetViewport(is.config.WindowWidth, is.config.WindowHeight, 1, false)
 
 	// Navigate to the insurance company's quote page
 	err := page.Navigate(target.BaseURL)
@@ -400,7 +398,7 @@ func (is *InsuranceScraper) extractQuoteData(page *rod.Page, target *repo.Scrape
 	}
 
 	// Convert result to map
-	data, ok := result.Value.(map[string]interface{})
+	data, ok := result.Value.Val().(map[string]interface{})
 	if !ok {
 		return nil, fmt.Errorf("unexpected result type from JavaScript")
 	}
@@ -550,15 +548,15 @@ func (is *InsuranceScraper) enableStealthMode(page *rod.Page) error {
 // applyAntiBotStrategies applies various anti-bot detection strategies
 func (is *InsuranceScraper) applyAntiBotStrategies(page *rod.Page) error {
 	// Random mouse movements
-	page.MustMouseMove(100, 100)
+	page.Mouse.MustMoveTo(100, 100)
 	time.Sleep(100 * time.Millisecond)
-	page.MustMouseMove(200, 200)
+	page.Mouse.MustMoveTo(200, 200)
 	time.Sleep(100 * time.Millisecond)
 
 	// Random scroll
-	page.MustScroll(0, 300)
+	page.Mouse.MustScroll(0, 300)
 	time.Sleep(500 * time.Millisecond)
-	page.MustScroll(0, -300)
+	page.Mouse.MustScroll(0, -300)
 
 	// Random delay
 	time.Sleep(time.Duration(1000+rand.Intn(2000)) * time.Millisecond)
@@ -576,28 +574,7 @@ func (is *InsuranceScraper) Close() error {
 
 // ValidateTCKN validates Turkish ID number
 func ValidateTCKN(tckn string) bool {
-	if len(tckn) != 11 {
-		return false
-	}
-
-	// Check if all digits
-	for _, char := range tckn {
-		if char < '0' || char > '9' {
-			return false
-		}
-	}
-
-	// TCKN validation algorithm
-	sum := 0
-	for i := 0; i < 10; i++ {
-		sum += int(tckn[i] - '0')
-	}
-
-	if sum%10 != int(tckn[10]-'0') {
-		return false
-	}
-
-	return true
+	return validation.ValidateTCKN(tckn)
 }
 
 // NormalizePhone normalizes Turkish phone numbers
@@ -606,8 +583,11 @@ func NormalizePhone(phone string) string {
 	re := regexp.MustCompile(`\D`)
 	digits := re.ReplaceAllString(phone, "")
 
-	// Add country code if missing
-	if len(digits) == 10 && digits[0] == '5' {
+	// Add country code if missing, replacing the trunk prefix 0
+	if len(digits) == 11 && digits[0] == '0' {
+		digits = digits[1:]
+	}
+	if len(digits) == 10 && digits[0] != '0' {
 		digits = "90" + digits
 	}
 
@@ -0,0 +1,140 @@
+package validation
+
+import (
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"eesigorta/backend/internal/repo"
+)
+
+// Genders a customer may be recorded with
+var Genders = []string{"male", "female", "other"}
+
+// ValidateCustomer checks a customer before it's saved and returns
+// FieldErrors listing every problem. It trims the identifier and derives the
+// customer type from it when none is set: an 11-digit TC Kimlik No makes an
+// individual, a 10-digit VKN a company.
+func ValidateCustomer(customer *repo.Customer, now time.Time) error {
+	errs := FieldErrors{}
+
+	customer.TCVKN = strings.Join(strings.Fields(customer.TCVKN), "")
+	customer.Name = strings.TrimSpace(customer.Name)
+
+	identifiedAs := ""
+	switch len(customer.TCVKN) {
+	case 0:
+		errs.Add("tc_vkn", "is required")
+	case 11:
+		if ValidateTCKN(customer.TCVKN) {
+			identifiedAs = repo.CustomerTypeIndividual
+		} else {
+			errs.Add("tc_vkn", "is not a valid TC Kimlik No")
+		}
+	case 10:
+		if ValidateVKN(customer.TCVKN) {
+			identifiedAs = repo.CustomerTypeCorporate
+		} else {
+			errs.Add("tc_vkn", "is not a valid VKN")
+		}
+	default:
+		errs.Add("tc_vkn", "must be an 11-digit TC Kimlik No or a 10-digit VKN")
+	}
+
+	switch customer.CustomerType {
+	case "":
+		customer.CustomerType = identifiedAs
+	case repo.CustomerTypeIndividual:
+		if identifiedAs == repo.CustomerTypeCorporate {
+			errs.Add("customer_type", "bireysel customers are identified by a TC Kimlik No, not a VKN")
+		}
+	case repo.CustomerTypeCorporate:
+		if identifiedAs == repo.CustomerTypeIndividual {
+			errs.Add("customer_type", "kurumsal customers are identified by a VKN, not a TC Kimlik No")
+		}
+	default:
+		errs.Add("customer_type", "must be bireysel or kurumsal")
+	}
+
+	if customer.Name == "" {
+		errs.Add("name", "is required")
+	}
+
+	switch customer.CustomerType {
+	case repo.CustomerTypeIndividual:
+		for field, value := range map[string]string{
+			"tax_office":        customer.TaxOffice,
+			"trade_title":       customer.TradeTitle,
+			"authorized_person": customer.AuthorizedPerson,
+		} {
+			if value != "" {
+				errs.Add(field, "is only for kurumsal customers")
+			}
+		}
+		if customer.Gender != "" && !slices.Contains(Genders, customer.Gender) {
+			errs.Add("gender", "must be one of "+strings.Join(Genders, ", "))
+		}
+		if customer.BirthDate != nil && customer.BirthDate.After(now) {
+			errs.Add("birth_date", "can't be in the future")
+		}
+
+	case repo.CustomerTypeCorporate:
+		if strings.TrimSpace(customer.TaxOffice) == "" {
+			errs.Add("tax_office", "is required for kurumsal customers")
+		}
+		if strings.TrimSpace(customer.TradeTitle) == "" {
+			errs.Add("trade_title", "is required for kurumsal customers")
+		}
+		if customer.Gender != "" {
+			errs.Add("gender", "is only for bireysel customers")
+		}
+		if customer.BirthDate != nil {
+			errs.Add("birth_date", "is only for bireysel customers")
+		}
+	}
+
+	if customer.Email != "" && !ValidateEmail(customer.Email) {
+		errs.Add("email", "is not a valid email address")
+	}
+	if customer.Phone != "" && !ValidatePhone(customer.Phone) {
+		errs.Add("phone", "is not a valid Turkish phone number")
+	}
+	if customer.PostalCode != "" {
+		if _, ok := parseDigits(customer.PostalCode, 5); !ok {
+			errs.Add("postal_code", "must be 5 digits")
+		}
+	}
+
+	return errs.Err()
+}
+
+// ValidateEmail checks that a value is a bare email address
+func ValidateEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+// ValidatePhone checks for a Turkish phone number, with or without the
+// leading 0 or +90, in any layout
+func ValidatePhone(phone string) bool {
+	var digits strings.Builder
+	for _, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '+':
+		default:
+			return false
+		}
+	}
+
+	number := digits.String()
+	switch {
+	case len(number) == 12 && strings.HasPrefix(number, "90"):
+		number = number[2:]
+	case len(number) == 11 && number[0] == '0':
+		number = number[1:]
+	}
+	return len(number) == 10 && number[0] >= '2'
+}
@@ -0,0 +1,39 @@
+package validation
+
+import (
+	"sort"
+	"strings"
+)
+
+// FieldErrors maps each invalid field, by its JSON name, to what's wrong
+// with it
+type FieldErrors map[string]string
+
+// Add records a problem with a field, keeping the first one reported
+func (e FieldErrors) Add(field, message string) {
+	if _, ok := e[field]; !ok {
+		e[field] = message
+	}
+}
+
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	problems := make([]string, 0, len(fields))
+	for _, field := range fields {
+		problems = append(problems, field+": "+e[field])
+	}
+	return "validation failed: " + strings.Join(problems, "; ")
+}
+
+// Err returns the errors as an error, or nil when there are none
+func (e FieldErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,63 @@
+package validation
+
+// ValidateTCKN checks a Turkish identity number (TC Kimlik No): eleven
+// digits not starting with 0, where the 10th digit is seven times the sum of
+// the odd-positioned digits minus the sum of the even-positioned ones, and
+// the 11th digit is the sum of the first ten, both mod 10
+func ValidateTCKN(tckn string) bool {
+	digits, ok := parseDigits(tckn, 11)
+	if !ok || digits[0] == 0 {
+		return false
+	}
+
+	odd := digits[0] + digits[2] + digits[4] + digits[6] + digits[8]
+	even := digits[1] + digits[3] + digits[5] + digits[7]
+	if ((odd*7-even)%10+10)%10 != digits[9] {
+		return false
+	}
+
+	sum := 0
+	for _, d := range digits[:10] {
+		sum += d
+	}
+	return sum%10 == digits[10]
+}
+
+// ValidateVKN checks a Turkish tax number (Vergi Kimlik No): ten digits, the
+// last one a checksum of the first nine
+func ValidateVKN(vkn string) bool {
+	digits, ok := parseDigits(vkn, 10)
+	if !ok {
+		return false
+	}
+
+	sum := 0
+	for i, d := range digits[:9] {
+		shifted := (d + 9 - i) % 10
+		if shifted == 0 {
+			continue
+		}
+		weighted := (shifted << (9 - i)) % 9
+		if weighted == 0 {
+			weighted = 9
+		}
+		sum += weighted
+	}
+	return (10-sum%10)%10 == digits[9]
+}
+
+// parseDigits splits a string of exactly n ASCII digits into their values
+func parseDigits(value string, n int) ([]int, bool) {
+	if len(value) != n {
+		return nil, false
+	}
+
+	digits := make([]int, n)
+	for i := 0; i < n; i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return nil, false
+		}
+		digits[i] = int(value[i] - '0')
+	}
+	return digits, true
+}